@@ -0,0 +1,83 @@
+package api
+
+import (
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// MergeRequest the kind of the duplicate candidate, "entry" when empty, or "submission"
+type MergeRequest struct {
+	DuplicateID string `json:"duplicateId"`
+	Kind        string `json:"kind,omitempty"`
+}
+
+// AgendaDuplicateHandler protected routes
+//
+//	GET  /agenda/{id}/duplicates                                     the entries and submissions which may be the same event
+//	POST /agenda/{id}/merge {"duplicateId": "...", "kind": "entry"}  completes the entry with the duplicate, which goes to the trash
+func AgendaDuplicateHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		urlPaths := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		if len(urlPaths) != 3 {
+			writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: "Not found"})
+			return
+		}
+		entryID := urlPaths[1]
+		switch {
+		case req.Method == http.MethodGet && urlPaths[2] == "duplicates":
+			entry, err := services.agendaRepository.FindByID(req.Context(), entryID)
+			if err != nil {
+				writeDuplicateError(writer, "FindByID", err)
+				return
+			}
+			duplicates, err := services.agendaRepository.FindDuplicates(req.Context(), entry)
+			if err != nil {
+				writeDuplicateError(writer, "FindDuplicates", err)
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: duplicates})
+
+		case req.Method == http.MethodPost && urlPaths[2] == "merge":
+			var mergeRequest MergeRequest
+			if err := json.NewDecoder(req.Body).Decode(&mergeRequest); err != nil || mergeRequest.DuplicateID == "" {
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "The duplicate is missing"})
+				return
+			}
+			if mergeRequest.Kind == "" {
+				mergeRequest.Kind = repository.DuplicateEntry
+			}
+			entry, err := services.agendaRepository.Merge(req.Context(), entryID, mergeRequest.DuplicateID, mergeRequest.Kind)
+			if err != nil {
+				writeDuplicateError(writer, "Merge", err)
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Entries merged", Data: entry})
+
+		default:
+			writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: "Not found"})
+		}
+	}
+}
+
+func writeDuplicateError(writer http.ResponseWriter, operation string, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNoAgendaEntryFound), errors.Is(err, sql.ErrNoRows):
+		writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: repository.ErrNoAgendaEntryFound.Error()})
+	case errors.Is(err, repository.ErrNoSubmissionFound):
+		writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, db.ErrInvalidTransition):
+		writeJSONResponse(writer, http.StatusConflict, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, repository.ErrInvalidMerge), errors.Is(err, repository.ErrNoVenueFound),
+		errors.Is(err, repository.ErrNoOrganizerFound):
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: err.Error()})
+	default:
+		log.Printf("AgendaDuplicateHandler::%s %v", operation, err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while merging the entries"})
+	}
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"dpatrov/scraper/internal/utils"
+	"log"
+	"net/http"
+	"time"
+)
+
+// AgendaFacetsResponse the counts and the [from, to] dates of each window, to filter the agenda with
+type AgendaFacetsResponse struct {
+	BaseResponse
+	*utils.EventFacetCounts
+	Windows map[string][2]string `json:"windows"`
+}
+
+// AgendaFacetsHandler GET /api/agenda/facets, the counts of the homepage filters
+func AgendaFacetsHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeJSONResponse(resp, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			return
+		}
+		now := time.Now()
+		counts, err := services.facets.Current(req.Context(), now)
+		if err != nil {
+			log.Printf("AgendaFacetsHandler %v", err)
+			writeJSONResponse(resp, http.StatusInternalServerError, ErrorResponse{Message: "Fail to count events"})
+			return
+		}
+		windows := utils.FacetWindows(now)
+		resp.Header().Set("Cache-Control", "public, max-age=60")
+		writeJSONResponse(resp, http.StatusOK, AgendaFacetsResponse{
+			EventFacetCounts: counts,
+			Windows: map[string][2]string{
+				"today":       {windows.TodayStart, windows.TodayEnd},
+				"thisWeekend": {windows.WeekendStart, windows.WeekendEnd},
+				"thisWeek":    {windows.WeekStart, windows.WeekEnd},
+				"nextWeek":    {windows.NextWeekStart, windows.NextWeekEnd},
+			},
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/feed"
+	"dpatrov/scraper/internal/ical"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+const maxFeedItems = 100
+
+// AgendaICSHandler GET /api/agenda.ics, the active events as an iCalendar subscription
+func AgendaICSHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			writeJSONResponse(resp, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			return
+		}
+		entries, err := services.agendaRepository.FindAll(req.Context(), repository.Filter{"status": int(db.Status_Active)})
+		if err != nil {
+			log.Printf("AgendaICSHandler %v", err)
+			writeJSONResponse(resp, http.StatusInternalServerError, ErrorResponse{Message: "Fail to load events"})
+			return
+		}
+		events := []ical.Event{}
+		for _, entry := range entries {
+			// FindAll also returns the deleted entries with the active ones
+			if entry.Status == db.Status_Active {
+				events = append(events, EntryEvent(entry))
+			}
+		}
+		writeICS(resp, "agenda.ics", events)
+	}
+}
+
+// agendaEntryICS GET /api/agenda/{id}.ics
+func agendaEntryICS(resp http.ResponseWriter, req *http.Request, agendaID string) {
+	agendaRepo, err := GetRepository[repository.AgendaRepository](req.Context(), agendaRepoKey)
+	if err != nil {
+		http.Error(resp, "Fail to get agenda repository", http.StatusInternalServerError)
+		return
+	}
+	entry, err := agendaRepo.FindByID(req.Context(), agendaID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && entry.Status != db.Status_Active) {
+		writeJSONResponse(resp, http.StatusNotFound, ErrorResponse{Message: "Event not found"})
+		return
+	}
+	if err != nil {
+		writeJSONResponse(resp, http.StatusInternalServerError, ErrorResponse{Message: err.Error()})
+		return
+	}
+	writeICS(resp, entry.ID+".ics", []ical.Event{EntryEvent(entry)})
+}
+
+func writeICS(resp http.ResponseWriter, filename string, events []ical.Event) {
+	resp.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	resp.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
+	resp.Header().Set("Cache-Control", "public, max-age=900")
+	if err := ical.Write(resp, "Afromemo", events); err != nil {
+		log.Printf("writeICS %v", err)
+	}
+}
+
+// EntryEvent combines the dates and times of the entry in Europe/Zurich
+func EntryEvent(entry db.AgendaEntry) ical.Event {
+	start := combineDateTime(entry.StartDate, entry.StartTime)
+	endDate := entry.EndDate
+	if endDate.Year() <= 1 {
+		endDate = entry.StartDate
+	}
+	end := combineDateTime(endDate, entry.EndTime)
+
+	var location []string
+	for _, part := range []string{entry.VenueName, entry.Address, entry.Place} {
+		part = strings.TrimSpace(part)
+		if part != "" && !slices.Contains(location, part) {
+			location = append(location, part)
+		}
+	}
+	categories := []string{}
+	for _, category := range append([]string{entry.Category}, entry.Tags...) {
+		category = strings.TrimSpace(category)
+		if category != "" && !slices.Contains(categories, category) {
+			categories = append(categories, category)
+		}
+	}
+	description := entry.Description
+	if entry.Price != "" {
+		description = strings.TrimSpace(description + "\n\nPrix: " + entry.Price)
+	}
+	summary := strings.TrimSpace(entry.Title + " " + entry.Subtitle)
+	status := "CONFIRMED"
+	if label := entry.LifecycleLabel(); label != "" {
+		summary = "[" + label + "] " + summary
+	}
+	switch entry.Lifecycle() {
+	case db.Lifecycle_Cancelled:
+		status = "CANCELLED"
+	case db.Lifecycle_Postponed:
+		if entry.OriginalStartDate == "" {
+			// postponed, the new date is not known yet
+			status = "TENTATIVE"
+		}
+	}
+	if originalDate, err := time.Parse("2006-01-02", entry.OriginalStartDate); err == nil {
+		description = strings.TrimSpace("Initialement prévu le " + originalDate.Format("02.01.2006") + "\n\n" + description)
+	}
+
+	event := ical.Event{
+		UID:         entry.ID + "@" + uidDomain(),
+		Summary:     summary,
+		Description: description,
+		Location:    strings.Join(location, ", "),
+		URL:         fmt.Sprintf("%s/agenda/%s", os.Getenv("FRONT_URL"), entry.ID),
+		Categories:  categories,
+		Start:       start,
+		End:         end,
+		Status:      status,
+	}
+	// a series is a single event with its recurrence rule
+	if rule, err := ical.ParseRRule(entry.RRule); entry.RRule != "" && err == nil {
+		event.Recurrence = rule
+		exdates, _ := entry.ParseExDates()
+		for _, exdate := range exdates {
+			event.ExDates = append(event.ExDates, combineDateTime(exdate, entry.StartTime))
+		}
+	}
+	return event
+}
+
+func combineDateTime(date time.Time, clock time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), clock.Hour(), clock.Minute(), 0, 0, ical.DefaultLocation)
+}
+
+// the UID must be globally unique, the front domain is used
+func uidDomain() string {
+	if frontURL, err := url.Parse(os.Getenv("FRONT_URL")); err == nil && frontURL.Hostname() != "" {
+		return frontURL.Hostname()
+	}
+	return "afromemo.ch"
+}
+
+// UpcomingFeedHandler GET /api/feeds/upcoming.rss and /api/feeds/upcoming.atom
+//
+//	?category=concert&tag=jazz,world -> the entries of one of the categories with one of the tags
+func UpcomingFeedHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			writeJSONResponse(resp, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			return
+		}
+		page, err := services.agendaRepository.Find(req.Context(), UpcomingQuery(req.URL.Query(), time.Now()))
+		if err != nil {
+			log.Printf("UpcomingFeedHandler %v", err)
+			writeJSONResponse(resp, http.StatusInternalServerError, ErrorResponse{Message: "Fail to load events"})
+			return
+		}
+
+		selfLink := requestBaseURL(req) + req.URL.RequestURI()
+		upcoming := UpcomingFeed(page.Entries, requestBaseURL(req), selfLink)
+		// the length of the posters served by the api, one item per entry
+		for i, entry := range page.Entries {
+			if enclosure := upcoming.Items[i].Enclosure; enclosure != nil && !strings.HasPrefix(entry.Poster, "http") {
+				if object, err := services.storage.Stat(req.Context(), path.Base(entry.Poster)); err == nil {
+					enclosure.Length = object.Size
+				}
+			}
+		}
+		resp.Header().Set("Cache-Control", "public, max-age=900")
+		if strings.HasSuffix(req.URL.Path, ".atom") {
+			resp.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+			err = feed.WriteAtom(resp, upcoming)
+		} else {
+			resp.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+			err = feed.WriteRSS(resp, upcoming)
+		}
+		if err != nil {
+			log.Printf("UpcomingFeedHandler %v", err)
+		}
+	}
+}
+
+// UpcomingQuery the active entries not yet ended, of one of the categories with one of the tags
+func UpcomingQuery(values url.Values, now time.Time) repository.AgendaQuery {
+	return repository.AgendaQuery{
+		Statuses:   []db.Status{db.Status_Active},
+		From:       now.In(ical.DefaultLocation),
+		Categories: queryValues(values, "category"),
+		Tags:       queryValues(values, "tag"),
+		Limit:      maxFeedItems,
+	}
+}
+
+// UpcomingFeed builds the feed of the entries, posters are served by the api under /images/
+func UpcomingFeed(entries []db.AgendaEntry, apiURL string, selfLink string) feed.Feed {
+	upcoming := feed.Feed{
+		Title:       "Afromemo - Agenda",
+		Description: "Les prochains événements de l'agenda",
+		Link:        os.Getenv("FRONT_URL") + "/agenda",
+		SelfLink:    selfLink,
+		Language:    "fr",
+		Items:       make([]feed.Item, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		event := EntryEvent(entry)
+		published := entry.CreatedAt
+		if published.IsZero() {
+			published = event.Start
+		}
+		updated := entry.UpdatedAt
+		if updated.IsZero() {
+			updated = published
+		}
+		if updated.After(upcoming.Updated) {
+			upcoming.Updated = updated
+		}
+
+		when := event.Start.Format("02.01.2006 15:04")
+		if event.Location != "" {
+			when += ", " + event.Location
+		}
+		id := event.URL
+		if entry.RRule != "" {
+			// one item per occurrence of a series
+			id += "#" + entry.StartDate.Format("2006-01-02")
+		}
+		upcoming.Items = append(upcoming.Items, feed.Item{
+			ID:          id,
+			Title:       event.Summary,
+			Link:        event.URL,
+			Description: strings.TrimSpace(when + "\n\n" + event.Description),
+			Categories:  event.Categories,
+			Published:   published,
+			Updated:     updated,
+			Enclosure:   posterEnclosure(entry.Poster, apiURL),
+		})
+	}
+	if upcoming.Updated.IsZero() {
+		upcoming.Updated = time.Now()
+	}
+	return upcoming
+}
+
+func posterEnclosure(poster string, apiURL string) *feed.Enclosure {
+	if poster == "" {
+		return nil
+	}
+	enclosure := &feed.Enclosure{URL: poster, Type: mime.TypeByExtension(strings.ToLower(path.Ext(poster)))}
+	if !strings.HasPrefix(poster, "http://") && !strings.HasPrefix(poster, "https://") {
+		enclosure.URL = apiURL + "/images/" + url.PathEscape(poster)
+	}
+	if enclosure.Type == "" {
+		enclosure.Type = "image/jpeg"
+	}
+	return enclosure
+}
+
+// queryValues accepts repeated and comma separated values: ?tag=jazz&tag=world or ?tag=jazz,world
+func queryValues(query url.Values, name string) []string {
+	values := []string{}
+	for _, value := range query[name] {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.ToLower(strings.TrimSpace(part)); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
+
+// requestBaseURL is the public url of the api, behind a proxy the forwarded headers are used
+func requestBaseURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if forwarded := req.Header.Get("X-Forwarded-Proto"); forwarded != "" {
+		scheme = forwarded
+	}
+	host := req.Host
+	if forwarded := req.Header.Get("X-Forwarded-Host"); forwarded != "" {
+		host = forwarded
+	}
+	return scheme + "://" + host
+}
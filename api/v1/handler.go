@@ -6,33 +6,26 @@ import (
 	"dpatrov/scraper/internal/db"
 	"dpatrov/scraper/internal/db/repository"
 	"dpatrov/scraper/internal/gendb"
-	"dpatrov/scraper/internal/types"
+	"dpatrov/scraper/internal/imaging"
+	"dpatrov/scraper/internal/storage"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"image"
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
 	"os/signal"
 	"syscall"
 
-	_ "golang.org/x/image/bmp"
-	_ "golang.org/x/image/tiff"
-	_ "golang.org/x/image/webp"
-
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	internal "dpatrov/scraper/internal"
-
 	"dpatrov/scraper/internal/utils"
 
 	"dpatrov/scraper/internal/validators"
@@ -88,6 +81,14 @@ type PublishActionRequest struct {
 	FormData db.AgendaEntry `json:"formData"`
 	Action   string         `json:"action"`
 	Token    string         `json:"token"`
+	// reject
+	Reason string `json:"reason"`
+	// request_changes
+	Comment string `json:"comment"`
+	// assign, 0 to unassign
+	AssignedTo int `json:"assignedTo"`
+	// note
+	Note string `json:"note"`
 }
 
 type BaseResponse struct{}
@@ -130,6 +131,7 @@ const (
 	userIDKey     = contextKey("userIDKey")
 	userRepoKey   = contextKey("userRepository")
 	serviceKey    = contextKey("service")
+	storageKey    = contextKey("storage")
 )
 
 // JWT token session
@@ -141,12 +143,20 @@ var (
 )
 
 type ServiceMiddleWare struct {
-	agendaRepository repository.AgendaRepository
-	taskRepository   repository.TaskRepository
-	userRepository   repository.UserRepository
-	queries          gendb.Queries
-	mailer           utils.Mailer
-	eventBroker      utils.EventNotification
+	agendaRepository       repository.AgendaRepository
+	taskRepository         repository.TaskRepository
+	userRepository         repository.UserRepository
+	venueRepository        repository.VenueRepository
+	organizerRepository    repository.OrganizerRepository
+	subscriptionRepository repository.SubscriptionRepository
+	outboxRepository       repository.OutboxRepository
+	queries                gendb.Queries
+	db                     *sql.DB
+	mailer                 *utils.Mailer
+	eventBroker            utils.EventNotification
+	scrapingRunner         *utils.ScrapingRunner
+	facets                 *utils.EventFacetCounts
+	storage                storage.Storage
 }
 
 func NewServiceMiddleWare(db *sql.DB) *ServiceMiddleWare {
@@ -159,13 +169,63 @@ func NewServiceMiddleWare(db *sql.DB) *ServiceMiddleWare {
 		FromEmail:    "info@afromemo.ch",
 	}
 	return &ServiceMiddleWare{
-		agendaRepository: *repository.NewAgendaRepository(db),
-		taskRepository:   *repository.NewTaskRepository(db),
-		userRepository:   *repository.NewUserRepository(db),
-		queries:          *gendb.New(db),
-		mailer:           *utils.NewMailer(mailerConf),
-		eventBroker:      *utils.NewEventNotication(),
+		agendaRepository:       *repository.NewAgendaRepository(db),
+		taskRepository:         *repository.NewTaskRepository(db),
+		userRepository:         *repository.NewUserRepository(db),
+		venueRepository:        *repository.NewVenueRepository(db),
+		organizerRepository:    *repository.NewOrganizerRepository(db),
+		subscriptionRepository: *repository.NewSubscriptionRepository(db),
+		outboxRepository:       *repository.NewOutboxRepository(db),
+		queries:                *gendb.New(db),
+		db:                     db,
+		mailer:                 utils.NewMailer(mailerConf, db),
+		eventBroker:            *utils.NewEventNotication(),
+		scrapingRunner:         utils.NewScrapingRunner(db),
+		facets:                 utils.NewEventFacetCounts(db),
+		storage:                storage.NewLocal("./uploads", "/images"),
+	}
+}
+
+// WithStorage keeps the uploads in the storage instead of ./uploads
+func (m *ServiceMiddleWare) WithStorage(store storage.Storage) *ServiceMiddleWare {
+	m.storage = store
+	return m
+}
+
+// Mailer the mailer of the services, started by StartApiServer
+func (m *ServiceMiddleWare) Mailer() *utils.Mailer {
+	return m.mailer
+}
+
+// updateWithMail runs the submission changes and enqueues the email in one transaction,
+// the email is only sent when the changes are committed. A nil email only runs the changes
+func (m *ServiceMiddleWare) updateWithMail(ctx context.Context, email *utils.EmailTask, update func(queries *gendb.Queries) error) error {
+	return m.transactWithMail(ctx, email, func(tx *sql.Tx) error {
+		return update(m.queries.WithTx(tx))
+	})
+}
+
+// transactWithMail as updateWithMail, for the changes which go through the repositories as well
+// (AgendaRepository.WithTx)
+func (m *ServiceMiddleWare) transactWithMail(ctx context.Context, email *utils.EmailTask, update func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := update(tx); err != nil {
+		return err
+	}
+	if email != nil {
+		if err := m.mailer.Enqueue(ctx, tx, *email); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
 	}
+	m.mailer.Wake()
+	return nil
 }
 
 func (m *ServiceMiddleWare) Handler(next http.HandlerFunc) HandlerFunc {
@@ -175,6 +235,7 @@ func (m *ServiceMiddleWare) Handler(next http.HandlerFunc) HandlerFunc {
 		ctx = context.WithValue(ctx, taskRepoKey, m.taskRepository)
 		ctx = context.WithValue(ctx, userRepoKey, m.userRepository)
 		ctx = context.WithValue(ctx, serviceKey, m.queries)
+		ctx = context.WithValue(ctx, storageKey, m.storage)
 		// Call next handler with context
 		next.ServeHTTP(resp, req.WithContext(ctx))
 	}
@@ -447,6 +508,8 @@ func authMiddleware(next http.Handler) http.Handler {
 			return
 		}
 		ctx := context.WithValue(req.Context(), userIDKey, claims.UserID)
+		// the agenda revisions keep the user
+		ctx = repository.WithActor(ctx, claims.UserID)
 		next.ServeHTTP(resp, req.WithContext(ctx))
 	})
 }
@@ -501,17 +564,28 @@ func GetRepository[T any](ctx context.Context, key contextKey) (T, error) {
 	return repo, nil
 }
 
+// getAllEvents GET /api/agenda
+//
+//	?from=2025-10-01&to=2025-10-31&category=concert&tag=jazz,world&venue=carouge
+//	&lifecycle=scheduled&q=kora&sort=-startdate&limit=20&cursor=...
+//
+// The body stays the list of the entries, the next page is given by the Link header (rel="next")
 func getAllEvents(resp http.ResponseWriter, req *http.Request) {
 	agendaRepo, err := GetRepository[repository.AgendaRepository](req.Context(), agendaRepoKey)
 	if err != nil {
 		http.Error(resp, "Fail to get agenda repository", http.StatusInternalServerError)
+		return
+	}
+	query, err := AgendaQueryFromRequest(req.URL.Query())
+	if err != nil {
+		writeJSONResponse(resp, http.StatusBadRequest, ErrorResponse{Message: err.Error(), Error: true})
+		return
 	}
 	/* Check token in the context */
-	queryFilter := make(map[string]int)
-	queryFilter["status"] = 1
+	publicStatuses := []db.Status{db.Status_Active, db.Status_Deleted}
 
 	userId := req.Context().Value(userIDKey)
-	log.Printf("Current userId %s", userId)
+	log.Printf("Current userId %v", userId)
 	if userId != nil {
 		userRepo, err := GetRepository[repository.UserRepository](req.Context(), userRepoKey)
 		if err != nil {
@@ -528,20 +602,96 @@ func getAllEvents(resp http.ResponseWriter, req *http.Request) {
 			return
 		}
 		if user.IsAdmin() {
-			queryFilter["status"] = -int(db.Status_Unlinked)
+			publicStatuses = nil
+			query.ExcludedStatuses = []db.Status{db.Status_Unlinked}
+		} else {
+			query.Owner = userId.(int)
+		}
+	}
+	if publicStatuses != nil {
+		// ?status= can only narrow the visible statuses
+		if len(query.Statuses) == 0 {
+			query.Statuses = publicStatuses
 		} else {
-			queryFilter["owner"] = userId.(int)
+			query.Statuses = slices.DeleteFunc(query.Statuses, func(status db.Status) bool {
+				return !slices.Contains(publicStatuses, status)
+			})
+			if len(query.Statuses) == 0 {
+				json.NewEncoder(resp).Encode([]db.AgendaEntry{})
+				return
+			}
 		}
 	}
 
-	events, err := agendaRepo.FindAll(req.Context(), queryFilter)
+	page, err := agendaRepo.Find(req.Context(), query)
+	if errors.Is(err, repository.ErrInvalidAgendaQuery) {
+		writeJSONResponse(resp, http.StatusBadRequest, ErrorResponse{Message: err.Error(), Error: true})
+		return
+	}
 	if err != nil {
-		fmt.Printf("err %v", err)
-		log.Fatalf("%v", err)
-		http.Error(resp, "Fail to load events", http.StatusInternalServerError)
+		log.Printf("getAllEvents %v", err)
+		writeJSONResponse(resp, http.StatusInternalServerError, ErrorResponse{Message: "Fail to load events", Error: true})
 		return
 	}
-	json.NewEncoder(resp).Encode(events)
+	if page.Next != "" {
+		nextURL := *req.URL
+		values := nextURL.Query()
+		values.Set("cursor", page.Next)
+		nextURL.RawQuery = values.Encode()
+		resp.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextURL.RequestURI()))
+		resp.Header().Set("Access-Control-Expose-Headers", "Link")
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(page.Entries)
+}
+
+const maxAgendaPageSize = 200
+
+// AgendaQueryFromRequest reads the listing parameters, the lists accept repeated or comma separated values
+func AgendaQueryFromRequest(values url.Values) (repository.AgendaQuery, error) {
+	query := repository.AgendaQuery{
+		Categories: queryValues(values, "category"),
+		Tags:       queryValues(values, "tag"),
+		Lifecycles: queryValues(values, "lifecycle"),
+		Venue:      strings.TrimSpace(values.Get("venue")),
+		Text:       strings.TrimSpace(values.Get("q")),
+		Sort:       values.Get("sort"),
+		Cursor:     values.Get("cursor"),
+	}
+	var err error
+	for name, date := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if value := values.Get(name); value != "" {
+			if *date, err = time.Parse("2006-01-02", value); err != nil {
+				return query, fmt.Errorf("%s: expected a date as 2006-01-02", name)
+			}
+		}
+	}
+	if !slices.Contains(repository.AgendaSorts(), query.Sort) && query.Sort != "" {
+		return query, fmt.Errorf("sort: expected one of %s", strings.Join(repository.AgendaSorts(), ", "))
+	}
+	if value := values.Get("limit"); value != "" {
+		if query.Limit, err = strconv.Atoi(value); err != nil || query.Limit < 1 || query.Limit > maxAgendaPageSize {
+			return query, fmt.Errorf("limit: expected a number between 1 and %d", maxAgendaPageSize)
+		}
+	}
+	if value := values.Get("venueId"); value != "" {
+		if query.VenueID, err = strconv.Atoi(value); err != nil || query.VenueID < 1 {
+			return query, fmt.Errorf("venueId: expected a venue id")
+		}
+	}
+	if value := values.Get("organizerId"); value != "" {
+		if query.OrganizerID, err = strconv.Atoi(value); err != nil || query.OrganizerID < 1 {
+			return query, fmt.Errorf("organizerId: expected an organizer id")
+		}
+	}
+	for _, value := range queryValues(values, "status") {
+		status, err := strconv.Atoi(value)
+		if err != nil {
+			return query, fmt.Errorf("status: expected a number")
+		}
+		query.Statuses = append(query.Statuses, db.Status(status))
+	}
+	return query, nil
 }
 
 // status handler
@@ -572,26 +722,35 @@ func agendaStatusHandler(resp http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
-	// Supprimer l'événement/supprimer
+	// Supprimer l'événement/supprimer: the entry and its submission go to the trash
 	if statusRequest.Status == int(db.Status_Removed) {
-		log.Println("Deleting Event %s \n", statusRequest.Id)
-		if err := agendaEntry.Delete(req.Context(), statusRequest.Id); err != nil {
-			log.Printf("Internal Error while delete entry: %s\n", statusRequest.Id)
+		if err := agendaEntry.Trash(req.Context(), statusRequest.Id); err != nil {
+			writeTrashError(resp, "Trash", err)
+			return
 		}
 		queries, err := GetRepository[gendb.Queries](req.Context(), serviceKey)
 		if err != nil {
 			log.Printf("Internal error %v", err)
 			return
 		}
-		log.Println("Deleting linked Submission for %s", statusRequest.Id)
-		err = queries.DeleteSubmissionByID(req.Context(), statusRequest.Id)
+		err = queries.TrashSubmission(req.Context(), gendb.TrashSubmissionParams{
+			DeletedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true},
+			ID:        statusRequest.Id,
+		})
 		if err != nil {
-			log.Println("DeleteSubmissionByID::Error")
-			return
+			log.Printf("TrashSubmission::Error %v", err)
 		}
+		writeJSONResponse(resp, http.StatusAccepted, OkResponse{
+			Success: true,
+			Message: "Moved to trash",
+		})
 	} else {
 		// deal with current user form token
-		if err := agendaEntry.UpdateStatus(statusRequest.Id, statusRequest.Status); err != nil {
+		if err := agendaEntry.UpdateStatus(req.Context(), statusRequest.Id, statusRequest.Status); err != nil {
+			if errors.Is(err, db.ErrInvalidTransition) {
+				writeJSONResponse(resp, http.StatusConflict, ErrorResponse{Message: err.Error()})
+				return
+			}
 			log.Printf("Internal Error while updated Status %v", err)
 			writeJSONResponse(resp, http.StatusInternalServerError, ErrorResponse{
 				Message: "Internal Error while updated Status",
@@ -607,39 +766,75 @@ func agendaStatusHandler(resp http.ResponseWriter, req *http.Request) {
 
 }
 
-func handlePoster(agendaEntry *db.AgendaEntry) {
-	if strings.HasPrefix(agendaEntry.Poster, "/tmp/") {
-		tmpPoster, err := os.Open(agendaEntry.Poster)
-		if err != nil {
-			log.Printf("Failed to open file %s", agendaEntry.Poster)
-			agendaEntry.Poster = "" // reset the path, let the front handle that
-		} else {
-			defer tmpPoster.Close()
-			if dir, err := os.Getwd(); err == nil {
-				newFilename := internal.TransformPosterName(filepath.Base(tmpPoster.Name()))
-				dest := filepath.Join(dir, "uploads", newFilename)
-
-				// use copy
-				log.Printf("Created file %s!", dest)
-				destFile, err := os.Create(dest)
-				if err != nil {
-					log.Printf("Error while creating file %v", err)
-					return
-				}
-				defer destFile.Close()
-				// copy
-				_, err = io.Copy(destFile, tmpPoster)
-				if err != nil {
-					log.Printf("Error while coping %s to %s, %v !", tmpPoster.Name(), destFile.Name(), err)
-					return
-				}
-				os.Remove(tmpPoster.Name())
-				agendaEntry.Poster = filepath.Base(dest)
+// handlePoster publishes a new poster in its sizes, the original is kept when it can't be processed
+func handlePoster(ctx context.Context, store storage.Storage, agendaEntry *db.AgendaEntry) {
+	if !strings.HasPrefix(agendaEntry.Poster, "/tmp/") {
+		return
+	}
+	variants, err := processPoster(ctx, store, agendaEntry.Poster)
+	if err == nil {
+		agendaEntry.Poster = variants[len(variants)-1].File
+		agendaEntry.PosterVariants = variants
+		return
+	}
+	log.Printf("handlePoster::processPoster %s %v", agendaEntry.Poster, err)
+	agendaEntry.Poster = persistUpload(ctx, store, agendaEntry.Poster)
+	agendaEntry.PosterVariants = nil
+}
 
-			}
-		}
+// processPoster stores the sizes of a poster waiting in /tmp/
+func processPoster(ctx context.Context, store storage.Storage, path string) ([]db.PosterVariant, error) {
+	tmpFile, _, err := store.Get(ctx, tmpKey(path))
+	if err != nil {
+		return nil, err
+	}
+	defer tmpFile.Close()
+	processed, err := imaging.ProcessPoster(ctx, tmpFile, store)
+	if err != nil {
+		return nil, err
+	}
+	variants := make([]db.PosterVariant, 0, len(processed))
+	for _, variant := range processed {
+		variants = append(variants, db.PosterVariant{Size: variant.Size, File: variant.File, Width: variant.Width, Height: variant.Height})
+	}
+	store.Delete(ctx, tmpKey(path))
+	return variants, nil
+}
+
+// tmpKey the key of an upload waiting for publication, the front knows it as /tmp/poster123.jpg
+func tmpKey(path string) string {
+	return storage.TmpPrefix + filepath.Base(path)
+}
+
+// persistUpload moves a file uploaded in /tmp/ to the published uploads and returns its content key,
+// an identical file is stored once
+func persistUpload(ctx context.Context, store storage.Storage, path string) string {
+	if !strings.HasPrefix(path, "/tmp/") {
+		return path
 	}
+	tmpFile, _, err := store.Get(ctx, tmpKey(path))
+	if err != nil {
+		log.Printf("Failed to open file %s %v", path, err)
+		return "" // reset the path, let the front handle that
+	}
+	data, err := io.ReadAll(tmpFile)
+	tmpFile.Close()
+	if err != nil {
+		log.Printf("Error while reading %s, %v !", path, err)
+		return path
+	}
+	key, created, err := storage.PutContent(ctx, store, data, storage.DetectExtension(data, path))
+	if err != nil {
+		log.Printf("Error while saving %s, %v !", path, err)
+		return path
+	}
+	if created {
+		log.Printf("Created file %s!", key)
+	}
+	store.Delete(ctx, tmpKey(path))
+	return key
 }
+
 func createAgendaEntry(req *http.Request, agendyEntry *db.AgendaEntry) (bool, error) {
 	agendaRepository, err := GetRepository[repository.AgendaRepository](req.Context(), agendaRepoKey)
 	if err != nil {
@@ -659,6 +854,10 @@ func agendaHandler(resp http.ResponseWriter, req *http.Request) {
 		urlPaths := strings.Split(req.URL.Path, "/")
 		if len(urlPaths) > 3 {
 			agendaID := urlPaths[3]
+			if strings.HasSuffix(agendaID, ".ics") {
+				agendaEntryICS(resp, req, strings.TrimSuffix(agendaID, ".ics"))
+				return
+			}
 			if agendaID != "" {
 				agendaRepo, err := GetRepository[repository.AgendaRepository](req.Context(), agendaRepoKey)
 				if err != nil {
@@ -697,7 +896,12 @@ func agendaHandler(resp http.ResponseWriter, req *http.Request) {
 			return
 		}
 		// Persist - BUILD AN INJECTOR
-		handlePoster(&agendaEntry)
+		store, err := GetRepository[storage.Storage](req.Context(), storageKey)
+		if err != nil {
+			http.Error(resp, "Fail to get storage", http.StatusInternalServerError)
+			return
+		}
+		handlePoster(req.Context(), store, &agendaEntry)
 		_, err = createAgendaEntry(req, &agendaEntry)
 		if err != nil {
 			return
@@ -727,8 +931,17 @@ func agendaHandler(resp http.ResponseWriter, req *http.Request) {
 			return
 		}
 		// Deal with poster
-		handlePoster(&agendaEntry)
+		store, err := GetRepository[storage.Storage](req.Context(), storageKey)
+		if err != nil {
+			http.Error(resp, "Fail to get storage", http.StatusInternalServerError)
+			return
+		}
+		handlePoster(req.Context(), store, &agendaEntry)
 		err = agendaRepository.Update(req.Context(), agendaEntry)
+		if errors.Is(err, db.ErrInvalidTransition) {
+			writeJSONResponse(resp, http.StatusConflict, ErrorResponse{Message: err.Error(), Error: true})
+			return
+		}
 		if err != nil {
 			writeJSONResponse(resp, http.StatusInternalServerError, ErrorResponse{
 				Message: "Failed to update entity",
@@ -746,19 +959,6 @@ func agendaHandler(resp http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func taskHandler(resp http.ResponseWriter, req *http.Request) {
-	if req.Method != http.MethodPost {
-		http.Error(resp, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	var scrapingRequest types.ScrapingTask
-	err := json.NewDecoder(req.Body).Decode(&scrapingRequest)
-	if err != nil {
-		http.Error(resp, "Bad request", http.StatusBadRequest)
-	}
-	fmt.Println(scrapingRequest)
-}
-
 func healthHandler(resp http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	idUser := ctx.Value(userIDKey)
@@ -813,8 +1013,16 @@ func uploadHandler(resp http.ResponseWriter, req *http.Request) {
 		})
 		return
 	}
-	//// image.DecodeConfig reads just enough to determine format/dimensions.
-	_, format, err := image.DecodeConfig(file)
+	// reads just enough to determine format/dimensions, the poster is processed on publish
+	format, err := imaging.CheckImage(file)
+	if errors.Is(err, imaging.ErrImageTooLarge) {
+		log.Printf("Image is too large %v", err)
+		writeJSONResponse(resp, http.StatusRequestEntityTooLarge, ErrorResponse{
+			Error:   true,
+			Message: "Uploaded image is too large",
+		})
+		return
+	}
 	if err != nil {
 		// This file is NOT a valid image format.
 		log.Printf("Invalid or unsupported image file\n %v", err)
@@ -828,20 +1036,27 @@ func uploadHandler(resp http.ResponseWriter, req *http.Request) {
 	if seeker, ok := file.(io.Seeker); ok {
 		seeker.Seek(0, 0)
 	}
-
-	// save file to tmp
-	tmpPath, err := os.CreateTemp("/tmp", fmt.Sprintf("poster*%s", filepath.Ext(handler.Filename)))
+	store, err := GetRepository[storage.Storage](req.Context(), storageKey)
 	if err != nil {
-		log.Println("Error while creating a tmp file", err)
-		writeJSONResponse(resp, http.StatusBadRequest, ErrorResponse{
+		writeJSONResponse(resp, http.StatusInternalServerError, ErrorResponse{
 			Error:   true,
-			Message: "Error while creating a tmp file",
+			Message: "Fail to get storage",
 		})
 		return
 	}
 
-	// Copy file contents
-	_, err = io.Copy(tmpPath, file)
+	// save file to tmp, published with the entry. The extension is the decoded format, never the one
+	// of the client: an image/html polyglot named x.html would be served as a page
+	extension := storage.FormatExtension(format)
+	if extension == "" {
+		writeJSONResponse(resp, http.StatusInternalServerError, ErrorResponse{
+			Error:   true,
+			Message: "Invalid or unsupported image file",
+		})
+		return
+	}
+	tmpName := "poster" + uuid.New().String() + extension
+	err = store.Put(req.Context(), tmpKey(tmpName), file, storage.ContentType(tmpName))
 	if err != nil {
 		log.Println("Error while saving the file %v", err)
 		writeJSONResponse(resp, http.StatusBadRequest, ErrorResponse{
@@ -851,17 +1066,36 @@ func uploadHandler(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// preview of the upload
+	previewURL, err := store.SignedURL(req.Context(), tmpKey(tmpName), time.Hour)
+	if err != nil {
+		log.Printf("SignedURL %v", err)
+	}
+
 	writeJSONResponse(resp, http.StatusOK, OkResponse{
 		Success: true,
 		Data: map[string]string{
-			"filename": tmpPath.Name(),
+			"filename": "/tmp/" + tmpName,
+			"url":      previewURL,
 			"size":     fmt.Sprintf("%d bytes", handler.Size),
 		},
 	})
 }
 
+// AdminActionHandler moderation of the submissions, found by their edit token
+//
+//	GET  /agenda/admin?token=                                     status, moderator, comment and notes of the submission
+//	POST /agenda/admin {"action": "publish", "formData": {...}}   publish the submission
+//	POST /agenda/admin {"action": "reject", "reason": ""}         reject, the reason is emailed to the submitter
+//	POST /agenda/admin {"action": "request_changes", "comment": ""} the comment is shown on the public edit page
+//	POST /agenda/admin {"action": "assign", "assignedTo": 1}      assign to a moderator
+//	POST /agenda/admin {"action": "note", "note": ""}             internal note of the moderator
 func AdminActionHandler(service *ServiceMiddleWare) HandlerFunc {
 	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			getModeration(service, writer, req)
+			return
+		}
 		var actionRequest PublishActionRequest
 		err := json.NewDecoder(req.Body).Decode(&actionRequest)
 		if err != nil {
@@ -874,70 +1108,100 @@ func AdminActionHandler(service *ServiceMiddleWare) HandlerFunc {
 			return
 		}
 
-		if actionRequest.Action == "publish" {
-			formSubmission, err := service.queries.GetSubmissionByToken(req.Context(), gendb.GetSubmissionByTokenParams{EditToken: actionRequest.Token})
-			if err != nil {
-				log.Printf("GetSubmissionByToken: %v", err)
-				writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: "Submission Not found"})
-				return
-			}
-			agendaEntry := actionRequest.FormData
-			issues := validators.AgendaEntrySchema.Validate(&agendaEntry)
-			if issues != nil {
-				log.Printf("Validation error: %v", issues)
-				writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: "Form is not valid"})
-				return
-			}
-			// Deal with poster // if it has changed
-			handlePoster(&agendaEntry)
-			// We keep the same submission ID && update status
-			agendaEntry.ID = formSubmission.ID
-			agendaEntry.Status = db.Status_Active
-
-			// We update the submission status with the updated data
-			agendaEntryData, err := json.Marshal(agendaEntry)
-			if err != nil {
-				log.Printf("Error: %v", err)
-				writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: err.Error()})
-				return
+		switch actionRequest.Action {
+		case "reject", "request_changes":
+			moderateSubmission(service, writer, req, actionRequest)
+		case "assign":
+			assignSubmission(service, writer, req, actionRequest)
+		case "note":
+			addSubmissionNote(service, writer, req, actionRequest)
+		case "publish":
+			publishSubmission(service, writer, req, actionRequest)
+		default:
+			writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: fmt.Sprintf("Unknown action %s", actionRequest.Action)})
+		}
+	}
+}
+
+func publishSubmission(service *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request, actionRequest PublishActionRequest) {
+	formSubmission, err := service.queries.GetSubmissionByToken(req.Context(), gendb.GetSubmissionByTokenParams{EditToken: actionRequest.Token})
+	if err != nil {
+		log.Printf("GetSubmissionByToken: %v", err)
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: "Submission Not found"})
+		return
+	}
+	if !canMoveSubmission(writer, formSubmission.ID, formSubmission.Status, db.SubmissionStatus_Active) {
+		return
+	}
+	agendaEntry := actionRequest.FormData
+	issues := validators.AgendaEntrySchema.Validate(&agendaEntry)
+	if issues != nil {
+		log.Printf("Validation error: %v", issues)
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: "Form is not valid"})
+		return
+	}
+	// notify user, the submission is not published without its email
+	frontUrl := os.Getenv("FRONT_URL")
+	accepted, err := service.mailer.Render("submission_accepted", formSubmission.Email, "Afromémo - Publication de votre événement", utils.Record{
+		"EditURL":    fmt.Sprintf("%s/agenda/public/%s/edit", frontUrl, formSubmission.EditToken),
+		"CancelURL":  fmt.Sprintf("%s/agenda/public/%s/cancel", frontUrl, formSubmission.CancelToken),
+		"DetailURL":  fmt.Sprintf("%s/agenda/%s", frontUrl, formSubmission.ID),
+		"EventTitle": agendaEntry.Title,
+	})
+	if err != nil {
+		log.Printf("Render submission_accepted: %v", err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while preparing the email"})
+		return
+	}
+	// Deal with poster // if it has changed
+	handlePoster(req.Context(), service.storage, &agendaEntry)
+	// We keep the same submission ID && update status
+	agendaEntry.ID = formSubmission.ID
+	agendaEntry.Status = db.Status_Active
+
+	// We update the submission status with the updated data
+	agendaEntryData, err := json.Marshal(agendaEntry)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: err.Error()})
+		return
+	}
+	// the agenda entry, the submission and the email are saved together or not at all
+	err = service.transactWithMail(req.Context(), &accepted, func(tx *sql.Tx) error {
+		agendaRepository := service.agendaRepository.WithTx(tx)
+		// We try to find the agenda item
+		agenda, err := agendaRepository.FindByID(req.Context(), formSubmission.ID)
+		switch {
+		case err == nil:
+			fmt.Printf("linked Agenda found %s \n", agenda.ID)
+			if err := agendaRepository.Update(req.Context(), agendaEntry); err != nil {
+				return fmt.Errorf("update agenda entry: %w", err)
 			}
-			// We try to find the agenda item
-			agenda, err := service.agendaRepository.FindByID(req.Context(), formSubmission.ID)
-
-			if !agenda.IsZero() && agenda.ID != "" {
-				fmt.Printf("linked Agenda found %s \n", agenda.ID)
-				service.agendaRepository.Update(req.Context(), agendaEntry)
-			} else {
-				// Create a new agenda entry
-				_, err = service.agendaRepository.Create(req.Context(), &agendaEntry)
-				if err != nil {
-					fmt.Printf("error::createAgendaEntry %v", err)
-					writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{
-						Message: err.Error(),
-					})
-					return
-				}
+		case errors.Is(err, sql.ErrNoRows):
+			// Create a new agenda entry
+			if _, err := agendaRepository.Create(req.Context(), &agendaEntry); err != nil {
+				return fmt.Errorf("create agenda entry: %w", err)
 			}
-			service.queries.UpdateSubmissionStatus(req.Context(), gendb.UpdateSubmissionStatusParams{
-				Status:    "active",
-				Data:      string(agendaEntryData),
-				EditToken: formSubmission.EditToken,
-			})
-
-			// notify user
-			frontUrl := os.Getenv("FRONT_URL")
-			err = service.mailer.SendMail("submission_accepted", formSubmission.Email, "Afromémo - Publication de votre événement", utils.Record{
-				"EditURL":    fmt.Sprintf("%s/agenda/public/%s/edit", frontUrl, formSubmission.EditToken),
-				"CancelURL":  fmt.Sprintf("%s/agenda/public/%s/cancel", frontUrl, formSubmission.CancelToken),
-				"DetailURL":  fmt.Sprintf("%s/agenda/%s", frontUrl, formSubmission.ID),
-				"EventTitle": agendaEntry.Title,
-			})
-
-			writeJSONResponse(writer, http.StatusOK, OkResponse{
-				Message: "ok",
-			})
+		default:
+			return fmt.Errorf("find agenda entry: %w", err)
 		}
+		return service.queries.WithTx(tx).UpdateSubmissionStatus(req.Context(), gendb.UpdateSubmissionStatusParams{
+			Status:    string(db.SubmissionStatus_Active),
+			Data:      string(agendaEntryData),
+			EditToken: formSubmission.EditToken,
+		})
+	})
+	if err != nil {
+		log.Printf("publishSubmission: %v", err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while publishing the submission"})
+		return
 	}
+	// committed, the caches of the agenda are built again
+	repository.AgendaChanged()
+
+	writeJSONResponse(writer, http.StatusOK, OkResponse{
+		Message: "ok",
+	})
 }
 
 // creation du token store
@@ -993,10 +1257,40 @@ func StartApiServer(portNumber int) {
 	// Handle Migration
 	db.ApplyMigration(localDb)
 
-	ea := utils.NewEventArchiver(localDb, time.Hour*24)
-	ea.Start(context.Background())
+	retention := utils.DefaultTrashRetention
+	if days := os.Getenv("TRASH_RETENTION_DAYS"); days != "" {
+		retentionDays, err := strconv.Atoi(days)
+		if err != nil || retentionDays <= 0 {
+			log.Fatalf("TRASH_RETENTION_DAYS must be a number of days")
+		}
+		retention = time.Duration(retentionDays) * 24 * time.Hour
+	}
+	ea := utils.NewEventArchiver(localDb, time.Hour*24).WithRetention(retention)
+
+	store, err := storage.FromEnv()
+	if err != nil {
+		log.Fatalf("Storage: %v", err)
+	}
+	serviceMiddleWare := NewServiceMiddleWare(localDb).WithStorage(store)
 
-	serviceMiddleWare := NewServiceMiddleWare(localDb)
+	tmpUploadTTL := utils.DefaultTmpUploadTTL
+	if hours := os.Getenv("TMP_UPLOAD_TTL_HOURS"); hours != "" {
+		ttlHours, err := strconv.Atoi(hours)
+		if err != nil || ttlHours <= 0 {
+			log.Fatalf("TMP_UPLOAD_TTL_HOURS must be a number of hours")
+		}
+		tmpUploadTTL = time.Duration(ttlHours) * time.Hour
+	}
+	sweeper := utils.NewUploadSweeper(localDb, store, time.Hour*6).WithTmpTTL(tmpUploadTTL)
+	// scraping runs
+	runnerCtx, stopRunner := context.WithCancel(context.Background())
+	ea.Start(runnerCtx)
+	sweeper.Start(runnerCtx)
+	serviceMiddleWare.scrapingRunner.Start(runnerCtx)
+	// the outbox emails
+	serviceMiddleWare.mailer.Start(runnerCtx)
+	scheduler := utils.NewScrapingScheduler(localDb, serviceMiddleWare.scrapingRunner, time.Minute)
+	scheduler.Start(runnerCtx)
 	agendaHandler := serviceMiddleWare.Handler(agendaHandler)
 	loginHandler := withCORS(loginWithServices(serviceMiddleWare))
 
@@ -1010,13 +1304,22 @@ func StartApiServer(portNumber int) {
 	// event broker
 	serviceMiddleWare.eventBroker.Start(mux)
 
-	tmpImgServer := NewTmpFileServer("/tmp")
 	// auth routes
 	mux.HandleFunc("/api/login", loginHandler)
 	mux.HandleFunc("/api/health", healthHandler)
 	mux.HandleFunc("/api/refreshToken", refreshTokenHandler)
 	mux.HandleFunc("/api/agenda/", withCORS(agendaHandler))
 	mux.HandleFunc("/api/agenda", withCORS(agendaHandler))
+	mux.HandleFunc("/api/agenda/facets", withCORS(AgendaFacetsHandler(serviceMiddleWare)))
+	mux.HandleFunc("/api/agenda/search", withCORS(SearchHandler(serviceMiddleWare)))
+	mux.HandleFunc("/api/venues", withCORS(VenuesHandler(serviceMiddleWare)))
+	mux.HandleFunc("/api/venues/", withCORS(VenuesHandler(serviceMiddleWare)))
+	mux.HandleFunc("/api/organizers", withCORS(OrganizersHandler(serviceMiddleWare)))
+	mux.HandleFunc("/api/organizers/", withCORS(OrganizersHandler(serviceMiddleWare)))
+	// calendar subscription
+	mux.HandleFunc("/api/agenda.ics", withCORS(AgendaICSHandler(serviceMiddleWare)))
+	mux.HandleFunc("/api/feeds/upcoming.rss", withCORS(UpcomingFeedHandler(serviceMiddleWare)))
+	mux.HandleFunc("/api/feeds/upcoming.atom", withCORS(UpcomingFeedHandler(serviceMiddleWare)))
 
 	// token
 	mux.HandleFunc("/api/csrfToken", withCORS(csrfTokenHandler))
@@ -1025,23 +1328,20 @@ func StartApiServer(portNumber int) {
 	mux.HandleFunc("/api/submissions/confirm", withCORS(ConfirmSubmission(serviceMiddleWare)))
 	mux.HandleFunc("/api/submissions/delete", withCORS(SubmissionHandler(serviceMiddleWare)))
 	mux.HandleFunc("/api/submissions/diff/", withCORS(GetSubmissionDiff(serviceMiddleWare)))
+	mux.HandleFunc("/api/submissions/lifecycle", withCORS(SubmissionLifecycleHandler(serviceMiddleWare)))
+	// visitors following an event
+	mux.HandleFunc("/api/subscriptions", withCORS(EventSubscriptionHandler(serviceMiddleWare)))
+	mux.HandleFunc("/api/subscriptions/", withCORS(EventSubscriptionHandler(serviceMiddleWare)))
 
 	mux.HandleFunc("/api/submissions/", withCORS(SubmissionHandler(serviceMiddleWare)))
 	mux.HandleFunc("/api/submissions", withCORS(HandlerVisitorForm(serviceMiddleWare)))
 
 	// [token] e74341c8-a7ab-40ed-b404-38e6406e3249
 	// to remove
-	mux.HandleFunc("/api/upload", withCORS(uploadHandler))
+	mux.HandleFunc("/api/upload", withCORS(serviceMiddleWare.Handler(uploadHandler)))
 
-	// static file and image
-	fsHandler := http.FileServer(http.Dir("./uploads"))
-	fsStrippedHandler := http.StripPrefix("/images/", fsHandler)
-	corsProtectedFs := withCORS(func(resp http.ResponseWriter, req *http.Request) {
-		fsStrippedHandler.ServeHTTP(resp, req)
-	})
-	mux.Handle("/images/", http.HandlerFunc(corsProtectedFs))
-	// handle /images/tmp/
-	mux.HandleFunc("/images/tmp/", tmpImgServer.ServeHandler)
+	// posters and logos, /images/tmp/ the uploads not yet published
+	mux.HandleFunc("/images/", withCORS(ImageHandler(serviceMiddleWare)))
 
 	// handle protected routes
 	protectedRoutes := http.NewServeMux()
@@ -1050,9 +1350,45 @@ func StartApiServer(portNumber int) {
 	userHandler = withCORS(userHandler)
 	agendaHandler = withCORS(agendaHandler)
 
-	protectedRoutes.HandleFunc("/scraper-task", taskHandler)
+	scrapingTaskHandler := ScrapingTaskHandler(serviceMiddleWare)
+	protectedRoutes.HandleFunc("/scraper-task", scrapingTaskHandler)
+	protectedRoutes.HandleFunc("/scraper-task/", scrapingTaskHandler)
 	// Agenda
 	protectedRoutes.HandleFunc("/agenda", agendaHandler)
+	revisionHandler := AgendaRevisionHandler(serviceMiddleWare)
+	lifecycleHandler := AgendaLifecycleHandler(serviceMiddleWare)
+	duplicateHandler := AgendaDuplicateHandler(serviceMiddleWare)
+	// /agenda/{id}/{action}
+	protectedRoutes.HandleFunc("/agenda/", withCORS(func(resp http.ResponseWriter, req *http.Request) {
+		urlPaths := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		action := ""
+		if len(urlPaths) > 2 {
+			action = urlPaths[2]
+		}
+		switch action {
+		case "lifecycle":
+			lifecycleHandler(resp, req)
+		case "duplicates", "merge":
+			duplicateHandler(resp, req)
+		default:
+			revisionHandler(resp, req)
+		}
+	}))
+
+	venueHandler := VenueHandler(serviceMiddleWare)
+	protectedRoutes.HandleFunc("/venues", venueHandler)
+	protectedRoutes.HandleFunc("/venues/", venueHandler)
+	organizerHandler := OrganizerHandler(serviceMiddleWare)
+	protectedRoutes.HandleFunc("/organizers", organizerHandler)
+	protectedRoutes.HandleFunc("/organizers/", organizerHandler)
+
+	trashHandler := withCORS(TrashHandler(serviceMiddleWare))
+	protectedRoutes.HandleFunc("/trash", trashHandler)
+	protectedRoutes.HandleFunc("/trash/", trashHandler)
+
+	outboxHandler := withCORS(OutboxHandler(serviceMiddleWare))
+	protectedRoutes.HandleFunc("/outbox", outboxHandler)
+	protectedRoutes.HandleFunc("/outbox/", outboxHandler)
 
 	protectedRoutes.HandleFunc("/user/", userHandler)
 	protectedRoutes.HandleFunc("/user", userHandler)
@@ -1061,6 +1397,7 @@ func StartApiServer(portNumber int) {
 	protectedRoutes.HandleFunc("/submissions", SubmissionHandler(serviceMiddleWare))
 
 	protectedRoutes.HandleFunc("/agenda/admin", withCORS(AdminActionHandler(serviceMiddleWare)))
+	protectedRoutes.HandleFunc("/agenda/import-ics", ImportICSHandler(serviceMiddleWare))
 	// agenda/publication?action=publish
 	// Post formsubmission
 
@@ -1098,6 +1435,13 @@ func StartApiServer(portNumber int) {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown after timeout: %v", err)
 	}
+	// stop scheduling before interrupting the running scrapings
+	stopRunner()
+	scheduler.Stop()
+	serviceMiddleWare.scrapingRunner.Stop()
+	serviceMiddleWare.mailer.Stop()
+	sweeper.Stop()
+	ea.Stop()
 	log.Println("Server successfully exited.")
 
 }
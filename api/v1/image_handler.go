@@ -0,0 +1,97 @@
+package api
+
+import (
+	"dpatrov/scraper/internal/storage"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// immutableCache the content of a content key never changes
+const immutableCache = "public, max-age=31536000, immutable"
+
+// ImageHandler GET /images/{key} serves the posters and logos from the storage,
+// /images/tmp/{name} the uploads waiting for publication. Only the image types are served, with nosniff. The posters stored under their hash are
+// cached for good, with the hash as ETag.
+func ImageHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key, err := storage.CleanKey(strings.TrimPrefix(req.URL.Path, "/images/"))
+		if err != nil {
+			http.Error(writer, "Invalid filename", http.StatusBadRequest)
+			return
+		}
+		// only images, whatever the key or its stored content type
+		if !storage.IsImage(key) {
+			http.NotFound(writer, req)
+			return
+		}
+		writer.Header().Set("X-Content-Type-Options", "nosniff")
+		etag := ""
+		if storage.IsContentKey(key) {
+			etag = `"` + storage.ContentHash(key) + `"`
+			// the client has it, no need to read the storage
+			if matchesETag(req.Header.Get("If-None-Match"), etag) {
+				writer.Header().Set("Cache-Control", immutableCache)
+				writer.Header().Set("ETag", etag)
+				writer.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		body, object, err := services.storage.Get(req.Context(), key)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.NotFound(writer, req)
+			return
+		}
+		if err != nil {
+			log.Printf("ImageHandler::Get %v", err)
+			http.Error(writer, "Fail to read the image", http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+		switch {
+		case etag != "":
+			writer.Header().Set("Cache-Control", immutableCache)
+			writer.Header().Set("ETag", etag)
+		case strings.HasPrefix(key, storage.TmpPrefix):
+			writer.Header().Set("Cache-Control", "no-store")
+		default:
+			// the posters named after the upload may be replaced
+			writer.Header().Set("Cache-Control", "public, max-age=3600")
+		}
+		if seeker, ok := body.(io.ReadSeeker); ok {
+			// ranges and If-Modified-Since of the local files
+			writer.Header().Set("Content-Type", storage.ContentType(key))
+			http.ServeContent(writer, req, key, object.ModTime, seeker)
+			return
+		}
+		writer.Header().Set("Content-Type", storage.ContentType(key))
+		if object.Size >= 0 {
+			writer.Header().Set("Content-Length", strconv.FormatInt(object.Size, 10))
+		}
+		if !object.ModTime.IsZero() {
+			writer.Header().Set("Last-Modified", object.ModTime.UTC().Format(http.TimeFormat))
+		}
+		if req.Method == http.MethodHead {
+			return
+		}
+		io.Copy(writer, body)
+	}
+}
+
+// matchesETag If-None-Match: "a", W/"b" or *
+func matchesETag(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"dpatrov/scraper/internal/utils"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const maxImportBodySize = 10 << 20
+
+// ImportICSRequest imports the calendar at Url, Defaults fill the fields missing in the calendar
+type ImportICSRequest struct {
+	Url      string            `json:"url"`
+	Defaults map[string]string `json:"defaults"`
+}
+
+// ImportICSHandler POST /agenda/import-ics
+//
+//	Content-Type: text/calendar -> the body is the calendar, defaults are read from the query (?category=concert),
+//	only the agenda entry fields are accepted
+//	Content-Type: application/json -> ImportICSRequest
+func ImportICSHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeJSONResponse(writer, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			return
+		}
+		req.Body = http.MaxBytesReader(writer, req.Body, maxImportBodySize)
+		importer := utils.NewICSImporter(&services.agendaRepository)
+
+		var result *utils.RunResult
+		var err error
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if mediaType == "text/calendar" {
+			defaults := map[string]string{}
+			for field := range req.URL.Query() {
+				defaults[field] = req.URL.Query().Get(field)
+			}
+			if err := utils.ValidateICSDefaults(defaults); err != nil {
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+				return
+			}
+			result, err = importer.Import(req.Context(), req.Body, "", defaults)
+		} else {
+			var importRequest ImportICSRequest
+			if err := json.NewDecoder(req.Body).Decode(&importRequest); err != nil {
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Bad request"})
+				return
+			}
+			// never read a local file from the api
+			if !slices.ContainsFunc([]string{"http://", "https://", "webcal://"}, func(scheme string) bool {
+				return strings.HasPrefix(importRequest.Url, scheme)
+			}) {
+				writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: "Invalid calendar url"})
+				return
+			}
+			if err := utils.ValidateICSDefaults(importRequest.Defaults); err != nil {
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: err.Error()})
+				return
+			}
+			result, err = importer.ImportSource(req.Context(), importRequest.Url, importRequest.Defaults)
+		}
+		if err != nil {
+			log.Printf("ImportICSHandler %v", err)
+			writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: err.Error()})
+			return
+		}
+		writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Calendar imported", Data: result})
+	}
+}
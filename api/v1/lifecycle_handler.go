@@ -0,0 +1,251 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// LifecycleRequest a postponed event gets its new start date (2006-01-02) when it is known
+type LifecycleRequest struct {
+	Token     string `json:"token,omitempty"`
+	Lifecycle string `json:"lifecycle"`
+	StartDate string `json:"startdate,omitempty"`
+}
+
+type SubscriptionRequest struct {
+	EntryID string `json:"entryId"`
+	Email   string `json:"email"`
+}
+
+// AgendaLifecycleHandler protected route
+//
+//	POST /agenda/{id}/lifecycle {"lifecycle": "postponed", "startdate": "2025-11-20"}
+func AgendaLifecycleHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		urlPaths := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		if len(urlPaths) != 3 || urlPaths[2] != "lifecycle" {
+			writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: "Not found"})
+			return
+		}
+		if req.Method != http.MethodPost {
+			writeJSONResponse(writer, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			return
+		}
+		var lifecycleRequest LifecycleRequest
+		if err := json.NewDecoder(req.Body).Decode(&lifecycleRequest); err != nil {
+			writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Invalid JSON payload"})
+			return
+		}
+		updateLifecycle(services, writer, req, urlPaths[1], lifecycleRequest)
+	}
+}
+
+// SubmissionLifecycleHandler POST /api/submissions/lifecycle, the submitter of a published event
+// changes its lifecycle with the edit token
+func SubmissionLifecycleHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeJSONResponse(writer, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			return
+		}
+		var lifecycleRequest LifecycleRequest
+		if err := json.NewDecoder(req.Body).Decode(&lifecycleRequest); err != nil {
+			writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Invalid JSON payload"})
+			return
+		}
+		submission, ok := findSubmission(services, writer, req, lifecycleRequest.Token)
+		if !ok {
+			return
+		}
+		if submission.Status != string(db.SubmissionStatus_Active) {
+			writeJSONResponse(writer, http.StatusConflict, ErrorResponse{Message: "The event is not published"})
+			return
+		}
+		// the published entry keeps the id of the submission
+		updateLifecycle(services, writer, req, submission.ID, lifecycleRequest)
+	}
+}
+
+func updateLifecycle(services *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request, id string, lifecycleRequest LifecycleRequest) {
+	var startDate time.Time
+	if lifecycleRequest.StartDate != "" {
+		date, err := time.Parse("2006-01-02", lifecycleRequest.StartDate)
+		if err != nil {
+			writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: "The new date must be formatted as 2006-01-02"})
+			return
+		}
+		startDate = date
+	}
+	// the lifecycle and the notices of the subscribers are saved together or not at all
+	var entry db.AgendaEntry
+	err := services.transactWithMail(req.Context(), nil, func(tx *sql.Tx) error {
+		agendaRepository := services.agendaRepository.WithTx(tx)
+		previous, _ := agendaRepository.FindByID(req.Context(), id)
+		var err error
+		entry, err = agendaRepository.UpdateLifecycle(req.Context(), id, lifecycleRequest.Lifecycle, startDate)
+		if err != nil {
+			return err
+		}
+		if previous.Lifecycle() != entry.Lifecycle() || !previous.StartDate.Equal(entry.StartDate) {
+			return notifySubscribers(req.Context(), services, tx, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		writeLifecycleError(writer, "UpdateLifecycle", err)
+		return
+	}
+	repository.AgendaChanged()
+	writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: fmt.Sprintf("Event %s", entry.Lifecycle()), Data: entry})
+}
+
+// lifecycleNotice the sentence of the notification email
+func lifecycleNotice(entry db.AgendaEntry) string {
+	date := entry.StartDate.Format("02.01.2006")
+	switch entry.Lifecycle() {
+	case db.Lifecycle_Cancelled:
+		return "L'événement est annulé."
+	case db.Lifecycle_Postponed:
+		if entry.OriginalStartDate == "" {
+			return "L'événement est reporté, la nouvelle date sera communiquée prochainement."
+		}
+		return fmt.Sprintf("L'événement est reporté au %s.", date)
+	case db.Lifecycle_SoldOut:
+		return "L'événement affiche complet."
+	case db.Lifecycle_Online:
+		return fmt.Sprintf("L'événement du %s aura lieu en ligne.", date)
+	default:
+		return fmt.Sprintf("L'événement aura lieu le %s.", date)
+	}
+}
+
+// notifySubscribers enqueues the lifecycle of the event for its subscribers in the transaction
+func notifySubscribers(ctx context.Context, services *ServiceMiddleWare, tx *sql.Tx, entry db.AgendaEntry) error {
+	subscriptions, err := services.subscriptionRepository.WithTx(tx).FindByEntry(ctx, entry.ID)
+	if err != nil {
+		return fmt.Errorf("notify the subscribers of %s: %w", entry.ID, err)
+	}
+	frontUrl := os.Getenv("FRONT_URL")
+	subject := fmt.Sprintf("Afromémo - %s", entry.Title)
+	if label := entry.LifecycleLabel(); label != "" {
+		subject = fmt.Sprintf("Afromémo - %s : %s", entry.Title, label)
+	}
+	originalDate := ""
+	if date, err := time.Parse("2006-01-02", entry.OriginalStartDate); err == nil {
+		originalDate = date.Format("02.01.2006")
+	}
+	for _, subscription := range subscriptions {
+		notice, err := services.mailer.Render("event_lifecycle", subscription.Email, subject, utils.Record{
+			"EventTitle":     entry.Title,
+			"Notice":         lifecycleNotice(entry),
+			"OriginalDate":   originalDate,
+			"DetailURL":      fmt.Sprintf("%s/agenda/%s", frontUrl, entry.ID),
+			"UnsubscribeURL": fmt.Sprintf("%s/agenda/subscriptions/%s/unsubscribe", frontUrl, subscription.Token),
+		})
+		if err != nil {
+			return fmt.Errorf("render event_lifecycle for subscription %d: %w", subscription.ID, err)
+		}
+		if err := services.mailer.Enqueue(ctx, tx, notice); err != nil {
+			return fmt.Errorf("enqueue event_lifecycle for subscription %d: %w", subscription.ID, err)
+		}
+	}
+	return nil
+}
+
+// EventSubscriptionHandler public routes, double opt-in: the visitor confirms the email before being notified
+//
+//	POST   /api/subscriptions {"entryId": "...", "email": "..."}  emails the confirmation link
+//	POST   /api/subscriptions/{token}/confirm                     notify the email when the event changes
+//	DELETE /api/subscriptions/{token}                            unsubscribe
+func EventSubscriptionHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		urlPaths := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/subscriptions"), "/"), "/")
+		token := urlPaths[0]
+		switch {
+		case req.Method == http.MethodPost && token == "":
+			if !allowPublicRequest(writer, req) {
+				return
+			}
+			var subscriptionRequest SubscriptionRequest
+			if err := json.NewDecoder(req.Body).Decode(&subscriptionRequest); err != nil {
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Invalid JSON payload"})
+				return
+			}
+			// only the published events are followed
+			entry, err := services.agendaRepository.FindByID(req.Context(), subscriptionRequest.EntryID)
+			if err != nil || entry.Status != db.Status_Active {
+				writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: repository.ErrNoAgendaEntryFound.Error()})
+				return
+			}
+			// the subscription and its confirmation email are saved together or not at all
+			err = services.transactWithMail(req.Context(), nil, func(tx *sql.Tx) error {
+				subscription, err := services.subscriptionRepository.WithTx(tx).Subscribe(req.Context(), entry.ID, subscriptionRequest.Email)
+				if err != nil {
+					return err
+				}
+				// a confirmed email isn't asked again
+				if subscription.ConfirmedAt != nil {
+					return nil
+				}
+				frontUrl := os.Getenv("FRONT_URL")
+				confirmation, err := services.mailer.Render("subscription_confirmation", subscription.Email, fmt.Sprintf("Afromémo - Suivre %s", entry.Title), utils.Record{
+					"EventTitle":      entry.Title,
+					"Email":           subscription.Email,
+					"DetailURL":       fmt.Sprintf("%s/agenda/%s", frontUrl, entry.ID),
+					"ConfirmationURL": fmt.Sprintf("%s/agenda/subscriptions/%s/confirm", frontUrl, subscription.Token),
+				})
+				if err != nil {
+					return fmt.Errorf("render subscription_confirmation: %w", err)
+				}
+				return services.mailer.Enqueue(req.Context(), tx, confirmation)
+			})
+			if err != nil {
+				writeLifecycleError(writer, "Subscribe", err)
+				return
+			}
+			writeJSONResponse(writer, http.StatusAccepted, OkResponse{Success: true, Message: "Confirmation email sent"})
+
+		case req.Method == http.MethodPost && len(urlPaths) == 2 && urlPaths[1] == "confirm":
+			if _, err := services.subscriptionRepository.Confirm(req.Context(), token); err != nil {
+				writeLifecycleError(writer, "Confirm", err)
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Subscribed"})
+
+		case req.Method == http.MethodDelete && token != "" && len(urlPaths) == 1:
+			if err := services.subscriptionRepository.Unsubscribe(req.Context(), token); err != nil {
+				writeLifecycleError(writer, "Unsubscribe", err)
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Unsubscribed"})
+
+		default:
+			writeJSONResponse(writer, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+		}
+	}
+}
+
+func writeLifecycleError(writer http.ResponseWriter, operation string, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNoAgendaEntryFound), errors.Is(err, repository.ErrNoSubscriptionFound):
+		writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, db.ErrInvalidTransition):
+		writeJSONResponse(writer, http.StatusConflict, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, repository.ErrInvalidLifecycle), errors.Is(err, repository.ErrInvalidSubscription):
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: err.Error()})
+	default:
+		log.Printf("LifecycleHandler::%s %v", operation, err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while updating the event"})
+	}
+}
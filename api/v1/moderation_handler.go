@@ -0,0 +1,192 @@
+package api
+
+import (
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	gendb "dpatrov/scraper/internal/gendb"
+	"dpatrov/scraper/internal/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type SubmissionNote struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"userId,omitempty"`
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// SubmissionModeration the moderation state of a submission, the notes are only for the moderators
+type SubmissionModeration struct {
+	ID         string           `json:"id"`
+	Status     string           `json:"status"`
+	AssignedTo int              `json:"assignedTo,omitempty"`
+	Comment    string           `json:"comment"`
+	Notes      []SubmissionNote `json:"notes"`
+}
+
+// canMoveSubmission writes a 409 when the submission can't take the status
+func canMoveSubmission(writer http.ResponseWriter, id string, status string, next db.SubmissionStatus) bool {
+	if err := db.SubmissionTransition(id, status, next); err != nil {
+		writeJSONResponse(writer, http.StatusConflict, ErrorResponse{Message: err.Error()})
+		return false
+	}
+	return true
+}
+
+func findSubmission(service *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request, token string) (gendb.GetSubmissionByTokenRow, bool) {
+	if token == "" {
+		writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Token is missing"})
+		return gendb.GetSubmissionByTokenRow{}, false
+	}
+	submission, err := service.queries.GetSubmissionByToken(req.Context(), gendb.GetSubmissionByTokenParams{EditToken: token})
+	if err == sql.ErrNoRows {
+		writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: "Submission not found"})
+		return submission, false
+	}
+	if err != nil {
+		log.Printf("GetSubmissionByToken: %v", err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while loading the submission"})
+		return submission, false
+	}
+	return submission, true
+}
+
+func getModeration(service *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request) {
+	submission, ok := findSubmission(service, writer, req, req.URL.Query().Get("token"))
+	if !ok {
+		return
+	}
+	notes, err := service.queries.GetSubmissionNotes(req.Context(), submission.ID)
+	if err != nil {
+		log.Printf("GetSubmissionNotes: %v", err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while loading the notes"})
+		return
+	}
+	moderation := SubmissionModeration{
+		ID:         submission.ID,
+		Status:     submission.Status,
+		AssignedTo: int(submission.AssignedTo.Int64),
+		Comment:    submission.ModerationComment,
+		Notes:      []SubmissionNote{},
+	}
+	for _, note := range notes {
+		moderation.Notes = append(moderation.Notes, SubmissionNote{
+			ID:        int(note.ID),
+			UserID:    int(note.UserID.Int64),
+			Body:      note.Body,
+			CreatedAt: note.CreatedAt,
+		})
+	}
+	writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: moderation})
+}
+
+// moderateSubmission rejects the submission or requests changes, the submitter gets the reason or the comment by email
+func moderateSubmission(service *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request, actionRequest PublishActionRequest) {
+	status, comment, template, subject := db.SubmissionStatus_Rejected, actionRequest.Reason, "submission_rejected", "Afromémo - Votre événement n'a pas été publié"
+	if actionRequest.Action == "request_changes" {
+		status, comment, template, subject = db.SubmissionStatus_ChangesRequested, actionRequest.Comment, "submission_changes_requested", "Afromémo - Modifications de votre événement"
+	}
+	if comment = strings.TrimSpace(comment); comment == "" {
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: "The reason or the comment is required"})
+		return
+	}
+	submission, ok := findSubmission(service, writer, req, actionRequest.Token)
+	if !ok || !canMoveSubmission(writer, submission.ID, submission.Status, status) {
+		return
+	}
+	var agendaEntry db.AgendaEntry
+	_ = json.Unmarshal([]byte(submission.Data), &agendaEntry)
+	frontUrl := os.Getenv("FRONT_URL")
+	// the submitter is always told, the submission is not moderated without its email
+	moderated, err := service.mailer.Render(template, submission.Email, subject, utils.Record{
+		"EditURL":    fmt.Sprintf("%s/agenda/public/%s/edit", frontUrl, submission.EditToken),
+		"EventTitle": agendaEntry.Title,
+		"Reason":     comment,
+		"Comment":    comment,
+	})
+	if err != nil {
+		log.Printf("Render %s: %v", template, err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while preparing the email"})
+		return
+	}
+	err = service.updateWithMail(req.Context(), &moderated, func(queries *gendb.Queries) error {
+		// only from the status checked above, another moderator may have moved it since
+		updated, err := queries.UpdateSubmissionModeration(req.Context(), gendb.UpdateSubmissionModerationParams{
+			Status:            string(status),
+			ModerationComment: comment,
+			UpdatedAt:         time.Now(),
+			ID:                submission.ID,
+			Status_2:          submission.Status,
+		})
+		if err == nil && updated == 0 {
+			err = fmt.Errorf("%w: submission %s is no longer %s", db.ErrInvalidTransition, submission.ID, submission.Status)
+		}
+		return err
+	})
+	if errors.Is(err, db.ErrInvalidTransition) {
+		writeJSONResponse(writer, http.StatusConflict, ErrorResponse{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("UpdateSubmissionModeration: %v", err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while updating the submission"})
+		return
+	}
+	writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: fmt.Sprintf("Submission %s", status)})
+}
+
+func assignSubmission(service *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request, actionRequest PublishActionRequest) {
+	submission, ok := findSubmission(service, writer, req, actionRequest.Token)
+	if !ok {
+		return
+	}
+	if actionRequest.AssignedTo != 0 {
+		if _, err := service.userRepository.FindByID(req.Context(), actionRequest.AssignedTo); err != nil {
+			writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: "Moderator not found"})
+			return
+		}
+	}
+	err := service.queries.UpdateSubmissionAssignee(req.Context(), gendb.UpdateSubmissionAssigneeParams{
+		AssignedTo: sql.NullInt64{Int64: int64(actionRequest.AssignedTo), Valid: actionRequest.AssignedTo != 0},
+		UpdatedAt:  time.Now(),
+		ID:         submission.ID,
+	})
+	if err != nil {
+		log.Printf("UpdateSubmissionAssignee: %v", err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while updating the submission"})
+		return
+	}
+	writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Submission assigned"})
+}
+
+func addSubmissionNote(service *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request, actionRequest PublishActionRequest) {
+	note := strings.TrimSpace(actionRequest.Note)
+	if note == "" {
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: "The note is empty"})
+		return
+	}
+	submission, ok := findSubmission(service, writer, req, actionRequest.Token)
+	if !ok {
+		return
+	}
+	userID, _ := req.Context().Value(userIDKey).(int)
+	err := service.queries.CreateSubmissionNote(req.Context(), gendb.CreateSubmissionNoteParams{
+		SubmissionID: submission.ID,
+		UserID:       sql.NullInt64{Int64: int64(userID), Valid: userID != 0},
+		Body:         note,
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		log.Printf("CreateSubmissionNote: %v", err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while saving the note"})
+		return
+	}
+	writeJSONResponse(writer, http.StatusCreated, OkResponse{Success: true, Message: "Note added"})
+}
@@ -0,0 +1,175 @@
+package api
+
+import (
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const organizerEventsLimit = 50
+
+// OrganizerDetail is the organizer page: the upcoming events, then the past ones from the latest
+type OrganizerDetail struct {
+	db.Organizer
+	Upcoming []db.AgendaEntry `json:"upcoming"`
+	Past     []db.AgendaEntry `json:"past"`
+}
+
+// OrganizersHandler public routes, without the contact email
+//
+//	GET /api/organizers        list the organizers
+//	GET /api/organizers/{slug} organizer page with the upcoming and past events
+func OrganizersHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeJSONResponse(writer, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			return
+		}
+		slug := strings.Trim(strings.TrimPrefix(req.URL.Path, "/api/organizers"), "/")
+		if slug == "" {
+			organizers, err := services.organizerRepository.FindAll(req.Context())
+			if err != nil {
+				writeOrganizerError(writer, "FindAll", err)
+				return
+			}
+			for i := range organizers {
+				organizers[i].Email = ""
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: organizers})
+			return
+		}
+		organizer, err := services.organizerRepository.FindBySlug(req.Context(), slug)
+		if err != nil {
+			writeOrganizerError(writer, "FindBySlug", err)
+			return
+		}
+		organizer.Email = ""
+
+		today := time.Now()
+		upcoming, err := services.agendaRepository.Find(req.Context(), repository.AgendaQuery{
+			Statuses:    []db.Status{db.Status_Active},
+			OrganizerID: organizer.ID,
+			From:        today,
+			Limit:       organizerEventsLimit,
+		})
+		if err != nil {
+			writeOrganizerError(writer, "Find", err)
+			return
+		}
+		past, err := services.agendaRepository.Find(req.Context(), repository.AgendaQuery{
+			Statuses:    []db.Status{db.Status_Active, db.Status_Archived},
+			OrganizerID: organizer.ID,
+			To:          today.AddDate(0, 0, -1),
+			Sort:        "-startdate",
+			Limit:       organizerEventsLimit,
+		})
+		if err != nil {
+			writeOrganizerError(writer, "Find", err)
+			return
+		}
+		// an event on several days is upcoming until its end
+		pastEntries := []db.AgendaEntry{}
+		for _, entry := range past.Entries {
+			if entry.EndDate.Before(today.AddDate(0, 0, -1)) {
+				pastEntries = append(pastEntries, entry)
+			}
+		}
+		writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: OrganizerDetail{organizer, upcoming.Entries, pastEntries}})
+	}
+}
+
+// OrganizerHandler protected routes, the logo is an upload of /api/upload
+//
+//	GET    /organizers      list the organizers
+//	POST   /organizers      create an organizer
+//	GET    /organizers/{id} organizer
+//	PUT    /organizers/{id} update the organizer
+//	DELETE /organizers/{id} delete the organizer, the events and submissions are unlinked
+func OrganizerHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		urlPaths := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		if len(urlPaths) == 1 {
+			switch req.Method {
+			case http.MethodGet:
+				organizers, err := services.organizerRepository.FindAll(req.Context())
+				if err != nil {
+					writeOrganizerError(writer, "FindAll", err)
+					return
+				}
+				writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: organizers})
+			case http.MethodPost:
+				var organizer db.Organizer
+				if err := json.NewDecoder(req.Body).Decode(&organizer); err != nil {
+					writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Bad request"})
+					return
+				}
+				organizer.Logo = persistUpload(req.Context(), services.storage, organizer.Logo)
+				if err := services.organizerRepository.Create(req.Context(), &organizer); err != nil {
+					writeOrganizerError(writer, "Create", err)
+					return
+				}
+				writeJSONResponse(writer, http.StatusCreated, OkResponse{Success: true, Message: "Organizer created", Data: organizer})
+			default:
+				writeJSONResponse(writer, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			}
+			return
+		}
+
+		organizerID, err := strconv.Atoi(urlPaths[1])
+		if err != nil || len(urlPaths) > 2 {
+			writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Invalid organizer id"})
+			return
+		}
+		organizer, err := services.organizerRepository.FindByID(req.Context(), organizerID)
+		if err != nil {
+			writeOrganizerError(writer, "FindByID", err)
+			return
+		}
+		switch req.Method {
+		case http.MethodGet:
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: organizer})
+		case http.MethodPut:
+			var update db.Organizer
+			if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Bad request"})
+				return
+			}
+			update.ID = organizer.ID
+			update.Logo = persistUpload(req.Context(), services.storage, update.Logo)
+			if err := services.organizerRepository.Update(req.Context(), update); err != nil {
+				writeOrganizerError(writer, "Update", err)
+				return
+			}
+			organizer, _ = services.organizerRepository.FindByID(req.Context(), organizer.ID)
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Organizer updated", Data: organizer})
+		case http.MethodDelete:
+			if err := services.organizerRepository.Delete(req.Context(), organizer.ID); err != nil {
+				writeOrganizerError(writer, "Delete", err)
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Organizer deleted"})
+		default:
+			writeJSONResponse(writer, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+		}
+	}
+}
+
+func writeOrganizerError(writer http.ResponseWriter, operation string, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNoOrganizerFound):
+		writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, repository.ErrOrganizerExists):
+		writeJSONResponse(writer, http.StatusConflict, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, repository.ErrInvalidOrganizer):
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: err.Error()})
+	default:
+		log.Printf("OrganizerHandler::%s %v", operation, err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while loading the organizers"})
+	}
+}
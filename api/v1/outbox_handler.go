@@ -0,0 +1,87 @@
+package api
+
+import (
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// outboxLimit the emails listed by the outbox
+const outboxLimit = 200
+
+// OutboxHandler protected routes, the emails sent by the mailer
+//
+//	GET  /outbox?status=dead   the emails, the latest first, filtered by pending|sending|sent|dead
+//	GET  /outbox/{id}          the email with its body
+//	POST /outbox/{id}/resend   send the dead or retrying email again right away
+func OutboxHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		urlPaths := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		if req.Method == http.MethodGet && len(urlPaths) == 1 {
+			status := db.OutboxStatus(req.URL.Query().Get("status"))
+			switch status {
+			case "", db.OutboxStatus_Pending, db.OutboxStatus_Sending, db.OutboxStatus_Sent, db.OutboxStatus_Dead:
+			default:
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Unknown status " + string(status)})
+				return
+			}
+			emails, err := services.outboxRepository.Find(req.Context(), status, outboxLimit)
+			if err != nil {
+				writeOutboxError(writer, "Find", err)
+				return
+			}
+			for i := range emails {
+				emails[i].Body = ""
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: emails})
+			return
+		}
+		if len(urlPaths) < 2 {
+			writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: "Not found"})
+			return
+		}
+		id, err := strconv.ParseInt(urlPaths[1], 10, 64)
+		if err != nil {
+			writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: repository.ErrNoOutboxEmailFound.Error()})
+			return
+		}
+		switch {
+		case req.Method == http.MethodGet && len(urlPaths) == 2:
+			email, err := services.outboxRepository.FindByID(req.Context(), id)
+			if err != nil {
+				writeOutboxError(writer, "FindByID", err)
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: email})
+
+		case req.Method == http.MethodPost && len(urlPaths) == 3 && urlPaths[2] == "resend":
+			email, err := services.outboxRepository.Resend(req.Context(), id)
+			if err != nil {
+				writeOutboxError(writer, "Resend", err)
+				return
+			}
+			services.mailer.Wake()
+			email.Body = ""
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Email queued", Data: email})
+
+		default:
+			writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: "Not found"})
+		}
+	}
+}
+
+func writeOutboxError(writer http.ResponseWriter, operation string, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNoOutboxEmailFound):
+		writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, repository.ErrInvalidResend):
+		writeJSONResponse(writer, http.StatusConflict, ErrorResponse{Message: err.Error()})
+	default:
+		log.Printf("OutboxHandler::%s %v", operation, err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while reading the outbox"})
+	}
+}
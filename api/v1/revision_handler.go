@@ -0,0 +1,115 @@
+package api
+
+import (
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RevisionDiff the changes between two revisions of an entry
+type RevisionDiff struct {
+	From    db.AgendaRevision            `json:"from"`
+	To      db.AgendaRevision            `json:"to"`
+	Changes map[string]utils.FieldChange `json:"changes"`
+}
+
+// AgendaRevisionHandler protected routes
+//
+//	GET  /agenda/{id}/revisions                    revisions of the entry, the latest first
+//	GET  /agenda/{id}/revisions/{revisionId}       revision
+//	GET  /agenda/{id}/revisions/diff?from=1&to=2   changes between two revisions
+//	POST /agenda/{id}/revisions/{revisionId}/restore  restore the entry as it was in the revision
+func AgendaRevisionHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		urlPaths := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		if len(urlPaths) < 3 || urlPaths[2] != "revisions" {
+			writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: "Not found"})
+			return
+		}
+		entryID := urlPaths[1]
+		action := strings.Join(urlPaths[3:], "/")
+		switch {
+		case req.Method == http.MethodGet && action == "":
+			revisions, err := services.agendaRepository.FindRevisions(req.Context(), entryID)
+			if err != nil {
+				writeRevisionError(writer, "FindRevisions", err)
+				return
+			}
+			if len(revisions) == 0 {
+				writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: repository.ErrNoRevisionFound.Error()})
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: revisions})
+
+		case req.Method == http.MethodGet && action == "diff":
+			from, ok := revisionFromParam(services, writer, req, entryID, req.URL.Query().Get("from"))
+			if !ok {
+				return
+			}
+			to, ok := revisionFromParam(services, writer, req, entryID, req.URL.Query().Get("to"))
+			if !ok {
+				return
+			}
+			changes := utils.DiffStructs(from.Entry, to.Entry, "updatedat")
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: RevisionDiff{from, to, changes}})
+
+		case req.Method == http.MethodGet && len(urlPaths) == 4:
+			revision, ok := revisionFromParam(services, writer, req, entryID, urlPaths[3])
+			if !ok {
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: revision})
+
+		case req.Method == http.MethodPost && len(urlPaths) == 5 && urlPaths[4] == "restore":
+			revisionID, err := strconv.Atoi(urlPaths[3])
+			if err != nil {
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Invalid revision id"})
+				return
+			}
+			entry, err := services.agendaRepository.Restore(req.Context(), entryID, revisionID)
+			if err != nil {
+				writeRevisionError(writer, "Restore", err)
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Revision restored", Data: entry})
+
+		default:
+			writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: "Not found"})
+		}
+	}
+}
+
+func revisionFromParam(services *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request, entryID string, value string) (db.AgendaRevision, bool) {
+	revisionID, err := strconv.Atoi(value)
+	if err != nil {
+		writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Invalid revision id"})
+		return db.AgendaRevision{}, false
+	}
+	revision, err := services.agendaRepository.FindRevision(req.Context(), entryID, revisionID)
+	if err != nil {
+		writeRevisionError(writer, "FindRevision", err)
+		return revision, false
+	}
+	return revision, true
+}
+
+func writeRevisionError(writer http.ResponseWriter, operation string, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNoRevisionFound), errors.Is(err, sql.ErrNoRows):
+		writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, db.ErrInvalidTransition), errors.Is(err, repository.ErrEntryInTrash):
+		writeJSONResponse(writer, http.StatusConflict, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, repository.ErrNoVenueFound), errors.Is(err, repository.ErrNoOrganizerFound),
+		errors.Is(err, repository.ErrInvalidVenue):
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: err.Error()})
+	default:
+		log.Printf("AgendaRevisionHandler::%s %v", operation, err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while loading the revisions"})
+	}
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"dpatrov/scraper/internal/gendb"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchTerms     = 10
+	// the highlight markers are replaced by <mark> once the text is escaped
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+type SearchResult struct {
+	ID             string  `json:"id"`
+	Title          string  `json:"title"`
+	Subtitle       string  `json:"subtitle"`
+	TitleHighlight string  `json:"titleHighlight"`
+	Snippet        string  `json:"snippet"`
+	StartDate      string  `json:"startdate"`
+	EndDate        string  `json:"enddate"`
+	Poster         string  `json:"poster"`
+	Category       string  `json:"category"`
+	VenueName      string  `json:"venuename"`
+	Place          string  `json:"place"`
+	Rank           float64 `json:"rank"`
+}
+
+// SearchHandler GET /api/agenda/search?q=concert kora genève&limit=20
+//
+// The active entries ranked by relevance, the matches are wrapped in <mark> in titleHighlight and snippet
+func SearchHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(resp http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeJSONResponse(resp, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			return
+		}
+		match := SearchMatch(req.URL.Query().Get("q"))
+		if match == "" {
+			writeJSONResponse(resp, http.StatusBadRequest, ErrorResponse{Message: "q: search terms are required"})
+			return
+		}
+		limit := defaultSearchLimit
+		if value := req.URL.Query().Get("limit"); value != "" {
+			var err error
+			if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxAgendaPageSize {
+				writeJSONResponse(resp, http.StatusBadRequest, ErrorResponse{Message: fmt.Sprintf("limit: expected a number between 1 and %d", maxAgendaPageSize)})
+				return
+			}
+		}
+
+		rows, err := services.queries.SearchAgendaEntries(req.Context(), gendb.SearchAgendaEntriesParams{
+			MarkStart: markStart,
+			MarkEnd:   markEnd,
+			Query:     match,
+			Limit:     int64(limit),
+		})
+		if err != nil {
+			log.Printf("SearchHandler %v", err)
+			writeJSONResponse(resp, http.StatusInternalServerError, ErrorResponse{Message: "Fail to search events"})
+			return
+		}
+		results := make([]SearchResult, 0, len(rows))
+		for _, row := range rows {
+			results = append(results, SearchResult{
+				ID:             row.ID,
+				Title:          row.Title,
+				Subtitle:       textValue(row.Subtitle),
+				TitleHighlight: highlightHTML(row.TitleHighlight),
+				Snippet:        highlightHTML(row.Snippet),
+				StartDate:      row.Startdate,
+				EndDate:        textValue(row.Enddate),
+				Poster:         row.Poster.String,
+				Category:       row.Category.String,
+				VenueName:      textValue(row.Venuename),
+				Place:          row.Place.String,
+				Rank:           row.Rank,
+			})
+		}
+		writeJSONResponse(resp, http.StatusOK, OkResponse{Success: true, Data: results})
+	}
+}
+
+// SearchMatch turns the visitor input into an FTS5 query: every term must match, as a prefix
+//
+//	concert kora Gen -> "concert"* "kora"* "gen"*
+func SearchMatch(input string) string {
+	terms := strings.FieldsFunc(input, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	for i, term := range terms {
+		terms[i] = `"` + strings.ToLower(term) + `"*`
+	}
+	return strings.Join(terms, " ")
+}
+
+// highlightHTML escapes the indexed text, only the highlight markers become html
+func highlightHTML(text string) string {
+	text = html.EscapeString(text)
+	return strings.NewReplacer(markStart, "<mark>", markEnd, "</mark>").Replace(text)
+}
+
+func textValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	}
+	return ""
+}
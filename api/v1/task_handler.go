@@ -0,0 +1,202 @@
+package api
+
+import (
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/scraper"
+	"dpatrov/scraper/internal/types"
+	"dpatrov/scraper/internal/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const runHistoryLimit = 50
+
+// ScrapingTaskRequest is the task definition and its optional cron schedule
+type ScrapingTaskRequest struct {
+	types.ScrapingTask
+	Schedule string `json:"schedule"`
+}
+
+type ScrapingScheduleRequest struct {
+	Schedule string `json:"schedule"`
+}
+
+type ScrapingTaskDetail struct {
+	db.ScrapingTask
+	Runs []db.ScrapingRun `json:"runs"`
+}
+
+// ScrapingTaskHandler
+//
+//	GET  /scraper-task                          list the tasks
+//	POST /scraper-task                          create a task
+//	GET  /scraper-task/{id}                     task with its latest runs
+//	GET  /scraper-task/{id}/runs                run history
+//	POST /scraper-task/{id}/run                 queue a run
+//	POST /scraper-task/{id}/schedule            set the cron schedule, empty to disable
+//	POST /scraper-task/{id}/runs/{runID}/cancel cancel a queued or running run
+func ScrapingTaskHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		urlPaths := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		if len(urlPaths) == 1 {
+			switch req.Method {
+			case http.MethodGet:
+				listScrapingTasks(services, writer, req)
+			case http.MethodPost:
+				createScrapingTask(services, writer, req)
+			default:
+				writeJSONResponse(writer, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			}
+			return
+		}
+
+		taskID, err := strconv.Atoi(urlPaths[1])
+		if err != nil {
+			writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Invalid task id"})
+			return
+		}
+		task, err := services.taskRepository.FindByID(req.Context(), taskID)
+		if err != nil {
+			if errors.Is(err, repository.ErrNoScrapingTaskFound) {
+				writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: err.Error()})
+				return
+			}
+			log.Printf("ScrapingTaskHandler::FindByID %v", err)
+			writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while loading the task"})
+			return
+		}
+
+		action := strings.Join(urlPaths[2:], "/")
+		switch {
+		case req.Method == http.MethodGet && (action == "" || action == "runs"):
+			runs, err := services.taskRepository.FindRunsByTask(req.Context(), task.Id, runHistoryLimit)
+			if err != nil {
+				log.Printf("ScrapingTaskHandler::FindRunsByTask %v", err)
+				writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while loading the runs"})
+				return
+			}
+			if action == "runs" {
+				writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: runs})
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: ScrapingTaskDetail{task, runs}})
+
+		case req.Method == http.MethodPost && action == "run":
+			run, err := services.scrapingRunner.Enqueue(req.Context(), task.Id)
+			if err != nil {
+				log.Printf("ScrapingTaskHandler::Enqueue %v", err)
+				writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while queuing the run"})
+				return
+			}
+			writeJSONResponse(writer, http.StatusAccepted, OkResponse{Success: true, Message: "Run queued", Data: run})
+
+		case req.Method == http.MethodPost && action == "schedule":
+			var scheduleRequest ScrapingScheduleRequest
+			if err := json.NewDecoder(req.Body).Decode(&scheduleRequest); err != nil {
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Bad request"})
+				return
+			}
+			nextRunAt, err := nextScheduledRun(scheduleRequest.Schedule)
+			if err != nil {
+				writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: err.Error()})
+				return
+			}
+			task.Schedule = strings.TrimSpace(scheduleRequest.Schedule)
+			task.NextRunAt = nextRunAt
+			if err := services.taskRepository.UpdateSchedule(req.Context(), task.Id, task.Schedule, task.NextRunAt); err != nil {
+				log.Printf("ScrapingTaskHandler::UpdateSchedule %v", err)
+				writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while saving the schedule"})
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Schedule updated", Data: task})
+
+		case req.Method == http.MethodPost && len(urlPaths) == 5 && urlPaths[2] == "runs" && urlPaths[4] == "cancel":
+			runID, err := strconv.Atoi(urlPaths[3])
+			if err != nil {
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Invalid run id"})
+				return
+			}
+			run, err := services.taskRepository.FindRunByID(req.Context(), runID)
+			if err != nil || run.TaskID != task.Id {
+				writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: repository.ErrNoScrapingRunFound.Error()})
+				return
+			}
+			run, err = services.scrapingRunner.Cancel(req.Context(), runID)
+			if err != nil {
+				if errors.Is(err, utils.ErrRunAlreadyFinished) || errors.Is(err, utils.ErrRunNotRunningHere) {
+					writeJSONResponse(writer, http.StatusConflict, ErrorResponse{Message: err.Error()})
+					return
+				}
+				log.Printf("ScrapingTaskHandler::Cancel %v", err)
+				writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while cancelling the run"})
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Run cancelled", Data: run})
+
+		default:
+			writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: "Not found"})
+		}
+	}
+}
+
+func listScrapingTasks(services *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request) {
+	tasks, err := services.taskRepository.FindAll(req.Context())
+	if err != nil {
+		log.Printf("listScrapingTasks %v", err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while loading the tasks"})
+		return
+	}
+	writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: tasks})
+}
+
+func createScrapingTask(services *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request) {
+	var taskRequest ScrapingTaskRequest
+	if err := json.NewDecoder(req.Body).Decode(&taskRequest); err != nil {
+		writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Bad request"})
+		return
+	}
+	definition := taskRequest.ScrapingTask
+	if err := scraper.ValidateScrapingTask(definition); err != nil {
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: err.Error()})
+		return
+	}
+	nextRunAt, err := nextScheduledRun(taskRequest.Schedule)
+	if err != nil {
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: err.Error()})
+		return
+	}
+	task := db.ScrapingTask{
+		Name:        definition.Name,
+		Type:        "agenda",
+		Url:         definition.Url,
+		Definition:  definition,
+		Status:      db.TaskStatus_Idle,
+		CreatedTime: time.Now(),
+		Schedule:    strings.TrimSpace(taskRequest.Schedule),
+		NextRunAt:   nextRunAt,
+	}
+	if err := services.taskRepository.Create(req.Context(), &task); err != nil {
+		log.Printf("createScrapingTask %v", err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while saving the task"})
+		return
+	}
+	writeJSONResponse(writer, http.StatusCreated, OkResponse{Success: true, Message: "Task created", Data: task})
+}
+
+// nextScheduledRun is zero for an empty schedule
+func nextScheduledRun(schedule string) (time.Time, error) {
+	if strings.TrimSpace(schedule) == "" {
+		return time.Time{}, nil
+	}
+	cronSchedule, err := utils.ParseCron(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cronSchedule.Next(time.Now()), nil
+}
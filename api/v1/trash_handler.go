@@ -0,0 +1,52 @@
+package api
+
+import (
+	"dpatrov/scraper/internal/db/repository"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// TrashHandler protected routes, the removed entries are purged by the archiver after the retention
+//
+//	GET  /trash               the trashed entries, the last trashed first
+//	POST /trash/{id}/restore  put the entry and its submission back
+func TrashHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		urlPaths := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		switch {
+		case req.Method == http.MethodGet && len(urlPaths) == 1:
+			entries, err := services.agendaRepository.FindTrashed(req.Context())
+			if err != nil {
+				writeTrashError(writer, "FindTrashed", err)
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: entries})
+
+		case req.Method == http.MethodPost && len(urlPaths) == 3 && urlPaths[2] == "restore":
+			entry, err := services.agendaRepository.RestoreTrashed(req.Context(), urlPaths[1])
+			if err != nil {
+				writeTrashError(writer, "RestoreTrashed", err)
+				return
+			}
+			if err := services.queries.RestoreSubmission(req.Context(), entry.ID); err != nil {
+				log.Printf("TrashHandler::RestoreSubmission %v", err)
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Entry restored", Data: entry})
+
+		default:
+			writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: "Not found"})
+		}
+	}
+}
+
+func writeTrashError(writer http.ResponseWriter, operation string, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNoAgendaEntryFound):
+		writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: err.Error()})
+	default:
+		log.Printf("TrashHandler::%s %v", operation, err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while updating the trash"})
+	}
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	venueSuggestionLimit    = 10
+	maxVenueSuggestionLimit = 50
+)
+
+type VenueMergeRequest struct {
+	Into int `json:"into"`
+}
+
+// VenueDetail is the venue page: the venue and its upcoming events
+type VenueDetail struct {
+	db.Venue
+	Entries []db.AgendaEntry `json:"entries"`
+}
+
+// VenuesHandler public routes
+//
+//	GET /api/venues?q=chat&limit=10 autocomplete of the visitor form
+//	GET /api/venues/{id}            venue page with the upcoming events
+func VenuesHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			writeJSONResponse(writer, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			return
+		}
+		urlPaths := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, "/api"), "/"), "/")
+		if len(urlPaths) == 1 {
+			listVenues(services, writer, req, venueSuggestionLimit, true)
+			return
+		}
+		venue, ok := venueFromPath(services, writer, req, urlPaths)
+		if !ok {
+			return
+		}
+		// a venue only known from the entries waiting for the moderation isn't public
+		if venue.Events == 0 {
+			writeVenueError(writer, "FindByID", repository.ErrNoVenueFound)
+			return
+		}
+		page, err := services.agendaRepository.Find(req.Context(), repository.AgendaQuery{
+			Statuses: []db.Status{db.Status_Active},
+			VenueID:  venue.ID,
+			From:     time.Now(),
+			Limit:    maxFeedItems,
+		})
+		if err != nil {
+			log.Printf("VenuesHandler::Find %v", err)
+			writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while loading the events"})
+			return
+		}
+		writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: VenueDetail{venue, page.Entries}})
+	}
+}
+
+// VenueHandler protected routes
+//
+//	GET    /venues?q=          list the venues
+//	POST   /venues             create a venue
+//	GET    /venues/{id}        venue
+//	PUT    /venues/{id}        update the venue and the copy kept by its entries
+//	DELETE /venues/{id}        delete the venue, the entries are unlinked
+//	POST   /venues/{id}/merge  move the entries to the venue {"into": id} and delete this one
+func VenueHandler(services *ServiceMiddleWare) HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		urlPaths := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+		if len(urlPaths) == 1 {
+			switch req.Method {
+			case http.MethodGet:
+				listVenues(services, writer, req, 0, false)
+			case http.MethodPost:
+				var venue db.Venue
+				if err := json.NewDecoder(req.Body).Decode(&venue); err != nil {
+					writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Bad request"})
+					return
+				}
+				if err := services.venueRepository.Create(req.Context(), &venue); err != nil {
+					writeVenueError(writer, "Create", err)
+					return
+				}
+				writeJSONResponse(writer, http.StatusCreated, OkResponse{Success: true, Message: "Venue created", Data: venue})
+			default:
+				writeJSONResponse(writer, http.StatusMethodNotAllowed, ErrorResponse{Message: "Method not allowed"})
+			}
+			return
+		}
+
+		venue, ok := venueFromPath(services, writer, req, urlPaths)
+		if !ok {
+			return
+		}
+		action := strings.Join(urlPaths[2:], "/")
+		switch {
+		case req.Method == http.MethodGet && action == "":
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: venue})
+
+		case req.Method == http.MethodPut && action == "":
+			var update db.Venue
+			if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Bad request"})
+				return
+			}
+			update.ID = venue.ID
+			if err := services.venueRepository.Update(req.Context(), update); err != nil {
+				writeVenueError(writer, "Update", err)
+				return
+			}
+			venue, _ = services.venueRepository.FindByID(req.Context(), venue.ID)
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Venue updated", Data: venue})
+
+		case req.Method == http.MethodDelete && action == "":
+			if err := services.venueRepository.Delete(req.Context(), venue.ID); err != nil {
+				writeVenueError(writer, "Delete", err)
+				return
+			}
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Venue deleted"})
+
+		case req.Method == http.MethodPost && action == "merge":
+			var mergeRequest VenueMergeRequest
+			if err := json.NewDecoder(req.Body).Decode(&mergeRequest); err != nil || mergeRequest.Into == 0 {
+				writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Bad request"})
+				return
+			}
+			if err := services.venueRepository.Merge(req.Context(), venue.ID, mergeRequest.Into); err != nil {
+				writeVenueError(writer, "Merge", err)
+				return
+			}
+			kept, _ := services.venueRepository.FindByID(req.Context(), mergeRequest.Into)
+			writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Message: "Venues merged", Data: kept})
+
+		default:
+			writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: "Not found"})
+		}
+	}
+}
+
+// listVenues the visitors only see the venues with published entries
+func listVenues(services *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request, limit int, published bool) {
+	if value := req.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxVenueSuggestionLimit {
+			writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Invalid limit"})
+			return
+		}
+	}
+	search := services.venueRepository.Search
+	if published {
+		search = services.venueRepository.SearchPublished
+	}
+	venues, err := search(req.Context(), req.URL.Query().Get("q"), limit)
+	if err != nil {
+		log.Printf("listVenues %v", err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while loading the venues"})
+		return
+	}
+	writeJSONResponse(writer, http.StatusOK, OkResponse{Success: true, Data: venues})
+}
+
+func venueFromPath(services *ServiceMiddleWare, writer http.ResponseWriter, req *http.Request, urlPaths []string) (db.Venue, bool) {
+	venueID, err := strconv.Atoi(urlPaths[1])
+	if err != nil {
+		writeJSONResponse(writer, http.StatusBadRequest, ErrorResponse{Message: "Invalid venue id"})
+		return db.Venue{}, false
+	}
+	venue, err := services.venueRepository.FindByID(req.Context(), venueID)
+	if err != nil {
+		writeVenueError(writer, "FindByID", err)
+		return venue, false
+	}
+	return venue, true
+}
+
+func writeVenueError(writer http.ResponseWriter, operation string, err error) {
+	switch {
+	case errors.Is(err, repository.ErrNoVenueFound):
+		writeJSONResponse(writer, http.StatusNotFound, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, repository.ErrVenueExists):
+		writeJSONResponse(writer, http.StatusConflict, ErrorResponse{Message: err.Error()})
+	case errors.Is(err, repository.ErrInvalidVenue):
+		writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{Message: err.Error()})
+	default:
+		log.Printf("VenueHandler::%s %v", operation, err)
+		writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{Message: "Error while saving the venue"})
+	}
+}
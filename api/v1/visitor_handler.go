@@ -16,7 +16,6 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"reflect"
 	"sort"
 	"strconv"
 	"strings"
@@ -38,6 +37,10 @@ type VisitorFormRequest struct {
 	Email    string         `json:"email"`
 	Token    string         `json:"token"`
 	Status   string         `json:"status"`
+	// the requested changes or the reject reason of the moderator
+	Comment string `json:"comment,omitempty"`
+	// Duplicates the entries and submissions which may be the same event, for the moderators
+	Duplicates []db.DuplicateCandidate `json:"duplicates,omitempty"`
 }
 
 type SubmissionConfirmationRequest struct {
@@ -79,7 +82,8 @@ func (f *FormSubmissionWrapper) NewFromRequest(formRequest VisitorFormRequest) *
 		CancelToken:       generateToken(),
 		ConfirmationToken: generateToken(),
 		ExpiredAt:         time.Now().Add(7 * 24 * time.Hour), // / 7 jours
-		Status:            "pending",
+		Status:            string(db.SubmissionStatus_Pending),
+		OrganizerID:       sql.NullInt64{Int64: int64(formRequest.FormData.OrganizerID), Valid: formRequest.FormData.OrganizerID != 0},
 	}
 }
 
@@ -95,6 +99,7 @@ func createFormParameters(formSubmission gendb.FormSubmission) *gendb.CreateForm
 		ExpiredAt:         formSubmission.ExpiredAt,
 		Status:            formSubmission.Status,
 		ConfirmationToken: formSubmission.ConfirmationToken,
+		OrganizerID:       formSubmission.OrganizerID,
 	}
 }
 
@@ -106,23 +111,31 @@ func createErrorResponse(writer http.ResponseWriter, msg string, status int) {
 	})
 }
 
+// allowPublicRequest the rate limit and the origin check of the public forms, writes the error when refused
+func allowPublicRequest(writer http.ResponseWriter, req *http.Request) bool {
+	clientIP := internal.GetClientIP(req)
+	if !rateLimiter.Allow(clientIP) {
+		writer.Header().Set("Retry-After", "30") // Value is in seconds
+		createErrorResponse(writer, "Too many requests /minute", http.StatusTooManyRequests)
+		return false
+	}
+	// Origin
+	origin := req.Header.Get("Origin")
+	allowedOrigins := os.Getenv("ALLOWED_ORIGINS")
+
+	if !strings.Contains(allowedOrigins, origin) {
+		fmt.Printf("origin: %s, allowedOrigin %s", origin, allowedOrigins)
+		createErrorResponse(writer, "Invalid origin", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 func HandlerVisitorForm(services *ServiceMiddleWare) func(http.ResponseWriter, *http.Request) {
 
 	return func(writer http.ResponseWriter, req *http.Request) {
 		// check token + rate
-		clientIP := internal.GetClientIP(req)
-		if !rateLimiter.Allow(clientIP) {
-			writer.Header().Set("Retry-After", "30") // Value is in seconds
-			createErrorResponse(writer, "Too many requests /minute", http.StatusTooManyRequests)
-			return
-		}
-		// Origin
-		origin := req.Header.Get("Origin")
-		allowedOrigins := os.Getenv("ALLOWED_ORIGINS")
-
-		if !strings.Contains(allowedOrigins, origin) {
-			fmt.Printf("origin: %s, allowedOrigin %s", origin, allowedOrigins)
-			createErrorResponse(writer, "Invalid origin", http.StatusForbidden)
+		if !allowPublicRequest(writer, req) {
 			return
 		}
 
@@ -172,6 +185,7 @@ func HandlerVisitorForm(services *ServiceMiddleWare) func(http.ResponseWriter, *
 				return
 			}
 			// if update email should not changed
+			var submissionID string
 			if visitorRequest.Token != "" {
 				previousSubmission, err := services.queries.GetSubmissionByToken(req.Context(), gendb.GetSubmissionByTokenParams{
 					EditToken: visitorRequest.Token,
@@ -187,12 +201,19 @@ func HandlerVisitorForm(services *ServiceMiddleWare) func(http.ResponseWriter, *
 					createErrorResponse(writer, "Email missmatched", http.StatusUnprocessableEntity)
 					return
 				}
+				if !canMoveSubmission(writer, previousSubmission.ID, previousSubmission.Status, db.SubmissionStatus_Pending) {
+					return
+				}
+				submissionID = previousSubmission.ID
 			}
 
 			// steps - validate email - generate delete / edit token
 			fw := FormSubmissionWrapper{FormSubmission: gendb.FormSubmission{}}
 			submissionData := fw.NewFromRequest(visitorRequest)
 			submissionData.Email = visitorRequest.Email
+			if submissionID == "" {
+				submissionID = submissionData.ID
+			}
 
 			// create or update Submission
 			var submissionParams = createFormParameters(*submissionData)
@@ -206,7 +227,7 @@ func HandlerVisitorForm(services *ServiceMiddleWare) func(http.ResponseWriter, *
 			// edit mode
 			if visitorRequest.Token != "" { // edit
 				err = services.queries.UpdateSubmissionStatus(req.Context(), gendb.UpdateSubmissionStatusParams{
-					Status:    "pending",
+					Status:    string(db.SubmissionStatus_Pending),
 					Data:      string(dataJson),
 					EditToken: visitorRequest.Token,
 				})
@@ -231,7 +252,11 @@ func HandlerVisitorForm(services *ServiceMiddleWare) func(http.ResponseWriter, *
 				} else if agendaEntry.ID != "" {
 
 					// linked agenda exists, put it offline
-					err := services.agendaRepository.UpdateStatus(agendaEntry.ID, int(db.Status_Unlinked))
+					err := services.agendaRepository.UpdateStatus(req.Context(), agendaEntry.ID, int(db.Status_Unlinked))
+					if errors.Is(err, db.ErrInvalidTransition) {
+						createErrorResponse(writer, err.Error(), http.StatusConflict)
+						return
+					}
 					if err != nil {
 						fmt.Printf("<error>%v", err)
 						createErrorResponse(writer, "Error while Updating linked agenda entry", http.StatusInternalServerError)
@@ -240,14 +265,8 @@ func HandlerVisitorForm(services *ServiceMiddleWare) func(http.ResponseWriter, *
 				}
 
 			} else { // new
-				submissionParams.Status = "unconfirmed"
-				err = services.queries.CreateFormSubmission(req.Context(), *submissionParams)
-				if err != nil {
-					log.Printf("Error while Saving form %v", err)
-					createErrorResponse(writer, "Error while Saving the form", http.StatusInternalServerError)
-					return
-				}
-				err = services.mailer.SendMail("confirmation_email", submissionData.Email, "Afromémo - Confirmation de votre email", utils.Record{
+				submissionParams.Status = string(db.SubmissionStatus_Unconfirmed)
+				email, err := services.mailer.Render("confirmation_email", submissionData.Email, "Afromémo - Confirmation de votre email", utils.Record{
 					"Email":           submissionData.Email,
 					"ConfirmationURL": fmt.Sprintf("%s/submission/%s/confirmation", os.Getenv("FRONT_URL"), submissionData.ConfirmationToken),
 				})
@@ -256,12 +275,36 @@ func HandlerVisitorForm(services *ServiceMiddleWare) func(http.ResponseWriter, *
 					createErrorResponse(writer, "Error while Sending mail to user", http.StatusInternalServerError)
 					return
 				}
+				// the submission is only saved with its confirmation email
+				err = services.updateWithMail(req.Context(), &email, func(queries *gendb.Queries) error {
+					return queries.CreateFormSubmission(req.Context(), *submissionParams)
+				})
+				if err != nil {
+					log.Printf("Error while Saving form %v", err)
+					createErrorResponse(writer, "Error while Saving the form", http.StatusInternalServerError)
+					return
+				}
+			}
+
+			// the visitor only sees the published events it may duplicate, the moderators get every candidate
+			published := []db.DuplicateCandidate{}
+			candidate := visitorRequest.FormData
+			candidate.ID = submissionID
+			duplicates, err := services.agendaRepository.FindDuplicates(req.Context(), candidate)
+			if err != nil {
+				log.Printf("FindDuplicates::error %v", err)
+			}
+			for _, duplicate := range duplicates {
+				if duplicate.Kind == repository.DuplicateEntry && duplicate.Status == db.Status_Active.String() {
+					published = append(published, duplicate)
+				}
 			}
 
 			// send response
 			writer.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(writer).Encode(Record{
-				"success": true,
+				"success":    true,
+				"duplicates": published,
 			})
 			return
 		default:
@@ -334,12 +377,19 @@ func getAllSubmissions(service *ServiceMiddleWare, writer http.ResponseWriter, r
 			continue
 		}
 
+		candidate := agenda
+		candidate.ID = submission.ID
+		duplicates, err := service.agendaRepository.FindDuplicates(req.Context(), candidate)
+		if err != nil {
+			log.Printf("getAllSubmissions::FindDuplicates %v", err)
+		}
 		response = append(response, VisitorFormRequest{
-			ID:       submission.ID,
-			Token:    submission.EditToken,
-			Email:    submission.Email,
-			FormData: agenda,
-			Status:   submission.Status,
+			ID:         submission.ID,
+			Token:      submission.EditToken,
+			Email:      submission.Email,
+			FormData:   agenda,
+			Status:     submission.Status,
+			Duplicates: duplicates,
 		})
 	}
 	json.NewEncoder(writer).Encode(response)
@@ -365,15 +415,18 @@ func deleteSubmission(services *ServiceMiddleWare, writer http.ResponseWriter, r
 		})
 		return
 	}
+	if !canMoveSubmission(writer, submission.ID, submission.Status, db.SubmissionStatus_Deleted) {
+		return
+	}
 	// update status
 	// Update submission state // send action email
 	_ = services.queries.UpdateSubmissionStatus(req.Context(), gendb.UpdateSubmissionStatusParams{
-		Status:    "deleted", // should be deleted later
+		Status:    string(db.SubmissionStatus_Deleted), // should be deleted later
 		Data:      submission.Data,
 		EditToken: submission.EditToken,
 	})
 	// deleted linked agenda
-	err = services.agendaRepository.UpdateStatus(submission.ID, int(db.Status_Deleted))
+	err = services.agendaRepository.UpdateStatus(req.Context(), submission.ID, int(db.Status_Deleted))
 	if err != nil && err != repository.ErrNoAgendaEntryFound {
 		fmt.Printf("UpdateStatus::Error %s\n", err)
 		return
@@ -410,10 +463,9 @@ func ConfirmSubmission(services *ServiceMiddleWare) func(http.ResponseWriter, *h
 			return
 		}
 
-		if submission.Status != "unconfirmed" {
-			writeJSONResponse(writer, http.StatusUnprocessableEntity, ErrorResponse{
-				Message: "Already confirmed",
-			})
+		// the confirmation is the only way out of unconfirmed
+		if err := db.SubmissionConfirmation(submission.ID, submission.Status); err != nil {
+			writeJSONResponse(writer, http.StatusConflict, ErrorResponse{Message: err.Error()})
 			return
 		}
 		// validate confirmation token
@@ -424,15 +476,9 @@ func ConfirmSubmission(services *ServiceMiddleWare) func(http.ResponseWriter, *h
 			return
 		}
 
-		// Update submission state // send action email
-		_ = services.queries.UpdateSubmissionStatus(req.Context(), gendb.UpdateSubmissionStatusParams{
-			Status:    "pending",
-			Data:      submission.Data,
-			EditToken: submission.EditToken,
-		})
 		// send action mail
 		frontUrl := os.Getenv("FRONT_URL")
-		err = services.mailer.SendMail("actions_email", submission.Email, "Afromémo - Gérer votre événement", utils.Record{
+		email, err := services.mailer.Render("actions_email", submission.Email, "Afromémo - Gérer votre événement", utils.Record{
 			"EditURL":   fmt.Sprintf("%s/agenda/public/%s/edit", frontUrl, submission.EditToken),
 			"CancelURL": fmt.Sprintf("%s/agenda/public/%s/cancel", frontUrl, submission.CancelToken),
 			"CreatedAt": submission.CreatedAt,
@@ -443,6 +489,21 @@ func ConfirmSubmission(services *ServiceMiddleWare) func(http.ResponseWriter, *h
 			})
 			return
 		}
+		// Update submission state with the action email
+		err = services.updateWithMail(req.Context(), &email, func(queries *gendb.Queries) error {
+			return queries.UpdateSubmissionStatus(req.Context(), gendb.UpdateSubmissionStatusParams{
+				Status:    string(db.SubmissionStatus_Pending),
+				Data:      submission.Data,
+				EditToken: submission.EditToken,
+			})
+		})
+		if err != nil {
+			log.Printf("UpdateSubmissionStatus: %v", err)
+			writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{
+				Message: "Error while confirming the submission",
+			})
+			return
+		}
 		writeJSONResponse(writer, http.StatusOK, ErrorResponse{
 			Message: "Ok",
 		})
@@ -479,6 +540,11 @@ func SubmissionHandler(services *ServiceMiddleWare) func(http.ResponseWriter, *h
 						Token:    submission.EditToken,
 						Email:    submission.Email,
 						FormData: agenda,
+						Status:   submission.Status,
+					}
+					switch db.SubmissionStatus(submission.Status) {
+					case db.SubmissionStatus_ChangesRequested, db.SubmissionStatus_Rejected:
+						response.Comment = submission.ModerationComment
 					}
 					if time.Now().After(submission.CreatedAt.Add(time.Hour * 24 * 7)) {
 						writeJSONResponse(writer, http.StatusInternalServerError, ErrorResponse{
@@ -560,7 +626,7 @@ func GetSubmissionDiff(service *ServiceMiddleWare) func(http.ResponseWriter, *ht
 
 			if err != nil {
 				if errors.Is(err, sql.ErrNoRows) {
-					result := make(map[string]Record)
+					result := make(map[string]utils.FieldChange)
 					writeJSONResponse(writer, http.StatusAccepted, OkResponse{
 						Data: result,
 					})
@@ -598,31 +664,7 @@ func GetSubmissionDiff(service *ServiceMiddleWare) func(http.ResponseWriter, *ht
 				})
 				return
 			}
-			// compare data here
-			// get all keys
-			// loop over key to compares
-			reflectSubmissionData := reflect.ValueOf(submissionData)
-			reflectAgenda := reflect.ValueOf(agendaEntry)
-
-			numField := reflectAgenda.NumField()
-
-			var result map[string]Record
-			result = make(map[string]Record)
-			for i := 0; i < numField; i++ {
-				fieldNameA := reflectSubmissionData.Field(i)
-				fieldNameB := reflectAgenda.Field(i)
-
-				fieldName := reflectAgenda.Type().Field(i).Name
-				fieldName = strings.ToLower(fieldName)
-
-				if !reflect.DeepEqual(fieldNameA.Interface(), fieldNameB.Interface()) {
-
-					result[fieldName] = Record{
-						"old": fieldNameB.Interface(),
-						"new": fieldNameA.Interface(),
-					}
-				}
-			}
+			result := utils.DiffStructs(agendaEntry, submissionData)
 			writeJSONResponse(writer, http.StatusAccepted, OkResponse{
 				Data: result,
 			})
@@ -4,12 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
 	"dpatrov/scraper/internal/gendb"
+	"dpatrov/scraper/internal/storage"
 	"dpatrov/scraper/internal/utils"
 	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/bcrypt"
@@ -18,6 +21,10 @@ import (
 var (
 	username string
 	password string
+	defaults map[string]string
+	// gc-uploads
+	dryRun       bool
+	tmpUploadTTL time.Duration
 )
 
 var rootCmd = &cobra.Command{
@@ -67,16 +74,7 @@ var checkEventsFacet = &cobra.Command{
 		// eventFacet := utils.EventFacetCounts.New(db)
 		eventFacet := utils.NewEventFacetCounts(db)
 
-		facetCount, err := eventFacet.GetFacetCount(context.WithoutCancel(context.TODO()), gendb.GetAgendaCountFacetsParams{
-			TodayEnd:      "2025-10-12",
-			TodayStart:    "2025-10-12",
-			WeekendEnd:    "2025-10-19",
-			WeekendStart:  "2025-10-17",
-			WeekEnd:       "2025-10-12",
-			WeekStart:     "2025-10-06",
-			NextWeekEnd:   "2025-10-24",
-			NextWeekStart: "2025-10-26",
-		})
+		facetCount, err := eventFacet.Current(context.Background(), time.Now())
 		if err != nil {
 			fmt.Printf("Error:: %+v", err)
 			return
@@ -89,13 +87,81 @@ var checkEventsFacet = &cobra.Command{
 	},
 }
 
+var importICSCmd = &cobra.Command{
+	Use:   "import-ics <file|url>",
+	Short: "Import the events of an iCalendar file as pending agenda entries.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		localDb := db.InitDb()
+		importer := utils.NewICSImporter(repository.NewAgendaRepository(localDb))
+		result, err := importer.ImportSource(context.Background(), args[0], defaults)
+		if err != nil {
+			fmt.Printf("Error:: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d created, %d updated, %d skipped (already moderated), %d invalid\n",
+			len(result.Created), len(result.Updated), len(result.Skipped), len(result.Invalid))
+		for _, invalid := range result.Invalid {
+			fmt.Printf("  invalid %q: %v\n", invalid.Record["title"], invalid.Errors)
+		}
+	},
+}
+
+var gcUploadsCmd = &cobra.Command{
+	Use:   "gc-uploads",
+	Short: "Report the abandoned tmp uploads and the posters no longer referenced, --dry-run=false deletes them.",
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := storage.FromEnv()
+		if err != nil {
+			fmt.Printf("Error:: %v\n", err)
+			os.Exit(1)
+		}
+		sweeper := utils.NewUploadSweeper(db.InitDb(), store, time.Hour).WithTmpTTL(tmpUploadTTL)
+		result, err := sweeper.Sweep(context.Background(), time.Now(), dryRun)
+		if err != nil {
+			fmt.Printf("Error:: %v\n", err)
+			os.Exit(1)
+		}
+		action := "deleted"
+		if dryRun {
+			action = "would be deleted"
+		}
+		for _, object := range result.Tmp {
+			fmt.Printf("  tmp     %s (%d bytes, %s)\n", object.Key, object.Size, object.ModTime.Format(time.DateTime))
+		}
+		for _, object := range result.Orphans {
+			fmt.Printf("  orphan  %s (%d bytes, %s)\n", object.Key, object.Size, object.ModTime.Format(time.DateTime))
+		}
+		fmt.Printf("%d tmp uploads and %d orphaned uploads %s, %d bytes\n", len(result.Tmp), len(result.Orphans), action, result.Size())
+	},
+}
+
+var backfillVenuesCmd = &cobra.Command{
+	Use:   "backfill-venues",
+	Short: "Key the venues again, merging the duplicates, and link the entries without a venue. Also re-links the entries of deleted venues.",
+	Run: func(cmd *cobra.Command, args []string) {
+		linked, err := repository.NewVenueRepository(db.InitDb()).Backfill(context.Background())
+		if err != nil {
+			fmt.Printf("Error:: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d entries linked to a venue\n", linked)
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(createCmd)
 	rootCmd.AddCommand(checkEventsFacet)
+	rootCmd.AddCommand(importICSCmd)
+	rootCmd.AddCommand(gcUploadsCmd)
+	rootCmd.AddCommand(backfillVenuesCmd)
 	// Define params
 	createCmd.Flags().StringVarP(&username, "username", "u", "", "Username for the new admin user (required)")
 	createCmd.Flags().StringVarP(&password, "password", "p", "", "Username for the new admin user (required)")
 	createCmd.MarkFlagRequired(username)
+	gcUploadsCmd.Flags().BoolVar(&dryRun, "dry-run", true, "Only report the uploads which would be deleted")
+	gcUploadsCmd.Flags().DurationVar(&tmpUploadTTL, "tmp-ttl", utils.DefaultTmpUploadTTL, "Age of the tmp uploads to delete")
+	importICSCmd.Flags().StringToStringVarP(&defaults, "default", "d", nil, "Value of a field missing in the calendar, ex: -d category=concert -d price=0")
 }
 
 func main() {
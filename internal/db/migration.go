@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
@@ -25,6 +26,32 @@ func (l Logger) Verbose() bool {
 	return l.verbose == true
 }
 
+// goMigrations are the steps written in Go, run once right after the SQL migration of their version
+var goMigrations = map[uint]func(*sql.DB) error{}
+
+// RegisterGoMigration adds a step written in Go to the migration of the version
+func RegisterGoMigration(version uint, step func(*sql.DB) error) {
+	goMigrations[version] = step
+}
+
+// runGoMigrations runs the steps of the versions applied after the version from
+func runGoMigrations(db *sql.DB, from uint, to uint) error {
+	versions := []uint{}
+	for version := range goMigrations {
+		if version > from && version <= to {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	for _, version := range versions {
+		log.Printf("Running the Go step of the migration %d", version)
+		if err := goMigrations[version](db); err != nil {
+			return fmt.Errorf("migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
 func SetupMigration(db *sql.DB, migrationDir string) (*migrate.Migrate, error) {
 	// create the folder if if
 	fmt.Println("Inside SetupMigration....")
@@ -82,6 +109,13 @@ func RunMigration(db *sql.DB, migrationsDir string) error {
 		}
 		log.Fatalf("Failed to Setup migration...", err)
 	}
+	applied, _, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if err := runGoMigrations(db, version, applied); err != nil {
+		return err
+	}
 	log.Println("Migrations completed successfully")
 	return nil
 }
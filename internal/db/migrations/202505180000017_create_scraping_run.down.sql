@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_scraping_run_status;
+DROP INDEX IF EXISTS idx_scraping_run_task_id;
+DROP TABLE IF EXISTS scraping_run;
+ALTER TABLE scraping_task DROP COLUMN name;
@@ -0,0 +1,16 @@
+ALTER TABLE scraping_task ADD COLUMN name TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS scraping_run (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id INTEGER NOT NULL,
+    status INTEGER NOT NULL DEFAULT 1,
+    created_at DATETIME NOT NULL,
+    started_at DATETIME,
+    finished_at DATETIME,
+    entries_count INTEGER NOT NULL DEFAULT 0,
+    result TEXT NOT NULL DEFAULT '',
+    error TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (task_id) REFERENCES scraping_task(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_scraping_run_task_id ON scraping_run(task_id);
+CREATE INDEX IF NOT EXISTS idx_scraping_run_status ON scraping_run(status);
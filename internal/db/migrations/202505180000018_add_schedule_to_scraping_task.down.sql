@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_scraping_task_next_run_at;
+ALTER TABLE scraping_task DROP COLUMN next_run_at;
+ALTER TABLE scraping_task DROP COLUMN schedule;
@@ -0,0 +1,3 @@
+ALTER TABLE scraping_task ADD COLUMN schedule TEXT NOT NULL DEFAULT '';
+ALTER TABLE scraping_task ADD COLUMN next_run_at DATETIME;
+CREATE INDEX IF NOT EXISTS idx_scraping_task_next_run_at ON scraping_task(next_run_at);
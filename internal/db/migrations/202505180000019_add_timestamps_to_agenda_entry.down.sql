@@ -0,0 +1,2 @@
+ALTER TABLE agenda_entry DROP COLUMN updated_at;
+ALTER TABLE agenda_entry DROP COLUMN created_at;
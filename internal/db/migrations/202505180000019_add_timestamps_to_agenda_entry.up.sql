@@ -0,0 +1,4 @@
+ALTER TABLE agenda_entry ADD COLUMN created_at DATETIME;
+ALTER TABLE agenda_entry ADD COLUMN updated_at DATETIME;
+-- existing entries get the migration date
+UPDATE agenda_entry SET created_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP;
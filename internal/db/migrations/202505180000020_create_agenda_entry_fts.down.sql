@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS agenda_entry_fts_delete;
+DROP TRIGGER IF EXISTS agenda_entry_fts_update;
+DROP TRIGGER IF EXISTS agenda_entry_fts_insert;
+DROP TABLE IF EXISTS agenda_entry_fts;
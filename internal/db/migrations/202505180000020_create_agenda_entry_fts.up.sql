@@ -0,0 +1,29 @@
+-- full-text index of the agenda entries, remove_diacritics makes "geneve" match "Genève"
+CREATE VIRTUAL TABLE IF NOT EXISTS agenda_entry_fts USING fts5(
+    id UNINDEXED,
+    title,
+    subtitle,
+    description,
+    venuename,
+    place,
+    tag,
+    tokenize = 'unicode61 remove_diacritics 2'
+);
+
+INSERT INTO agenda_entry_fts (id, title, subtitle, description, venuename, place, tag)
+    SELECT id, title, subtitle, description, venuename, place, tag FROM agenda_entry;
+
+CREATE TRIGGER IF NOT EXISTS agenda_entry_fts_insert AFTER INSERT ON agenda_entry BEGIN
+    INSERT INTO agenda_entry_fts (id, title, subtitle, description, venuename, place, tag)
+        VALUES (new.id, new.title, new.subtitle, new.description, new.venuename, new.place, new.tag);
+END;
+
+CREATE TRIGGER IF NOT EXISTS agenda_entry_fts_update AFTER UPDATE OF id, title, subtitle, description, venuename, place, tag ON agenda_entry BEGIN
+    DELETE FROM agenda_entry_fts WHERE id = old.id;
+    INSERT INTO agenda_entry_fts (id, title, subtitle, description, venuename, place, tag)
+        VALUES (new.id, new.title, new.subtitle, new.description, new.venuename, new.place, new.tag);
+END;
+
+CREATE TRIGGER IF NOT EXISTS agenda_entry_fts_delete AFTER DELETE ON agenda_entry BEGIN
+    DELETE FROM agenda_entry_fts WHERE id = old.id;
+END;
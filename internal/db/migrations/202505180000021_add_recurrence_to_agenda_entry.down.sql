@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_agenda_occurrence_startdate;
+DROP TABLE IF EXISTS agenda_occurrence;
+ALTER TABLE agenda_entry DROP COLUMN exdates;
+ALTER TABLE agenda_entry DROP COLUMN rrule;
@@ -0,0 +1,14 @@
+ALTER TABLE agenda_entry ADD COLUMN rrule TEXT NOT NULL DEFAULT '';
+-- comma separated dates: 2025-10-14,2025-10-21
+ALTER TABLE agenda_entry ADD COLUMN exdates TEXT NOT NULL DEFAULT '';
+
+-- the expanded occurrences of the recurring entries
+CREATE TABLE IF NOT EXISTS agenda_occurrence (
+    entry_id TEXT NOT NULL,
+    startdate TEXT NOT NULL,
+    enddate TEXT NOT NULL,
+    status INTEGER NOT NULL DEFAULT 1,
+    PRIMARY KEY (entry_id, startdate),
+    FOREIGN KEY (entry_id) REFERENCES agenda_entry(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_agenda_occurrence_startdate ON agenda_occurrence(startdate);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_agenda_entry_venue_id;
+ALTER TABLE agenda_entry DROP COLUMN venue_id;
+DROP TABLE IF EXISTS venue;
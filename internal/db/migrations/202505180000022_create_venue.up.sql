@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS venue (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    address TEXT NOT NULL DEFAULT '',
+    place TEXT NOT NULL DEFAULT '',
+    -- normalized name and place, see repository.VenueKey
+    normalized_key TEXT NOT NULL UNIQUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE agenda_entry ADD COLUMN venue_id INTEGER REFERENCES venue(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_agenda_entry_venue_id ON agenda_entry(venue_id);
+
+-- the venues of the existing entries are backfilled in Go, see repository.VenueRepository.Backfill
@@ -0,0 +1,4 @@
+ALTER TABLE form_submissions DROP COLUMN organizer_id;
+DROP INDEX IF EXISTS idx_agenda_entry_organizer_id;
+ALTER TABLE agenda_entry DROP COLUMN organizer_id;
+DROP TABLE IF EXISTS organizer;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS organizer (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    slug TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    -- json array of urls
+    links TEXT NOT NULL DEFAULT '[]',
+    -- file name in uploads
+    logo TEXT NOT NULL DEFAULT '',
+    email TEXT NOT NULL DEFAULT '',
+    verified INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE agenda_entry ADD COLUMN organizer_id INTEGER REFERENCES organizer(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_agenda_entry_organizer_id ON agenda_entry(organizer_id);
+
+ALTER TABLE form_submissions ADD COLUMN organizer_id INTEGER REFERENCES organizer(id) ON DELETE SET NULL;
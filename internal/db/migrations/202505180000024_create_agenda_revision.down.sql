@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_agenda_revision_entry_id;
+DROP TABLE IF EXISTS agenda_revision;
@@ -0,0 +1,12 @@
+-- every version of the agenda entries, the entries may have been deleted since
+CREATE TABLE IF NOT EXISTS agenda_revision (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entry_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    -- json of the entry after the action
+    snapshot TEXT NOT NULL,
+    -- NULL for the visitors, the scrapers and the jobs
+    user_id INTEGER,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_agenda_revision_entry_id ON agenda_revision(entry_id);
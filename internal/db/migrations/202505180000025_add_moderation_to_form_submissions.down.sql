@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_submission_note_submission_id;
+DROP TABLE IF EXISTS submission_note;
+ALTER TABLE form_submissions DROP COLUMN moderation_comment;
+ALTER TABLE form_submissions DROP COLUMN assigned_to;
@@ -0,0 +1,14 @@
+-- moderator in charge of the submission
+ALTER TABLE form_submissions ADD COLUMN assigned_to INTEGER REFERENCES user(id) ON DELETE SET NULL;
+-- reject reason or requested changes, shown to the submitter
+ALTER TABLE form_submissions ADD COLUMN moderation_comment TEXT NOT NULL DEFAULT '';
+
+-- internal notes of the moderators, never shown to the submitter
+CREATE TABLE IF NOT EXISTS submission_note (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    submission_id TEXT NOT NULL REFERENCES form_submissions(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES user(id) ON DELETE SET NULL,
+    body TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_submission_note_submission_id ON submission_note(submission_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS event_subscription;
+ALTER TABLE agenda_entry DROP COLUMN original_startdate;
@@ -0,0 +1,13 @@
+-- date of a postponed event before it was moved (2006-01-02)
+ALTER TABLE agenda_entry ADD COLUMN original_startdate TEXT NOT NULL DEFAULT '';
+
+-- visitors notified when the event is cancelled, postponed, sold out or moved online
+CREATE TABLE IF NOT EXISTS event_subscription (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    entry_id TEXT NOT NULL,
+    email TEXT NOT NULL,
+    -- unsubscribe link
+    token TEXT NOT NULL UNIQUE,
+    created_at DATETIME NOT NULL,
+    UNIQUE (entry_id, email)
+);
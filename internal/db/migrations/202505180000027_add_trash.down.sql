@@ -0,0 +1,4 @@
+ALTER TABLE form_submissions DROP COLUMN deleted_at;
+
+DROP INDEX IF EXISTS idx_agenda_entry_deleted_at;
+ALTER TABLE agenda_entry DROP COLUMN deleted_at;
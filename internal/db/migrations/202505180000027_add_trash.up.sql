@@ -0,0 +1,5 @@
+-- a removed entry stays in the trash until the archiver purges it
+ALTER TABLE agenda_entry ADD COLUMN deleted_at DATETIME;
+CREATE INDEX IF NOT EXISTS idx_agenda_entry_deleted_at ON agenda_entry(deleted_at);
+
+ALTER TABLE form_submissions ADD COLUMN deleted_at DATETIME;
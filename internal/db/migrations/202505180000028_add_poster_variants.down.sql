@@ -0,0 +1 @@
+ALTER TABLE agenda_entry DROP COLUMN poster_variants;
@@ -0,0 +1,2 @@
+-- the processed sizes of the poster as JSON: [{"size":"card","file":"...","width":640,"height":905}]
+ALTER TABLE agenda_entry ADD COLUMN poster_variants TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE event_subscription DROP COLUMN confirmed_at;
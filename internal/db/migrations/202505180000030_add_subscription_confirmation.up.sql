@@ -0,0 +1,4 @@
+-- double opt-in: a subscription is only notified once the visitor confirmed the email,
+-- the subscriptions made before the confirmation are kept
+ALTER TABLE event_subscription ADD COLUMN confirmed_at DATETIME;
+UPDATE event_subscription SET confirmed_at = created_at;
@@ -1,8 +1,11 @@
 package db
 
 import (
+	"dpatrov/scraper/internal/ical"
+	"dpatrov/scraper/internal/types"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"reflect"
 	"strings"
 	"time"
@@ -31,7 +34,16 @@ const (
 	Status_Removed
 )
 
-// custom unmarshal
+var statusNames = []string{"inactive", "active", "pending", "deleted", "unlinked", "archived", "removed"}
+
+func (a Status) String() string {
+	if int(a) < 0 || int(a) >= len(statusNames) {
+		return fmt.Sprintf("unknown(%d)", int(a))
+	}
+	return statusNames[a]
+}
+
+// custom unmarshal, an unknown status is an error instead of an inactive entry
 
 func (a *Status) UnmarshalJSON(b []byte) error {
 
@@ -39,34 +51,116 @@ func (a *Status) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(b, &value); err != nil {
 		return err
 	}
-	switch value {
-	case 0:
-		*a = Status_Inactive
-	case 1:
-		*a = Status_Active
-	case 2:
-		*a = Status_Pending
-	case 3:
-		*a = Status_Deleted
-	case 4:
-		*a = Status_Unlinked
-	case 5:
-		*a = Status_Archived
-	case 6:
-		*a = Status_Removed
-	default:
-		*a = Status_Inactive
+	if value < int(Status_Inactive) || value > int(Status_Removed) {
+		return fmt.Errorf("unknown agenda status %d", value)
 	}
+	*a = Status(value)
 	return nil
 }
 
+// SubmissionStatus of a visitor form submission, the moves are in transitions.go
+type SubmissionStatus string
+
+const (
+	SubmissionStatus_Unconfirmed      SubmissionStatus = "unconfirmed"
+	SubmissionStatus_Pending          SubmissionStatus = "pending"
+	SubmissionStatus_ChangesRequested SubmissionStatus = "changes_requested"
+	SubmissionStatus_Active           SubmissionStatus = "active"
+	SubmissionStatus_Rejected         SubmissionStatus = "rejected"
+	SubmissionStatus_Archived         SubmissionStatus = "archived"
+	SubmissionStatus_Deleted          SubmissionStatus = "deleted"
+	SubmissionStatus_Cancelled        SubmissionStatus = "cancelled"
+)
+
+// Lifecycle of the event itself, independent of the publication status. An entry without
+// lifecycle is scheduled, the scraped entries are saved without one
+const (
+	Lifecycle_Scheduled = "scheduled"
+	Lifecycle_Cancelled = "cancelled"
+	Lifecycle_Postponed = "postponed"
+	Lifecycle_SoldOut   = "soldout"
+	Lifecycle_Online    = "online"
+)
+
+// lifecycleLabels the notices shown with the event
+var lifecycleLabels = map[string]string{
+	Lifecycle_Scheduled: "",
+	Lifecycle_Cancelled: "Annulé",
+	Lifecycle_Postponed: "Reporté",
+	Lifecycle_SoldOut:   "Complet",
+	Lifecycle_Online:    "En ligne",
+}
+
+// NormalizeLifecycle "Scheduled" and "" are saved as "", false for an unknown lifecycle
+func NormalizeLifecycle(lifecycle string) (string, bool) {
+	lifecycle = strings.ToLower(strings.TrimSpace(lifecycle))
+	if lifecycle == "" {
+		return "", true
+	}
+	if _, known := lifecycleLabels[lifecycle]; !known {
+		return lifecycle, false
+	}
+	if lifecycle == Lifecycle_Scheduled {
+		return "", true
+	}
+	return lifecycle, true
+}
+
+// Scraping task / run lifecycle: queued -> running -> succeeded|failed|cancelled
+type TaskStatus int
+
+const (
+	TaskStatus_Idle TaskStatus = iota
+	TaskStatus_Queued
+	TaskStatus_Running
+	TaskStatus_Succeeded
+	TaskStatus_Failed
+	TaskStatus_Cancelled
+)
+
+var taskStatusNames = []string{"idle", "queued", "running", "succeeded", "failed", "cancelled"}
+
+func (s TaskStatus) String() string {
+	if int(s) < 0 || int(s) >= len(taskStatusNames) {
+		return "unknown"
+	}
+	return taskStatusNames[s]
+}
+
+func (s TaskStatus) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s TaskStatus) IsFinished() bool {
+	return s == TaskStatus_Succeeded || s == TaskStatus_Failed || s == TaskStatus_Cancelled
+}
+
 type ScrapingTask struct {
-	Id             int
-	Int            int
-	Type           string
-	Url            string
-	ScrapingParams Record
-	CreatedTime    time.Time
+	Id             int                `json:"id"`
+	Int            int                `json:"-"`
+	Name           string             `json:"name"`
+	Type           string             `json:"type"`
+	Url            string             `json:"url"`
+	ScrapingParams Record             `json:"-"`
+	Definition     types.ScrapingTask `json:"definition"`
+	Status         TaskStatus         `json:"status"`
+	CreatedTime    time.Time          `json:"created_time"`
+	// cron expression, empty when the task is only run on demand
+	Schedule  string    `json:"schedule"`
+	NextRunAt time.Time `json:"next_run_at,omitzero"`
+}
+
+// ScrapingRun is one execution of a scraping task
+type ScrapingRun struct {
+	ID           int             `json:"id"`
+	TaskID       int             `json:"task_id"`
+	Status       TaskStatus      `json:"status"`
+	CreatedAt    time.Time       `json:"created_at"`
+	StartedAt    time.Time       `json:"started_at,omitzero"`
+	FinishedAt   time.Time       `json:"finished_at,omitzero"`
+	EntriesCount int             `json:"entries_count"`
+	Result       json.RawMessage `json:"result,omitempty"`
+	Error        string          `json:"error"`
 }
 
 var (
@@ -76,12 +170,49 @@ var (
 
 type ErrorMap = map[string]string
 
+// AgendaRevision is a version of an agenda entry, UserID is 0 when no user made the change
+type AgendaRevision struct {
+	ID        int         `json:"id"`
+	EntryID   string      `json:"entryId"`
+	Action    string      `json:"action"`
+	UserID    int         `json:"userId,omitempty"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Entry     AgendaEntry `json:"entry"`
+}
+
+// Organizer is the collective behind the events, the email is a private contact
+type Organizer struct {
+	ID          int       `json:"id"`
+	Slug        string    `json:"slug"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Links       []string  `json:"links"`
+	Logo        string    `json:"logo"`
+	Email       string    `json:"email,omitempty"`
+	Verified    bool      `json:"verified"`
+	CreatedAt   time.Time `json:"createdAt,omitzero"`
+	UpdatedAt   time.Time `json:"updatedAt,omitzero"`
+}
+
+// Venue is shared by the agenda entries, which keep a copy of its name, address and place
+type Venue struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Address   string    `json:"address"`
+	Place     string    `json:"place"`
+	Events    int       `json:"events"`
+	CreatedAt time.Time `json:"createdAt,omitzero"`
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+}
+
 type AgendaEntry struct {
 	ID                   string    `json:"id"`
 	Title                string    `json:"title"`
 	Link                 string    `json:"link"`
 	Price                string    `json:"price"`
 	VenueName            string    `json:"venuename"`
+	VenueID              int       `json:"venueId,omitempty"`
+	OrganizerID          int       `json:"organizerId,omitempty"`
 	Address              string    `json:"address"`
 	StartDate            time.Time `json:"startdate"`
 	Description          string    `json:"description"`
@@ -96,6 +227,139 @@ type AgendaEntry struct {
 	EndTime              time.Time `json:"endtime"`
 	Subtitle             string    `json:"subtitle"`
 	EndDate              time.Time `json:"enddate"`
+	CreatedAt            time.Time `json:"createdAt,omitzero"`
+	UpdatedAt            time.Time `json:"updatedAt,omitzero"`
+	// RRule repeats the entry (RFC 5545: FREQ=WEEKLY;BYDAY=TU), ExDates are the cancelled dates (2006-01-02)
+	RRule   string   `json:"rrule"`
+	ExDates []string `json:"exdates"`
+	// OriginalStartDate the date (2006-01-02) a postponed event was first planned on
+	OriginalStartDate string `json:"originalStartdate,omitempty"`
+	// DeletedAt the entry is in the trash since then
+	DeletedAt time.Time `json:"deletedAt,omitzero"`
+	// PosterVariants the sizes of the processed poster, the largest last
+	PosterVariants []PosterVariant `json:"posterVariants,omitempty"`
+}
+
+// PosterVariant a size of the poster served from /images/
+type PosterVariant struct {
+	Size   string `json:"size"`
+	File   string `json:"file"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// PosterSrcset the srcset attribute of the poster: "/images/a-thumbnail.jpg 320w, /images/a-card.jpg 640w"
+func (entry AgendaEntry) PosterSrcset() string {
+	sources := make([]string, 0, len(entry.PosterVariants))
+	for _, variant := range entry.PosterVariants {
+		sources = append(sources, fmt.Sprintf("/images/%s %dw", url.PathEscape(variant.File), variant.Width))
+	}
+	return strings.Join(sources, ", ")
+}
+
+// DuplicateCandidate an agenda entry or a pending submission which may be the same event,
+// Score goes from 0 to 1
+type DuplicateCandidate struct {
+	ID        string  `json:"id"`
+	Kind      string  `json:"kind"`
+	Status    string  `json:"status"`
+	Title     string  `json:"title"`
+	VenueName string  `json:"venuename"`
+	StartDate string  `json:"startdate"`
+	StartTime string  `json:"starttime"`
+	Score     float64 `json:"score"`
+}
+
+// EventSubscription a visitor notified when the lifecycle of the event changes
+type EventSubscription struct {
+	ID        int       `json:"id"`
+	EntryID   string    `json:"entryId"`
+	Email     string    `json:"email"`
+	Token     string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+	// nil until the visitor confirms the email, only the confirmed subscriptions are notified
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+}
+
+type OutboxStatus string
+
+const (
+	OutboxStatus_Pending OutboxStatus = "pending"
+	OutboxStatus_Sending OutboxStatus = "sending"
+	OutboxStatus_Sent    OutboxStatus = "sent"
+	OutboxStatus_Dead    OutboxStatus = "dead"
+)
+
+// OutboxEmail a rendered email waiting in the outbox, a failed email is retried at NextAttemptAt
+// until it goes dead
+type OutboxEmail struct {
+	ID            int64        `json:"id"`
+	Recipient     string       `json:"recipient"`
+	Subject       string       `json:"subject"`
+	Body          string       `json:"body,omitempty"`
+	Template      string       `json:"template"`
+	Status        OutboxStatus `json:"status"`
+	Attempts      int          `json:"attempts"`
+	LastError     string       `json:"lastError"`
+	NextAttemptAt time.Time    `json:"nextAttemptAt"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	UpdatedAt     time.Time    `json:"updatedAt"`
+	SentAt        *time.Time   `json:"sentAt,omitempty"`
+}
+
+// Occurrence of a recurring entry, its end keeps the duration of the first occurrence
+type Occurrence struct {
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// Occurrences expands the recurrence rule until the horizon, a single entry has one occurrence
+func (entry AgendaEntry) Occurrences(horizon time.Time, limit int) ([]Occurrence, error) {
+	duration := 0
+	if entry.EndDate.After(entry.StartDate) {
+		duration = int(entry.EndDate.Sub(entry.StartDate).Hours() / 24)
+	}
+	if entry.RRule == "" {
+		return []Occurrence{{StartDate: entry.StartDate, EndDate: entry.StartDate.AddDate(0, 0, duration)}}, nil
+	}
+	rule, err := ical.ParseRRule(entry.RRule)
+	if err != nil {
+		return nil, err
+	}
+	exdates, err := entry.ParseExDates()
+	if err != nil {
+		return nil, err
+	}
+	occurrences := []Occurrence{}
+	for _, date := range rule.Occurrences(entry.StartDate, exdates, horizon, limit) {
+		occurrences = append(occurrences, Occurrence{StartDate: date, EndDate: date.AddDate(0, 0, duration)})
+	}
+	return occurrences, nil
+}
+
+// Lifecycle the lifecycle of the event, scheduled when none is set
+func (entry AgendaEntry) Lifecycle() string {
+	if lifecycle, _ := NormalizeLifecycle(entry.EventLifecycleStatus); lifecycle != "" {
+		return lifecycle
+	}
+	return Lifecycle_Scheduled
+}
+
+// LifecycleLabel the notice of the event ("Annulé", "Complet"...), empty when it is scheduled
+func (entry AgendaEntry) LifecycleLabel() string {
+	return lifecycleLabels[entry.Lifecycle()]
+}
+
+func (entry AgendaEntry) ParseExDates() ([]time.Time, error) {
+	exdates := []time.Time{}
+	for _, value := range entry.ExDates {
+		exdate, err := time.Parse(dateLayout, strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid excluded date %s", value)
+		}
+		exdates = append(exdates, exdate)
+	}
+	return exdates, nil
 }
 
 func (entry AgendaEntry) FormatTagsToString() string {
@@ -115,12 +379,14 @@ func (entry AgendaEntry) MarshalJSON() ([]byte, error) {
 		EndDate   string `json:"enddate"`
 		StartTime string `json:"starttime"`
 		EndTime   string `json:"endtime"`
+		Srcset    string `json:"posterSrcset,omitempty"`
 		*Alias
 	}{
 		StartDate: entry.StartDate.Format(dateLayout),
 		EndDate:   entry.EndDate.Format(dateLayout),
 		StartTime: entry.StartTime.Format(timeLayout),
 		EndTime:   entry.EndTime.Format(timeLayout),
+		Srcset:    entry.PosterSrcset(),
 		Alias:     (*Alias)(&entry),
 	})
 }
@@ -215,6 +481,18 @@ func (entry *AgendaEntry) Validate() error {
 		}
 	}
 
+	if entry.RRule != "" {
+		if _, err := ical.ParseRRule(entry.RRule); err != nil {
+			errorsList = append(errorsList, "Recurrence rule is not valid")
+		}
+	}
+	if _, err := entry.ParseExDates(); err != nil {
+		errorsList = append(errorsList, "Excluded dates must be formatted as 2006-01-02")
+	}
+	if _, known := NormalizeLifecycle(entry.EventLifecycleStatus); !known {
+		errorsList = append(errorsList, "Event lifecycle is not valid")
+	}
+
 	if len(errorsList) > 0 {
 		return fmt.Errorf("%s", strings.Join(errorsList, "; "))
 	}
@@ -1,31 +1,71 @@
 -- name: ArchivePastEvents :exec
-UPDATE agenda_entry SET status = 5 WHERE date(enddate, '+1 day') < date('now') OR enddate="";
+UPDATE agenda_entry SET status = 5 WHERE status = 1 AND (date(enddate, '+1 day') < date(CAST(sqlc.arg(today) AS TEXT)) OR enddate="") AND rrule = '' AND deleted_at IS NULL;
+
+-- name: ArchivePastOccurrences :exec
+UPDATE agenda_occurrence SET status = 5 WHERE status = 1 AND date(enddate, '+1 day') < date(CAST(sqlc.arg(today) AS TEXT));
+
+-- name: ArchiveEndedSeries :exec
+UPDATE agenda_entry SET status = 5
+WHERE status = 1 AND rrule != '' AND deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM agenda_occurrence o WHERE o.entry_id = agenda_entry.id AND o.status = 1);
 
 
 -- name: GetAgendaCountFacets :one
 SELECT
-  SUM(CASE
+  COUNT(DISTINCT CASE
     WHEN startdate <= CAST(sqlc.arg(today_end) AS TEXT)
-    AND COALESCE(NULLIF(enddate, ''), startdate) >= CAST(sqlc.arg(today_start) AS TEXT)
-    THEN 1 ELSE 0
+    AND enddate >= CAST(sqlc.arg(today_start) AS TEXT)
+    THEN id
   END) as today,
   
-  SUM(CASE
+  COUNT(DISTINCT CASE
     WHEN startdate <= CAST(sqlc.arg(weekend_end) AS TEXT)
-    AND COALESCE(NULLIF(enddate, ''), startdate) >= CAST(sqlc.arg(weekend_start) AS TEXT)
-    THEN 1 ELSE 0
+    AND enddate >= CAST(sqlc.arg(weekend_start) AS TEXT)
+    THEN id
   END) as this_weekend,
   
-  SUM(CASE
+  COUNT(DISTINCT CASE
     WHEN startdate <= CAST(sqlc.arg(week_end) AS TEXT)
-    AND COALESCE(NULLIF(enddate, ''), startdate) >= CAST(sqlc.arg(week_start) AS TEXT)
-    THEN 1 ELSE 0
+    AND enddate >= CAST(sqlc.arg(week_start) AS TEXT)
+    THEN id
   END) as this_week,
   
-  SUM(CASE
+  COUNT(DISTINCT CASE
     WHEN startdate <= CAST(sqlc.arg(next_week_end) AS TEXT)
-    AND COALESCE(NULLIF(enddate, ''), startdate) >= CAST(sqlc.arg(next_week_start) AS TEXT)
-    THEN 1 ELSE 0
+    AND enddate >= CAST(sqlc.arg(next_week_start) AS TEXT)
+    THEN id
   END) as next_week
+FROM (
+  SELECT id, startdate, max(startdate, COALESCE(enddate, '')) AS enddate
+  FROM agenda_entry
+  WHERE status = 1 AND rrule = '' AND deleted_at IS NULL
+  UNION ALL
+  SELECT o.entry_id AS id, o.startdate, o.enddate
+  FROM agenda_occurrence o JOIN agenda_entry e ON e.id = o.entry_id
+  WHERE e.status = 1 AND o.status = 1 AND e.deleted_at IS NULL
+) AS dated_entry;
+
+-- name: ListUpcomingCategoriesAndTags :many
+SELECT category, tag, event_lifecycle_status
 FROM agenda_entry
-WHERE status = 1;
\ No newline at end of file
+WHERE status = 1 AND deleted_at IS NULL
+AND (
+  (rrule = '' AND max(startdate, COALESCE(enddate, '')) >= CAST(sqlc.arg(today) AS TEXT))
+  OR EXISTS (
+    SELECT 1 FROM agenda_occurrence o
+    WHERE o.entry_id = agenda_entry.id AND o.status = 1 AND o.enddate >= CAST(sqlc.arg(today) AS TEXT)
+  )
+);
+
+-- name: SearchAgendaEntries :many
+SELECT
+  e.id, e.title, e.subtitle, e.startdate, e.enddate, e.poster, e.category, e.venuename, e.place,
+  CAST(highlight(agenda_entry_fts, 1, sqlc.arg(mark_start), sqlc.arg(mark_end)) AS TEXT) AS title_highlight,
+  CAST(snippet(agenda_entry_fts, -1, sqlc.arg(mark_start), sqlc.arg(mark_end), '…', 16) AS TEXT) AS snippet,
+  CAST(bm25(agenda_entry_fts, 0.0, 10.0, 5.0, 1.0, 3.0, 3.0, 2.0) AS REAL) AS rank
+FROM agenda_entry_fts
+JOIN agenda_entry e ON e.id = agenda_entry_fts.id
+WHERE agenda_entry_fts MATCH sqlc.arg(query)
+AND e.status = 1 AND e.deleted_at IS NULL
+ORDER BY rank
+LIMIT sqlc.arg(limit);
@@ -1,21 +1,23 @@
 -- name: CreateFormSubmission :exec
-INSERT INTO form_submissions (id, email, data, edit_token, cancel_token, confirmation_token, created_at, updated_at, expired_at, status)
-    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+INSERT INTO form_submissions (id, email, data, edit_token, cancel_token, confirmation_token, created_at, updated_at, expired_at, status, organizer_id)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
 
 -- name: GetSubmissionByToken :one
-SELECT id, email, data, edit_token, cancel_token, confirmation_token, created_at, updated_at, expired_at, status
+SELECT id, email, data, edit_token, cancel_token, confirmation_token, created_at, updated_at, expired_at, status, organizer_id,
+    assigned_to, moderation_comment
     FROM form_submissions 
-    WHERE (edit_token = ? OR cancel_token = ? OR confirmation_token = ?);
+    WHERE (edit_token = ? OR cancel_token = ? OR confirmation_token = ?) AND deleted_at IS NULL;
 
 -- name: GetSubmissions :many
 SELECT * 
     FROM form_submissions
-    WHERE status='pending' or status='active' or status='archived';
+    WHERE deleted_at IS NULL
+    AND (status='pending' or status='changes_requested' or status='active' or status='archived');
 
 -- name: GetSubmissionByID :one
 SELECT *
     FROM form_submissions
-    WHERE ID = ?;
+    WHERE ID = ? AND deleted_at IS NULL;
 
 
 -- name: UpdateSubmissionStatus :exec
@@ -38,4 +40,33 @@ UPDATE form_submissions
     WHERE ID = ?;
 
 -- name: DeleteSubmissionByID :exec
-DELETE FROM form_submissions WHERE ID = ?;
\ No newline at end of file
+DELETE FROM form_submissions WHERE ID = ?;
+
+-- name: TrashSubmission :exec
+UPDATE form_submissions SET deleted_at = ? WHERE ID = ? AND deleted_at IS NULL;
+
+-- name: RestoreSubmission :exec
+UPDATE form_submissions SET deleted_at = NULL WHERE ID = ?;
+
+-- name: PurgeTrashedSubmissions :exec
+DELETE FROM form_submissions WHERE deleted_at IS NOT NULL AND deleted_at < ?;
+
+-- name: UpdateSubmissionModeration :execrows
+UPDATE form_submissions
+    SET status = ?, moderation_comment = ?, updated_at = ?
+    WHERE id = ? AND status = ?;
+
+-- name: UpdateSubmissionAssignee :exec
+UPDATE form_submissions
+    SET assigned_to = ?, updated_at = ?
+    WHERE id = ?;
+
+-- name: CreateSubmissionNote :exec
+INSERT INTO submission_note (submission_id, user_id, body, created_at)
+    VALUES (?, ?, ?, ?);
+
+-- name: GetSubmissionNotes :many
+SELECT id, submission_id, user_id, body, created_at
+    FROM submission_note
+    WHERE submission_id = ?
+    ORDER BY created_at, id;
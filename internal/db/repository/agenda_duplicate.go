@@ -0,0 +1,270 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Duplicate kinds
+const (
+	DuplicateEntry      = "entry"
+	DuplicateSubmission = "submission"
+)
+
+const (
+	// a candidate has at least half of the title words in common and a score of minDuplicateScore
+	minTitleSimilarity = 0.5
+	minDuplicateScore  = 0.6
+	// two start times closer than this are the same show
+	duplicateTimeTolerance = time.Hour
+)
+
+var ErrInvalidMerge = errors.New("invalid merge")
+var ErrNoSubmissionFound = errors.New("No submission found")
+
+// titleSimilarity the words in common (Jaccard index) of the normalized titles
+func titleSimilarity(a string, b string) float64 {
+	words := func(value string) []string {
+		return slices.Compact(slices.Sorted(slices.Values(strings.Fields(normalizeVenue(value)))))
+	}
+	wordsA, wordsB := words(a), words(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+	common, union := 0, len(wordsB)
+	for _, word := range wordsA {
+		if slices.Contains(wordsB, word) {
+			common++
+		} else {
+			union++
+		}
+	}
+	return float64(common) / float64(union)
+}
+
+// DuplicateScore compares the title, the venue and the start time of two events on the same day,
+// a missing venue or time counts half
+func DuplicateScore(entry db.AgendaEntry, other db.AgendaEntry) float64 {
+	if !entry.StartDate.Equal(other.StartDate) {
+		return 0
+	}
+	title := titleSimilarity(entry.Title+" "+entry.Subtitle, other.Title+" "+other.Subtitle)
+	if title < minTitleSimilarity {
+		return 0
+	}
+	venue := 0.5
+	if normalizeVenue(entry.VenueName) != "" && normalizeVenue(other.VenueName) != "" {
+		venue = titleSimilarity(entry.VenueName, other.VenueName)
+	}
+	startTime := 0.5
+	if !entry.StartTime.IsZero() && !other.StartTime.IsZero() {
+		startTime = 0
+		if math.Abs(entry.StartTime.Sub(other.StartTime).Minutes()) <= duplicateTimeTolerance.Minutes() {
+			startTime = 1
+		}
+	}
+	return math.Round((0.6*title+0.25*venue+0.15*startTime)*100) / 100
+}
+
+// FindDuplicates the agenda entries and the submissions waiting for moderation which may be the same
+// event as the entry, the most similar first
+func (repo *AgendaRepository) FindDuplicates(ctx context.Context, entry db.AgendaEntry) ([]db.DuplicateCandidate, error) {
+	candidates := []db.DuplicateCandidate{}
+	if entry.StartDate.IsZero() {
+		return candidates, nil
+	}
+	day := entry.StartDate.Format(dateLayout)
+	rows, err := repo.db.QueryContext(ctx, `SELECT `+agendaColumns+` FROM agenda_entry
+		WHERE startdate = ? AND id != ? AND deleted_at IS NULL AND status != ?`, day, entry.ID, db.Status_Removed)
+	if err != nil {
+		return nil, fmt.Errorf("duplicates of %s: %w", entry.ID, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var other db.AgendaEntry
+		if _, err := repo.rowToAgendaEntry(rows, &other); err != nil {
+			return nil, err
+		}
+		if score := DuplicateScore(entry, other); score >= minDuplicateScore {
+			candidates = append(candidates, duplicateCandidate(DuplicateEntry, other.Status.String(), other, score))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// a published submission is compared as agenda entry
+	submissions, err := repo.db.QueryContext(ctx, `SELECT id, status, data FROM form_submissions
+		WHERE id != ? AND deleted_at IS NULL AND status IN (?, ?, ?) AND json_extract(data, '$.startdate') = ?`,
+		entry.ID, db.SubmissionStatus_Unconfirmed, db.SubmissionStatus_Pending, db.SubmissionStatus_ChangesRequested, day)
+	if err != nil {
+		return nil, fmt.Errorf("duplicates of %s: %w", entry.ID, err)
+	}
+	defer submissions.Close()
+	for submissions.Next() {
+		var id, status, data string
+		if err := submissions.Scan(&id, &status, &data); err != nil {
+			return nil, err
+		}
+		var other db.AgendaEntry
+		if err := json.Unmarshal([]byte(data), &other); err != nil {
+			continue
+		}
+		other.ID = id
+		if score := DuplicateScore(entry, other); score >= minDuplicateScore {
+			candidates = append(candidates, duplicateCandidate(DuplicateSubmission, status, other, score))
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Score > candidates[j].Score })
+	return candidates, submissions.Err()
+}
+
+func duplicateCandidate(kind string, status string, entry db.AgendaEntry, score float64) db.DuplicateCandidate {
+	candidate := db.DuplicateCandidate{
+		ID:        entry.ID,
+		Kind:      kind,
+		Status:    status,
+		Title:     entry.Title,
+		VenueName: entry.VenueName,
+		StartDate: entry.StartDate.Format(dateLayout),
+		Score:     score,
+	}
+	if !entry.StartTime.IsZero() {
+		candidate.StartTime = entry.StartTime.Format(timeLayout)
+	}
+	return candidate
+}
+
+// Merge keeps the target entry and completes its empty fields with the duplicate, an agenda entry or a
+// submission waiting for moderation (DuplicateEntry or DuplicateSubmission). The subscriptions of a duplicate
+// entry move to the target. The duplicate and its submission go to the trash, in the same transaction.
+func (repo *AgendaRepository) Merge(ctx context.Context, targetID string, duplicateID string, kind string) (db.AgendaEntry, error) {
+	if targetID == duplicateID {
+		return db.AgendaEntry{}, fmt.Errorf("%w: an entry can't be merged with itself", ErrInvalidMerge)
+	}
+	if kind != DuplicateEntry && kind != DuplicateSubmission {
+		return db.AgendaEntry{}, fmt.Errorf("%w: unknown duplicate kind %q", ErrInvalidMerge, kind)
+	}
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return db.AgendaEntry{}, fmt.Errorf("merge %s: %w", duplicateID, err)
+	}
+	defer tx.Rollback()
+	txRepo := &AgendaRepository{tx}
+
+	target, err := txRepo.FindByID(ctx, targetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return target, ErrNoAgendaEntryFound
+	}
+	if err != nil {
+		return target, fmt.Errorf("merge %s: %w", targetID, err)
+	}
+	var duplicate db.AgendaEntry
+	if kind == DuplicateSubmission {
+		duplicate, err = txRepo.findModeratedSubmission(ctx, duplicateID)
+	} else {
+		duplicate, err = txRepo.FindByID(ctx, duplicateID)
+		if errors.Is(err, sql.ErrNoRows) {
+			err = ErrNoAgendaEntryFound
+		}
+	}
+	if err != nil {
+		return target, fmt.Errorf("merge %s: %w", duplicateID, err)
+	}
+
+	completeEntry(&target, duplicate)
+	if err := txRepo.update(ctx, target, RevisionMerge); err != nil {
+		return target, err
+	}
+	if kind == DuplicateEntry {
+		// a subscriber of both events keeps one subscription
+		if _, err := tx.ExecContext(ctx, `UPDATE OR IGNORE event_subscription SET entry_id = ? WHERE entry_id = ?`, targetID, duplicateID); err != nil {
+			return target, fmt.Errorf("merge subscriptions of %s: %w", duplicateID, err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM event_subscription WHERE entry_id = ?`, duplicateID); err != nil {
+			return target, fmt.Errorf("merge subscriptions of %s: %w", duplicateID, err)
+		}
+		if err := txRepo.Trash(ctx, duplicateID); err != nil {
+			return target, err
+		}
+	}
+	// the submission of a published entry keeps its id
+	if _, err := tx.ExecContext(ctx, `UPDATE form_submissions SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`,
+		time.Now().UTC(), duplicateID); err != nil {
+		return target, fmt.Errorf("trash submission %s: %w", duplicateID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return target, fmt.Errorf("merge %s: %w", duplicateID, err)
+	}
+	return repo.FindByID(ctx, targetID)
+}
+
+// findModeratedSubmission the event of a submission waiting for moderation, as FindDuplicates lists them
+func (repo *AgendaRepository) findModeratedSubmission(ctx context.Context, id string) (db.AgendaEntry, error) {
+	var data string
+	err := repo.db.QueryRowContext(ctx, `SELECT data FROM form_submissions
+		WHERE id = ? AND deleted_at IS NULL AND status IN (?, ?, ?)`,
+		id, db.SubmissionStatus_Unconfirmed, db.SubmissionStatus_Pending, db.SubmissionStatus_ChangesRequested).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.AgendaEntry{}, ErrNoSubmissionFound
+	}
+	if err != nil {
+		return db.AgendaEntry{}, err
+	}
+	var entry db.AgendaEntry
+	if err := json.Unmarshal([]byte(data), &entry); err != nil {
+		return entry, fmt.Errorf("%w: the submission %s can't be read", ErrInvalidMerge, id)
+	}
+	entry.ID = id
+	return entry, nil
+}
+
+// completeEntry fills the empty fields of the target with the duplicate, the tags are added
+func completeEntry(target *db.AgendaEntry, duplicate db.AgendaEntry) {
+	for field, value := range map[*string]string{
+		&target.Subtitle:    duplicate.Subtitle,
+		&target.Link:        duplicate.Link,
+		&target.Price:       duplicate.Price,
+		&target.Description: duplicate.Description,
+		&target.Category:    duplicate.Category,
+		&target.Infos:       duplicate.Infos,
+	} {
+		if strings.TrimSpace(*field) == "" {
+			*field = value
+		}
+	}
+	// the sizes of the poster come with it
+	if strings.TrimSpace(target.Poster) == "" {
+		target.Poster, target.PosterVariants = duplicate.Poster, duplicate.PosterVariants
+	}
+	if strings.TrimSpace(target.VenueName) == "" {
+		target.VenueName, target.VenueID = duplicate.VenueName, duplicate.VenueID
+		target.Address, target.Place = duplicate.Address, duplicate.Place
+	}
+	if target.OrganizerID == 0 {
+		target.OrganizerID = duplicate.OrganizerID
+	}
+	if target.StartTime.IsZero() {
+		target.StartTime = duplicate.StartTime
+	}
+	if target.EndTime.IsZero() {
+		target.EndTime = duplicate.EndTime
+	}
+	if target.EndDate.IsZero() {
+		target.EndDate = duplicate.EndDate
+	}
+	for _, tag := range duplicate.Tags {
+		if !slices.ContainsFunc(target.Tags, func(other string) bool { return strings.EqualFold(other, tag) }) {
+			target.Tags = append(target.Tags, tag)
+		}
+	}
+}
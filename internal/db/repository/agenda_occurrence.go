@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"dpatrov/scraper/internal/db"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// the occurrences are expanded one year ahead, the archiver extends them every day
+	occurrenceHorizon = 1
+	maxOccurrences    = 1000
+)
+
+// occurrencesSource lists every occurrence as an agenda entry: the rows of agenda_occurrence with the
+// dates (and the archived status) of the occurrence, plus the entries without recurrence
+var occurrencesSource = func() string {
+	var columns []string
+	for _, column := range strings.Split(agendaColumns+", event_owner", ",") {
+		column = strings.TrimSpace(column)
+		switch column {
+		case "startdate", "enddate":
+			columns = append(columns, "o."+column+" AS "+column)
+		case "status":
+			columns = append(columns, "CASE WHEN e.status = 1 THEN o.status ELSE e.status END AS status")
+		default:
+			columns = append(columns, "e."+column)
+		}
+	}
+	return `SELECT ` + strings.Join(columns, ", ") + `
+		FROM agenda_entry e JOIN agenda_occurrence o ON o.entry_id = e.id
+		WHERE e.rrule != ''
+		UNION ALL
+		SELECT ` + agendaColumns + `, event_owner FROM agenda_entry WHERE rrule = ''`
+}()
+
+// SaveOccurrences expands the recurrence of the entry, the passed occurrences are archived
+func (repo *AgendaRepository) SaveOccurrences(ctx context.Context, entry db.AgendaEntry, now time.Time) error {
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return fmt.Errorf("save occurrences: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM agenda_occurrence WHERE entry_id = ?`, entry.ID); err != nil {
+		return fmt.Errorf("save occurrences: %w", err)
+	}
+	if entry.RRule != "" {
+		horizon := now
+		if entry.StartDate.After(horizon) {
+			horizon = entry.StartDate
+		}
+		occurrences, err := entry.Occurrences(horizon.AddDate(occurrenceHorizon, 0, 0), maxOccurrences)
+		if err != nil {
+			return fmt.Errorf("save occurrences of %s: %w", entry.ID, err)
+		}
+		today := now.Format(dateLayout)
+		for _, occurrence := range occurrences {
+			status := db.Status_Active
+			if occurrence.EndDate.Format(dateLayout) < today {
+				status = db.Status_Archived
+			}
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO agenda_occurrence (entry_id, startdate, enddate, status) VALUES (?, ?, ?, ?)`,
+				entry.ID, occurrence.StartDate.Format(dateLayout), occurrence.EndDate.Format(dateLayout), status); err != nil {
+				return fmt.Errorf("save occurrences: %w", err)
+			}
+		}
+	}
+	agendaChanged(tx)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("save occurrences: %w", err)
+	}
+	return nil
+}
+
+// RefreshOccurrences expands again the recurring entries, to keep a year of occurrences ahead
+func (repo *AgendaRepository) RefreshOccurrences(ctx context.Context, now time.Time) error {
+	rows, err := repo.db.QueryContext(ctx, `SELECT `+agendaColumns+` FROM agenda_entry WHERE rrule != '' AND deleted_at IS NULL`)
+	if err != nil {
+		return fmt.Errorf("refresh occurrences: %w", err)
+	}
+	var entries []db.AgendaEntry
+	for rows.Next() {
+		var entry db.AgendaEntry
+		if _, err := repo.rowToAgendaEntry(rows, &entry); err != nil {
+			rows.Close()
+			return err
+		}
+		entries = append(entries, entry)
+	}
+	rows.Close()
+	for _, entry := range entries {
+		if err := repo.SaveOccurrences(ctx, entry, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
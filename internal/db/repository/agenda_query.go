@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"dpatrov/scraper/internal/db"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidAgendaQuery = errors.New("invalid agenda query")
+
+// AgendaQuery filters the agenda entries, the zero value matches every entry
+type AgendaQuery struct {
+	Statuses         []db.Status
+	ExcludedStatuses []db.Status
+	Owner            int
+	// the entries taking place between From and To (inclusive dates)
+	From time.Time
+	To   time.Time
+	// any of the categories, any of the tags, any of the lifecycle statuses
+	Categories []string
+	Tags       []string
+	Lifecycles []string
+	// Venue is searched in the venue name, the address and the place, VenueID is the linked venue
+	Venue   string
+	VenueID int
+	// OrganizerID the entries of the organizer
+	OrganizerID int
+	// Text is searched in the title, the subtitle and the description
+	Text string
+	// Sort is one of AgendaSorts, by start date by default
+	Sort string
+	// Limit the size of a page, Cursor is the AgendaPage.Next of the previous page
+	Limit  int
+	Cursor string
+}
+
+// AgendaPage Next is empty on the last page
+type AgendaPage struct {
+	Entries []db.AgendaEntry
+	Next    string
+}
+
+type agendaSort struct {
+	// the id (and the start date of an occurrence) keeps the order stable between pages
+	keys []string
+	desc bool
+}
+
+var agendaSorts = map[string]agendaSort{
+	"":           {keys: []string{"startdate", "COALESCE(starttime, '')", "id"}},
+	"startdate":  {keys: []string{"startdate", "COALESCE(starttime, '')", "id"}},
+	"-startdate": {keys: []string{"startdate", "COALESCE(starttime, '')", "id"}, desc: true},
+	"title":      {keys: []string{"lower(title)", "startdate", "id"}},
+	"-title":     {keys: []string{"lower(title)", "startdate", "id"}, desc: true},
+}
+
+func AgendaSorts() []string {
+	return []string{"startdate", "-startdate", "title", "-title"}
+}
+
+// criteria every value is passed as a parameter of the statement
+func (query AgendaQuery) criteria() ([]string, []any) {
+	// the trashed entries are only listed by FindTrashed
+	criteria := []string{"deleted_at IS NULL"}
+	var args []any
+	in := func(column string, values []any) {
+		criteria = append(criteria, fmt.Sprintf("%s IN (%s)", column, strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")))
+		args = append(args, values...)
+	}
+
+	if len(query.Statuses) > 0 {
+		in("status", anyValues(query.Statuses, func(status db.Status) any { return int(status) }))
+	}
+	if len(query.ExcludedStatuses) > 0 {
+		in("status NOT", anyValues(query.ExcludedStatuses, func(status db.Status) any { return int(status) }))
+	}
+	if query.Owner != 0 {
+		criteria = append(criteria, "event_owner = ?")
+		args = append(args, query.Owner)
+	}
+	if !query.From.IsZero() {
+		// an entry without end date ends on its start date
+		criteria = append(criteria, "max(startdate, COALESCE(enddate, '')) >= ?")
+		args = append(args, query.From.Format(dateLayout))
+	}
+	if !query.To.IsZero() {
+		criteria = append(criteria, "startdate <= ?")
+		args = append(args, query.To.Format(dateLayout))
+	}
+	if len(query.Categories) > 0 {
+		in("lower(category)", anyValues(query.Categories, func(category string) any { return strings.ToLower(category) }))
+	}
+	if len(query.Lifecycles) > 0 {
+		// scheduled events are saved without lifecycle
+		in("lower(event_lifecycle_status)", anyValues(query.Lifecycles, func(lifecycle string) any {
+			if normalized, known := db.NormalizeLifecycle(lifecycle); known {
+				return normalized
+			}
+			return strings.ToLower(lifecycle)
+		}))
+	}
+	if len(query.Tags) > 0 {
+		// tags are saved comma separated: "Jazz,World"
+		var tagCriteria []string
+		for _, tag := range query.Tags {
+			tagCriteria = append(tagCriteria, `(',' || COALESCE(tag, '') || ',') LIKE ? ESCAPE '\'`)
+			args = append(args, "%,"+likeEscape(strings.TrimSpace(tag))+",%")
+		}
+		criteria = append(criteria, "("+strings.Join(tagCriteria, " OR ")+")")
+	}
+	if query.Venue != "" {
+		criteria = append(criteria, `(venuename LIKE ? ESCAPE '\' OR address LIKE ? ESCAPE '\' OR place LIKE ? ESCAPE '\')`)
+		pattern := "%" + likeEscape(query.Venue) + "%"
+		args = append(args, pattern, pattern, pattern)
+	}
+	if query.VenueID != 0 {
+		criteria = append(criteria, "venue_id = ?")
+		args = append(args, query.VenueID)
+	}
+	if query.OrganizerID != 0 {
+		criteria = append(criteria, "organizer_id = ?")
+		args = append(args, query.OrganizerID)
+	}
+	if query.Text != "" {
+		criteria = append(criteria, `(title LIKE ? ESCAPE '\' OR subtitle LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\')`)
+		pattern := "%" + likeEscape(query.Text) + "%"
+		args = append(args, pattern, pattern, pattern)
+	}
+	return criteria, args
+}
+
+func anyValues[T any](values []T, convert func(T) any) []any {
+	result := make([]any, len(values))
+	for i, value := range values {
+		result[i] = convert(value)
+	}
+	return result
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func likeEscape(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+// the cursor holds the sort keys of the last entry of the page
+func encodeCursor(keys []string) string {
+	data, _ := json.Marshal(keys)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string, size int) ([]string, error) {
+	var keys []string
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &keys)
+	}
+	if err != nil || len(keys) != size {
+		return nil, fmt.Errorf("%w: bad cursor", ErrInvalidAgendaQuery)
+	}
+	return keys, nil
+}
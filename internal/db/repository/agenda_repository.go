@@ -4,27 +4,46 @@ import (
 	"context"
 	"database/sql"
 	"dpatrov/scraper/internal/db"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"math"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type AgendaRepository struct {
-	db *sql.DB
+	db dbtx
 }
 
 func NewAgendaRepository(db *sql.DB) *AgendaRepository {
 	return &AgendaRepository{db}
 }
 
+// WithTx the repository inside the transaction, committed by the caller
+func (repo *AgendaRepository) WithTx(tx *sql.Tx) *AgendaRepository {
+	return &AgendaRepository{tx}
+}
+
 type Filter = map[string]int
 
 var ErrNoAgendaEntryFound = errors.New("No Agenda found")
+var ErrInvalidLifecycle = errors.New("invalid event lifecycle")
+
+// agendaVersion changes once every write of the agenda entries is committed, the caches built on them compare it
+var agendaVersion atomic.Uint64
+
+func AgendaVersion() uint64 {
+	return agendaVersion.Load()
+}
+
+// AgendaChanged is called by the owner of a transaction given to WithTx, once it is committed
+func AgendaChanged() {
+	agendaVersion.Add(1)
+}
 
 var (
 	dateLayout = "2006-01-02"
@@ -33,21 +52,61 @@ var (
 
 // API
 func (repo *AgendaRepository) Create(ctx context.Context, entity *db.AgendaEntry) (*db.AgendaEntry, error) {
-	stm, err := repo.db.Prepare(`INSERT INTO agenda_entry
+	return repo.create(ctx, entity, RevisionCreate)
+}
+
+// create the entry with its venue, occurrences and revision in one transaction
+func (repo *AgendaRepository) create(ctx context.Context, entity *db.AgendaEntry, action string) (*db.AgendaEntry, error) {
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return nil, fmt.Errorf("Create agenda error %w", err)
+	}
+	defer tx.Rollback()
+	if err := (&AgendaRepository{tx}).insertEntry(ctx, entity, action); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("Create agenda error %w", err)
+	}
+	return entity, nil
+}
+
+func (repo *AgendaRepository) insertEntry(ctx context.Context, entity *db.AgendaEntry, action string) error {
+	stm, err := repo.db.PrepareContext(ctx, `INSERT INTO agenda_entry
 									(id, title, link, price, address, 
 										startdate, description, poster, category, tag, 
 										infos, place, status, event_lifecycle_status,
-										starttime, endtime, subtitle, enddate, venuename)
+										starttime, endtime, subtitle, enddate, venuename,
+										created_at, updated_at, rrule, exdates, venue_id, organizer_id, original_startdate,
+										poster_variants)
 										VALUES 
-									(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+									(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		log.Fatalf("AgendaRepository::Create STM error: %v", err)
 	}
+	defer stm.Close()
 
+	if err := repo.linkVenue(ctx, entity); err != nil {
+		return err
+	}
+	if err := repo.checkOrganizer(ctx, *entity); err != nil {
+		return err
+	}
+	if entity.ID != "" {
+		trashed, err := repo.isTrashed(ctx, entity.ID)
+		if err != nil {
+			return err
+		}
+		if trashed {
+			return fmt.Errorf("%w: %s", ErrEntryInTrash, entity.ID)
+		}
+	}
 	tagStrings := strings.Join(entity.Tags, ",")
 	if entity.ID == "" {
 		entity.ID = uuid.New().String()
 	}
+	entity.CreatedAt = time.Now().UTC()
+	entity.UpdatedAt = entity.CreatedAt
 	_, err = stm.ExecContext(ctx,
 		entity.ID,
 		entity.Title,
@@ -68,144 +127,141 @@ func (repo *AgendaRepository) Create(ctx context.Context, entity *db.AgendaEntry
 		entity.Subtitle,
 		entity.EndDate.Format(dateLayout),
 		entity.VenueName,
+		nullTime(entity.CreatedAt),
+		nullTime(entity.UpdatedAt),
+		entity.RRule,
+		strings.Join(entity.ExDates, ","),
+		nullInt(entity.VenueID),
+		nullInt(entity.OrganizerID),
+		entity.OriginalStartDate,
+		posterVariantsString(entity.PosterVariants),
 	)
 	if err != nil {
-		fmt.Errorf("Create agenda error %v", err)
+		return fmt.Errorf("Create agenda error %w", err)
 	}
-	// populate back with ID
-	return entity, nil
+	agendaChanged(repo.db)
+	if entity.RRule != "" {
+		if err := repo.SaveOccurrences(ctx, *entity, time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := repo.recordRevision(ctx, action, entity.ID); err != nil {
+		return err
+	}
+	return nil
 }
 
+const agendaColumns = `id, title, link, price, address, startdate, description, poster, category, tag,
+	infos, status, event_lifecycle_status, place, starttime, endtime, subtitle, enddate, venuename,
+	created_at, updated_at, rrule, exdates, venue_id, organizer_id, original_startdate, deleted_at, poster_variants`
+
+// FindAll keeps the legacy filters: {"status": 1} returns the active and deleted entries,
+// {"status": -4} every entry but the unlinked ones
 func (repo *AgendaRepository) FindAll(ctx context.Context, filter Filter) ([]db.AgendaEntry, error) {
-	entries := []db.AgendaEntry{}
-
-	var criteria []string
-	criteria = append(criteria, "WHERE 1=1")
-
-	query := `SELECT 
-				id, 
-				title, 
-				link, 
-				price, 
-				address, 
-				startdate, 
-				description, 
-				poster, 
-				category, 
-				tag, 
-				infos, 
-				status,
-				event_lifecycle_status,
-				place,
-				starttime,
-				endtime,
-				subtitle,
-				enddate,
-				venuename
-			FROM agenda_entry
-			`
-	// apply filter
-	//WHERE enddate >= date('now', '-7 days')
-
-	if filter != nil {
-		if status, exists := filter["status"]; exists {
-			if status == -int(db.Status_Unlinked) {
-				// activeStatus := fmt.Sprintf("%d%d", db.Status_Active, db.Status_Archived)
-				// statusCode, _ := strconv.Atoi(activeStatus)
-				criteria = append(criteria, fmt.Sprintf("status!=%d", int(math.Abs(float64(status)))))
-			} else if status == int(db.Status_Active) {
-				criteria = append(criteria, fmt.Sprintf("status=%d OR status=%d", status, db.Status_Deleted))
-			} else {
-				criteria = append(criteria, fmt.Sprintf("status=%d", status))
-			}
-		}
-		if owner, exists := filter["owner"]; exists {
-			criteria = append(criteria, fmt.Sprintf("owner=%d", owner))
+	query := AgendaQuery{}
+	if status, exists := filter["status"]; exists {
+		if status == -int(db.Status_Unlinked) {
+			query.ExcludedStatuses = []db.Status{db.Status_Unlinked}
+		} else if status == int(db.Status_Active) {
+			query.Statuses = []db.Status{db.Status_Active, db.Status_Deleted}
+		} else {
+			query.Statuses = []db.Status{db.Status(status)}
 		}
 	}
-	query += strings.Join(criteria, " AND ")
-	query += " ORDER BY startdate ASC;"
-	fmt.Printf("Query:: %s \n", query)
-
-	var tagString string
-	var startDateString string
-	var endDateString string
-	var startTimeString string
-	var endTimeString string
-
-	rows, err := repo.db.Query(query)
+	if owner, exists := filter["owner"]; exists {
+		query.Owner = owner
+	}
+	page, err := repo.Find(ctx, query)
 	if err != nil {
-		fmt.Errorf("%v", err)
 		return nil, err
 	}
-	for rows.Next() {
-		var entry db.AgendaEntry
-		err := rows.Scan(
-			&entry.ID,
-			&entry.Title,
-			&entry.Link,
-			&entry.Price,
-			&entry.Address,
-			&startDateString,
-			&entry.Description,
-			&entry.Poster,
-			&entry.Category,
-			&tagString,
-			&entry.Infos,
-			&entry.Status,
-			&entry.EventLifecycleStatus,
-			&entry.Place,
-			&startTimeString,
-			&endTimeString,
-			&entry.Subtitle,
-			&endDateString,
-			&entry.VenueName,
-		)
+	return page.Entries, nil
+}
 
+// Find returns the entries matching the query, a page at a time when a limit is given
+func (repo *AgendaRepository) Find(ctx context.Context, query AgendaQuery) (AgendaPage, error) {
+	page := AgendaPage{Entries: []db.AgendaEntry{}}
+	sort, exists := agendaSorts[query.Sort]
+	if !exists {
+		return page, fmt.Errorf("%w: unknown sort %s", ErrInvalidAgendaQuery, query.Sort)
+	}
+	criteria, args := query.criteria()
+
+	if query.Cursor != "" {
+		values, err := decodeCursor(query.Cursor, len(sort.keys))
 		if err != nil {
-			fmt.Printf("HS agenda_repository %v\n", err)
-			return nil, err
+			return page, err
 		}
-		if startDate, err := time.Parse(dateLayout, startDateString); err == nil {
-			entry.StartDate = startDate
-		} else {
-			fmt.Printf("err%v", err)
+		operator := ">"
+		if sort.desc {
+			operator = "<"
 		}
-
-		if endDate, err := time.Parse(dateLayout, endDateString); err == nil {
-			entry.EndDate = endDate
+		criteria = append(criteria, fmt.Sprintf("(%s) %s (%s)",
+			strings.Join(sort.keys, ", "), operator, strings.TrimSuffix(strings.Repeat("?, ", len(sort.keys)), ", ")))
+		for _, value := range values {
+			args = append(args, value)
 		}
-		if startTime, err := time.Parse(timeLayout, startTimeString); err == nil {
-			entry.StartTime = startTime
-		} else {
-			fmt.Printf("error %v, %s", startTime, startDateString)
+	}
+
+	source := "agenda_entry"
+	if !query.From.IsZero() || !query.To.IsZero() {
+		// within a window, a recurring entry is listed once per occurrence
+		source = "(" + occurrencesSource + ") AS agenda_entry"
+	}
+	statement := "SELECT " + agendaColumns + ", " + strings.Join(sort.keys, ", ") + " FROM " + source
+	if len(criteria) > 0 {
+		statement += " WHERE " + strings.Join(criteria, " AND ")
+	}
+	direction := " ASC"
+	if sort.desc {
+		direction = " DESC"
+	}
+	statement += " ORDER BY " + strings.Join(sort.keys, direction+", ") + direction
+	if query.Limit > 0 {
+		// one more row tells if there is a next page
+		statement += " LIMIT ?"
+		args = append(args, query.Limit+1)
+	}
+
+	rows, err := repo.db.QueryContext(ctx, statement, args...)
+	if err != nil {
+		return page, fmt.Errorf("find agenda entries: %w", err)
+	}
+	defer rows.Close()
+	var lastKeys []string
+	for rows.Next() {
+		if query.Limit > 0 && len(page.Entries) == query.Limit {
+			page.Next = encodeCursor(lastKeys)
+			break
 		}
-		if endTime, err := time.Parse(timeLayout, endTimeString); err == nil {
-			entry.EndTime = endTime
-		} else {
-			fmt.Printf("error %v", endTime)
+		var entry db.AgendaEntry
+		keys := make([]string, len(sort.keys))
+		sortValues := make([]any, len(keys))
+		for i := range keys {
+			sortValues[i] = &keys[i]
 		}
-
-		if len(tagString) == 0 {
-			entry.Tags = make([]string, 0)
-		} else {
-			entry.Tags = strings.Split(tagString, ",")
+		if _, err := repo.rowToAgendaEntry(rows, &entry, sortValues...); err != nil {
+			return page, err
 		}
-
-		entries = append(entries, entry)
+		page.Entries = append(page.Entries, entry)
+		lastKeys = keys
 	}
-	return entries, nil
-
+	return page, rows.Err()
 }
 
-func (repo *AgendaRepository) rowToAgendaEntry(row *sql.Row, entry *db.AgendaEntry) (*db.AgendaEntry, error) {
+// rowToAgendaEntry scans the agendaColumns, extra receives the columns selected after them
+func (repo *AgendaRepository) rowToAgendaEntry(row interface{ Scan(...any) error }, entry *db.AgendaEntry, extra ...any) (*db.AgendaEntry, error) {
 	var tagString string
 	var startDateString string
 	var startTimeString string
 	var endTimeString string
 	var endDateString string
+	var createdAt, updatedAt, deletedAt sql.NullTime
+	var exdatesString string
+	var venueID, organizerID sql.NullInt64
+	var posterVariantsString string
 
-	err := row.Scan(
+	err := row.Scan(append([]any{
 		&entry.ID,
 		&entry.Title,
 		&entry.Link,
@@ -227,7 +283,16 @@ func (repo *AgendaRepository) rowToAgendaEntry(row *sql.Row, entry *db.AgendaEnt
 		&entry.Subtitle,
 		&endDateString,
 		&entry.VenueName,
-	)
+		&createdAt,
+		&updatedAt,
+		&entry.RRule,
+		&exdatesString,
+		&venueID,
+		&organizerID,
+		&entry.OriginalStartDate,
+		&deletedAt,
+		&posterVariantsString,
+	}, extra...)...)
 	if err != nil {
 		fmt.Printf("agenda_repository:rowToAgendaEntry %v\n", err)
 		return nil, err
@@ -245,6 +310,16 @@ func (repo *AgendaRepository) rowToAgendaEntry(row *sql.Row, entry *db.AgendaEnt
 	if endTime, err := time.Parse(timeLayout, endTimeString); err == nil {
 		entry.EndTime = endTime
 	}
+	entry.CreatedAt = createdAt.Time
+	entry.UpdatedAt = updatedAt.Time
+	entry.DeletedAt = deletedAt.Time
+	entry.VenueID = int(venueID.Int64)
+	entry.OrganizerID = int(organizerID.Int64)
+	entry.PosterVariants = parsePosterVariants(posterVariantsString)
+	entry.ExDates = []string{}
+	if exdatesString != "" {
+		entry.ExDates = strings.Split(exdatesString, ",")
+	}
 
 	if len(tagString) == 0 {
 		entry.Tags = make([]string, 0)
@@ -257,29 +332,7 @@ func (repo *AgendaRepository) rowToAgendaEntry(row *sql.Row, entry *db.AgendaEnt
 func (repo *AgendaRepository) FindByID(ctx context.Context, id string) (db.AgendaEntry, error) {
 	var agenda_entry db.AgendaEntry
 
-	stm, err := repo.db.Prepare(`SELECT 
-					id, 
-					title, 
-					link, 
-					price, 
-					address, 
-					startdate, 
-					description, 
-					poster, 
-					category, 
-					tag, 
-					infos,
-					status, 
-					event_lifecycle_status,
-					place, 
-					starttime, 
-					endtime, 
-					subtitle, 
-					enddate, 
-					venuename
-				FROM agenda_entry
-				WHERE
-					id=?`)
+	stm, err := repo.db.PrepareContext(ctx, `SELECT `+agendaColumns+` FROM agenda_entry WHERE id=? AND deleted_at IS NULL`)
 	if err != nil {
 		fmt.Printf("agenda_repository:FindByID %v\n", err)
 		return agenda_entry, fmt.Errorf("Error while scanning agenda\n")
@@ -291,6 +344,27 @@ func (repo *AgendaRepository) FindByID(ctx context.Context, id string) (db.Agend
 }
 
 func (repo *AgendaRepository) Update(ctx context.Context, entry db.AgendaEntry) error {
+	return repo.update(ctx, entry, RevisionUpdate)
+}
+
+// update the entry with its venue, occurrences and revision in one transaction,
+// ErrNoAgendaEntryFound for an unknown or trashed entry
+func (repo *AgendaRepository) update(ctx context.Context, entry db.AgendaEntry, action string) error {
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return fmt.Errorf("update agenda entry: %w", err)
+	}
+	defer tx.Rollback()
+	if err := (&AgendaRepository{tx}).updateEntry(ctx, entry, action); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("update agenda entry: %w", err)
+	}
+	return nil
+}
+
+func (repo *AgendaRepository) updateEntry(ctx context.Context, entry db.AgendaEntry, action string) error {
 	query := `
 		UPDATE agenda_entry
 		SET 
@@ -311,16 +385,50 @@ func (repo *AgendaRepository) Update(ctx context.Context, entry db.AgendaEntry)
 			endtime = ?,
 			subtitle = ?,
 			enddate = ?,
-			venuename = ?
-		WHERE id = ?` // Change tag -> tags after migration
-
-	stm, err := repo.db.Prepare(query)
+			venuename = ?,
+			updated_at = ?,
+			rrule = ?,
+			exdates = ?,
+			venue_id = ?,
+			organizer_id = ?,
+			original_startdate = ?,
+			poster_variants = ?
+		WHERE id = ? AND deleted_at IS NULL` // Change tag -> tags after migration
+
+	if err := repo.linkVenue(ctx, &entry); err != nil {
+		return err
+	}
+	if err := repo.checkOrganizer(ctx, entry); err != nil {
+		return err
+	}
+	current, err := repo.FindByID(ctx, entry.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoAgendaEntryFound
+	}
+	if err != nil {
+		return fmt.Errorf("update agenda entry %s: %w", entry.ID, err)
+	}
+	if err := db.AgendaTransition(entry.ID, current.Status, entry.Status); err != nil {
+		return err
+	}
+	// the first planned date is only set by UpdateLifecycle
+	if entry.OriginalStartDate == "" {
+		entry.OriginalStartDate = current.OriginalStartDate
+	}
+	// the form sends the poster name only, the sizes stay while the poster is the same
+	if entry.PosterVariants == nil && entry.Poster == current.Poster {
+		entry.PosterVariants = current.PosterVariants
+	}
+	if err := repo.ensureBaseline(ctx, entry.ID); err != nil {
+		return err
+	}
+	stm, err := repo.db.PrepareContext(ctx, query)
 	if err != nil {
 		log.Printf("Error while updating entry: [%v]", err)
 		return fmt.Errorf("prepare update statement: %w", err)
 	}
 	defer stm.Close()
-	_, err = stm.ExecContext(ctx,
+	result, err := stm.ExecContext(ctx,
 		entry.Title,
 		entry.Link,
 		entry.Price,
@@ -342,17 +450,95 @@ func (repo *AgendaRepository) Update(ctx context.Context, entry db.AgendaEntry)
 		entry.Subtitle,
 		entry.EndDate.Format(dateLayout),
 		entry.VenueName,
+		nullTime(time.Now()),
+		entry.RRule,
+		strings.Join(entry.ExDates, ","),
+		nullInt(entry.VenueID),
+		nullInt(entry.OrganizerID),
+		entry.OriginalStartDate,
+		posterVariantsString(entry.PosterVariants),
 		entry.ID)
 	if err != nil {
 		log.Printf("[%v]", err)
 		return fmt.Errorf("execute update statement:%w", err)
 	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("execute update statement:%w", err)
+	} else if updated == 0 {
+		return ErrNoAgendaEntryFound
+	}
+	agendaChanged(repo.db)
+	if err := repo.SaveOccurrences(ctx, entry, time.Now()); err != nil {
+		return err
+	}
+	return repo.recordRevision(ctx, action, entry.ID)
+}
+
+// linkVenue an entry chosen from the venues gets its name, address and place, an entry with another
+// venue name is linked to the venue with the same name and place, created if needed
+func (repo *AgendaRepository) linkVenue(ctx context.Context, entry *db.AgendaEntry) error {
+	venues := &VenueRepository{repo.db}
+	if entry.VenueID != 0 {
+		venue, err := venues.FindByID(ctx, entry.VenueID)
+		if err != nil {
+			return fmt.Errorf("venue %d: %w", entry.VenueID, err)
+		}
+		if strings.TrimSpace(entry.VenueName) == "" || VenueKey(entry.VenueName, entry.Place) == VenueKey(venue.Name, venue.Place) {
+			entry.VenueName = venue.Name
+			if venue.Address != "" {
+				entry.Address = venue.Address
+			}
+			if venue.Place != "" {
+				entry.Place = venue.Place
+			}
+			return nil
+		}
+	}
+	entry.VenueID = 0
+	if strings.TrimSpace(entry.VenueName) == "" {
+		return nil
+	}
+	venue, err := venues.FindOrCreate(ctx, entry.VenueName, entry.Address, entry.Place)
+	if err != nil {
+		return fmt.Errorf("venue %s: %w", entry.VenueName, err)
+	}
+	entry.VenueID = venue.ID
 	return nil
 }
 
-func (repo *AgendaRepository) UpdateStatus(id string, status int) error {
-	query := `UPDATE agenda_entry SET status=? WHERE id=?`
-	result, err := repo.db.Exec(query, status, id)
+func (repo *AgendaRepository) checkOrganizer(ctx context.Context, entry db.AgendaEntry) error {
+	if entry.OrganizerID == 0 {
+		return nil
+	}
+	if _, err := (&OrganizerRepository{repo.db}).FindByID(ctx, entry.OrganizerID); err != nil {
+		return fmt.Errorf("organizer %d: %w", entry.OrganizerID, err)
+	}
+	return nil
+}
+
+// UpdateStatus moves the entry to the status, db.ErrInvalidTransition when the move isn't allowed
+func (repo *AgendaRepository) UpdateStatus(ctx context.Context, id string, status int) error {
+	current, err := repo.FindByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoAgendaEntryFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	if err := db.AgendaTransition(id, current.Status, db.Status(status)); err != nil {
+		return err
+	}
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	defer tx.Rollback()
+	txRepo := &AgendaRepository{tx}
+	if err := txRepo.ensureBaseline(ctx, id); err != nil {
+		return err
+	}
+	query := `UPDATE agenda_entry SET status=?, updated_at=? WHERE id=? AND deleted_at IS NULL`
+	result, err := tx.ExecContext(ctx, query, status, nullTime(time.Now()), id)
 	if err != nil {
 		log.Printf("Failed to update status %w", err)
 		return fmt.Errorf("Failed to update status %w", err)
@@ -367,12 +553,69 @@ func (repo *AgendaRepository) UpdateStatus(id string, status int) error {
 		log.Printf("Agenda entry with id %s not found", err)
 		return fmt.Errorf("Agenda entry with id %s not found", err)
 	}
-	return nil
+	agendaChanged(tx)
+	if err := txRepo.recordRevision(ctx, RevisionStatus, id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
+// UpdateLifecycle marks the event as cancelled, postponed, sold out, online or scheduled again. A postponed
+// event moved to startDate keeps its duration and the date it was first planned on
+func (repo *AgendaRepository) UpdateLifecycle(ctx context.Context, id string, lifecycle string, startDate time.Time) (db.AgendaEntry, error) {
+	lifecycle, known := db.NormalizeLifecycle(lifecycle)
+	if !known {
+		return db.AgendaEntry{}, fmt.Errorf("%w: %s", ErrInvalidLifecycle, lifecycle)
+	}
+	if !startDate.IsZero() && lifecycle != db.Lifecycle_Postponed {
+		return db.AgendaEntry{}, fmt.Errorf("%w: only a postponed event is moved to a new date", ErrInvalidLifecycle)
+	}
+	entry, err := repo.FindByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entry, ErrNoAgendaEntryFound
+	}
+	if err != nil {
+		return entry, fmt.Errorf("failed to update lifecycle: %w", err)
+	}
+	if !startDate.IsZero() {
+		if entry.OriginalStartDate == "" {
+			entry.OriginalStartDate = entry.StartDate.Format(dateLayout)
+		}
+		if !entry.EndDate.IsZero() {
+			duration := time.Duration(0)
+			if entry.EndDate.After(entry.StartDate) {
+				duration = entry.EndDate.Sub(entry.StartDate)
+			}
+			entry.EndDate = startDate.Add(duration)
+		}
+		entry.StartDate = startDate
+	}
+	entry.EventLifecycleStatus = lifecycle
+	if err := repo.update(ctx, entry, RevisionLifecycle); err != nil {
+		return entry, err
+	}
+	return repo.FindByID(ctx, id)
+}
+
+// Delete the entry, its last version is kept as revision to restore it
 func (repo *AgendaRepository) Delete(ctx context.Context, id string) error {
+	entry, err := repo.FindByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoAgendaEntryFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete entry with id %s: %w", id, err)
+	}
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return fmt.Errorf("failed to delete entry with id %s: %w", id, err)
+	}
+	defer tx.Rollback()
 	query := `DELETE FROM agenda_entry WHERE id=?`
-	result, err := repo.db.Exec(query, id)
+	result, err := tx.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete entry with id %s: %w", id, err)
+	}
 	rowAffected, err := result.RowsAffected()
 	if err != nil {
 		log.Printf("failed to check rows affected: %w", err)
@@ -382,5 +625,33 @@ func (repo *AgendaRepository) Delete(ctx context.Context, id string) error {
 		log.Printf("Agenda entry with id %s not found", err)
 		return ErrNoAgendaEntryFound
 	}
-	return nil
+	if _, err := tx.ExecContext(ctx, `DELETE FROM agenda_occurrence WHERE entry_id=?`, id); err != nil {
+		return fmt.Errorf("failed to delete occurrences of %s: %w", id, err)
+	}
+	agendaChanged(tx)
+	if err := (&AgendaRepository{tx}).saveRevision(ctx, RevisionDelete, entry, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func posterVariantsString(variants []db.PosterVariant) string {
+	if len(variants) == 0 {
+		return ""
+	}
+	data, err := json.Marshal(variants)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func parsePosterVariants(value string) []db.PosterVariant {
+	var variants []db.PosterVariant
+	if value != "" {
+		if err := json.Unmarshal([]byte(value), &variants); err != nil {
+			log.Printf("agenda_repository: invalid poster variants %q", value)
+		}
+	}
+	return variants
 }
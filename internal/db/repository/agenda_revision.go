@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Revision actions
+const (
+	RevisionInitial   = "initial"
+	RevisionCreate    = "create"
+	RevisionUpdate    = "update"
+	RevisionStatus    = "status"
+	RevisionLifecycle = "lifecycle"
+	RevisionRestore   = "restore"
+	RevisionMerge     = "merge"
+	RevisionTrash     = "trash"
+	RevisionUntrash   = "untrash"
+	RevisionDelete    = "delete"
+)
+
+var ErrNoRevisionFound = errors.New("No revision found")
+
+type actorKey struct{}
+
+// WithActor the revisions saved with this context are made by the user
+func WithActor(ctx context.Context, userID int) context.Context {
+	return context.WithValue(ctx, actorKey{}, userID)
+}
+
+func actorFrom(ctx context.Context) int {
+	userID, _ := ctx.Value(actorKey{}).(int)
+	return userID
+}
+
+func (repo *AgendaRepository) saveRevision(ctx context.Context, action string, entry db.AgendaEntry, createdAt time.Time) error {
+	snapshot, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("revision of %s: %w", entry.ID, err)
+	}
+	_, err = repo.db.ExecContext(ctx, `INSERT INTO agenda_revision (entry_id, action, snapshot, user_id, created_at) VALUES (?, ?, ?, ?, ?)`,
+		entry.ID, action, string(snapshot), nullInt(actorFrom(ctx)), nullTime(createdAt))
+	if err != nil {
+		return fmt.Errorf("revision of %s: %w", entry.ID, err)
+	}
+	return nil
+}
+
+// recordRevision saves the entry as stored after the action
+func (repo *AgendaRepository) recordRevision(ctx context.Context, action string, id string) error {
+	entry, err := repo.FindByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("revision of %s: %w", id, err)
+	}
+	return repo.saveRevision(ctx, action, entry, time.Now().UTC())
+}
+
+// ensureBaseline the entries created before the revisions get their current version as first revision,
+// so the first change can be undone
+func (repo *AgendaRepository) ensureBaseline(ctx context.Context, id string) error {
+	var exists bool
+	if err := repo.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM agenda_revision WHERE entry_id = ?)`, id).Scan(&exists); err != nil {
+		return fmt.Errorf("revisions of %s: %w", id, err)
+	}
+	if exists {
+		return nil
+	}
+	entry, err := repo.FindByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("revisions of %s: %w", id, err)
+	}
+	createdAt := entry.UpdatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now().UTC()
+	}
+	return repo.saveRevision(WithActor(ctx, 0), RevisionInitial, entry, createdAt)
+}
+
+func (repo *AgendaRepository) scanRevision(row interface{ Scan(...any) error }) (db.AgendaRevision, error) {
+	var revision db.AgendaRevision
+	var snapshot string
+	var userID sql.NullInt64
+	var createdAt sql.NullTime
+	if err := row.Scan(&revision.ID, &revision.EntryID, &revision.Action, &snapshot, &userID, &createdAt); err != nil {
+		return revision, err
+	}
+	if err := json.Unmarshal([]byte(snapshot), &revision.Entry); err != nil {
+		return revision, fmt.Errorf("revision %d: %w", revision.ID, err)
+	}
+	revision.UserID = int(userID.Int64)
+	revision.CreatedAt = createdAt.Time
+	return revision, nil
+}
+
+// FindRevisions the revisions of the entry, the latest first
+func (repo *AgendaRepository) FindRevisions(ctx context.Context, entryID string) ([]db.AgendaRevision, error) {
+	rows, err := repo.db.QueryContext(ctx, `SELECT id, entry_id, action, snapshot, user_id, created_at
+		FROM agenda_revision WHERE entry_id = ? ORDER BY id DESC`, entryID)
+	if err != nil {
+		return nil, fmt.Errorf("list revisions: %w", err)
+	}
+	defer rows.Close()
+	revisions := []db.AgendaRevision{}
+	for rows.Next() {
+		revision, err := repo.scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+func (repo *AgendaRepository) FindRevision(ctx context.Context, entryID string, revisionID int) (db.AgendaRevision, error) {
+	revision, err := repo.scanRevision(repo.db.QueryRowContext(ctx, `SELECT id, entry_id, action, snapshot, user_id, created_at
+		FROM agenda_revision WHERE entry_id = ? AND id = ?`, entryID, revisionID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return revision, ErrNoRevisionFound
+	}
+	return revision, err
+}
+
+// Restore the entry as it was in the revision, a deleted entry is created again.
+// The venue and organizer deleted since then are dropped
+func (repo *AgendaRepository) Restore(ctx context.Context, entryID string, revisionID int) (db.AgendaEntry, error) {
+	revision, err := repo.FindRevision(ctx, entryID, revisionID)
+	if err != nil {
+		return db.AgendaEntry{}, err
+	}
+	entry := revision.Entry
+	if entry.VenueID != 0 {
+		if _, err := (&VenueRepository{repo.db}).FindByID(ctx, entry.VenueID); errors.Is(err, ErrNoVenueFound) {
+			entry.VenueID = 0
+		}
+	}
+	if entry.OrganizerID != 0 {
+		if _, err := (&OrganizerRepository{repo.db}).FindByID(ctx, entry.OrganizerID); errors.Is(err, ErrNoOrganizerFound) {
+			entry.OrganizerID = 0
+		}
+	}
+
+	_, err = repo.FindByID(ctx, entryID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if _, err := repo.create(ctx, &entry, RevisionRestore); err != nil {
+			return entry, err
+		}
+	case err != nil:
+		return entry, err
+	default:
+		if err := repo.update(ctx, entry, RevisionRestore); err != nil {
+			return entry, err
+		}
+	}
+	return repo.FindByID(ctx, entryID)
+}
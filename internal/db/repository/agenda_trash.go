@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrEntryInTrash the entry must be restored from the trash first
+var ErrEntryInTrash = errors.New("Agenda entry is in the trash")
+
+func (repo *AgendaRepository) isTrashed(ctx context.Context, id string) (bool, error) {
+	var trashed bool
+	err := repo.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM agenda_entry WHERE id = ? AND deleted_at IS NOT NULL)`, id).Scan(&trashed)
+	if err != nil {
+		return false, fmt.Errorf("trash of %s: %w", id, err)
+	}
+	return trashed, nil
+}
+
+// Trash hides the entry until it is restored or purged, the revision keeps it as it was trashed
+func (repo *AgendaRepository) Trash(ctx context.Context, id string) error {
+	entry, err := repo.FindByID(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoAgendaEntryFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to trash %s: %w", id, err)
+	}
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return fmt.Errorf("failed to trash %s: %w", id, err)
+	}
+	defer tx.Rollback()
+	txRepo := &AgendaRepository{tx}
+	if err := txRepo.ensureBaseline(ctx, id); err != nil {
+		return err
+	}
+	entry.DeletedAt = time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, `UPDATE agenda_entry SET deleted_at = ? WHERE id = ?`, nullTime(entry.DeletedAt), id); err != nil {
+		return fmt.Errorf("failed to trash %s: %w", id, err)
+	}
+	agendaChanged(tx)
+	if err := txRepo.saveRevision(ctx, RevisionTrash, entry, entry.DeletedAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// FindTrashed the entries in the trash, the last trashed first
+func (repo *AgendaRepository) FindTrashed(ctx context.Context) ([]db.AgendaEntry, error) {
+	rows, err := repo.db.QueryContext(ctx, `SELECT `+agendaColumns+` FROM agenda_entry
+		WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC, id`)
+	if err != nil {
+		return nil, fmt.Errorf("list trash: %w", err)
+	}
+	defer rows.Close()
+	entries := []db.AgendaEntry{}
+	for rows.Next() {
+		var entry db.AgendaEntry
+		if _, err := repo.rowToAgendaEntry(rows, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// RestoreTrashed puts the entry back in the agenda with the status it had
+func (repo *AgendaRepository) RestoreTrashed(ctx context.Context, id string) (db.AgendaEntry, error) {
+	result, err := repo.db.ExecContext(ctx, `UPDATE agenda_entry SET deleted_at = NULL, updated_at = ? WHERE id = ? AND deleted_at IS NOT NULL`,
+		nullTime(time.Now()), id)
+	if err != nil {
+		return db.AgendaEntry{}, fmt.Errorf("failed to restore %s: %w", id, err)
+	}
+	if restored, err := result.RowsAffected(); err != nil || restored == 0 {
+		return db.AgendaEntry{}, ErrNoAgendaEntryFound
+	}
+	agendaChanged(repo.db)
+	if err := repo.recordRevision(ctx, RevisionUntrash, id); err != nil {
+		return db.AgendaEntry{}, err
+	}
+	return repo.FindByID(ctx, id)
+}
+
+// PurgeTrash deletes for good the entries trashed before the date, with their occurrences, revisions
+// and subscriptions
+func (repo *AgendaRepository) PurgeTrash(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := repo.db.QueryContext(ctx, `SELECT id FROM agenda_entry WHERE deleted_at IS NOT NULL AND deleted_at < ?`, nullTime(before))
+	if err != nil {
+		return nil, fmt.Errorf("purge trash: %w", err)
+	}
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("purge trash: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("purge trash: %w", err)
+	}
+
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return nil, fmt.Errorf("purge trash: %w", err)
+	}
+	defer tx.Rollback()
+	for _, id := range ids {
+		for _, statement := range []string{
+			`DELETE FROM agenda_occurrence WHERE entry_id = ?`,
+			`DELETE FROM agenda_revision WHERE entry_id = ?`,
+			`DELETE FROM event_subscription WHERE entry_id = ?`,
+			`DELETE FROM agenda_entry WHERE id = ?`,
+		} {
+			if _, err := tx.ExecContext(ctx, statement, id); err != nil {
+				return nil, fmt.Errorf("purge %s: %w", id, err)
+			}
+		}
+	}
+	if len(ids) > 0 {
+		agendaChanged(tx)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("purge trash: %w", err)
+	}
+	return ids, nil
+}
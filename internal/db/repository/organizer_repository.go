@@ -0,0 +1,212 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type OrganizerRepository struct {
+	db dbtx
+}
+
+var ErrNoOrganizerFound = errors.New("No organizer found")
+var ErrOrganizerExists = errors.New("Organizer slug already exists")
+var ErrInvalidOrganizer = errors.New("invalid organizer")
+
+func NewOrganizerRepository(db *sql.DB) *OrganizerRepository {
+	return &OrganizerRepository{db}
+}
+
+// Slugify "Collectif Kora & Cie" is "collectif-kora-cie"
+func Slugify(value string) string {
+	var slug strings.Builder
+	for _, char := range normalizeVenue(value) {
+		if (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') {
+			slug.WriteRune(char)
+		} else if slug.Len() > 0 && !strings.HasSuffix(slug.String(), "-") {
+			slug.WriteByte('-')
+		}
+	}
+	return strings.TrimSuffix(slug.String(), "-")
+}
+
+const organizerColumns = `id, slug, name, description, links, logo, email, verified, created_at, updated_at`
+
+func (repo *OrganizerRepository) scanOrganizer(row interface{ Scan(...any) error }) (db.Organizer, error) {
+	var organizer db.Organizer
+	var links string
+	var createdAt, updatedAt sql.NullTime
+	err := row.Scan(&organizer.ID, &organizer.Slug, &organizer.Name, &organizer.Description, &links, &organizer.Logo,
+		&organizer.Email, &organizer.Verified, &createdAt, &updatedAt)
+	if err != nil {
+		return organizer, err
+	}
+	organizer.Links = []string{}
+	if err := json.Unmarshal([]byte(links), &organizer.Links); err != nil {
+		return organizer, fmt.Errorf("links of the organizer %d: %w", organizer.ID, err)
+	}
+	organizer.CreatedAt = createdAt.Time
+	organizer.UpdatedAt = updatedAt.Time
+	return organizer, nil
+}
+
+func validateOrganizer(organizer *db.Organizer) error {
+	organizer.Name = strings.TrimSpace(organizer.Name)
+	organizer.Email = strings.TrimSpace(organizer.Email)
+	if organizer.Name == "" {
+		return fmt.Errorf("%w: the name is required", ErrInvalidOrganizer)
+	}
+	if organizer.Email != "" {
+		if _, err := mail.ParseAddress(organizer.Email); err != nil {
+			return fmt.Errorf("%w: the email %s is not valid", ErrInvalidOrganizer, organizer.Email)
+		}
+	}
+	links := []string{}
+	for _, link := range organizer.Links {
+		if link = strings.TrimSpace(link); link == "" {
+			continue
+		}
+		if parsed, err := url.Parse(link); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("%w: the link %s is not valid", ErrInvalidOrganizer, link)
+		}
+		links = append(links, link)
+	}
+	organizer.Links = links
+	return nil
+}
+
+// Create the organizer, the slug is made from the name when it is empty and numbered when it is taken
+func (repo *OrganizerRepository) Create(ctx context.Context, organizer *db.Organizer) error {
+	if err := validateOrganizer(organizer); err != nil {
+		return err
+	}
+	base := Slugify(organizer.Slug)
+	if base == "" {
+		base = Slugify(organizer.Name)
+	}
+	if base == "" {
+		return fmt.Errorf("%w: the name can't be used in an url", ErrInvalidOrganizer)
+	}
+	links, _ := json.Marshal(organizer.Links)
+	organizer.CreatedAt = time.Now().UTC()
+	organizer.UpdatedAt = organizer.CreatedAt
+	for i := 1; ; i++ {
+		organizer.Slug = base
+		if i > 1 {
+			organizer.Slug = base + "-" + strconv.Itoa(i)
+		}
+		result, err := repo.db.ExecContext(ctx, `INSERT INTO organizer
+			(slug, name, description, links, logo, email, verified, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			organizer.Slug, organizer.Name, organizer.Description, string(links), organizer.Logo, organizer.Email,
+			organizer.Verified, nullTime(organizer.CreatedAt), nullTime(organizer.UpdatedAt))
+		if err != nil && isUniqueViolation(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("create organizer: %w", err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("create organizer: %w", err)
+		}
+		organizer.ID = int(id)
+		return nil
+	}
+}
+
+func (repo *OrganizerRepository) FindByID(ctx context.Context, id int) (db.Organizer, error) {
+	organizer, err := repo.scanOrganizer(repo.db.QueryRowContext(ctx, `SELECT `+organizerColumns+` FROM organizer WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return organizer, ErrNoOrganizerFound
+	}
+	return organizer, err
+}
+
+func (repo *OrganizerRepository) FindBySlug(ctx context.Context, slug string) (db.Organizer, error) {
+	organizer, err := repo.scanOrganizer(repo.db.QueryRowContext(ctx, `SELECT `+organizerColumns+` FROM organizer WHERE slug = ?`, slug))
+	if errors.Is(err, sql.ErrNoRows) {
+		return organizer, ErrNoOrganizerFound
+	}
+	return organizer, err
+}
+
+func (repo *OrganizerRepository) FindAll(ctx context.Context) ([]db.Organizer, error) {
+	rows, err := repo.db.QueryContext(ctx, `SELECT `+organizerColumns+` FROM organizer ORDER BY lower(name)`)
+	if err != nil {
+		return nil, fmt.Errorf("list organizers: %w", err)
+	}
+	defer rows.Close()
+	organizers := []db.Organizer{}
+	for rows.Next() {
+		organizer, err := repo.scanOrganizer(rows)
+		if err != nil {
+			return nil, err
+		}
+		organizers = append(organizers, organizer)
+	}
+	return organizers, rows.Err()
+}
+
+// Update the organizer, the slug only changes when a new one is given
+func (repo *OrganizerRepository) Update(ctx context.Context, organizer db.Organizer) error {
+	if err := validateOrganizer(&organizer); err != nil {
+		return err
+	}
+	current, err := repo.FindByID(ctx, organizer.ID)
+	if err != nil {
+		return err
+	}
+	slug := current.Slug
+	if organizer.Slug != "" && organizer.Slug != current.Slug {
+		if slug = Slugify(organizer.Slug); slug != organizer.Slug {
+			return fmt.Errorf("%w: the slug %s is not valid, use %s", ErrInvalidOrganizer, organizer.Slug, slug)
+		}
+	}
+	links, _ := json.Marshal(organizer.Links)
+	_, err = repo.db.ExecContext(ctx, `UPDATE organizer SET
+		slug = ?, name = ?, description = ?, links = ?, logo = ?, email = ?, verified = ?, updated_at = ? WHERE id = ?`,
+		slug, organizer.Name, organizer.Description, string(links), organizer.Logo, organizer.Email, organizer.Verified,
+		nullTime(time.Now()), organizer.ID)
+	if err != nil && isUniqueViolation(err) {
+		return ErrOrganizerExists
+	}
+	if err != nil {
+		return fmt.Errorf("update organizer: %w", err)
+	}
+	return nil
+}
+
+// Delete the organizer, its entries and submissions are unlinked
+func (repo *OrganizerRepository) Delete(ctx context.Context, id int) error {
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return fmt.Errorf("delete organizer: %w", err)
+	}
+	defer tx.Rollback()
+	for _, table := range []string{"agenda_entry", "form_submissions"} {
+		if _, err := tx.ExecContext(ctx, `UPDATE `+table+` SET organizer_id = NULL WHERE organizer_id = ?`, id); err != nil {
+			return fmt.Errorf("delete organizer: %w", err)
+		}
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM organizer WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete organizer: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return ErrNoOrganizerFound
+	}
+	agendaChanged(tx)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("delete organizer: %w", err)
+	}
+	return nil
+}
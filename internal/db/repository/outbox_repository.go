@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// OutboxRepository the emails waiting to be sent, bound to a transaction with WithTx
+// the email is only sent when the changes which trigger it are committed
+type OutboxRepository struct {
+	db dbtx
+}
+
+var ErrNoOutboxEmailFound = errors.New("No email found in the outbox")
+var ErrInvalidResend = errors.New("the email can't be sent again")
+
+const (
+	// OutboxMaxAttempts a failed email goes dead after
+	OutboxMaxAttempts = 6
+	// OutboxRetryDelay before the first retry, doubled at each attempt
+	OutboxRetryDelay = time.Minute
+	outboxMaxDelay   = 6 * time.Hour
+)
+
+func NewOutboxRepository(db *sql.DB) *OutboxRepository {
+	return &OutboxRepository{db}
+}
+
+// WithTx the outbox inside the transaction
+func (repo *OutboxRepository) WithTx(tx *sql.Tx) *OutboxRepository {
+	return &OutboxRepository{tx}
+}
+
+// OutboxBackoff the delay before the next attempt once the email failed attempts times
+func OutboxBackoff(attempts int) time.Duration {
+	delay := OutboxRetryDelay
+	for i := 1; i < attempts && delay < outboxMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxDelay)
+}
+
+const outboxColumns = `id, recipient, subject, body, template, status, attempts, last_error, next_attempt_at, created_at, updated_at, sent_at`
+
+func (repo *OutboxRepository) scanEmail(row interface{ Scan(...any) error }) (db.OutboxEmail, error) {
+	var email db.OutboxEmail
+	var sentAt sql.NullTime
+	err := row.Scan(&email.ID, &email.Recipient, &email.Subject, &email.Body, &email.Template, &email.Status,
+		&email.Attempts, &email.LastError, &email.NextAttemptAt, &email.CreatedAt, &email.UpdatedAt, &sentAt)
+	if sentAt.Valid {
+		email.SentAt = &sentAt.Time
+	}
+	return email, err
+}
+
+func (repo *OutboxRepository) findEmails(ctx context.Context, query string, args ...any) ([]db.OutboxEmail, error) {
+	rows, err := repo.db.QueryContext(ctx, `SELECT `+outboxColumns+` FROM email_outbox `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	emails := []db.OutboxEmail{}
+	for rows.Next() {
+		email, err := repo.scanEmail(rows)
+		if err != nil {
+			return nil, err
+		}
+		emails = append(emails, email)
+	}
+	return emails, rows.Err()
+}
+
+// Enqueue the email, due right away
+func (repo *OutboxRepository) Enqueue(ctx context.Context, email *db.OutboxEmail) error {
+	now := time.Now().UTC()
+	email.Status = db.OutboxStatus_Pending
+	email.Attempts = 0
+	email.NextAttemptAt = now
+	email.CreatedAt = now
+	email.UpdatedAt = now
+	result, err := repo.db.ExecContext(ctx,
+		`INSERT INTO email_outbox (recipient, subject, body, template, status, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		email.Recipient, email.Subject, email.Body, email.Template, email.Status, now, now, now)
+	if err != nil {
+		return fmt.Errorf("enqueue email to %s: %w", email.Recipient, err)
+	}
+	email.ID, err = result.LastInsertId()
+	return err
+}
+
+// FindDue the pending emails to send at now, the oldest first
+func (repo *OutboxRepository) FindDue(ctx context.Context, now time.Time, limit int) ([]db.OutboxEmail, error) {
+	emails, err := repo.findEmails(ctx, `WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?`,
+		db.OutboxStatus_Pending, now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("due emails: %w", err)
+	}
+	return emails, nil
+}
+
+// Find the emails with the status, all of them when empty, the latest first
+func (repo *OutboxRepository) Find(ctx context.Context, status db.OutboxStatus, limit int) ([]db.OutboxEmail, error) {
+	query, args := `ORDER BY id DESC LIMIT ?`, []any{limit}
+	if status != "" {
+		query, args = `WHERE status = ? `+query, []any{status, limit}
+	}
+	emails, err := repo.findEmails(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("outbox emails: %w", err)
+	}
+	return emails, nil
+}
+
+func (repo *OutboxRepository) FindByID(ctx context.Context, id int64) (db.OutboxEmail, error) {
+	row := repo.db.QueryRowContext(ctx, `SELECT `+outboxColumns+` FROM email_outbox WHERE id = ?`, id)
+	email, err := repo.scanEmail(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return email, ErrNoOutboxEmailFound
+	}
+	return email, err
+}
+
+// Claim the pending email before sending it, false when another worker has it
+func (repo *OutboxRepository) Claim(ctx context.Context, id int64) (bool, error) {
+	result, err := repo.db.ExecContext(ctx,
+		`UPDATE email_outbox SET status = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		db.OutboxStatus_Sending, time.Now().UTC(), id, db.OutboxStatus_Pending)
+	if err != nil {
+		return false, fmt.Errorf("claim email %d: %w", id, err)
+	}
+	claimed, err := result.RowsAffected()
+	return claimed == 1, err
+}
+
+func (repo *OutboxRepository) MarkSent(ctx context.Context, id int64, now time.Time) error {
+	_, err := repo.db.ExecContext(ctx,
+		`UPDATE email_outbox SET status = ?, attempts = attempts + 1, last_error = '', sent_at = ?, updated_at = ? WHERE id = ?`,
+		db.OutboxStatus_Sent, now.UTC(), now.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("mark email %d sent: %w", id, err)
+	}
+	return nil
+}
+
+// MarkFailed retries the email with an exponential backoff, after OutboxMaxAttempts the email goes dead
+func (repo *OutboxRepository) MarkFailed(ctx context.Context, id int64, sendErr error, now time.Time) (db.OutboxEmail, error) {
+	email, err := repo.FindByID(ctx, id)
+	if err != nil {
+		return email, err
+	}
+	email.Attempts++
+	email.LastError = sendErr.Error()
+	email.Status = db.OutboxStatus_Pending
+	email.NextAttemptAt = now.UTC().Add(OutboxBackoff(email.Attempts))
+	if email.Attempts >= OutboxMaxAttempts {
+		email.Status = db.OutboxStatus_Dead
+	}
+	email.UpdatedAt = now.UTC()
+	_, err = repo.db.ExecContext(ctx,
+		`UPDATE email_outbox SET status = ?, attempts = ?, last_error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?`,
+		email.Status, email.Attempts, email.LastError, email.NextAttemptAt, email.UpdatedAt, id)
+	if err != nil {
+		return email, fmt.Errorf("mark email %d failed: %w", id, err)
+	}
+	return email, nil
+}
+
+// Resend the dead or retrying email right away, with all its attempts
+func (repo *OutboxRepository) Resend(ctx context.Context, id int64) (db.OutboxEmail, error) {
+	email, err := repo.FindByID(ctx, id)
+	if err != nil {
+		return email, err
+	}
+	if email.Status != db.OutboxStatus_Dead && email.Status != db.OutboxStatus_Pending {
+		return email, fmt.Errorf("%w: the email is %s", ErrInvalidResend, email.Status)
+	}
+	now := time.Now().UTC()
+	_, err = repo.db.ExecContext(ctx,
+		`UPDATE email_outbox SET status = ?, attempts = 0, next_attempt_at = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		db.OutboxStatus_Pending, now, now, id, email.Status)
+	if err != nil {
+		return email, fmt.Errorf("resend email %d: %w", id, err)
+	}
+	return repo.FindByID(ctx, id)
+}
+
+// ResetSending gives back to the outbox the emails claimed when the server stopped
+func (repo *OutboxRepository) ResetSending(ctx context.Context) (int64, error) {
+	result, err := repo.db.ExecContext(ctx,
+		`UPDATE email_outbox SET status = ?, updated_at = ? WHERE status = ?`,
+		db.OutboxStatus_Pending, time.Now().UTC(), db.OutboxStatus_Sending)
+	if err != nil {
+		return 0, fmt.Errorf("reset sending emails: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SubscriptionRepository the visitors following an event
+type SubscriptionRepository struct {
+	db dbtx
+}
+
+var ErrNoSubscriptionFound = errors.New("No subscription found")
+var ErrInvalidSubscription = errors.New("invalid subscription")
+
+func NewSubscriptionRepository(db *sql.DB) *SubscriptionRepository {
+	return &SubscriptionRepository{db}
+}
+
+// WithTx the repository inside the transaction, committed by the caller
+func (repo *SubscriptionRepository) WithTx(tx *sql.Tx) *SubscriptionRepository {
+	return &SubscriptionRepository{tx}
+}
+
+const subscriptionColumns = `id, entry_id, email, token, created_at, confirmed_at`
+
+func (repo *SubscriptionRepository) scanSubscription(row interface{ Scan(...any) error }) (db.EventSubscription, error) {
+	var subscription db.EventSubscription
+	var confirmedAt sql.NullTime
+	err := row.Scan(&subscription.ID, &subscription.EntryID, &subscription.Email, &subscription.Token, &subscription.CreatedAt, &confirmedAt)
+	if confirmedAt.Valid {
+		subscription.ConfirmedAt = &confirmedAt.Time
+	}
+	return subscription, err
+}
+
+// Subscribe the email to the event, subscribing twice returns the first subscription.
+// The subscription is not confirmed: the token is emailed to the visitor, see Confirm
+func (repo *SubscriptionRepository) Subscribe(ctx context.Context, entryID string, email string) (db.EventSubscription, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if _, err := mail.ParseAddress(email); err != nil {
+		return db.EventSubscription{}, fmt.Errorf("%w: the email %s is not valid", ErrInvalidSubscription, email)
+	}
+	_, err := repo.db.ExecContext(ctx,
+		`INSERT INTO event_subscription (entry_id, email, token, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (entry_id, email) DO NOTHING`,
+		entryID, email, uuid.New().String(), time.Now().UTC())
+	if err != nil {
+		return db.EventSubscription{}, fmt.Errorf("subscribe to %s: %w", entryID, err)
+	}
+	row := repo.db.QueryRowContext(ctx,
+		`SELECT `+subscriptionColumns+` FROM event_subscription WHERE entry_id = ? AND email = ?`, entryID, email)
+	return repo.scanSubscription(row)
+}
+
+// Confirm the subscription with the token of the confirmation email, confirming twice keeps the first date
+func (repo *SubscriptionRepository) Confirm(ctx context.Context, token string) (db.EventSubscription, error) {
+	_, err := repo.db.ExecContext(ctx,
+		`UPDATE event_subscription SET confirmed_at = ? WHERE token = ? AND confirmed_at IS NULL`, time.Now().UTC(), token)
+	if err != nil {
+		return db.EventSubscription{}, fmt.Errorf("confirm subscription: %w", err)
+	}
+	row := repo.db.QueryRowContext(ctx, `SELECT `+subscriptionColumns+` FROM event_subscription WHERE token = ?`, token)
+	subscription, err := repo.scanSubscription(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return subscription, ErrNoSubscriptionFound
+	}
+	return subscription, err
+}
+
+// Unsubscribe with the token of the notification emails
+func (repo *SubscriptionRepository) Unsubscribe(ctx context.Context, token string) error {
+	result, err := repo.db.ExecContext(ctx, `DELETE FROM event_subscription WHERE token = ?`, token)
+	if err != nil {
+		return fmt.Errorf("unsubscribe: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return ErrNoSubscriptionFound
+	}
+	return err
+}
+
+// FindByEntry the confirmed subscribers of the event, the oldest first
+func (repo *SubscriptionRepository) FindByEntry(ctx context.Context, entryID string) ([]db.EventSubscription, error) {
+	rows, err := repo.db.QueryContext(ctx,
+		`SELECT `+subscriptionColumns+` FROM event_subscription WHERE entry_id = ? AND confirmed_at IS NOT NULL ORDER BY id`, entryID)
+	if err != nil {
+		return nil, fmt.Errorf("subscriptions of %s: %w", entryID, err)
+	}
+	defer rows.Close()
+	subscriptions := []db.EventSubscription{}
+	for rows.Next() {
+		subscription, err := repo.scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("subscriptions of %s: %w", entryID, err)
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
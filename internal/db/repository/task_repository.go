@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/types"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 )
 
 type Repository[T any] interface {
@@ -20,6 +24,11 @@ type TaskRepository struct {
 	db *sql.DB
 }
 
+var ErrNoScrapingTaskFound = errors.New("No scraping task found")
+var ErrNoScrapingRunFound = errors.New("No scraping run found")
+
+const taskTimeLayout = "2006-01-02 15:04:05"
+
 // create
 func NewTaskRepository(db *sql.DB) *TaskRepository {
 	return &TaskRepository{db}
@@ -27,25 +36,273 @@ func NewTaskRepository(db *sql.DB) *TaskRepository {
 
 // Inplementation
 func (repo *TaskRepository) Create(ctx context.Context, model *db.ScrapingTask) error {
-	stm, err := repo.db.Prepare("INSERT INTO scraping_task (type, url, params, running_time) VALUES (?, ?, ?, ?)")
+	stm, err := repo.db.Prepare("INSERT INTO scraping_task (type, url, params, running_time, name, status, schedule, next_run_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		log.Printf("TaskRepository::Create STM error: %v", err)
+		return fmt.Errorf("prepare create task: %w", err)
+	}
+	defer stm.Close()
+
+	// the definition is the source of truth, ScrapingParams is the legacy selector map
+	definition := model.Definition
+	if len(definition.ScrapingMap) == 0 && len(model.ScrapingParams) > 0 {
+		definition.ScrapingMap = types.ScrapingMap(model.ScrapingParams)
+	}
+	definition.Url = model.Url
+	definition.Name = model.Name
+	params, err := json.Marshal(definition)
 	if err != nil {
-		log.Fatalf("Create STM error: %v", err)
+		return fmt.Errorf("marshal task definition: %w", err)
 	}
-	result, qrerr := stm.ExecContext(ctx,
+
+	result, err := stm.ExecContext(ctx,
 		model.Type,
 		model.Url,
-		model.ScrapingParams.ToJSON(),
-		model.CreatedTime.Format("2006-01-02 15:04:05"))
-	//
-	if qrerr != nil {
-		log.Fatalf("ExecContext STM error: %f", qrerr)
+		string(params),
+		model.CreatedTime.Format(taskTimeLayout),
+		model.Name,
+		model.Status,
+		model.Schedule,
+		nullTime(model.NextRunAt))
+	if err != nil {
+		log.Printf("TaskRepository::Create ExecContext error: %v", err)
+		return fmt.Errorf("create task: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("Error getting task id: %w", err)
+	}
+	model.Id = int(id)
+	model.Definition = definition
+	return nil
+}
+
+const taskColumns = `id, name, type, url, params, running_time, COALESCE(status, 0), schedule, next_run_at`
+
+func (repo *TaskRepository) scanTask(row interface{ Scan(...any) error }) (db.ScrapingTask, error) {
+	var task db.ScrapingTask
+	var params string
+	var runningTime string
+	var nextRunAt sql.NullTime
+	err := row.Scan(&task.Id, &task.Name, &task.Type, &task.Url, &params, &runningTime, &task.Status,
+		&task.Schedule, &nextRunAt)
+	if err != nil {
+		return task, err
+	}
+	if err := json.Unmarshal([]byte(params), &task.Definition); err != nil || len(task.Definition.ScrapingMap) == 0 {
+		// legacy rows only store the selector map
+		var legacy types.ScrapingMap
+		if err := json.Unmarshal([]byte(params), &legacy); err == nil {
+			task.Definition.ScrapingMap = legacy
+		}
+	}
+	if task.Definition.Url == "" {
+		task.Definition.Url = task.Url
+	}
+	if task.Definition.Name == "" {
+		task.Definition.Name = task.Name
+	}
+	task.ScrapingParams = db.Record(task.Definition.ScrapingMap)
+	task.NextRunAt = nextRunAt.Time
+	if createdTime, err := time.Parse(taskTimeLayout, runningTime); err == nil {
+		task.CreatedTime = createdTime
+	}
+	return task, nil
+}
+
+func (repo *TaskRepository) FindAll(ctx context.Context) ([]db.ScrapingTask, error) {
+	tasks := []db.ScrapingTask{}
+	rows, err := repo.db.QueryContext(ctx, "SELECT "+taskColumns+" FROM scraping_task ORDER BY id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		task, err := repo.scanTask(rows)
+		if err != nil {
+			log.Printf("TaskRepository::FindAll %v", err)
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+func (repo *TaskRepository) FindByID(ctx context.Context, id int) (db.ScrapingTask, error) {
+	row := repo.db.QueryRowContext(ctx, "SELECT "+taskColumns+" FROM scraping_task WHERE id=?", id)
+	task, err := repo.scanTask(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return task, ErrNoScrapingTaskFound
+	}
+	return task, err
+}
+
+func (repo *TaskRepository) UpdateStatus(ctx context.Context, id int, status db.TaskStatus) error {
+	_, err := repo.db.ExecContext(ctx, "UPDATE scraping_task SET status=? WHERE id=?", status, id)
+	if err != nil {
+		return fmt.Errorf("update task status: %w", err)
+	}
+	return nil
+}
+
+// QueueTask moves the task to queued unless one of its runs is running,
+// the task shows the running run until it finishes
+func (repo *TaskRepository) QueueTask(ctx context.Context, id int) error {
+	_, err := repo.db.ExecContext(ctx,
+		"UPDATE scraping_task SET status=? WHERE id=? AND NOT EXISTS (SELECT 1 FROM scraping_run WHERE task_id=? AND status=?)",
+		db.TaskStatus_Queued, id, id, db.TaskStatus_Running)
+	if err != nil {
+		return fmt.Errorf("queue task: %w", err)
+	}
+	return nil
+}
+
+// FindDueTasks returns the scheduled tasks whose next run is before now
+func (repo *TaskRepository) FindDueTasks(ctx context.Context, now time.Time) ([]db.ScrapingTask, error) {
+	tasks := []db.ScrapingTask{}
+	rows, err := repo.db.QueryContext(ctx,
+		"SELECT "+taskColumns+" FROM scraping_task WHERE schedule != '' AND next_run_at IS NOT NULL AND next_run_at <= ? ORDER BY next_run_at ASC",
+		now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		task, err := repo.scanTask(rows)
+		if err != nil {
+			log.Printf("TaskRepository::FindDueTasks %v", err)
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, rows.Err()
+}
+
+// UpdateSchedule saves the cron expression and the next run, an empty schedule disables it
+func (repo *TaskRepository) UpdateSchedule(ctx context.Context, id int, schedule string, nextRunAt time.Time) error {
+	_, err := repo.db.ExecContext(ctx, "UPDATE scraping_task SET schedule=?, next_run_at=? WHERE id=?",
+		schedule, nullTime(nextRunAt), id)
+	if err != nil {
+		return fmt.Errorf("update task schedule: %w", err)
+	}
+	return nil
+}
+
+// dates are saved in UTC so they can be compared as text
+func nullTime(value time.Time) sql.NullTime {
+	return sql.NullTime{Time: value.UTC(), Valid: !value.IsZero()}
+}
+
+func nullInt(value int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(value), Valid: value != 0}
+}
+
+/* Runs */
+
+const runColumns = `id, task_id, status, created_at, started_at, finished_at, entries_count, result, error`
+
+func (repo *TaskRepository) scanRun(row interface{ Scan(...any) error }) (db.ScrapingRun, error) {
+	var run db.ScrapingRun
+	var startedAt, finishedAt sql.NullTime
+	var result string
+	err := row.Scan(&run.ID, &run.TaskID, &run.Status, &run.CreatedAt, &startedAt, &finishedAt,
+		&run.EntriesCount, &result, &run.Error)
+	if err != nil {
+		return run, err
 	}
+	run.StartedAt = startedAt.Time
+	run.FinishedAt = finishedAt.Time
+	if result != "" {
+		run.Result = json.RawMessage(result)
+	}
+	return run, nil
+}
+
+func (repo *TaskRepository) CreateRun(ctx context.Context, taskID int) (db.ScrapingRun, error) {
+	run := db.ScrapingRun{TaskID: taskID, Status: db.TaskStatus_Queued, CreatedAt: time.Now()}
+	result, err := repo.db.ExecContext(ctx, "INSERT INTO scraping_run (task_id, status, created_at) VALUES (?, ?, ?)",
+		run.TaskID, run.Status, run.CreatedAt)
+	if err != nil {
+		return run, fmt.Errorf("create run: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return run, fmt.Errorf("Error getting run id: %w", err)
+	}
+	run.ID = int(id)
+	return run, nil
+}
+
+func (repo *TaskRepository) FindRunByID(ctx context.Context, id int) (db.ScrapingRun, error) {
+	row := repo.db.QueryRowContext(ctx, "SELECT "+runColumns+" FROM scraping_run WHERE id=?", id)
+	run, err := repo.scanRun(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return run, ErrNoScrapingRunFound
+	}
+	return run, err
+}
+
+func (repo *TaskRepository) findRuns(ctx context.Context, query string, args ...any) ([]db.ScrapingRun, error) {
+	runs := []db.ScrapingRun{}
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		run, err := repo.scanRun(rows)
+		if err != nil {
+			log.Printf("TaskRepository::findRuns %v", err)
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// FindRunsByTask returns the history of a task, latest first
+func (repo *TaskRepository) FindRunsByTask(ctx context.Context, taskID int, limit int) ([]db.ScrapingRun, error) {
+	return repo.findRuns(ctx, "SELECT "+runColumns+" FROM scraping_run WHERE task_id=? ORDER BY id DESC LIMIT ?", taskID, limit)
+}
+
+func (repo *TaskRepository) FindRunsByStatus(ctx context.Context, status db.TaskStatus) ([]db.ScrapingRun, error) {
+	return repo.findRuns(ctx, "SELECT "+runColumns+" FROM scraping_run WHERE status=? ORDER BY id ASC", status)
+}
+
+// ClaimRun moves a queued run to running, false if the run is no longer queued
+func (repo *TaskRepository) ClaimRun(ctx context.Context, id int) (bool, error) {
+	result, err := repo.db.ExecContext(ctx, "UPDATE scraping_run SET status=?, started_at=? WHERE id=? AND status=?",
+		db.TaskStatus_Running, time.Now(), id, db.TaskStatus_Queued)
+	if err != nil {
+		return false, fmt.Errorf("claim run: %w", err)
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("claim run: %w", err)
+	}
+	return rowAffected == 1, nil
+}
+
+// CancelQueuedRun cancels a run which has not started yet
+func (repo *TaskRepository) CancelQueuedRun(ctx context.Context, id int) (bool, error) {
+	result, err := repo.db.ExecContext(ctx, "UPDATE scraping_run SET status=?, finished_at=? WHERE id=? AND status=?",
+		db.TaskStatus_Cancelled, time.Now(), id, db.TaskStatus_Queued)
+	if err != nil {
+		return false, fmt.Errorf("cancel run: %w", err)
+	}
+	rowAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("cancel run: %w", err)
+	}
+	return rowAffected == 1, nil
+}
 
-	// Check if any rows were affected
-	rowsAffected, err := result.RowsAffected()
+func (repo *TaskRepository) FinishRun(ctx context.Context, run db.ScrapingRun) error {
+	_, err := repo.db.ExecContext(ctx,
+		"UPDATE scraping_run SET status=?, finished_at=?, entries_count=?, result=?, error=? WHERE id=?",
+		run.Status, run.FinishedAt, run.EntriesCount, string(run.Result), run.Error, run.ID)
 	if err != nil {
-		return fmt.Errorf("Error getting rows affected: %w", err)
+		return fmt.Errorf("finish run: %w", err)
 	}
-	fmt.Printf("Inserted %d row(s)\n", rowsAffected)
-	return err
+	return nil
 }
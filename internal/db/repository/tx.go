@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+// dbtx the connection of a repository: the *sql.DB, or the *sql.Tx of WithTx
+type dbtx interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+// txScope a transaction of the repository. A repository already in a transaction joins it:
+// Commit and Rollback are left to the owner of the transaction.
+type txScope struct {
+	dbtx
+	tx *sql.Tx
+	// the agenda version changes once the transaction is committed
+	agendaChanged bool
+}
+
+func beginTx(ctx context.Context, conn dbtx) (*txScope, error) {
+	localDb, ok := conn.(*sql.DB)
+	if !ok {
+		return &txScope{dbtx: conn}, nil
+	}
+	tx, err := localDb.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &txScope{dbtx: tx, tx: tx}, nil
+}
+
+func (scope *txScope) Commit() error {
+	if scope.tx == nil {
+		return nil
+	}
+	if err := scope.tx.Commit(); err != nil {
+		return err
+	}
+	if scope.agendaChanged {
+		agendaVersion.Add(1)
+	}
+	return nil
+}
+
+// agendaChanged changes the agenda version once the writes made on conn are committed.
+// The owner of a transaction given to WithTx calls AgendaChanged after its commit
+func agendaChanged(conn dbtx) {
+	switch conn := conn.(type) {
+	case *sql.DB:
+		agendaVersion.Add(1)
+	case *txScope:
+		if conn.tx != nil {
+			conn.agendaChanged = true
+		} else {
+			agendaChanged(conn.dbtx)
+		}
+	}
+}
+
+func (scope *txScope) Rollback() error {
+	if scope.tx == nil {
+		return nil
+	}
+	return scope.tx.Rollback()
+}
@@ -0,0 +1,387 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type VenueRepository struct {
+	db dbtx
+}
+
+var ErrNoVenueFound = errors.New("No venue found")
+var ErrVenueExists = errors.New("Venue already exists")
+var ErrInvalidVenue = errors.New("invalid venue")
+
+func NewVenueRepository(db *sql.DB) *VenueRepository {
+	return &VenueRepository{db}
+}
+
+func init() {
+	// the venues of the entries created before the migration create_venue
+	db.RegisterGoMigration(202505180000022, func(conn *sql.DB) error {
+		_, err := NewVenueRepository(conn).Backfill(context.Background())
+		return err
+	})
+}
+
+// the accents and the punctuation are ignored
+var venueNormalizer = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ä", "a", "ã", "a", "å", "a",
+	"ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ñ", "n",
+	"ò", "o", "ó", "o", "ô", "o", "ö", "o", "õ", "o",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ÿ", "y", "œ", "oe", "æ", "ae",
+	"-", " ", "'", " ", "’", " ", ".", " ", ",", " ", "(", " ", ")", " ", "/", " ", `"`, " ",
+)
+
+func normalizeVenue(value string) string {
+	return strings.Join(strings.Fields(venueNormalizer.Replace(strings.ToLower(value))), " ")
+}
+
+// VenueKey identifies a venue: "Le Chat-Noir" in "Carouge" and "le chat noir" in "carouge" are the same
+func VenueKey(name string, place string) string {
+	return normalizeVenue(name) + "|" + normalizeVenue(place)
+}
+
+// only the active entries are counted
+const venueColumns = `id, name, address, place, created_at, updated_at,
+	(SELECT COUNT(*) FROM agenda_entry WHERE agenda_entry.venue_id = venue.id AND agenda_entry.status = 1
+		AND agenda_entry.deleted_at IS NULL)`
+
+func (repo *VenueRepository) scanVenue(row interface{ Scan(...any) error }) (db.Venue, error) {
+	var venue db.Venue
+	var createdAt, updatedAt sql.NullTime
+	err := row.Scan(&venue.ID, &venue.Name, &venue.Address, &venue.Place, &createdAt, &updatedAt, &venue.Events)
+	venue.CreatedAt = createdAt.Time
+	venue.UpdatedAt = updatedAt.Time
+	return venue, err
+}
+
+func (repo *VenueRepository) Create(ctx context.Context, venue *db.Venue) error {
+	venue.Name = strings.TrimSpace(venue.Name)
+	if venue.Name == "" {
+		return fmt.Errorf("%w: the name is required", ErrInvalidVenue)
+	}
+	venue.CreatedAt = time.Now().UTC()
+	venue.UpdatedAt = venue.CreatedAt
+	result, err := repo.db.ExecContext(ctx,
+		`INSERT INTO venue (name, address, place, normalized_key, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		venue.Name, strings.TrimSpace(venue.Address), strings.TrimSpace(venue.Place), VenueKey(venue.Name, venue.Place),
+		nullTime(venue.CreatedAt), nullTime(venue.UpdatedAt))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrVenueExists
+		}
+		return fmt.Errorf("create venue: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("create venue: %w", err)
+	}
+	venue.ID = int(id)
+	return nil
+}
+
+func (repo *VenueRepository) FindByID(ctx context.Context, id int) (db.Venue, error) {
+	venue, err := repo.scanVenue(repo.db.QueryRowContext(ctx, `SELECT `+venueColumns+` FROM venue WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return venue, ErrNoVenueFound
+	}
+	return venue, err
+}
+
+func (repo *VenueRepository) FindByKey(ctx context.Context, name string, place string) (db.Venue, error) {
+	venue, err := repo.scanVenue(repo.db.QueryRowContext(ctx,
+		`SELECT `+venueColumns+` FROM venue WHERE normalized_key = ?`, VenueKey(name, place)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return venue, ErrNoVenueFound
+	}
+	return venue, err
+}
+
+// Search the venues by name or place, the most used ones first, every venue for an empty text
+func (repo *VenueRepository) Search(ctx context.Context, text string, limit int) ([]db.Venue, error) {
+	return repo.search(ctx, text, limit, false)
+}
+
+// SearchPublished searches the venues of the active entries only, the venues of the entries
+// waiting for the moderation are not shown to the visitors
+func (repo *VenueRepository) SearchPublished(ctx context.Context, text string, limit int) ([]db.Venue, error) {
+	return repo.search(ctx, text, limit, true)
+}
+
+func (repo *VenueRepository) search(ctx context.Context, text string, limit int, published bool) ([]db.Venue, error) {
+	query := `SELECT ` + venueColumns + ` AS events FROM venue WHERE 1 = 1`
+	args := []any{}
+	if published {
+		query += ` AND EXISTS (SELECT 1 FROM agenda_entry WHERE agenda_entry.venue_id = venue.id
+			AND agenda_entry.status = ? AND agenda_entry.deleted_at IS NULL)`
+		args = append(args, db.Status_Active)
+	}
+	if text = normalizeVenue(text); text != "" {
+		query += ` AND normalized_key LIKE ? ESCAPE '\'`
+		args = append(args, "%"+likeEscape(text)+"%")
+	}
+	query += ` ORDER BY events DESC, lower(name)`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := repo.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search venues: %w", err)
+	}
+	defer rows.Close()
+	venues := []db.Venue{}
+	for rows.Next() {
+		venue, err := repo.scanVenue(rows)
+		if err != nil {
+			return nil, fmt.Errorf("search venues: %w", err)
+		}
+		venues = append(venues, venue)
+	}
+	return venues, rows.Err()
+}
+
+// FindOrCreate returns the venue with the same key, a new one otherwise
+func (repo *VenueRepository) FindOrCreate(ctx context.Context, name string, address string, place string) (db.Venue, error) {
+	venue, err := repo.FindByKey(ctx, name, place)
+	if !errors.Is(err, ErrNoVenueFound) {
+		return venue, err
+	}
+	venue = db.Venue{Name: name, Address: address, Place: place}
+	err = repo.Create(ctx, &venue)
+	if errors.Is(err, ErrVenueExists) {
+		// created meanwhile
+		return repo.FindByKey(ctx, name, place)
+	}
+	return venue, err
+}
+
+// Update the venue and the copy kept by its entries
+func (repo *VenueRepository) Update(ctx context.Context, venue db.Venue) error {
+	venue.Name = strings.TrimSpace(venue.Name)
+	if venue.Name == "" {
+		return fmt.Errorf("%w: the name is required", ErrInvalidVenue)
+	}
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return fmt.Errorf("update venue: %w", err)
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx,
+		`UPDATE venue SET name = ?, address = ?, place = ?, normalized_key = ?, updated_at = ? WHERE id = ?`,
+		venue.Name, strings.TrimSpace(venue.Address), strings.TrimSpace(venue.Place), VenueKey(venue.Name, venue.Place),
+		nullTime(time.Now()), venue.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrVenueExists
+		}
+		return fmt.Errorf("update venue: %w", err)
+	}
+	if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+		return ErrNoVenueFound
+	}
+	if err := copyVenueToEntries(ctx, tx, venue.ID, venue.ID); err != nil {
+		return err
+	}
+	agendaChanged(tx)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("update venue: %w", err)
+	}
+	return nil
+}
+
+// Merge moves the entries of the duplicate venue to the kept one, the duplicate is deleted
+func (repo *VenueRepository) Merge(ctx context.Context, duplicateID int, keptID int) error {
+	if duplicateID == keptID {
+		return fmt.Errorf("%w: a venue can't be merged into itself", ErrInvalidVenue)
+	}
+	for _, id := range []int{duplicateID, keptID} {
+		if _, err := repo.FindByID(ctx, id); err != nil {
+			return err
+		}
+	}
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return fmt.Errorf("merge venues: %w", err)
+	}
+	defer tx.Rollback()
+	if err := copyVenueToEntries(ctx, tx, duplicateID, keptID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM venue WHERE id = ?`, duplicateID); err != nil {
+		return fmt.Errorf("merge venues: %w", err)
+	}
+	agendaChanged(tx)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("merge venues: %w", err)
+	}
+	return nil
+}
+
+// Delete the venue, its entries keep their copy of the venue
+func (repo *VenueRepository) Delete(ctx context.Context, id int) error {
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return fmt.Errorf("delete venue: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `UPDATE agenda_entry SET venue_id = NULL WHERE venue_id = ?`, id); err != nil {
+		return fmt.Errorf("delete venue: %w", err)
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM venue WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("delete venue: %w", err)
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return ErrNoVenueFound
+	}
+	return tx.Commit()
+}
+
+// Backfill keys the venues with VenueKey, the venues getting the same key are merged into the most used one,
+// then links the entries without a venue to the venue of their name and place, created with the most used spelling.
+// It returns the number of linked entries
+func (repo *VenueRepository) Backfill(ctx context.Context) (int, error) {
+	tx, err := beginTx(ctx, repo.db)
+	if err != nil {
+		return 0, fmt.Errorf("backfill venues: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, name, place, normalized_key FROM venue
+		ORDER BY (SELECT COUNT(*) FROM agenda_entry WHERE agenda_entry.venue_id = venue.id) DESC, id`)
+	if err != nil {
+		return 0, fmt.Errorf("backfill venues: %w", err)
+	}
+	venueIDs := map[string]int{}
+	rekeyed := map[int]string{}
+	duplicates := map[int]int{}
+	for rows.Next() {
+		var id int
+		var name, place, key string
+		if err := rows.Scan(&id, &name, &place, &key); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("backfill venues: %w", err)
+		}
+		normalized := VenueKey(name, place)
+		if keptID, found := venueIDs[normalized]; found {
+			duplicates[id] = keptID
+			continue
+		}
+		venueIDs[normalized] = id
+		if normalized != key {
+			rekeyed[id] = normalized
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("backfill venues: %w", err)
+	}
+	for duplicateID, keptID := range duplicates {
+		if err := copyVenueToEntries(ctx, tx, duplicateID, keptID); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM venue WHERE id = ?`, duplicateID); err != nil {
+			return 0, fmt.Errorf("backfill venues: %w", err)
+		}
+	}
+	// a new key may still be the key of another venue, every key is released first
+	for id := range rekeyed {
+		if _, err := tx.ExecContext(ctx, `UPDATE venue SET normalized_key = '#' || id WHERE id = ?`, id); err != nil {
+			return 0, fmt.Errorf("backfill venues: %w", err)
+		}
+	}
+	for id, key := range rekeyed {
+		if _, err := tx.ExecContext(ctx, `UPDATE venue SET normalized_key = ? WHERE id = ?`, key, id); err != nil {
+			return 0, fmt.Errorf("backfill venues: %w", err)
+		}
+	}
+
+	type spelling struct {
+		name, address, place string
+	}
+	entries := map[string][]string{}
+	spellings := map[string]map[spelling]int{}
+	rows, err = tx.QueryContext(ctx, `SELECT id, venuename, COALESCE(address, ''), COALESCE(place, '') FROM agenda_entry
+		WHERE venue_id IS NULL AND trim(COALESCE(venuename, '')) != ''`)
+	if err != nil {
+		return 0, fmt.Errorf("backfill venues: %w", err)
+	}
+	for rows.Next() {
+		var id string
+		var venue spelling
+		if err := rows.Scan(&id, &venue.name, &venue.address, &venue.place); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("backfill venues: %w", err)
+		}
+		venue = spelling{strings.TrimSpace(venue.name), strings.TrimSpace(venue.address), strings.TrimSpace(venue.place)}
+		key := VenueKey(venue.name, venue.place)
+		entries[key] = append(entries[key], id)
+		if spellings[key] == nil {
+			spellings[key] = map[spelling]int{}
+		}
+		spellings[key][venue]++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("backfill venues: %w", err)
+	}
+
+	linked := 0
+	for key, ids := range entries {
+		venueID, found := venueIDs[key]
+		if !found {
+			// the most used spelling, then the longest address
+			var best spelling
+			bestCount := 0
+			for venue, count := range spellings[key] {
+				if count > bestCount ||
+					count == bestCount && (len(venue.address) > len(best.address) ||
+						len(venue.address) == len(best.address) && venue.name < best.name) {
+					best, bestCount = venue, count
+				}
+			}
+			venue := db.Venue{Name: best.name, Address: best.address, Place: best.place}
+			if err := (&VenueRepository{tx}).Create(ctx, &venue); err != nil {
+				return 0, fmt.Errorf("backfill venues: %w", err)
+			}
+			venueID = venue.ID
+		}
+		for _, id := range ids {
+			if _, err := tx.ExecContext(ctx, `UPDATE agenda_entry SET venue_id = ? WHERE id = ?`, venueID, id); err != nil {
+				return 0, fmt.Errorf("backfill venues: %w", err)
+			}
+			linked++
+		}
+	}
+	agendaChanged(tx)
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("backfill venues: %w", err)
+	}
+	return linked, nil
+}
+
+// copyVenueToEntries links the entries of the venue fromID to the venue toID, with its name, address and place
+func copyVenueToEntries(ctx context.Context, tx dbtx, fromID int, toID int) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE agenda_entry SET venue_id = venue.id, venuename = venue.name,
+			address = COALESCE(NULLIF(venue.address, ''), agenda_entry.address),
+			place = COALESCE(NULLIF(venue.place, ''), agenda_entry.place)
+		FROM venue WHERE venue.id = ? AND agenda_entry.venue_id = ?`, toID, fromID)
+	if err != nil {
+		return fmt.Errorf("update the entries of the venue %d: %w", fromID, err)
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -0,0 +1,75 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// ErrInvalidTransition the status can't move to the requested one, the API answers 409
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// submissionMoves unconfirmed -> pending -> active -> archived, a moderator can request changes
+// (the submitter edit moves it back to pending) or reject it
+var submissionMoves = map[SubmissionStatus][]SubmissionStatus{
+	SubmissionStatus_Unconfirmed:      {SubmissionStatus_Pending, SubmissionStatus_Deleted},
+	SubmissionStatus_Pending:          {SubmissionStatus_Active, SubmissionStatus_ChangesRequested, SubmissionStatus_Rejected, SubmissionStatus_Archived, SubmissionStatus_Deleted, SubmissionStatus_Cancelled},
+	SubmissionStatus_ChangesRequested: {SubmissionStatus_Pending, SubmissionStatus_Active, SubmissionStatus_Rejected, SubmissionStatus_Archived, SubmissionStatus_Deleted, SubmissionStatus_Cancelled},
+	SubmissionStatus_Active:           {SubmissionStatus_Pending, SubmissionStatus_Archived, SubmissionStatus_Deleted, SubmissionStatus_Cancelled},
+	SubmissionStatus_Rejected:         {SubmissionStatus_Deleted},
+	SubmissionStatus_Archived:         {SubmissionStatus_Deleted},
+	SubmissionStatus_Deleted:          {},
+	SubmissionStatus_Cancelled:        {},
+}
+
+// agendaMoves only the published entries are archived, a removed entry is deleted from the agenda
+var agendaMoves = map[Status][]Status{
+	Status_Inactive: {Status_Active, Status_Pending, Status_Deleted, Status_Removed},
+	Status_Pending:  {Status_Active, Status_Inactive, Status_Deleted, Status_Unlinked, Status_Removed},
+	Status_Active:   {Status_Inactive, Status_Pending, Status_Deleted, Status_Unlinked, Status_Archived, Status_Removed},
+	Status_Unlinked: {Status_Active, Status_Pending, Status_Deleted, Status_Removed},
+	Status_Archived: {Status_Active, Status_Deleted, Status_Removed},
+	Status_Deleted:  {Status_Active, Status_Inactive, Status_Pending, Status_Removed},
+	Status_Removed:  {},
+}
+
+// CanMoveTo the submission can take the status, keeping the same status is always allowed
+func (s SubmissionStatus) CanMoveTo(next SubmissionStatus) bool {
+	moves, known := submissionMoves[s]
+	return known && (s == next || slices.Contains(moves, next))
+}
+
+// CanMoveTo the agenda entry can take the status, keeping the same status is always allowed
+func (a Status) CanMoveTo(next Status) bool {
+	moves, known := agendaMoves[a]
+	return known && (a == next || slices.Contains(moves, next))
+}
+
+// SubmissionTransition checks the move of a submission, a refused move is logged
+func SubmissionTransition(id string, from string, to SubmissionStatus) error {
+	if SubmissionStatus(from).CanMoveTo(to) {
+		return nil
+	}
+	log.Printf("Transition refused: submission %s from %s to %s", id, from, to)
+	return fmt.Errorf("%w: submission from %s to %s", ErrInvalidTransition, from, to)
+}
+
+// SubmissionConfirmation checks the move of the email confirmation: it only takes an unconfirmed
+// submission to pending, a confirmed one is not confirmed again (pending to pending is not a move)
+func SubmissionConfirmation(id string, from string) error {
+	if SubmissionStatus(from) != SubmissionStatus_Unconfirmed {
+		log.Printf("Transition refused: submission %s is already confirmed (%s)", id, from)
+		return fmt.Errorf("%w: submission %s is already confirmed", ErrInvalidTransition, from)
+	}
+	return SubmissionTransition(id, from, SubmissionStatus_Pending)
+}
+
+// AgendaTransition checks the move of an agenda entry, a refused move is logged
+func AgendaTransition(id string, from Status, to Status) error {
+	if from.CanMoveTo(to) {
+		return nil
+	}
+	log.Printf("Transition refused: agenda entry %s from %s to %s", id, from, to)
+	return fmt.Errorf("%w: agenda entry from %s to %s", ErrInvalidTransition, from, to)
+}
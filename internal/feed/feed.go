@@ -0,0 +1,221 @@
+package feed
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+const generator = "Afromemo"
+
+// Feed is serialized as RSS 2.0 or Atom 1.0
+type Feed struct {
+	Title       string
+	Description string
+	// Link is the html page of the feed, SelfLink the url of the feed itself
+	Link     string
+	SelfLink string
+	Language string
+	Updated  time.Time
+	Items    []Item
+}
+
+type Item struct {
+	ID          string
+	Title       string
+	Link        string
+	Description string
+	Categories  []string
+	Published   time.Time
+	Updated     time.Time
+	Enclosure   *Enclosure
+}
+
+// Enclosure is the poster of the event
+type Enclosure struct {
+	URL    string
+	Type   string
+	Length int64
+}
+
+/* RSS 2.0 */
+
+type rss struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	AtomSpace string     `xml:"xmlns:atom,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	AtomLink      atomLink  `xml:"atom:link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language,omitempty"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Generator     string    `xml:"generator"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Guid        rssGuid       `xml:"guid"`
+	Description string        `xml:"description,omitempty"`
+	Categories  []string      `xml:"category"`
+	PubDate     string        `xml:"pubDate,omitempty"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+func rssDate(value time.Time) string {
+	if value.IsZero() {
+		return ""
+	}
+	return value.Format(time.RFC1123Z)
+}
+
+// WriteRSS renders the feed as RSS 2.0, dates are RFC 1123
+func WriteRSS(writer io.Writer, feed Feed) error {
+	channel := rssChannel{
+		Title:         feed.Title,
+		Link:          feed.Link,
+		AtomLink:      atomLink{Href: feed.SelfLink, Rel: "self", Type: "application/rss+xml"},
+		Description:   feed.Description,
+		Language:      feed.Language,
+		LastBuildDate: rssDate(feed.Updated),
+		Generator:     generator,
+		Items:         make([]rssItem, 0, len(feed.Items)),
+	}
+	for _, item := range feed.Items {
+		rssItem := rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			Guid:        rssGuid{IsPermaLink: item.ID == item.Link, Value: item.ID},
+			Description: item.Description,
+			Categories:  item.Categories,
+			PubDate:     rssDate(item.Published),
+		}
+		if item.Enclosure != nil {
+			rssItem.Enclosure = &rssEnclosure{URL: item.Enclosure.URL, Length: item.Enclosure.Length, Type: item.Enclosure.Type}
+		}
+		channel.Items = append(channel.Items, rssItem)
+	}
+	return encode(writer, rss{Version: "2.0", AtomSpace: "http://www.w3.org/2005/Atom", Channel: channel})
+}
+
+/* Atom 1.0 */
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Lang     string      `xml:"xml:lang,attr,omitempty"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   atomAuthor  `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Links      []atomLink     `xml:"link"`
+	Published  string         `xml:"published,omitempty"`
+	Updated    string         `xml:"updated"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary"`
+}
+
+func atomDate(value time.Time) string {
+	return value.UTC().Format(time.RFC3339)
+}
+
+// WriteAtom renders the feed as Atom 1.0, an entry without update date takes its publication date
+func WriteAtom(writer io.Writer, feed Feed) error {
+	atom := atomFeed{
+		Lang:     feed.Language,
+		ID:       feed.SelfLink,
+		Title:    feed.Title,
+		Subtitle: feed.Description,
+		Updated:  atomDate(feed.Updated),
+		Links: []atomLink{
+			{Href: feed.Link, Rel: "alternate", Type: "text/html"},
+			{Href: feed.SelfLink, Rel: "self", Type: "application/atom+xml"},
+		},
+		Author:  atomAuthor{Name: generator},
+		Entries: make([]atomEntry, 0, len(feed.Items)),
+	}
+	for _, item := range feed.Items {
+		updated := item.Updated
+		if updated.IsZero() {
+			updated = item.Published
+		}
+		entry := atomEntry{
+			ID:      item.ID,
+			Title:   item.Title,
+			Links:   []atomLink{{Href: item.Link, Rel: "alternate", Type: "text/html"}},
+			Updated: atomDate(updated),
+		}
+		if !item.Published.IsZero() {
+			entry.Published = atomDate(item.Published)
+		}
+		for _, category := range item.Categories {
+			entry.Categories = append(entry.Categories, atomCategory{Term: category})
+		}
+		if item.Description != "" {
+			entry.Summary = &atomText{Type: "text", Value: item.Description}
+		}
+		if item.Enclosure != nil {
+			entry.Links = append(entry.Links, atomLink{
+				Href:   item.Enclosure.URL,
+				Rel:    "enclosure",
+				Type:   item.Enclosure.Type,
+				Length: item.Enclosure.Length,
+			})
+		}
+		atom.Entries = append(atom.Entries, entry)
+	}
+	return encode(writer, atom)
+}
+
+func encode(writer io.Writer, value any) error {
+	if _, err := io.WriteString(writer, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(writer)
+	encoder.Indent("", "  ")
+	return encoder.Encode(value)
+}
@@ -10,42 +10,69 @@ import (
 	"database/sql"
 )
 
+const archiveEndedSeries = `-- name: ArchiveEndedSeries :exec
+UPDATE agenda_entry SET status = 5
+WHERE status = 1 AND rrule != '' AND deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM agenda_occurrence o WHERE o.entry_id = agenda_entry.id AND o.status = 1)
+`
+
+func (q *Queries) ArchiveEndedSeries(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, archiveEndedSeries)
+	return err
+}
+
 const archivePastEvents = `-- name: ArchivePastEvents :exec
-UPDATE agenda_entry SET status = 5 WHERE date(enddate, '+1 day') < date('now') OR enddate=""
+UPDATE agenda_entry SET status = 5 WHERE status = 1 AND (date(enddate, '+1 day') < date(CAST(?1 AS TEXT)) OR enddate="") AND rrule = '' AND deleted_at IS NULL
 `
 
-func (q *Queries) ArchivePastEvents(ctx context.Context) error {
-	_, err := q.db.ExecContext(ctx, archivePastEvents)
+func (q *Queries) ArchivePastEvents(ctx context.Context, today string) error {
+	_, err := q.db.ExecContext(ctx, archivePastEvents, today)
+	return err
+}
+
+const archivePastOccurrences = `-- name: ArchivePastOccurrences :exec
+UPDATE agenda_occurrence SET status = 5 WHERE status = 1 AND date(enddate, '+1 day') < date(CAST(?1 AS TEXT))
+`
+
+func (q *Queries) ArchivePastOccurrences(ctx context.Context, today string) error {
+	_, err := q.db.ExecContext(ctx, archivePastOccurrences, today)
 	return err
 }
 
 const getAgendaCountFacets = `-- name: GetAgendaCountFacets :one
 SELECT
-  SUM(CASE
+  COUNT(DISTINCT CASE
     WHEN startdate <= CAST(?1 AS TEXT)
-    AND COALESCE(NULLIF(enddate, ''), startdate) >= CAST(?2 AS TEXT)
-    THEN 1 ELSE 0
+    AND enddate >= CAST(?2 AS TEXT)
+    THEN id
   END) as today,
   
-  SUM(CASE
+  COUNT(DISTINCT CASE
     WHEN startdate <= CAST(?3 AS TEXT)
-    AND COALESCE(NULLIF(enddate, ''), startdate) >= CAST(?4 AS TEXT)
-    THEN 1 ELSE 0
+    AND enddate >= CAST(?4 AS TEXT)
+    THEN id
   END) as this_weekend,
   
-  SUM(CASE
+  COUNT(DISTINCT CASE
     WHEN startdate <= CAST(?5 AS TEXT)
-    AND COALESCE(NULLIF(enddate, ''), startdate) >= CAST(?6 AS TEXT)
-    THEN 1 ELSE 0
+    AND enddate >= CAST(?6 AS TEXT)
+    THEN id
   END) as this_week,
   
-  SUM(CASE
+  COUNT(DISTINCT CASE
     WHEN startdate <= CAST(?7 AS TEXT)
-    AND COALESCE(NULLIF(enddate, ''), startdate) >= CAST(?8 AS TEXT)
-    THEN 1 ELSE 0
+    AND enddate >= CAST(?8 AS TEXT)
+    THEN id
   END) as next_week
-FROM agenda_entry
-WHERE status = 1
+FROM (
+  SELECT id, startdate, max(startdate, COALESCE(enddate, '')) AS enddate
+  FROM agenda_entry
+  WHERE status = 1 AND rrule = '' AND deleted_at IS NULL
+  UNION ALL
+  SELECT o.entry_id AS id, o.startdate, o.enddate
+  FROM agenda_occurrence o JOIN agenda_entry e ON e.id = o.entry_id
+  WHERE e.status = 1 AND o.status = 1 AND e.deleted_at IS NULL
+) AS dated_entry
 `
 
 type GetAgendaCountFacetsParams struct {
@@ -60,10 +87,10 @@ type GetAgendaCountFacetsParams struct {
 }
 
 type GetAgendaCountFacetsRow struct {
-	Today       sql.NullFloat64
-	ThisWeekend sql.NullFloat64
-	ThisWeek    sql.NullFloat64
-	NextWeek    sql.NullFloat64
+	Today       int64
+	ThisWeekend int64
+	ThisWeek    int64
+	NextWeek    int64
 }
 
 func (q *Queries) GetAgendaCountFacets(ctx context.Context, arg GetAgendaCountFacetsParams) (GetAgendaCountFacetsRow, error) {
@@ -86,3 +113,122 @@ func (q *Queries) GetAgendaCountFacets(ctx context.Context, arg GetAgendaCountFa
 	)
 	return i, err
 }
+
+const listUpcomingCategoriesAndTags = `-- name: ListUpcomingCategoriesAndTags :many
+SELECT category, tag, event_lifecycle_status
+FROM agenda_entry
+WHERE status = 1 AND deleted_at IS NULL
+AND (
+  (rrule = '' AND max(startdate, COALESCE(enddate, '')) >= CAST(?1 AS TEXT))
+  OR EXISTS (
+    SELECT 1 FROM agenda_occurrence o
+    WHERE o.entry_id = agenda_entry.id AND o.status = 1 AND o.enddate >= CAST(?1 AS TEXT)
+  )
+)
+`
+
+type ListUpcomingCategoriesAndTagsRow struct {
+	Category             sql.NullString
+	Tag                  sql.NullString
+	EventLifecycleStatus interface{}
+}
+
+func (q *Queries) ListUpcomingCategoriesAndTags(ctx context.Context, today string) ([]ListUpcomingCategoriesAndTagsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUpcomingCategoriesAndTags, today)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUpcomingCategoriesAndTagsRow
+	for rows.Next() {
+		var i ListUpcomingCategoriesAndTagsRow
+		if err := rows.Scan(&i.Category, &i.Tag, &i.EventLifecycleStatus); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchAgendaEntries = `-- name: SearchAgendaEntries :many
+SELECT
+  e.id, e.title, e.subtitle, e.startdate, e.enddate, e.poster, e.category, e.venuename, e.place,
+  CAST(highlight(agenda_entry_fts, 1, ?1, ?2) AS TEXT) AS title_highlight,
+  CAST(snippet(agenda_entry_fts, -1, ?1, ?2, '…', 16) AS TEXT) AS snippet,
+  CAST(bm25(agenda_entry_fts, 0.0, 10.0, 5.0, 1.0, 3.0, 3.0, 2.0) AS REAL) AS rank
+FROM agenda_entry_fts
+JOIN agenda_entry e ON e.id = agenda_entry_fts.id
+WHERE agenda_entry_fts MATCH ?3
+AND e.status = 1 AND e.deleted_at IS NULL
+ORDER BY rank
+LIMIT ?4
+`
+
+type SearchAgendaEntriesParams struct {
+	MarkStart interface{}
+	MarkEnd   interface{}
+	Query     string
+	Limit     int64
+}
+
+type SearchAgendaEntriesRow struct {
+	ID             string
+	Title          string
+	Subtitle       interface{}
+	Startdate      string
+	Enddate        interface{}
+	Poster         sql.NullString
+	Category       sql.NullString
+	Venuename      interface{}
+	Place          sql.NullString
+	TitleHighlight string
+	Snippet        string
+	Rank           float64
+}
+
+func (q *Queries) SearchAgendaEntries(ctx context.Context, arg SearchAgendaEntriesParams) ([]SearchAgendaEntriesRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchAgendaEntries,
+		arg.MarkStart,
+		arg.MarkEnd,
+		arg.Query,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchAgendaEntriesRow
+	for rows.Next() {
+		var i SearchAgendaEntriesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Title,
+			&i.Subtitle,
+			&i.Startdate,
+			&i.Enddate,
+			&i.Poster,
+			&i.Category,
+			&i.Venuename,
+			&i.Place,
+			&i.TitleHighlight,
+			&i.Snippet,
+			&i.Rank,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
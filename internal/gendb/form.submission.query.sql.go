@@ -7,6 +7,7 @@ package gendb
 
 import (
 	"context"
+	"database/sql"
 	"time"
 )
 
@@ -37,8 +38,8 @@ func (q *Queries) CleanupOldSubmission(ctx context.Context) error {
 }
 
 const createFormSubmission = `-- name: CreateFormSubmission :exec
-INSERT INTO form_submissions (id, email, data, edit_token, cancel_token, confirmation_token, created_at, updated_at, expired_at, status)
-    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+INSERT INTO form_submissions (id, email, data, edit_token, cancel_token, confirmation_token, created_at, updated_at, expired_at, status, organizer_id)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 `
 
 type CreateFormSubmissionParams struct {
@@ -52,6 +53,7 @@ type CreateFormSubmissionParams struct {
 	UpdatedAt         time.Time
 	ExpiredAt         time.Time
 	Status            string
+	OrganizerID       sql.NullInt64
 }
 
 func (q *Queries) CreateFormSubmission(ctx context.Context, arg CreateFormSubmissionParams) error {
@@ -66,6 +68,29 @@ func (q *Queries) CreateFormSubmission(ctx context.Context, arg CreateFormSubmis
 		arg.UpdatedAt,
 		arg.ExpiredAt,
 		arg.Status,
+		arg.OrganizerID,
+	)
+	return err
+}
+
+const createSubmissionNote = `-- name: CreateSubmissionNote :exec
+INSERT INTO submission_note (submission_id, user_id, body, created_at)
+    VALUES (?, ?, ?, ?)
+`
+
+type CreateSubmissionNoteParams struct {
+	SubmissionID string
+	UserID       sql.NullInt64
+	Body         string
+	CreatedAt    time.Time
+}
+
+func (q *Queries) CreateSubmissionNote(ctx context.Context, arg CreateSubmissionNoteParams) error {
+	_, err := q.db.ExecContext(ctx, createSubmissionNote,
+		arg.SubmissionID,
+		arg.UserID,
+		arg.Body,
+		arg.CreatedAt,
 	)
 	return err
 }
@@ -80,9 +105,9 @@ func (q *Queries) DeleteSubmissionByID(ctx context.Context, id string) error {
 }
 
 const getSubmissionByID = `-- name: GetSubmissionByID :one
-SELECT id, email, data, edit_token, cancel_token, created_at, updated_at, status, expired_at, confirmation_token
+SELECT id, email, data, edit_token, cancel_token, created_at, updated_at, status, expired_at, confirmation_token, organizer_id, assigned_to, moderation_comment, deleted_at
     FROM form_submissions
-    WHERE ID = ?
+    WHERE ID = ? AND deleted_at IS NULL
 `
 
 func (q *Queries) GetSubmissionByID(ctx context.Context, id string) (FormSubmission, error) {
@@ -99,14 +124,19 @@ func (q *Queries) GetSubmissionByID(ctx context.Context, id string) (FormSubmiss
 		&i.Status,
 		&i.ExpiredAt,
 		&i.ConfirmationToken,
+		&i.OrganizerID,
+		&i.AssignedTo,
+		&i.ModerationComment,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
 const getSubmissionByToken = `-- name: GetSubmissionByToken :one
-SELECT id, email, data, edit_token, cancel_token, confirmation_token, created_at, updated_at, expired_at, status
+SELECT id, email, data, edit_token, cancel_token, confirmation_token, created_at, updated_at, expired_at, status, organizer_id,
+    assigned_to, moderation_comment
     FROM form_submissions 
-    WHERE (edit_token = ? OR cancel_token = ? OR confirmation_token = ?)
+    WHERE (edit_token = ? OR cancel_token = ? OR confirmation_token = ?) AND deleted_at IS NULL
 `
 
 type GetSubmissionByTokenParams struct {
@@ -126,6 +156,9 @@ type GetSubmissionByTokenRow struct {
 	UpdatedAt         time.Time
 	ExpiredAt         time.Time
 	Status            string
+	OrganizerID       sql.NullInt64
+	AssignedTo        sql.NullInt64
+	ModerationComment string
 }
 
 func (q *Queries) GetSubmissionByToken(ctx context.Context, arg GetSubmissionByTokenParams) (GetSubmissionByTokenRow, error) {
@@ -142,14 +175,54 @@ func (q *Queries) GetSubmissionByToken(ctx context.Context, arg GetSubmissionByT
 		&i.UpdatedAt,
 		&i.ExpiredAt,
 		&i.Status,
+		&i.OrganizerID,
+		&i.AssignedTo,
+		&i.ModerationComment,
 	)
 	return i, err
 }
 
+const getSubmissionNotes = `-- name: GetSubmissionNotes :many
+SELECT id, submission_id, user_id, body, created_at
+    FROM submission_note
+    WHERE submission_id = ?
+    ORDER BY created_at, id
+`
+
+func (q *Queries) GetSubmissionNotes(ctx context.Context, submissionID string) ([]SubmissionNote, error) {
+	rows, err := q.db.QueryContext(ctx, getSubmissionNotes, submissionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SubmissionNote
+	for rows.Next() {
+		var i SubmissionNote
+		if err := rows.Scan(
+			&i.ID,
+			&i.SubmissionID,
+			&i.UserID,
+			&i.Body,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getSubmissions = `-- name: GetSubmissions :many
-SELECT id, email, data, edit_token, cancel_token, created_at, updated_at, status, expired_at, confirmation_token 
+SELECT id, email, data, edit_token, cancel_token, created_at, updated_at, status, expired_at, confirmation_token, organizer_id, assigned_to, moderation_comment, deleted_at 
     FROM form_submissions
-    WHERE status='pending' or status='active' or status='archived'
+    WHERE deleted_at IS NULL
+    AND (status='pending' or status='changes_requested' or status='active' or status='archived')
 `
 
 func (q *Queries) GetSubmissions(ctx context.Context) ([]FormSubmission, error) {
@@ -172,6 +245,10 @@ func (q *Queries) GetSubmissions(ctx context.Context) ([]FormSubmission, error)
 			&i.Status,
 			&i.ExpiredAt,
 			&i.ConfirmationToken,
+			&i.OrganizerID,
+			&i.AssignedTo,
+			&i.ModerationComment,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -186,6 +263,38 @@ func (q *Queries) GetSubmissions(ctx context.Context) ([]FormSubmission, error)
 	return items, nil
 }
 
+const purgeTrashedSubmissions = `-- name: PurgeTrashedSubmissions :exec
+DELETE FROM form_submissions WHERE deleted_at IS NOT NULL AND deleted_at < ?
+`
+
+func (q *Queries) PurgeTrashedSubmissions(ctx context.Context, deletedAt sql.NullTime) error {
+	_, err := q.db.ExecContext(ctx, purgeTrashedSubmissions, deletedAt)
+	return err
+}
+
+const restoreSubmission = `-- name: RestoreSubmission :exec
+UPDATE form_submissions SET deleted_at = NULL WHERE ID = ?
+`
+
+func (q *Queries) RestoreSubmission(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, restoreSubmission, id)
+	return err
+}
+
+const trashSubmission = `-- name: TrashSubmission :exec
+UPDATE form_submissions SET deleted_at = ? WHERE ID = ? AND deleted_at IS NULL
+`
+
+type TrashSubmissionParams struct {
+	DeletedAt sql.NullTime
+	ID        string
+}
+
+func (q *Queries) TrashSubmission(ctx context.Context, arg TrashSubmissionParams) error {
+	_, err := q.db.ExecContext(ctx, trashSubmission, arg.DeletedAt, arg.ID)
+	return err
+}
+
 const updateStatusByID = `-- name: UpdateStatusByID :exec
 UPDATE form_submissions 
     SET status = ?
@@ -202,6 +311,51 @@ func (q *Queries) UpdateStatusByID(ctx context.Context, arg UpdateStatusByIDPara
 	return err
 }
 
+const updateSubmissionAssignee = `-- name: UpdateSubmissionAssignee :exec
+UPDATE form_submissions
+    SET assigned_to = ?, updated_at = ?
+    WHERE id = ?
+`
+
+type UpdateSubmissionAssigneeParams struct {
+	AssignedTo sql.NullInt64
+	UpdatedAt  time.Time
+	ID         string
+}
+
+func (q *Queries) UpdateSubmissionAssignee(ctx context.Context, arg UpdateSubmissionAssigneeParams) error {
+	_, err := q.db.ExecContext(ctx, updateSubmissionAssignee, arg.AssignedTo, arg.UpdatedAt, arg.ID)
+	return err
+}
+
+const updateSubmissionModeration = `-- name: UpdateSubmissionModeration :execrows
+UPDATE form_submissions
+    SET status = ?, moderation_comment = ?, updated_at = ?
+    WHERE id = ? AND status = ?
+`
+
+type UpdateSubmissionModerationParams struct {
+	Status            string
+	ModerationComment string
+	UpdatedAt         time.Time
+	ID                string
+	Status_2          string
+}
+
+func (q *Queries) UpdateSubmissionModeration(ctx context.Context, arg UpdateSubmissionModerationParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateSubmissionModeration,
+		arg.Status,
+		arg.ModerationComment,
+		arg.UpdatedAt,
+		arg.ID,
+		arg.Status_2,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const updateSubmissionStatus = `-- name: UpdateSubmissionStatus :exec
 UPDATE form_submissions 
     SET status = ?, data = ?
@@ -30,6 +30,30 @@ type AgendaEntry struct {
 	Enddate              interface{}
 	Venuename            interface{}
 	EventOwner           int64
+	CreatedAt            sql.NullTime
+	UpdatedAt            sql.NullTime
+	Rrule                string
+	Exdates              string
+	VenueID              sql.NullInt64
+	OrganizerID          sql.NullInt64
+	OriginalStartdate    string
+	DeletedAt            sql.NullTime
+	PosterVariants       string
+}
+
+type AgendaOccurrence struct {
+	EntryID   string
+	Startdate string
+	Enddate   string
+	Status    int64
+}
+
+type EventSubscription struct {
+	ID        int64
+	EntryID   string
+	Email     string
+	Token     string
+	CreatedAt time.Time
 }
 
 type FormSubmission struct {
@@ -43,6 +67,23 @@ type FormSubmission struct {
 	Status            string
 	ExpiredAt         time.Time
 	ConfirmationToken string
+	OrganizerID       sql.NullInt64
+	AssignedTo        sql.NullInt64
+	ModerationComment string
+	DeletedAt         sql.NullTime
+}
+
+type Organizer struct {
+	ID          int64
+	Slug        string
+	Name        string
+	Description string
+	Links       string
+	Logo        string
+	Email       string
+	Verified    int64
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
 
 type RefreshToken struct {
@@ -53,6 +94,18 @@ type RefreshToken struct {
 	ExpiresAt time.Time
 }
 
+type ScrapingRun struct {
+	ID           int64
+	TaskID       int64
+	Status       int64
+	CreatedAt    time.Time
+	StartedAt    sql.NullTime
+	FinishedAt   sql.NullTime
+	EntriesCount int64
+	Result       string
+	Error        string
+}
+
 type ScrapingTask struct {
 	ID          int64
 	Type        string
@@ -60,6 +113,17 @@ type ScrapingTask struct {
 	Params      string
 	RunningTime int64
 	Status      sql.NullInt64
+	Name        string
+	Schedule    string
+	NextRunAt   sql.NullTime
+}
+
+type SubmissionNote struct {
+	ID           int64
+	SubmissionID string
+	UserID       sql.NullInt64
+	Body         string
+	CreatedAt    time.Time
 }
 
 type User struct {
@@ -70,3 +134,13 @@ type User struct {
 	Status       sql.NullInt64
 	TokenVersion interface{}
 }
+
+type Venue struct {
+	ID            int64
+	Name          string
+	Address       string
+	Place         string
+	NormalizedKey string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
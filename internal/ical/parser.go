@@ -0,0 +1,296 @@
+// iCalendar (RFC 5545) reading, only the VEVENT properties used by the agenda
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLocation is used for floating times and unknown TZID
+var DefaultLocation = loadLocation("Europe/Zurich")
+
+var ErrNoCalendar = errors.New("not an iCalendar file")
+
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+type Event struct {
+	UID          string
+	RecurrenceID string
+	Summary      string
+	Description  string
+	Location     string
+	URL          string
+	Status       string
+	Categories   []string
+	Attach       []Property
+	Start        time.Time
+	End          time.Time
+	// DTSTART;VALUE=DATE, End is then the last day of the event
+	AllDay bool
+	// Recurrence and ExDates are written by Write, the parser leaves them in Properties
+	Recurrence *RecurrenceRule
+	ExDates    []time.Time
+	// every property of the event, by name
+	Properties map[string][]Property
+}
+
+func loadLocation(name string) *time.Location {
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// Parse reads the VEVENT components of a calendar
+func Parse(reader io.Reader) ([]Event, error) {
+	lines, err := unfold(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, ErrNoCalendar
+	}
+
+	var events []Event
+	var current *Event
+	// nested components (VALARM) are ignored
+	depth := 0
+	for number, line := range lines {
+		property, err := parseProperty(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number+1, err)
+		}
+		switch {
+		case property.Name == "BEGIN" && strings.EqualFold(property.Value, "VEVENT"):
+			current = &Event{Properties: map[string][]Property{}}
+			depth = 0
+		case property.Name == "END" && strings.EqualFold(property.Value, "VEVENT") && current != nil:
+			if err := current.resolve(); err != nil {
+				return nil, fmt.Errorf("event %q: %w", current.UID, err)
+			}
+			events = append(events, *current)
+			current = nil
+		case current == nil:
+			continue
+		case property.Name == "BEGIN":
+			depth++
+		case property.Name == "END":
+			depth--
+		case depth == 0:
+			current.Properties[property.Name] = append(current.Properties[property.Name], property)
+		}
+	}
+	return events, nil
+}
+
+// unfold joins the continuation lines (starting with a space or a tab)
+func unfold(reader io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	// BOM
+	if len(lines) > 0 {
+		lines[0] = strings.TrimPrefix(lines[0], "\ufeff")
+	}
+	return lines, nil
+}
+
+// parseProperty reads NAME;PARAM=VALUE;PARAM="QUOTED:VALUE":value
+func parseProperty(line string) (Property, error) {
+	property := Property{Params: map[string]string{}}
+	inQuotes := false
+	start := 0
+	var key string
+	for i, char := range line {
+		switch {
+		case char == '"':
+			inQuotes = !inQuotes
+		case inQuotes:
+		case char == ';' || char == ':':
+			part := line[start:i]
+			if property.Name == "" {
+				property.Name = strings.ToUpper(part)
+			} else if key != "" {
+				property.Params[key] = strings.Trim(part, `"`)
+			}
+			key = ""
+			start = i + 1
+			if char == ':' {
+				property.Value = line[i+1:]
+				if property.Name == "" {
+					return property, fmt.Errorf("missing property name in %q", line)
+				}
+				return property, nil
+			}
+		case char == '=' && key == "" && property.Name != "":
+			key = strings.ToUpper(line[start:i])
+			start = i + 1
+		}
+	}
+	return property, fmt.Errorf("invalid content line %q", line)
+}
+
+func (event *Event) first(name string) (Property, bool) {
+	properties := event.Properties[name]
+	if len(properties) == 0 {
+		return Property{}, false
+	}
+	return properties[0], true
+}
+
+func (event *Event) text(name string) string {
+	property, _ := event.first(name)
+	return strings.TrimSpace(UnescapeText(property.Value))
+}
+
+func (event *Event) resolve() error {
+	event.UID = event.text("UID")
+	event.Summary = event.text("SUMMARY")
+	event.Description = event.text("DESCRIPTION")
+	event.Location = event.text("LOCATION")
+	event.URL = event.text("URL")
+	event.Status = strings.ToUpper(event.text("STATUS"))
+	for _, categories := range event.Properties["CATEGORIES"] {
+		for _, category := range splitText(categories.Value) {
+			if category = strings.TrimSpace(category); category != "" {
+				event.Categories = append(event.Categories, category)
+			}
+		}
+	}
+	event.Attach = event.Properties["ATTACH"]
+	if recurrenceID, ok := event.first("RECURRENCE-ID"); ok {
+		event.RecurrenceID = recurrenceID.Value
+	}
+
+	start, ok := event.first("DTSTART")
+	if !ok {
+		return errors.New("DTSTART is missing")
+	}
+	var err error
+	if event.Start, event.AllDay, err = ParseDateTime(start); err != nil {
+		return err
+	}
+	if end, ok := event.first("DTEND"); ok {
+		if event.End, _, err = ParseDateTime(end); err != nil {
+			return err
+		}
+	} else if duration, ok := event.first("DURATION"); ok {
+		length, err := ParseDuration(duration.Value)
+		if err != nil {
+			return err
+		}
+		event.End = event.Start.Add(length)
+	} else if event.AllDay {
+		event.End = event.Start.AddDate(0, 0, 1)
+	} else {
+		event.End = event.Start
+	}
+	// the DTEND of a whole day event is exclusive
+	if event.AllDay && event.End.After(event.Start) {
+		event.End = event.End.AddDate(0, 0, -1)
+	}
+	if event.End.Before(event.Start) {
+		return errors.New("DTEND is before DTSTART")
+	}
+	return nil
+}
+
+// ParseDateTime reads a DATE or DATE-TIME value (UTC, TZID or floating)
+func ParseDateTime(property Property) (time.Time, bool, error) {
+	value := strings.TrimSpace(property.Value)
+	if property.Params["VALUE"] == "DATE" || len(value) == 8 {
+		date, err := time.ParseInLocation("20060102", value, DefaultLocation)
+		return date, true, err
+	}
+	location := DefaultLocation
+	if tzid, ok := property.Params["TZID"]; ok {
+		// some producers prefix the TZID with a slash
+		if loaded, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+			location = loaded
+		}
+	}
+	if strings.HasSuffix(value, "Z") {
+		date, err := time.Parse("20060102T150405Z", value)
+		return date.In(DefaultLocation), false, err
+	}
+	date, err := time.ParseInLocation("20060102T150405", value, location)
+	if err != nil {
+		return date, false, err
+	}
+	return date.In(DefaultLocation), false, nil
+}
+
+var durationRegexp = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// ParseDuration reads a DURATION value (P1DT2H30M)
+func ParseDuration(value string) (time.Duration, error) {
+	matches := durationRegexp.FindStringSubmatch(strings.TrimSpace(value))
+	if matches == nil || value == "P" {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var duration time.Duration
+	for i, unit := range units {
+		if matches[i+2] == "" {
+			continue
+		}
+		number, _ := strconv.Atoi(matches[i+2])
+		duration += time.Duration(number) * unit
+	}
+	if matches[1] == "-" {
+		duration = -duration
+	}
+	return duration, nil
+}
+
+var textUnescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+func UnescapeText(value string) string {
+	return textUnescaper.Replace(value)
+}
+
+// splitText splits a list value on the unescaped commas
+func splitText(value string) []string {
+	var parts []string
+	var current strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			current.WriteByte(value[i])
+			current.WriteByte(value[i+1])
+			i++
+			continue
+		}
+		if value[i] == ',' {
+			parts = append(parts, UnescapeText(current.String()))
+			current.Reset()
+			continue
+		}
+		current.WriteByte(value[i])
+	}
+	return append(parts, UnescapeText(current.String()))
+}
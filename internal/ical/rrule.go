@@ -0,0 +1,297 @@
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+	FreqYearly  = "YEARLY"
+)
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// WeekdayNum is a BYDAY value: MO, 2TU (second tuesday), -1FR (last friday)
+type WeekdayNum struct {
+	Weekday time.Weekday
+	N       int
+}
+
+// RecurrenceRule is the date part of an RFC 5545 RRULE, agenda entries keep their start and end times
+type RecurrenceRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByMonth    []time.Month
+}
+
+// ParseRRule reads "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20251231", the RRULE: prefix is optional
+func ParseRRule(value string) (*RecurrenceRule, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	rule := &RecurrenceRule{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		name, partValue, found := strings.Cut(part, "=")
+		if !found {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRule, part)
+		}
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(partValue)
+			if !slices.Contains([]string{FreqDaily, FreqWeekly, FreqMonthly, FreqYearly}, rule.Freq) {
+				err = fmt.Errorf("unsupported frequency %s", partValue)
+			}
+		case "INTERVAL":
+			rule.Interval, err = positiveInt(partValue)
+		case "COUNT":
+			rule.Count, err = positiveInt(partValue)
+		case "UNTIL":
+			rule.Until, _, err = ParseDateTime(Property{Value: partValue})
+		case "BYDAY":
+			for _, day := range strings.Split(partValue, ",") {
+				day = strings.ToUpper(day)
+				if len(day) < 2 {
+					err = fmt.Errorf("bad day %s", day)
+					break
+				}
+				weekday, exists := weekdays[day[len(day)-2:]]
+				if !exists {
+					err = fmt.Errorf("bad day %s", day)
+					break
+				}
+				n := 0
+				if prefix := day[:len(day)-2]; prefix != "" {
+					if n, err = strconv.Atoi(prefix); err != nil || n == 0 || n < -53 || n > 53 {
+						err = fmt.Errorf("bad day %s", day)
+						break
+					}
+				}
+				rule.ByDay = append(rule.ByDay, WeekdayNum{Weekday: weekday, N: n})
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(partValue, ",") {
+				n, convErr := strconv.Atoi(day)
+				if convErr != nil || n == 0 || n < -31 || n > 31 {
+					err = fmt.Errorf("bad month day %s", day)
+					break
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, month := range strings.Split(partValue, ",") {
+				n, convErr := strconv.Atoi(month)
+				if convErr != nil || n < 1 || n > 12 {
+					err = fmt.Errorf("bad month %s", month)
+					break
+				}
+				rule.ByMonth = append(rule.ByMonth, time.Month(n))
+			}
+		case "WKST":
+			// weeks start on monday
+		default:
+			err = fmt.Errorf("unsupported part %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+	}
+	if rule.Freq == "" {
+		return nil, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, fmt.Errorf("%w: COUNT and UNTIL can't be combined", ErrInvalidRule)
+	}
+	return rule, nil
+}
+
+func positiveInt(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("expected a positive number, got %s", value)
+	}
+	return n, nil
+}
+
+// String is the RRULE value, without prefix
+func (rule *RecurrenceRule) String() string {
+	return rule.value(true)
+}
+
+// value UNTIL has the type of DTSTART: a date, or the end of the day in UTC for a DTSTART with time
+func (rule *RecurrenceRule) value(allDay bool) string {
+	parts := []string{"FREQ=" + rule.Freq}
+	if rule.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(rule.Interval))
+	}
+	if rule.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(rule.Count))
+	}
+	if !rule.Until.IsZero() {
+		if allDay {
+			parts = append(parts, "UNTIL="+rule.Until.Format("20060102"))
+		} else {
+			until := time.Date(rule.Until.Year(), rule.Until.Month(), rule.Until.Day(), 23, 59, 59, 0, DefaultLocation)
+			parts = append(parts, "UNTIL="+until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	if len(rule.ByDay) > 0 {
+		days := make([]string, len(rule.ByDay))
+		for i, day := range rule.ByDay {
+			days[i] = strings.ToUpper(day.Weekday.String()[:2])
+			if day.N != 0 {
+				days[i] = strconv.Itoa(day.N) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(rule.ByMonthDay) > 0 {
+		days := make([]string, len(rule.ByMonthDay))
+		for i, day := range rule.ByMonthDay {
+			days[i] = strconv.Itoa(day)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if len(rule.ByMonth) > 0 {
+		months := make([]string, len(rule.ByMonth))
+		for i, month := range rule.ByMonth {
+			months[i] = strconv.Itoa(int(month))
+		}
+		parts = append(parts, "BYMONTH="+strings.Join(months, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Occurrences returns the dates of the occurrences from start (always the first one) until the end of
+// the rule or the horizon, at most limit. The exdates are skipped but count for COUNT, as in RFC 5545.
+func (rule *RecurrenceRule) Occurrences(start time.Time, exdates []time.Time, horizon time.Time, limit int) []time.Time {
+	start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	last := time.Date(horizon.Year(), horizon.Month(), horizon.Day(), 0, 0, 0, 0, time.UTC)
+	if !rule.Until.IsZero() {
+		until := time.Date(rule.Until.Year(), rule.Until.Month(), rule.Until.Day(), 0, 0, 0, 0, time.UTC)
+		if until.Before(last) {
+			last = until
+		}
+	}
+	excluded := map[string]bool{}
+	for _, exdate := range exdates {
+		excluded[exdate.Format("2006-01-02")] = true
+	}
+
+	occurrences := []time.Time{}
+	count := 0
+	for day := start; !day.After(last); day = day.AddDate(0, 0, 1) {
+		if !day.Equal(start) && !rule.matches(day, start) {
+			continue
+		}
+		count++
+		if !excluded[day.Format("2006-01-02")] {
+			occurrences = append(occurrences, day)
+			if limit > 0 && len(occurrences) == limit {
+				break
+			}
+		}
+		if rule.Count > 0 && count == rule.Count {
+			break
+		}
+	}
+	return occurrences
+}
+
+// matches tells if the day, after start, is an occurrence
+func (rule *RecurrenceRule) matches(day time.Time, start time.Time) bool {
+	if len(rule.ByMonth) > 0 && !slices.Contains(rule.ByMonth, day.Month()) {
+		return false
+	}
+	switch rule.Freq {
+	case FreqDaily:
+		if daysBetween(start, day)%rule.Interval != 0 {
+			return false
+		}
+		return rule.matchesMonthDay(day) && rule.matchesWeekday(day)
+	case FreqWeekly:
+		if (daysBetween(weekStart(start), weekStart(day))/7)%rule.Interval != 0 {
+			return false
+		}
+		if len(rule.ByDay) == 0 {
+			return day.Weekday() == start.Weekday()
+		}
+		return rule.matchesWeekday(day)
+	case FreqMonthly:
+		months := (day.Year()-start.Year())*12 + int(day.Month()) - int(start.Month())
+		if months%rule.Interval != 0 {
+			return false
+		}
+		if len(rule.ByMonthDay) == 0 && len(rule.ByDay) == 0 {
+			return day.Day() == start.Day()
+		}
+		return rule.matchesMonthDay(day) && rule.matchesWeekday(day)
+	case FreqYearly:
+		if (day.Year()-start.Year())%rule.Interval != 0 {
+			return false
+		}
+		if len(rule.ByMonth) == 0 && day.Month() != start.Month() {
+			return false
+		}
+		if len(rule.ByMonthDay) == 0 && len(rule.ByDay) == 0 {
+			return day.Day() == start.Day()
+		}
+		return rule.matchesMonthDay(day) && rule.matchesWeekday(day)
+	}
+	return false
+}
+
+func (rule *RecurrenceRule) matchesMonthDay(day time.Time) bool {
+	if len(rule.ByMonthDay) == 0 {
+		return true
+	}
+	daysInMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, monthDay := range rule.ByMonthDay {
+		if monthDay == day.Day() || (monthDay < 0 && daysInMonth+monthDay+1 == day.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWeekday, the ordinals count in the month (2TU: second tuesday, -1FR: last friday)
+func (rule *RecurrenceRule) matchesWeekday(day time.Time) bool {
+	if len(rule.ByDay) == 0 {
+		return true
+	}
+	daysInMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, weekday := range rule.ByDay {
+		if weekday.Weekday != day.Weekday() {
+			continue
+		}
+		if weekday.N == 0 ||
+			(weekday.N > 0 && (day.Day()-1)/7+1 == weekday.N) ||
+			(weekday.N < 0 && (daysInMonth-day.Day())/7+1 == -weekday.N) {
+			return true
+		}
+	}
+	return false
+}
+
+func daysBetween(from time.Time, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// weekStart is the monday of the week
+func weekStart(day time.Time) time.Time {
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
@@ -0,0 +1,132 @@
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	TimezoneID = "Europe/Zurich"
+	productID  = "-//Afromemo//Agenda//FR"
+	// content lines are folded after 75 octets
+	maxLineLength = 75
+)
+
+// Central European Time rules since 1996
+var zurichTimezone = []string{
+	"BEGIN:VTIMEZONE",
+	"TZID:" + TimezoneID,
+	"X-LIC-LOCATION:" + TimezoneID,
+	"BEGIN:DAYLIGHT",
+	"TZOFFSETFROM:+0100",
+	"TZOFFSETTO:+0200",
+	"TZNAME:CEST",
+	"DTSTART:19810329T020000",
+	"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU",
+	"END:DAYLIGHT",
+	"BEGIN:STANDARD",
+	"TZOFFSETFROM:+0200",
+	"TZOFFSETTO:+0100",
+	"TZNAME:CET",
+	"DTSTART:19961027T030000",
+	"RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU",
+	"END:STANDARD",
+	"END:VTIMEZONE",
+}
+
+type lineWriter struct {
+	writer *bufio.Writer
+}
+
+// line folds the content line and ends it with CRLF
+func (lw *lineWriter) line(content string) {
+	limit := maxLineLength
+	for len(content) > limit {
+		cut := limit
+		// never split an utf-8 character
+		for cut > 0 && !utf8.RuneStart(content[cut]) {
+			cut--
+		}
+		lw.writer.WriteString(content[:cut] + "\r\n ")
+		content = content[cut:]
+		// the leading space counts in the continuation line
+		limit = maxLineLength - 1
+	}
+	lw.writer.WriteString(content + "\r\n")
+}
+
+func (lw *lineWriter) text(name string, value string) {
+	if value != "" {
+		lw.line(name + ":" + EscapeText(value))
+	}
+}
+
+// Write renders the events as a VCALENDAR, times are written in Europe/Zurich
+func Write(writer io.Writer, name string, events []Event) error {
+	lw := &lineWriter{bufio.NewWriter(writer)}
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+
+	lw.line("BEGIN:VCALENDAR")
+	lw.line("VERSION:2.0")
+	lw.line("PRODID:" + productID)
+	lw.line("CALSCALE:GREGORIAN")
+	lw.line("METHOD:PUBLISH")
+	lw.text("X-WR-CALNAME", name)
+	lw.line("X-WR-TIMEZONE:" + TimezoneID)
+	for _, line := range zurichTimezone {
+		lw.line(line)
+	}
+	for _, event := range events {
+		lw.line("BEGIN:VEVENT")
+		lw.line("UID:" + event.UID)
+		lw.line("DTSTAMP:" + stamp)
+		if event.AllDay {
+			lw.line("DTSTART;VALUE=DATE:" + event.Start.Format("20060102"))
+			// exclusive end
+			lw.line("DTEND;VALUE=DATE:" + event.End.AddDate(0, 0, 1).Format("20060102"))
+		} else {
+			lw.line("DTSTART;TZID=" + TimezoneID + ":" + event.Start.In(DefaultLocation).Format("20060102T150405"))
+			if event.End.After(event.Start) {
+				lw.line("DTEND;TZID=" + TimezoneID + ":" + event.End.In(DefaultLocation).Format("20060102T150405"))
+			}
+		}
+		if event.Recurrence != nil {
+			lw.line("RRULE:" + event.Recurrence.value(event.AllDay))
+		}
+		for _, exdate := range event.ExDates {
+			if event.AllDay {
+				lw.line("EXDATE;VALUE=DATE:" + exdate.Format("20060102"))
+			} else {
+				lw.line("EXDATE;TZID=" + TimezoneID + ":" + exdate.In(DefaultLocation).Format("20060102T150405"))
+			}
+		}
+		lw.text("SUMMARY", event.Summary)
+		lw.text("DESCRIPTION", event.Description)
+		lw.text("LOCATION", event.Location)
+		if event.URL != "" {
+			lw.line("URL:" + event.URL)
+		}
+		if len(event.Categories) > 0 {
+			categories := make([]string, len(event.Categories))
+			for i, category := range event.Categories {
+				categories[i] = EscapeText(category)
+			}
+			lw.line("CATEGORIES:" + strings.Join(categories, ","))
+		}
+		if event.Status != "" {
+			lw.line("STATUS:" + event.Status)
+		}
+		lw.line("END:VEVENT")
+	}
+	lw.line("END:VCALENDAR")
+	return lw.writer.Flush()
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func EscapeText(value string) string {
+	return textEscaper.Replace(value)
+}
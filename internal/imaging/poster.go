@@ -0,0 +1,130 @@
+// Package imaging prepares the uploaded posters for the agenda: upright, without metadata and
+// in the sizes used by the front.
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"dpatrov/scraper/internal/storage"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	// decoders of the accepted uploads
+	_ "image/gif"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+
+	"golang.org/x/image/draw"
+)
+
+// Size a poster width in pixels, a smaller poster is never enlarged
+type Size struct {
+	Name  string
+	Width int
+}
+
+// Sizes the thumbnail of the lists, the card of the agenda and the poster of the event page
+var Sizes = []Size{{"thumbnail", 320}, {"card", 640}, {"full", 1280}}
+
+// MaxPixels rejects the images which would take too much memory once decoded
+const MaxPixels = 40_000_000
+
+const jpegQuality = 82
+
+var (
+	ErrUnsupportedImage = errors.New("invalid or unsupported image")
+	ErrImageTooLarge    = errors.New("image is too large")
+)
+
+// Variant a processed file of the poster
+type Variant struct {
+	Size   string
+	File   string
+	Width  int
+	Height int
+}
+
+// CheckImage reads the header of the image only: its format and its size
+func CheckImage(r io.Reader) (string, error) {
+	config, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if config.Width*config.Height > MaxPixels {
+		return format, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, config.Width, config.Height)
+	}
+	return format, nil
+}
+
+// ProcessPoster stores the sizes of the poster under their content key (storage.PutContent), as .jpg or
+// .png when the poster is transparent, the largest last. The image is turned upright and encoded again,
+// which drops the EXIF and GPS metadata. The same poster uploaded twice is stored once.
+func ProcessPoster(ctx context.Context, r io.Reader, store storage.Storage) ([]Variant, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	format, err := CheckImage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if format == "jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+	extension := ".jpg"
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && !opaque.Opaque() {
+		extension = ".png"
+	}
+
+	variants := []Variant{}
+	// only the files stored by this call are removed on error, the others may be shared
+	created := []string{}
+	bounds := img.Bounds()
+	for _, size := range Sizes {
+		width := min(size.Width, bounds.Dx())
+		// the poster is smaller than this size, the previous one is already the original
+		if len(variants) > 0 && variants[len(variants)-1].Width == width {
+			continue
+		}
+		height := max(1, bounds.Dy()*width/bounds.Dx())
+		resized := image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+
+		key, isNew, err := storeImage(ctx, store, resized, extension)
+		if err != nil {
+			for _, key := range created {
+				store.Delete(ctx, key)
+			}
+			return nil, err
+		}
+		if isNew {
+			created = append(created, key)
+		}
+		variants = append(variants, Variant{Size: size.Name, File: key, Width: width, Height: height})
+	}
+	return variants, nil
+}
+
+func storeImage(ctx context.Context, store storage.Storage, img image.Image, extension string) (string, bool, error) {
+	var encoded bytes.Buffer
+	var err error
+	if extension == ".png" {
+		err = png.Encode(&encoded, img)
+	} else {
+		err = jpeg.Encode(&encoded, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("encode %s: %w", extension, err)
+	}
+	return storage.PutContent(ctx, store, encoded.Bytes(), extension)
+}
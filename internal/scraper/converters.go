@@ -0,0 +1,149 @@
+package scraper
+
+import (
+	"dpatrov/scraper/internal/types"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	dateLayout = "2006-01-02"
+	timeLayout = "15:04"
+)
+
+// French names are translated before parsing, longest first (janvier before janv.)
+var frenchDateReplacer = strings.NewReplacer(
+	"janvier", "January", "février", "February", "fevrier", "February", "mars", "March",
+	"avril", "April", "mai", "May", "juin", "June", "juillet", "July", "août", "August",
+	"aout", "August", "septembre", "September", "octobre", "October", "novembre", "November",
+	"décembre", "December", "decembre", "December",
+	"janv.", "Jan", "févr.", "Feb", "fevr.", "Feb", "avr.", "Apr", "juil.", "Jul",
+	"sept.", "Sep", "oct.", "Oct", "nov.", "Nov", "déc.", "Dec", "dec.", "Dec",
+	"lundi", "Monday", "mardi", "Tuesday", "mercredi", "Wednesday", "jeudi", "Thursday",
+	"vendredi", "Friday", "samedi", "Saturday", "dimanche", "Sunday",
+	"lun.", "Mon", "mar.", "Tue", "mer.", "Wed", "jeu.", "Thu", "ven.", "Fri", "sam.", "Sat", "dim.", "Sun",
+	"1er", "1",
+)
+
+// ConvertValue applies the converters of a field on the value scraped from node
+func ConvertValue(node *html.Node, value string, converters []types.Converter, baseURL *url.URL) (string, error) {
+	var err error
+	for _, converter := range converters {
+		value, err = applyConverter(node, value, converter, baseURL)
+		if err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+func applyConverter(node *html.Node, value string, converter types.Converter, baseURL *url.URL) (string, error) {
+	switch converter.Type {
+	case "trim":
+		return strings.Join(strings.Fields(value), " "), nil
+	case "attr":
+		if node == nil {
+			return "", fmt.Errorf("attr %s: no node", converter.Name)
+		}
+		attr, ok := getAttr(node, strings.ToLower(converter.Name))
+		if !ok {
+			return "", fmt.Errorf("attr %s not found on <%s>", converter.Name, node.Data)
+		}
+		return strings.TrimSpace(attr), nil
+	case "regex":
+		exp, err := regexp.Compile(converter.Pattern)
+		if err != nil {
+			return "", fmt.Errorf("regex %q: %w", converter.Pattern, err)
+		}
+		matches := exp.FindStringSubmatch(value)
+		if matches == nil {
+			return "", fmt.Errorf("regex %q doesn't match %q", converter.Pattern, value)
+		}
+		// first group if any
+		if len(matches) > 1 {
+			return matches[1], nil
+		}
+		return matches[0], nil
+	case "replace":
+		exp, err := regexp.Compile(converter.Pattern)
+		if err != nil {
+			return "", fmt.Errorf("replace %q: %w", converter.Pattern, err)
+		}
+		return exp.ReplaceAllString(value, converter.Replacement), nil
+	case "url":
+		return resolveURL(value, baseURL)
+	case "date":
+		parsed, err := parseDateValue(value, converter)
+		if err != nil {
+			return "", err
+		}
+		return parsed.Format(dateLayout), nil
+	case "time":
+		parsed, err := parseDateValue(value, converter)
+		if err != nil {
+			return "", err
+		}
+		return parsed.Format(timeLayout), nil
+	case "split":
+		separator := converter.Separator
+		if separator == "" {
+			separator = ","
+		}
+		var parts []string
+		for _, part := range strings.Split(value, separator) {
+			if part = strings.TrimSpace(part); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		return strings.Join(parts, ","), nil
+	}
+	return "", fmt.Errorf("unknown converter %q", converter.Type)
+}
+
+func resolveURL(value string, baseURL *url.URL) (string, error) {
+	ref, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return "", fmt.Errorf("invalid url %q: %w", value, err)
+	}
+	if baseURL == nil {
+		return ref.String(), nil
+	}
+	return baseURL.ResolveReference(ref).String(), nil
+}
+
+func parseDateValue(value string, converter types.Converter) (time.Time, error) {
+	value = strings.Join(strings.Fields(value), " ")
+	if converter.Locale == "fr" {
+		value = frenchDateReplacer.Replace(strings.ToLower(value))
+	}
+	layouts := converter.Layouts
+	if len(layouts) == 0 {
+		layouts = []string{dateLayout, timeLayout, time.RFC3339, "2006-01-02T15:04"}
+	}
+	for _, layout := range layouts {
+		parsed, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+		// layout without year ("dimanche 12 octobre")
+		if parsed.Year() == 0 {
+			parsed = withCurrentYear(parsed, time.Now())
+		}
+		return parsed, nil
+	}
+	return time.Time{}, fmt.Errorf("can't parse date %q with layouts %v", value, layouts)
+}
+
+// dates more than 6 months ago are for next year
+func withCurrentYear(parsed time.Time, now time.Time) time.Time {
+	parsed = parsed.AddDate(now.Year(), 0, 0)
+	if parsed.Before(now.AddDate(0, -6, 0)) {
+		parsed = parsed.AddDate(1, 0, 0)
+	}
+	return parsed
+}
@@ -1,17 +1,43 @@
 package scraper
 
 import (
+	"context"
+	"dpatrov/scraper/internal/db"
 	"dpatrov/scraper/internal/types"
+	"dpatrov/scraper/internal/validators"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"slices"
 	"strings"
-
-	"github.com/PuerkitoBio/goquery"
+	"time"
 )
 
 type Record map[string]string
 
+// agenda entry fields a scraping map can target
+var AgendaFields = []string{
+	"title", "subtitle", "link", "price", "venuename", "address", "place",
+	"startdate", "starttime", "enddate", "endtime",
+	"description", "poster", "category", "tags", "infos",
+}
+
+var converterTypes = []string{"trim", "attr", "regex", "replace", "url", "date", "time", "split"}
+
+// InvalidItem is a scraped item rejected by the validation
+type InvalidItem struct {
+	Record Record            `json:"record"`
+	Errors map[string]string `json:"errors"`
+}
+
+type ScrapResult struct {
+	Records []Record         `json:"records"`
+	Entries []db.AgendaEntry `json:"entries"`
+	Invalid []InvalidItem    `json:"invalid"`
+}
+
 func New() *types.ScrapingTask {
 	return &types.ScrapingTask{}
 }
@@ -30,23 +56,243 @@ func LoadScrapingTaskFromFile(filePath string) (types.ScrapingTask, error) {
 	return scrapingTask, nil
 }
 
-func HandleScrapTask(scrapTask types.ScrapingTask) Record {
+// ValidateScrapingTask checks the url, the selectors, the target fields and the converters
+func ValidateScrapingTask(scrapTask types.ScrapingTask) error {
+	var errorsList []string
+	if parsed, err := url.Parse(scrapTask.Url); err != nil || parsed.Host == "" {
+		errorsList = append(errorsList, fmt.Sprintf("invalid url %q", scrapTask.Url))
+	}
+	switch scrapTask.Mode {
+	case types.ScrapingMode_Selectors:
+		if len(scrapTask.ScrapingMap) == 0 {
+			errorsList = append(errorsList, "scraping map is empty")
+		}
+	case "", types.ScrapingMode_Structured:
+	default:
+		errorsList = append(errorsList, fmt.Sprintf("unknown mode %q", scrapTask.Mode))
+	}
+	if scrapTask.ItemSelector != "" {
+		if _, err := parseSelectorGroup(scrapTask.ItemSelector); err != nil {
+			errorsList = append(errorsList, err.Error())
+		}
+	}
+	for selector, field := range scrapTask.ScrapingMap {
+		if _, err := parseSelectorGroup(selector); err != nil {
+			errorsList = append(errorsList, err.Error())
+		}
+		if !slices.Contains(AgendaFields, field) {
+			errorsList = append(errorsList, fmt.Sprintf("unknown field %q", field))
+		}
+	}
+	for field, converters := range scrapTask.Converters {
+		if !slices.Contains(AgendaFields, field) {
+			errorsList = append(errorsList, fmt.Sprintf("unknown field %q", field))
+		}
+		for _, converter := range converters {
+			if !slices.Contains(converterTypes, converter.Type) {
+				errorsList = append(errorsList, fmt.Sprintf("unknown converter %q for %s", converter.Type, field))
+			}
+		}
+	}
+	for field := range scrapTask.Defaults {
+		if !slices.Contains(AgendaFields, field) {
+			errorsList = append(errorsList, fmt.Sprintf("unknown field %q", field))
+		}
+	}
+	if len(errorsList) > 0 {
+		return errors.New(strings.Join(errorsList, "; "))
+	}
+	return nil
+}
+
+func HandleScrapTask(ctx context.Context, scrapTask types.ScrapingTask) (*ScrapResult, error) {
+	if err := ValidateScrapingTask(scrapTask); err != nil {
+		return nil, err
+	}
 	// will visit url
 	// will extact data
 	scraper := CreateScraper()
-	result := map[string]string{}
+	result := &ScrapResult{}
 
 	scraper.OnElement("html", func(el *NodeWrapper) {
-		doc := goquery.NewDocumentFromNode(el.node)
-		for selector, name := range scrapTask.ScrapingMap {
-			selection := doc.Find(selector)
-			if selection.Length() > 0 {
-				result[name] = strings.TrimSpace(selection.Text())
-			} else {
-				fmt.Println("No node found with the specified selector")
-			}
+		if scrapTask.IsStructured() {
+			result = ExtractStructuredEntries(scrapTask, el)
+			return
 		}
+		result = ExtractEntries(scrapTask, el)
 	})
-	scraper.Visit(scrapTask.Url)
+	if err := scraper.VisitContext(ctx, scrapTask.Url); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ExtractEntries maps the scraped fields of each item to validated agenda entries (status pending)
+func ExtractEntries(scrapTask types.ScrapingTask, root *NodeWrapper) *ScrapResult {
+	result := &ScrapResult{Records: []Record{}, Entries: []db.AgendaEntry{}, Invalid: []InvalidItem{}}
+	baseURL, _ := url.Parse(scrapTask.Url)
+
+	items := NodeCollection{root.node}
+	if scrapTask.ItemSelector != "" {
+		items = root.Find(scrapTask.ItemSelector)
+	}
+	for _, item := range items {
+		record, fieldErrors := extractRecord(scrapTask, NewNodeWrapper(item), baseURL)
+		result.addRecord(scrapTask, record, fieldErrors)
+	}
+	return result
+}
+
+// ExtractStructuredEntries maps the schema.org events of the page, no scraping map needed
+func ExtractStructuredEntries(scrapTask types.ScrapingTask, root *NodeWrapper) *ScrapResult {
+	result := &ScrapResult{Records: []Record{}, Entries: []db.AgendaEntry{}, Invalid: []InvalidItem{}}
+	baseURL, _ := url.Parse(scrapTask.Url)
+
+	for _, event := range FindStructuredEvents(root) {
+		record, fieldErrors := EventRecord(event, baseURL)
+		for field, value := range scrapTask.Defaults {
+			if record[field] == "" {
+				record[field] = value
+			}
+		}
+		result.addRecord(scrapTask, record, fieldErrors)
+	}
 	return result
 }
+
+// addRecord validates the agenda entry built from the record
+func (result *ScrapResult) addRecord(scrapTask types.ScrapingTask, record Record, fieldErrors map[string]string) {
+	result.Records = append(result.Records, record)
+	entry, fieldErrors := BuildEntry(record, fieldErrors, scrapTask.Url)
+	if len(fieldErrors) > 0 {
+		result.Invalid = append(result.Invalid, InvalidItem{Record: record, Errors: fieldErrors})
+		return
+	}
+	result.Entries = append(result.Entries, entry)
+}
+
+// BuildEntry maps the record on a pending agenda entry, fieldErrors gets the validation errors
+func BuildEntry(record Record, fieldErrors map[string]string, defaultLink string) (db.AgendaEntry, map[string]string) {
+	entry := db.AgendaEntry{Status: db.Status_Pending, Tags: []string{}}
+	for field, value := range record {
+		if err := setAgendaField(&entry, field, value); err != nil {
+			fieldErrors[field] = err.Error()
+		}
+	}
+	if entry.Link == "" {
+		entry.Link = defaultLink
+	}
+	if issues := validators.AgendaEntrySchema.Validate(&entry); issues != nil {
+		for field, msg := range validators.FormatZogErrors(issues) {
+			fieldErrors[field] = msg
+		}
+	}
+	if err := entry.Validate(); err != nil {
+		fieldErrors["enddate"] = err.Error()
+	}
+	return entry, fieldErrors
+}
+
+func extractRecord(scrapTask types.ScrapingTask, item *NodeWrapper, baseURL *url.URL) (Record, map[string]string) {
+	record := Record{}
+	fieldErrors := map[string]string{}
+
+	for selector, field := range scrapTask.ScrapingMap {
+		nodes := item.Find(selector)
+		if len(nodes) == 0 {
+			continue
+		}
+		// tags are collected from every matched node
+		if field != "tags" {
+			nodes = nodes[:1]
+		}
+		var values []string
+		for _, node := range nodes {
+			text := strings.Join(strings.Fields(NewNodeWrapper(node).Text()), " ")
+			value, err := ConvertValue(node, text, scrapTask.Converters[field], baseURL)
+			if err != nil {
+				fieldErrors[field] = err.Error()
+				break
+			}
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+		if len(values) > 0 {
+			record[field] = strings.Join(values, ",")
+		}
+	}
+	for field, value := range scrapTask.Defaults {
+		if record[field] == "" {
+			record[field] = value
+		}
+	}
+	return record, fieldErrors
+}
+
+func setAgendaField(entry *db.AgendaEntry, field string, value string) error {
+	switch field {
+	case "title":
+		entry.Title = value
+	case "subtitle":
+		entry.Subtitle = value
+	case "link":
+		entry.Link = value
+	case "price":
+		entry.Price = value
+	case "venuename":
+		entry.VenueName = value
+	case "address":
+		entry.Address = value
+	case "place":
+		entry.Place = value
+	case "description":
+		entry.Description = value
+	case "poster":
+		entry.Poster = value
+	case "category":
+		entry.Category = value
+	case "infos":
+		entry.Infos = value
+	case "tags":
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(entry.Tags, tag) {
+				entry.Tags = append(entry.Tags, tag)
+			}
+		}
+	case "startdate", "enddate":
+		date, err := parseFieldTime(value, dateLayout, time.RFC3339, "2006-01-02T15:04")
+		if err != nil {
+			return err
+		}
+		date, _ = time.Parse(dateLayout, date.Format(dateLayout))
+		if field == "startdate" {
+			entry.StartDate = date
+		} else {
+			entry.EndDate = date
+		}
+	case "starttime", "endtime":
+		hour, err := parseFieldTime(value, timeLayout, time.RFC3339, "2006-01-02T15:04", "15h04", "15.04")
+		if err != nil {
+			return err
+		}
+		hour, _ = time.Parse(timeLayout, hour.Format(timeLayout))
+		if field == "starttime" {
+			entry.StartTime = hour
+		} else {
+			entry.EndTime = hour
+		}
+	default:
+		return fmt.Errorf("unknown field %q", field)
+	}
+	return nil
+}
+
+func parseFieldTime(value string, layouts ...string) (time.Time, error) {
+	for _, layout := range layouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date or time %q", value)
+}
@@ -2,15 +2,15 @@
 package scraper
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"regexp"
-	"slices"
 	"sort"
 	"strings"
+	"time"
 
 	"golang.org/x/net/html"
 )
@@ -22,24 +22,28 @@ type NodeWrapper struct {
 	node *html.Node
 }
 
+func NewNodeWrapper(node *html.Node) *NodeWrapper {
+	return &NodeWrapper{node}
+}
+
+// Find returns the descendants matching the selector, in document order
 func (nw *NodeWrapper) Find(selector string) NodeCollection {
 	var result NodeCollection
-	stack := []*html.Node{nw.node}
+	if nw.node == nil {
+		return result
+	}
 	nodeSelector := new(NodeSelector) // New -> pointer
-	ns, _ := nodeSelector.Parse(selector)
-
-	for len(stack) > 0 {
-		node := stack[len(stack)-1]
-		stack = stack[:len(stack)-1] // new slice [ low:hight [
-		if node.Type == html.ElementNode {
-			if ns.Match(node) {
-				result = append(result, node)
-			}
-			for currentNode := node.FirstChild; currentNode != nil; currentNode = currentNode.NextSibling {
-				stack = append(stack, currentNode)
-			}
-		}
+	ns, ok := nodeSelector.Parse(selector)
+	if !ok {
+		log.Printf("Invalid selector %s", selector)
+		return result
 	}
+
+	nw.visit(nw.node, func(current NodeWrapper) {
+		if current.node != nw.node && ns.Match(current.node) {
+			result = append(result, current.node)
+		}
+	})
 	return result
 }
 
@@ -86,12 +90,15 @@ func (nc NodeCollection) Eq(number int) *NodeWrapper {
 }
 
 /* Selector Infos */
+// NodeSelector is a compiled CSS selector group (ex: "ul > li.item, a[href^=http]:not(.ext)").
+// NodeType, ID and ClassNames describe the rightmost compound of the first selector.
 type NodeSelector struct {
 	Selector   string
 	ID         string
 	ClassNames []string
 	NodeType   string
 	weight     int
+	group      []*complexSelector
 }
 
 func (ns *NodeSelector) GetSelector() string {
@@ -99,75 +106,46 @@ func (ns *NodeSelector) GetSelector() string {
 }
 
 func (ns *NodeSelector) Parse(selector string) (*NodeSelector, bool) {
-
-	exp := regexp.MustCompile(`^(\w+)?([#.](\w|\-)+)*$`)
-	if !exp.MatchString(selector) {
+	group, err := parseSelectorGroup(selector)
+	if err != nil {
+		log.Printf("NodeSelector::Parse %v", err)
 		return ns, false
 	}
 	// save selector
 	ns.Selector = selector
+	ns.group = group
 
-	nodeRegexp := regexp.MustCompile(`^(\w|\-)+`)
-	idRegexp := regexp.MustCompile(`#(\w|\-)+`)
-	classRegexp := regexp.MustCompile(`\.(\w|\-)+`)
-
-	ns.NodeType = nodeRegexp.FindString(selector)
-	matches := idRegexp.FindStringSubmatch(selector)
-	if len(matches) > 1 {
-		ns.ID = matches[0]
+	subject := group[0].subject()
+	if subject.tag != "*" {
+		ns.NodeType = subject.tag
 	}
-	classeNames := []string{}
-	classes := classRegexp.FindAllString(selector, -1)
-
-	classeNames = append(classeNames, classes...)
-	ns.ClassNames = classeNames
-
-	if ns.ID != "" {
-		ns.weight = 1000
+	if len(subject.ids) > 0 {
+		ns.ID = "#" + subject.ids[0]
 	}
-
-	if len(ns.ClassNames) > 0 {
-		ns.weight = ns.weight + len(ns.ClassNames)*10
+	classeNames := []string{}
+	for _, className := range subject.classes {
+		classeNames = append(classeNames, "."+className)
 	}
+	ns.ClassNames = classeNames
 
-	if len(ns.NodeType) > 0 {
-		ns.weight = ns.weight + len(ns.NodeType)
+	// the most specific selector of the group gives the weight
+	ns.weight = 0
+	for _, complex := range group {
+		ns.weight = max(ns.weight, complex.specificity())
 	}
-
 	return ns, true
 }
 
 func (ns *NodeSelector) Match(node *html.Node) bool {
-	nodeType := node.Data
-	classes := []string{}
-	var ID string
-
-	// Not the same node type
-	if ns.NodeType != "" && ns.NodeType != nodeType {
-		return false
-	}
-	for _, attr := range node.Attr {
-		if attr.Key == "class" {
-			classes = append(classes, strings.Split(attr.Val, " ")...)
-		}
-		if attr.Key == "ID" {
-			ID = attr.Val
-		}
-	}
-
-	// check ID
-	if ns.ID != "" && ns.ID != ID {
+	if node == nil || node.Type != html.ElementNode {
 		return false
 	}
-
-	// if classes exists, all the provided classes should be in current node classes
-	for _, className := range ns.ClassNames {
-		if !slices.Contains(classes, strings.Replace(className, ".", "", -1)) {
-			return false
+	for _, complex := range ns.group {
+		if complex.match(node) {
+			return true
 		}
 	}
-
-	return true
+	return false
 }
 
 // Sortable: Sort BySelectorWeight
@@ -179,6 +157,8 @@ func (nsl BySelectorWeight) Swap(i, j int)      { nsl[i], nsl[j] = nsl[j], nsl[i
 func (nsl BySelectorWeight) Less(i, j int) bool { return nsl[i].weight > nsl[j].weight }
 
 /************* SCRAPER ****************/
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
 type Scraper struct {
 	path           string
 	raw_html       string
@@ -236,8 +216,13 @@ func (s *Scraper) Children(element *html.Node, selector string) NodeCollection {
 }
 
 func (scraper *Scraper) Visit(url string) error {
+	return scraper.VisitContext(context.Background(), url)
+}
+
+// VisitContext stops loading the page when ctx is cancelled
+func (scraper *Scraper) VisitContext(ctx context.Context, url string) error {
 
-	doc, err := scraper.Load(url)
+	doc, err := scraper.LoadContext(ctx, url)
 	if err != nil {
 		return err
 	}
@@ -275,8 +260,16 @@ func (s *Scraper) Text(element *html.Node) string {
 }
 
 func (s *Scraper) Load(url string) (*html.Node, error) {
-	response, err := http.Get(url)
+	return s.LoadContext(context.Background(), url)
+}
+
+func (s *Scraper) LoadContext(ctx context.Context, url string) (*html.Node, error) {
 	s.path = url
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := httpClient.Do(request)
 	if err != nil {
 		fmt.Println("Error fetching URL:", err)
 		return nil, err
@@ -287,20 +280,17 @@ func (s *Scraper) Load(url string) (*html.Node, error) {
 		buf := new(strings.Builder)
 		_, err = io.Copy(buf, response.Body) // Implement as an example
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		s.raw_html = buf.String()
 		node, err := html.Parse(strings.NewReader(s.raw_html)) // how to use iocopy
-		if err != nil {
-			log.Fatal(err) // call onError
-		}
 		if err != nil {
 			return nil, err
 		}
 		return node, nil
 	}
 
-	return nil, errors.New("can't load URL")
+	return nil, fmt.Errorf("can't load URL %s: %w", url, errors.New(response.Status))
 }
 
 func CreateScraper() *Scraper {
@@ -0,0 +1,602 @@
+package scraper
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+/* CSS selector engine used by NodeSelector */
+
+type combinator byte
+
+const (
+	combinatorDescendant combinator = ' '
+	combinatorChild      combinator = '>'
+	combinatorAdjacent   combinator = '+'
+	combinatorSibling    combinator = '~'
+)
+
+type attrSelector struct {
+	key             string
+	operator        string // "", "=", "~=", "|=", "^=", "$=", "*="
+	value           string
+	caseInsensitive bool
+}
+
+type pseudoSelector struct {
+	name string
+	// an+b for the nth-* pseudo classes
+	a, b int
+	// selector list for :not()
+	not []*complexSelector
+}
+
+// compoundSelector is a sequence of simple selectors without combinator, ex: a.link[href^=http]
+type compoundSelector struct {
+	tag     string
+	ids     []string
+	classes []string
+	attrs   []attrSelector
+	pseudos []pseudoSelector
+}
+
+// complexSelector holds compounds from left to right, combinators[i] links compounds[i] and compounds[i+1]
+type complexSelector struct {
+	compounds   []compoundSelector
+	combinators []combinator
+}
+
+func (cs *complexSelector) subject() compoundSelector {
+	return cs.compounds[len(cs.compounds)-1]
+}
+
+func (cs *complexSelector) specificity() int {
+	weight := 0
+	for _, compound := range cs.compounds {
+		weight += compound.specificity()
+	}
+	return weight
+}
+
+// same scale as the former weight: id=1000, class/attribute/pseudo=10, type=1
+func (c compoundSelector) specificity() int {
+	weight := len(c.ids)*1000 + (len(c.classes)+len(c.attrs))*10
+	if c.tag != "" && c.tag != "*" {
+		weight++
+	}
+	for _, pseudo := range c.pseudos {
+		if pseudo.name == "not" {
+			best := 0
+			for _, sel := range pseudo.not {
+				best = max(best, sel.specificity())
+			}
+			weight += best
+			continue
+		}
+		weight += 10
+	}
+	return weight
+}
+
+/** PARSER **/
+
+type selectorParser struct {
+	input string
+	pos   int
+}
+
+func parseSelectorGroup(selector string) ([]*complexSelector, error) {
+	p := &selectorParser{input: selector}
+	group, err := p.parseSelectorList()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos])
+	}
+	return group, nil
+}
+
+func (p *selectorParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("invalid selector %q at %d: %s", p.input, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *selectorParser) peek() byte {
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *selectorParser) skipSpaces() bool {
+	start := p.pos
+	for p.pos < len(p.input) && isSpace(p.input[p.pos]) {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+func (p *selectorParser) parseSelectorList() ([]*complexSelector, error) {
+	var group []*complexSelector
+	for {
+		p.skipSpaces()
+		sel, err := p.parseComplex()
+		if err != nil {
+			return nil, err
+		}
+		group = append(group, sel)
+		p.skipSpaces()
+		if p.peek() != ',' {
+			return group, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *selectorParser) parseComplex() (*complexSelector, error) {
+	sel := &complexSelector{}
+	compound, err := p.parseCompound()
+	if err != nil {
+		return nil, err
+	}
+	sel.compounds = append(sel.compounds, compound)
+
+	for {
+		hasSpace := p.skipSpaces()
+		var comb combinator
+		switch p.peek() {
+		case '>', '+', '~':
+			comb = combinator(p.peek())
+			p.pos++
+			p.skipSpaces()
+		case ',', ')', 0:
+			return sel, nil
+		default:
+			if !hasSpace {
+				return nil, p.errorf("unexpected %q", p.peek())
+			}
+			comb = combinatorDescendant
+		}
+		compound, err := p.parseCompound()
+		if err != nil {
+			return nil, err
+		}
+		sel.combinators = append(sel.combinators, comb)
+		sel.compounds = append(sel.compounds, compound)
+	}
+}
+
+func (p *selectorParser) parseCompound() (compoundSelector, error) {
+	var compound compoundSelector
+	start := p.pos
+
+	if p.peek() == '*' {
+		compound.tag = "*"
+		p.pos++
+	} else if isIdentStart(p.peek()) {
+		compound.tag = strings.ToLower(p.parseIdent())
+	}
+
+	for {
+		switch p.peek() {
+		case '#':
+			p.pos++
+			id := p.parseIdent()
+			if id == "" {
+				return compound, p.errorf("expected id")
+			}
+			compound.ids = append(compound.ids, id)
+		case '.':
+			p.pos++
+			class := p.parseIdent()
+			if class == "" {
+				return compound, p.errorf("expected class name")
+			}
+			compound.classes = append(compound.classes, class)
+		case '[':
+			attr, err := p.parseAttribute()
+			if err != nil {
+				return compound, err
+			}
+			compound.attrs = append(compound.attrs, attr)
+		case ':':
+			pseudo, err := p.parsePseudo()
+			if err != nil {
+				return compound, err
+			}
+			compound.pseudos = append(compound.pseudos, pseudo)
+		default:
+			if p.pos == start {
+				return compound, p.errorf("expected selector")
+			}
+			return compound, nil
+		}
+	}
+}
+
+func (p *selectorParser) parseAttribute() (attrSelector, error) {
+	var attr attrSelector
+	p.pos++ // [
+	p.skipSpaces()
+	attr.key = strings.ToLower(p.parseIdent())
+	if attr.key == "" {
+		return attr, p.errorf("expected attribute name")
+	}
+	p.skipSpaces()
+	if p.peek() == ']' {
+		p.pos++
+		return attr, nil
+	}
+
+	if p.peek() == '=' {
+		attr.operator = "="
+		p.pos++
+	} else if strings.ContainsRune("~|^$*", rune(p.peek())) && p.pos+1 < len(p.input) && p.input[p.pos+1] == '=' {
+		attr.operator = p.input[p.pos : p.pos+2]
+		p.pos += 2
+	} else {
+		return attr, p.errorf("expected attribute operator")
+	}
+	p.skipSpaces()
+
+	switch p.peek() {
+	case '"', '\'':
+		value, err := p.parseString()
+		if err != nil {
+			return attr, err
+		}
+		attr.value = value
+	default:
+		attr.value = p.parseIdent()
+		if attr.value == "" {
+			return attr, p.errorf("expected attribute value")
+		}
+	}
+	p.skipSpaces()
+	if p.peek() == 'i' || p.peek() == 'I' {
+		attr.caseInsensitive = true
+		p.pos++
+		p.skipSpaces()
+	}
+	if p.peek() != ']' {
+		return attr, p.errorf("expected ]")
+	}
+	p.pos++
+	return attr, nil
+}
+
+func (p *selectorParser) parsePseudo() (pseudoSelector, error) {
+	var pseudo pseudoSelector
+	p.pos++ // :
+	pseudo.name = strings.ToLower(p.parseIdent())
+
+	switch pseudo.name {
+	case "first-child", "last-child", "only-child",
+		"first-of-type", "last-of-type", "only-of-type",
+		"empty", "root":
+		return pseudo, nil
+	case "nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type":
+		if p.peek() != '(' {
+			return pseudo, p.errorf("expected ( after :%s", pseudo.name)
+		}
+		end := strings.IndexByte(p.input[p.pos:], ')')
+		if end < 0 {
+			return pseudo, p.errorf("expected )")
+		}
+		a, b, err := parseNth(p.input[p.pos+1 : p.pos+end])
+		if err != nil {
+			return pseudo, p.errorf("%v", err)
+		}
+		pseudo.a, pseudo.b = a, b
+		p.pos += end + 1
+		return pseudo, nil
+	case "not":
+		if p.peek() != '(' {
+			return pseudo, p.errorf("expected ( after :not")
+		}
+		p.pos++
+		list, err := p.parseSelectorList()
+		if err != nil {
+			return pseudo, err
+		}
+		if p.peek() != ')' {
+			return pseudo, p.errorf("expected )")
+		}
+		p.pos++
+		pseudo.not = list
+		return pseudo, nil
+	}
+	return pseudo, p.errorf("unsupported pseudo-class :%s", pseudo.name)
+}
+
+func (p *selectorParser) parseIdent() string {
+	var ident strings.Builder
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.input):
+			_, size := utf8.DecodeRuneInString(p.input[p.pos+1:])
+			ident.WriteString(p.input[p.pos+1 : p.pos+1+size])
+			p.pos += 1 + size
+		case isIdentChar(c):
+			ident.WriteByte(c)
+			p.pos++
+		default:
+			return ident.String()
+		}
+	}
+	return ident.String()
+}
+
+func (p *selectorParser) parseString() (string, error) {
+	quote := p.peek()
+	p.pos++
+	var value strings.Builder
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return value.String(), nil
+		case c == '\\' && p.pos+1 < len(p.input):
+			value.WriteByte(p.input[p.pos+1])
+			p.pos += 2
+		default:
+			value.WriteByte(c)
+			p.pos++
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// parseNth reads an+b expressions: odd, even, 3, 2n+1, -n+3...
+func parseNth(expr string) (int, int, error) {
+	s := strings.ToLower(strings.Join(strings.Fields(expr), ""))
+	switch s {
+	case "odd":
+		return 2, 1, nil
+	case "even":
+		return 2, 0, nil
+	case "":
+		return 0, 0, fmt.Errorf("empty nth expression")
+	}
+	index := strings.IndexByte(s, 'n')
+	if index < 0 {
+		b, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid nth expression %q", expr)
+		}
+		return 0, b, nil
+	}
+	var a, b int
+	switch coef := s[:index]; coef {
+	case "", "+":
+		a = 1
+	case "-":
+		a = -1
+	default:
+		value, err := strconv.Atoi(coef)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid nth expression %q", expr)
+		}
+		a = value
+	}
+	if rest := s[index+1:]; rest != "" {
+		value, err := strconv.Atoi(rest)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid nth expression %q", expr)
+		}
+		b = value
+	}
+	return a, b, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '-' || c == '\\' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
+
+/** MATCHER **/
+
+func (cs *complexSelector) match(node *html.Node) bool {
+	return cs.matchAt(node, len(cs.compounds)-1)
+}
+
+// matchAt checks compounds[index] against node then walks the combinators right to left
+func (cs *complexSelector) matchAt(node *html.Node, index int) bool {
+	if !cs.compounds[index].match(node) {
+		return false
+	}
+	if index == 0 {
+		return true
+	}
+	switch cs.combinators[index-1] {
+	case combinatorChild:
+		parent := node.Parent
+		return parent != nil && parent.Type == html.ElementNode && cs.matchAt(parent, index-1)
+	case combinatorDescendant:
+		for parent := node.Parent; parent != nil && parent.Type == html.ElementNode; parent = parent.Parent {
+			if cs.matchAt(parent, index-1) {
+				return true
+			}
+		}
+	case combinatorAdjacent:
+		sibling := previousElement(node)
+		return sibling != nil && cs.matchAt(sibling, index-1)
+	case combinatorSibling:
+		for sibling := previousElement(node); sibling != nil; sibling = previousElement(sibling) {
+			if cs.matchAt(sibling, index-1) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c compoundSelector) match(node *html.Node) bool {
+	if node.Type != html.ElementNode {
+		return false
+	}
+	if c.tag != "" && c.tag != "*" && c.tag != node.Data {
+		return false
+	}
+	for _, id := range c.ids {
+		if value, ok := getAttr(node, "id"); !ok || value != id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		value, _ := getAttr(node, "class")
+		classes := strings.Fields(value)
+		for _, className := range c.classes {
+			if !slices.Contains(classes, className) {
+				return false
+			}
+		}
+	}
+	for _, attr := range c.attrs {
+		if !attr.match(node) {
+			return false
+		}
+	}
+	for _, pseudo := range c.pseudos {
+		if !pseudo.match(node) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a attrSelector) match(node *html.Node) bool {
+	value, ok := getAttr(node, a.key)
+	if !ok {
+		return false
+	}
+	expected := a.value
+	if a.caseInsensitive {
+		value = strings.ToLower(value)
+		expected = strings.ToLower(expected)
+	}
+	switch a.operator {
+	case "":
+		return true
+	case "=":
+		return value == expected
+	case "~=":
+		return slices.Contains(strings.Fields(value), expected)
+	case "|=":
+		return value == expected || strings.HasPrefix(value, expected+"-")
+	case "^=":
+		return expected != "" && strings.HasPrefix(value, expected)
+	case "$=":
+		return expected != "" && strings.HasSuffix(value, expected)
+	case "*=":
+		return expected != "" && strings.Contains(value, expected)
+	}
+	return false
+}
+
+func (ps pseudoSelector) match(node *html.Node) bool {
+	switch ps.name {
+	case "first-child":
+		return previousElement(node) == nil
+	case "last-child":
+		return nextElement(node) == nil
+	case "only-child":
+		return previousElement(node) == nil && nextElement(node) == nil
+	case "first-of-type":
+		return siblingPosition(node, true, false) == 1
+	case "last-of-type":
+		return siblingPosition(node, true, true) == 1
+	case "only-of-type":
+		return siblingPosition(node, true, false) == 1 && siblingPosition(node, true, true) == 1
+	case "nth-child":
+		return nthMatch(ps.a, ps.b, siblingPosition(node, false, false))
+	case "nth-last-child":
+		return nthMatch(ps.a, ps.b, siblingPosition(node, false, true))
+	case "nth-of-type":
+		return nthMatch(ps.a, ps.b, siblingPosition(node, true, false))
+	case "nth-last-of-type":
+		return nthMatch(ps.a, ps.b, siblingPosition(node, true, true))
+	case "empty":
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.ElementNode || (child.Type == html.TextNode && child.Data != "") {
+				return false
+			}
+		}
+		return true
+	case "root":
+		return node.Parent != nil && node.Parent.Type == html.DocumentNode
+	case "not":
+		for _, sel := range ps.not {
+			if sel.match(node) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func nthMatch(a, b, position int) bool {
+	if a == 0 {
+		return position == b
+	}
+	n := position - b
+	return n%a == 0 && n/a >= 0
+}
+
+// siblingPosition is the 1-based index of node among its element siblings
+func siblingPosition(node *html.Node, sameType bool, fromEnd bool) int {
+	position := 1
+	next := previousElement
+	if fromEnd {
+		next = nextElement
+	}
+	for sibling := next(node); sibling != nil; sibling = next(sibling) {
+		if !sameType || sibling.Data == node.Data {
+			position++
+		}
+	}
+	return position
+}
+
+func previousElement(node *html.Node) *html.Node {
+	for sibling := node.PrevSibling; sibling != nil; sibling = sibling.PrevSibling {
+		if sibling.Type == html.ElementNode {
+			return sibling
+		}
+	}
+	return nil
+}
+
+func nextElement(node *html.Node) *html.Node {
+	for sibling := node.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+		if sibling.Type == html.ElementNode {
+			return sibling
+		}
+	}
+	return nil
+}
+
+func getAttr(node *html.Node, key string) (string, bool) {
+	for _, attr := range node.Attr {
+		if attr.Namespace == "" && attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
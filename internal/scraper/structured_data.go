@@ -0,0 +1,312 @@
+package scraper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// schema.org Event sub types which don't end with "Event"
+var eventTypes = []string{"Festival", "CourseInstance"}
+
+// StructuredItem is a schema.org object, the same shape for JSON-LD, microdata and RDFa:
+// property -> string | StructuredItem | []any
+type StructuredItem = map[string]any
+
+// FindStructuredEvents returns the schema.org Event objects embedded in the page
+// as JSON-LD, microdata (itemscope/itemprop) or RDFa-lite (typeof/property)
+func FindStructuredEvents(root *NodeWrapper) []StructuredItem {
+	var events []StructuredItem
+	for _, node := range root.Find(`script[type*="ld+json" i]`) {
+		var data any
+		content := strings.TrimSpace(NewNodeWrapper(node).Text())
+		if err := json.Unmarshal([]byte(content), &data); err != nil {
+			continue
+		}
+		events = append(events, collectEvents(data)...)
+	}
+	for _, node := range root.Find("[itemscope][itemtype]") {
+		if isEventType(attrValue(node, "itemtype")) && !hasEventAncestor(node, "itemtype") {
+			events = append(events, readItem(node, "itemprop", "itemscope"))
+		}
+	}
+	for _, node := range root.Find("[typeof]") {
+		if isEventType(attrValue(node, "typeof")) && !hasEventAncestor(node, "typeof") {
+			events = append(events, readItem(node, "property", "typeof"))
+		}
+	}
+	return events
+}
+
+// collectEvents walks JSON-LD (object, array or @graph) looking for events
+func collectEvents(data any) []StructuredItem {
+	var events []StructuredItem
+	switch value := data.(type) {
+	case []any:
+		for _, item := range value {
+			events = append(events, collectEvents(item)...)
+		}
+	case map[string]any:
+		if isEventType(value["@type"]) {
+			return append(events, value)
+		}
+		if graph, ok := value["@graph"]; ok {
+			events = append(events, collectEvents(graph)...)
+		}
+	}
+	return events
+}
+
+// isEventType accepts "Event", "MusicEvent", "http://schema.org/Event" or a list of types
+func isEventType(value any) bool {
+	switch itemType := value.(type) {
+	case []any:
+		return slices.ContainsFunc(itemType, isEventType)
+	case string:
+		for _, name := range strings.Fields(itemType) {
+			name = name[strings.LastIndexAny(name, "/:#")+1:]
+			if strings.HasSuffix(name, "Event") || slices.Contains(eventTypes, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func attrValue(node *html.Node, key string) string {
+	value, _ := getAttr(node, key)
+	return value
+}
+
+// sub events (a festival program) are read with their parent event
+func hasEventAncestor(node *html.Node, typeAttr string) bool {
+	for parent := node.Parent; parent != nil; parent = parent.Parent {
+		if parent.Type == html.ElementNode && isEventType(attrValue(parent, typeAttr)) {
+			return true
+		}
+	}
+	return false
+}
+
+// readItem collects the properties of a microdata / RDFa item. A property holding
+// a nested item (itemscope, typeof) is read as an item and its content is skipped.
+func readItem(item *html.Node, propAttr string, scopeAttr string) StructuredItem {
+	result := StructuredItem{}
+	if itemType := attrValue(item, "itemtype") + attrValue(item, "typeof"); itemType != "" {
+		result["@type"] = itemType
+	}
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type != html.ElementNode {
+				continue
+			}
+			_, isScope := getAttr(child, scopeAttr)
+			if props, ok := getAttr(child, propAttr); ok {
+				var value any
+				if isScope {
+					value = readItem(child, propAttr, scopeAttr)
+				} else {
+					value = propertyValue(child)
+				}
+				for _, prop := range strings.Fields(props) {
+					// RDFa may prefix the property (schema:name)
+					prop = prop[strings.LastIndexAny(prop, "/:")+1:]
+					addProperty(result, prop, value)
+				}
+			}
+			if !isScope {
+				walk(child)
+			}
+		}
+	}
+	walk(item)
+	return result
+}
+
+func addProperty(item StructuredItem, prop string, value any) {
+	existing, ok := item[prop]
+	if !ok {
+		item[prop] = value
+		return
+	}
+	if list, isList := existing.([]any); isList {
+		item[prop] = append(list, value)
+		return
+	}
+	item[prop] = []any{existing, value}
+}
+
+// propertyValue follows the microdata rules for the value of an element
+func propertyValue(node *html.Node) string {
+	if content, ok := getAttr(node, "content"); ok {
+		return strings.TrimSpace(content)
+	}
+	var key string
+	switch node.Data {
+	case "a", "area", "link":
+		key = "href"
+	case "img", "audio", "embed", "iframe", "source", "track", "video":
+		key = "src"
+	case "object":
+		key = "data"
+	case "time":
+		key = "datetime"
+	case "data", "meter":
+		key = "value"
+	}
+	if value, ok := getAttr(node, key); ok && key != "" {
+		return strings.TrimSpace(value)
+	}
+	return strings.Join(strings.Fields(NewNodeWrapper(node).Text()), " ")
+}
+
+// EventRecord maps a schema.org Event to the agenda entry fields
+func EventRecord(event StructuredItem, baseURL *url.URL) (Record, map[string]string) {
+	record := Record{}
+	fieldErrors := map[string]string{}
+
+	record["title"] = itemText(event["name"])
+	record["description"] = itemText(event["description"])
+	if link := itemText(event["url"]); link != "" {
+		record["link"] = resolveItemURL(link, baseURL)
+	}
+	if image := itemURL(event["image"]); image != "" {
+		record["poster"] = resolveItemURL(image, baseURL)
+	}
+	for _, bound := range []string{"start", "end"} {
+		value := itemText(event[bound+"Date"])
+		if value == "" {
+			continue
+		}
+		date, hasTime, err := parseISODate(value)
+		if err != nil {
+			fieldErrors[bound+"date"] = err.Error()
+			continue
+		}
+		record[bound+"date"] = date.Format(dateLayout)
+		if hasTime {
+			record[bound+"time"] = date.Format(timeLayout)
+		}
+	}
+
+	location := firstItem(event["location"])
+	switch place := location.(type) {
+	case string:
+		record["venuename"] = place
+	case StructuredItem:
+		record["venuename"] = itemText(place["name"])
+		if isType(place, "VirtualLocation") {
+			record["place"] = "online"
+			record["address"] = itemText(place["url"])
+			break
+		}
+		switch address := firstItem(place["address"]).(type) {
+		case string:
+			record["address"] = address
+		case StructuredItem:
+			record["address"] = strings.TrimSpace(itemText(address["streetAddress"]) + " " + itemText(address["postalCode"]))
+			record["place"] = itemText(address["addressLocality"])
+		}
+	}
+
+	if offer, ok := firstItem(event["offers"]).(StructuredItem); ok {
+		price := itemText(offer["price"])
+		if price == "" {
+			price = itemText(offer["lowPrice"])
+		}
+		if currency := itemText(offer["priceCurrency"]); price != "" && currency != "" {
+			price = currency + " " + price
+		}
+		record["price"] = price
+	}
+	if isFree, ok := event["isAccessibleForFree"].(bool); ok && isFree && record["price"] == "" {
+		record["price"] = "0"
+	}
+
+	switch keywords := event["keywords"].(type) {
+	case string:
+		record["tags"] = keywords
+	case []any:
+		var tags []string
+		for _, keyword := range keywords {
+			tags = append(tags, itemText(keyword))
+		}
+		record["tags"] = strings.Join(tags, ",")
+	}
+
+	for field, value := range record {
+		if value == "" {
+			delete(record, field)
+		}
+	}
+	return record, fieldErrors
+}
+
+func isType(item StructuredItem, name string) bool {
+	itemType := fmt.Sprint(item["@type"])
+	return strings.Contains(itemType, name)
+}
+
+func firstItem(value any) any {
+	if list, ok := value.([]any); ok {
+		if len(list) == 0 {
+			return nil
+		}
+		return list[0]
+	}
+	return value
+}
+
+// itemText returns the text of a property, the name for an object
+func itemText(value any) string {
+	switch text := firstItem(value).(type) {
+	case string:
+		return strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+	case float64:
+		return strings.TrimSuffix(fmt.Sprintf("%.2f", text), ".00")
+	case StructuredItem:
+		if name, ok := text["name"]; ok {
+			return itemText(name)
+		}
+		return itemText(text["@value"])
+	}
+	return ""
+}
+
+// itemURL reads an url or an ImageObject
+func itemURL(value any) string {
+	if image, ok := firstItem(value).(StructuredItem); ok {
+		if contentURL := itemText(image["url"]); contentURL != "" {
+			return contentURL
+		}
+		return itemText(image["contentUrl"])
+	}
+	return itemText(value)
+}
+
+func resolveItemURL(value string, baseURL *url.URL) string {
+	resolved, err := resolveURL(value, baseURL)
+	if err != nil {
+		return value
+	}
+	return resolved
+}
+
+// parseISODate reads the ISO 8601 dates used by schema.org, the clock time is kept as published
+func parseISODate(value string) (time.Time, bool, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04Z07:00", "2006-01-02T15:04"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, true, nil
+		}
+	}
+	if parsed, err := time.Parse(dateLayout, value); err == nil {
+		return parsed, false, nil
+	}
+	return time.Time{}, false, fmt.Errorf("invalid ISO 8601 date %q", value)
+}
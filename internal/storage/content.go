@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ContentGracePeriod a content object stored or stored again is not swept before, its entry may not be saved yet
+const ContentGracePeriod = time.Hour
+
+var contentKeyPattern = regexp.MustCompile(`^[0-9a-f]{64}\.[0-9a-z]+$`)
+
+// extensions of the detected image types
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+	"image/tiff": ".tiff",
+}
+
+// ContentKey the key of the data: its SHA-256 and its extension, "9f86d081...0a08.jpg"
+func ContentKey(data []byte, extension string) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]) + strings.ToLower(extension)
+}
+
+// IsContentKey the object never changes, its key is its hash
+func IsContentKey(key string) bool {
+	return contentKeyPattern.MatchString(key)
+}
+
+// ContentHash the hash of a content key, used as ETag
+func ContentHash(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key))
+}
+
+// FormatExtension the extension of an image format of image.DecodeConfig, "" when it is not an image
+func FormatExtension(format string) string {
+	return imageExtensions["image/"+format]
+}
+
+// IsImage the key is served as an image, never as a document the browser would run (html, svg)
+func IsImage(key string) bool {
+	_, ok := imageExtensions[ContentType(key)]
+	return ok
+}
+
+// DetectExtension the extension of the sniffed content type, the extension of the name otherwise
+func DetectExtension(data []byte, name string) string {
+	contentType := http.DetectContentType(data)
+	if extension, ok := imageExtensions[contentType]; ok {
+		return extension
+	}
+	// DetectContentType doesn't know TIFF
+	if len(data) > 4 && (string(data[:4]) == "II*\x00" || string(data[:4]) == "MM\x00*") {
+		return ".tiff"
+	}
+	return strings.ToLower(path.Ext(name))
+}
+
+// PutContent stores the data under its content key, identical files are stored once. created is false
+// when the file was already stored. A file stored a while ago is stored again: its ModTime starts
+// a new ContentGracePeriod, an orphan uploaded again isn't swept before its entry is saved.
+func PutContent(ctx context.Context, store Storage, data []byte, extension string) (key string, created bool, err error) {
+	key = ContentKey(data, extension)
+	object, err := store.Stat(ctx, key)
+	created = errors.Is(err, ErrNotFound)
+	if err != nil && !created {
+		return "", false, err
+	}
+	if !created && time.Since(object.ModTime) < ContentGracePeriod/2 {
+		return key, false, nil
+	}
+	if err := store.Put(ctx, key, bytes.NewReader(data), ContentType(key)); err != nil {
+		return "", false, err
+	}
+	return key, created, nil
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local stores the files in a directory, served publicly by the API under baseURL
+type Local struct {
+	root    string
+	baseURL string
+}
+
+func NewLocal(root string, baseURL string) *Local {
+	return &Local{root: root, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+func (local *Local) path(key string) (string, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(local.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes in a temporary file first, a reader never sees a partial file
+func (local *Local) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	dest, err := local.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(dest), ".upload*")
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = io.Copy(tmpFile, body)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	if err := os.Rename(tmpFile.Name(), dest); err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	return nil
+}
+
+func (local *Local) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	source, err := local.path(key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	file, err := os.Open(source)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, Object{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, Object{}, fmt.Errorf("get %s: %w", key, err)
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, Object{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return file, Object{Key: key, Size: info.Size(), ContentType: ContentType(key), ModTime: info.ModTime()}, nil
+}
+
+func (local *Local) Stat(ctx context.Context, key string) (Object, error) {
+	source, err := local.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(source)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return Object{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return Object{}, fmt.Errorf("stat %s: %w", key, err)
+	}
+	return Object{Key: key, Size: info.Size(), ContentType: ContentType(key), ModTime: info.ModTime()}, nil
+}
+
+func (local *Local) Delete(ctx context.Context, key string) error {
+	source, err := local.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(source); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// List the files under the prefix, the hidden files are skipped
+func (local *Local) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects := []Object{}
+	err := filepath.WalkDir(local.root, func(source string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(local.root, source)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relative)
+		if entry.IsDir() {
+			// only the directories on the way to the prefix or under it
+			if key != "." && !strings.HasPrefix(prefix, key+"/") && !strings.HasPrefix(key+"/", prefix) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), ContentType: ContentType(key), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", prefix, err)
+	}
+	return objects, nil
+}
+
+// SignedURL the local files are public, the URL doesn't expire
+func (local *Local) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return local.baseURL + "/" + (&url.URL{Path: key}).EscapedPath(), nil
+}
@@ -0,0 +1,338 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	amzDateLayout     = "20060102T150405Z"
+)
+
+// S3Config an S3 compatible bucket (AWS, MinIO, Infomaniak...)
+type S3Config struct {
+	// Endpoint https://s3.example.com, the bucket is in the path: https://s3.example.com/bucket/key
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+}
+
+// S3 stores the files in a bucket, the requests are signed with AWS Signature Version 4
+type S3 struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+func NewS3(config S3Config) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(config.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", config.Endpoint)
+	}
+	if config.Bucket == "" || config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("the S3 bucket and credentials are required")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	return &S3{config: config, endpoint: endpoint, client: &http.Client{Timeout: time.Minute}, now: time.Now}, nil
+}
+
+func (store *S3) objectURL(key string) *url.URL {
+	objectURL := *store.endpoint
+	objectURL.Path = strings.TrimSuffix(objectURL.Path, "/") + "/" + store.config.Bucket
+	if key != "" {
+		objectURL.Path += "/" + key
+	}
+	objectURL.RawPath = ""
+	return &objectURL
+}
+
+func (store *S3) do(ctx context.Context, method string, objectURL *url.URL, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, objectURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := sha256.Sum256(body)
+	store.sign(req, hex.EncodeToString(payloadHash[:]))
+	return store.client.Do(req)
+}
+
+func (store *S3) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	key, err := CleanKey(key)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	if contentType == "" {
+		contentType = ContentType(key)
+	}
+	resp, err := store.do(ctx, http.MethodPut, store.objectURL(key), data, http.Header{"Content-Type": {contentType}})
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("put %s: %w", key, s3Error(resp))
+	}
+	return nil
+}
+
+func (store *S3) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return nil, Object{}, err
+	}
+	resp, err := store.do(ctx, http.MethodGet, store.objectURL(key), nil, nil)
+	if err != nil {
+		return nil, Object{}, fmt.Errorf("get %s: %w", key, err)
+	}
+	object, err := responseObject(key, resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, Object{}, err
+	}
+	return resp.Body, object, nil
+}
+
+func (store *S3) Stat(ctx context.Context, key string) (Object, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return Object{}, err
+	}
+	resp, err := store.do(ctx, http.MethodHead, store.objectURL(key), nil, nil)
+	if err != nil {
+		return Object{}, fmt.Errorf("stat %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	return responseObject(key, resp)
+}
+
+func responseObject(key string, resp *http.Response) (Object, error) {
+	if resp.StatusCode == http.StatusNotFound {
+		return Object{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Object{}, fmt.Errorf("%s: %w", key, s3Error(resp))
+	}
+	object := Object{Key: key, Size: resp.ContentLength, ContentType: resp.Header.Get("Content-Type")}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		object.ModTime = modTime
+	}
+	return object, nil
+}
+
+func (store *S3) Delete(ctx context.Context, key string) error {
+	key, err := CleanKey(key)
+	if err != nil {
+		return err
+	}
+	resp, err := store.do(ctx, http.MethodDelete, store.objectURL(key), nil, nil)
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("delete %s: %w", key, s3Error(resp))
+	}
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2
+func (store *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects := []Object{}
+	token := ""
+	for {
+		listURL := store.objectURL("")
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		listURL.RawQuery = query.Encode()
+		resp, err := store.do(ctx, http.MethodGet, listURL, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", prefix, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := s3Error(resp)
+			resp.Body.Close()
+			return nil, fmt.Errorf("list %s: %w", prefix, err)
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", prefix, err)
+		}
+		for _, content := range result.Contents {
+			objects = append(objects, Object{Key: content.Key, Size: content.Size, ContentType: ContentType(content.Key), ModTime: content.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// SignedURL a presigned GET, S3 accepts at most 7 days
+func (store *S3) SignedURL(ctx context.Context, key string, expires time.Duration) (string, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	expires = min(max(expires, time.Second), 7*24*time.Hour)
+	now := store.now().UTC()
+	objectURL := store.objectURL(key)
+	query := url.Values{
+		"X-Amz-Algorithm":     {s3Algorithm},
+		"X-Amz-Credential":    {store.config.AccessKey + "/" + store.scope(now)},
+		"X-Amz-Date":          {now.Format(amzDateLayout)},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	objectURL.RawQuery = canonicalQuery(query)
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		canonicalPath(objectURL.Path),
+		objectURL.RawQuery,
+		"host:" + objectURL.Host + "\n",
+		"host",
+		s3UnsignedPayload,
+	}, "\n")
+	objectURL.RawQuery += "&X-Amz-Signature=" + store.signature(now, canonical)
+	return objectURL.String(), nil
+}
+
+// sign adds the Authorization header of the request
+func (store *S3) sign(req *http.Request, payloadHash string) {
+	now := store.now().UTC()
+	req.Header.Set("X-Amz-Date", now.Format(amzDateLayout))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical := strings.Join([]string{
+		req.Method,
+		canonicalPath(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, store.config.AccessKey, store.scope(now), signedHeaders, store.signature(now, canonical)))
+}
+
+func (store *S3) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + store.config.Region + "/s3/aws4_request"
+}
+
+func (store *S3) signature(now time.Time, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{s3Algorithm, now.Format(amzDateLayout), store.scope(now), hex.EncodeToString(hash[:])}, "\n")
+	key := hmacSHA256([]byte("AWS4"+store.config.SecretKey), now.Format("20060102"))
+	for _, part := range []string{store.config.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscape encodes everything but the unreserved characters of RFC 3986
+func awsEscape(value string, keepSlash bool) string {
+	var escaped strings.Builder
+	for _, char := range []byte(value) {
+		switch {
+		case 'A' <= char && char <= 'Z', 'a' <= char && char <= 'z', '0' <= char && char <= '9',
+			char == '-', char == '_', char == '.', char == '~', keepSlash && char == '/':
+			escaped.WriteByte(char)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", char)
+		}
+	}
+	return escaped.String()
+}
+
+func canonicalPath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return awsEscape(path, true)
+}
+
+func canonicalQuery(query url.Values) string {
+	pairs := []string{}
+	for name, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(name, false)+"="+awsEscape(value, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func s3Error(resp *http.Response) error {
+	var s3Error struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if xml.Unmarshal(body, &s3Error) == nil && s3Error.Code != "" {
+		return fmt.Errorf("S3 %d %s: %s", resp.StatusCode, s3Error.Code, s3Error.Message)
+	}
+	return fmt.Errorf("S3 %d", resp.StatusCode)
+}
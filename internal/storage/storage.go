@@ -0,0 +1,84 @@
+// Package storage keeps the uploads (posters, logos and the uploads waiting for publication) out of the
+// local disk assumptions: the API reads and writes them by key, on disk or in an S3 compatible bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// TmpPrefix the uploads which are not published yet
+const TmpPrefix = "tmp/"
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Object the metadata of a stored file
+type Object struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Storage stores the files by key: "affiche-card.jpg", "tmp/poster123.jpg"
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	// Get the caller closes the body
+	Get(ctx context.Context, key string) (io.ReadCloser, Object, error)
+	Stat(ctx context.Context, key string) (Object, error)
+	// Delete a missing object is not an error
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// SignedURL a URL to read the object without the API, valid for the duration
+	SignedURL(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// CleanKey rejects the keys leaving the storage: "../", "/etc/passwd"
+func CleanKey(key string) (string, error) {
+	cleaned := path.Clean(strings.ReplaceAll(key, "\\", "/"))
+	if key == "" || cleaned == "." || strings.HasPrefix(cleaned, "/") || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	return cleaned, nil
+}
+
+// ContentType from the extension of the key
+func ContentType(key string) string {
+	if contentType := mime.TypeByExtension(strings.ToLower(path.Ext(key))); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// FromEnv STORAGE_BACKEND=local (default) keeps the uploads in UPLOADS_DIR (./uploads),
+// STORAGE_BACKEND=s3 in the bucket S3_BUCKET of S3_ENDPOINT, with S3_REGION, S3_ACCESS_KEY and S3_SECRET_KEY
+func FromEnv() (Storage, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		dir := os.Getenv("UPLOADS_DIR")
+		if dir == "" {
+			dir = "./uploads"
+		}
+		return NewLocal(dir, "/images"), nil
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q, local or s3", backend)
+	}
+}
@@ -1,9 +1,45 @@
 package types
 
+// selector -> agenda entry field (title, startdate, venuename...)
 type ScrapingMap map[string]string
 
+// Converter transforms the value extracted for a field, converters are applied in order
+//
+//	{"type": "attr", "name": "datetime"}
+//	{"type": "date", "layouts": ["2 January 2006"], "locale": "fr"}
+type Converter struct {
+	Type        string   `json:"type"` // trim, attr, regex, replace, url, date, time, split
+	Name        string   `json:"name,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Replacement string   `json:"replacement,omitempty"`
+	Layouts     []string `json:"layouts,omitempty"`
+	Locale      string   `json:"locale,omitempty"`
+	Separator   string   `json:"separator,omitempty"`
+}
+
+const (
+	ScrapingMode_Selectors  = "selectors"
+	ScrapingMode_Structured = "structured"
+)
+
 type ScrapingTask struct {
 	Url         string      `json:"url"`
 	Name        string      `json:"name"`
 	ScrapingMap ScrapingMap `json:"scraping_map"`
+	// selectors or structured (schema.org events), guessed from the scraping map when empty
+	Mode string `json:"mode,omitempty"`
+	// optional, one agenda entry per matched node. Selectors of the ScrapingMap are then relative to it
+	ItemSelector string `json:"item_selector,omitempty"`
+	// field -> converters
+	Converters map[string][]Converter `json:"converters,omitempty"`
+	// field -> value used when nothing is scraped for the field
+	Defaults map[string]string `json:"defaults,omitempty"`
+}
+
+// IsStructured is true when the events are read from the schema.org data of the page
+func (task ScrapingTask) IsStructured() bool {
+	if task.Mode == "" {
+		return len(task.ScrapingMap) == 0
+	}
+	return task.Mode == ScrapingMode_Structured
 }
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// FieldChange is the old and new value of a changed field
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// DiffStructs compares the exported fields of two values of the same struct type,
+// the changes are keyed by the lowercase field name, the ignored fields are lowercase names too
+func DiffStructs(old, new any, ignored ...string) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	oldValue := reflect.Indirect(reflect.ValueOf(old))
+	newValue := reflect.Indirect(reflect.ValueOf(new))
+	if oldValue.Kind() != reflect.Struct || oldValue.Type() != newValue.Type() {
+		return changes
+	}
+	structType := oldValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		fieldName := strings.ToLower(field.Name)
+		if !field.IsExported() || slices.Contains(ignored, fieldName) {
+			continue
+		}
+		oldField := oldValue.Field(i).Interface()
+		newField := newValue.Field(i).Interface()
+		if !reflect.DeepEqual(oldField, newField) {
+			changes[fieldName] = FieldChange{Old: oldField, New: newField}
+		}
+	}
+	return changes
+}
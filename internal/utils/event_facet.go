@@ -3,17 +3,31 @@ package utils
 import (
 	"context"
 	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
 	"dpatrov/scraper/internal/gendb"
+	"dpatrov/scraper/internal/ical"
+	"strings"
+	"sync"
 	"time"
 )
 
+// the counts are computed at most every facetCacheTTL when nothing changes
+const facetCacheTTL = 10 * time.Minute
+
 type EventFacetCounts struct {
-	Today       int       `json:"today"`
-	ThisWeekend int       `json:"thisWeekend"`
-	ThisWeek    int       `json:"thisWeek"`
-	NextWeek    int       `json:"nexWeek"`
-	CacheAt     time.Time `json:"-"`
+	Today       int            `json:"today"`
+	ThisWeekend int            `json:"thisWeekend"`
+	ThisWeek    int            `json:"thisWeek"`
+	NextWeek    int            `json:"nextWeek"`
+	Categories  map[string]int `json:"categories"`
+	Tags        map[string]int `json:"tags"`
+	Lifecycles  map[string]int `json:"lifecycles"`
+	CacheAt     time.Time      `json:"-"`
 	queries     gendb.Queries
+	// agenda version of the cached counts
+	version uint64
+	mu      sync.Mutex
 }
 
 func NewEventFacetCounts(db *sql.DB) *EventFacetCounts {
@@ -22,16 +36,99 @@ func NewEventFacetCounts(db *sql.DB) *EventFacetCounts {
 	}
 }
 
-func (efc EventFacetCounts) GetFacetCount(ctx context.Context, params gendb.GetAgendaCountFacetsParams) (*EventFacetCounts, error) {
+// FacetWindows today, this weekend (friday to sunday), the rest of this week and next week (monday to sunday)
+func FacetWindows(now time.Time) gendb.GetAgendaCountFacetsParams {
+	now = now.In(ical.DefaultLocation)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, ical.DefaultLocation)
+	// monday = 0 ... sunday = 6
+	weekday := (int(today.Weekday()) + 6) % 7
+	sunday := today.AddDate(0, 0, 6-weekday)
+	weekendStart := sunday.AddDate(0, 0, -2)
+	if weekendStart.Before(today) {
+		weekendStart = today
+	}
+	day := func(date time.Time) string { return date.Format("2006-01-02") }
+	return gendb.GetAgendaCountFacetsParams{
+		TodayStart:    day(today),
+		TodayEnd:      day(today),
+		WeekendStart:  day(weekendStart),
+		WeekendEnd:    day(sunday),
+		WeekStart:     day(today),
+		WeekEnd:       day(sunday),
+		NextWeekStart: day(sunday.AddDate(0, 0, 1)),
+		NextWeekEnd:   day(sunday.AddDate(0, 0, 7)),
+	}
+}
+
+func (efc *EventFacetCounts) GetFacetCount(ctx context.Context, params gendb.GetAgendaCountFacetsParams) (*EventFacetCounts, error) {
 	result, err := efc.queries.GetAgendaCountFacets(ctx, params)
 	if err != nil {
 		return nil, err
 	}
 	return &EventFacetCounts{
-		Today:       int(result.Today.Float64),
-		ThisWeekend: int(result.ThisWeekend.Float64),
-		ThisWeek:    int(result.ThisWeek.Float64),
-		NextWeek:    int(result.NextWeek.Float64),
+		Today:       int(result.Today),
+		ThisWeekend: int(result.ThisWeekend),
+		ThisWeek:    int(result.ThisWeek),
+		NextWeek:    int(result.NextWeek),
 	}, nil
 
 }
+
+// Current returns the cached counts, they are computed again when an agenda entry changed,
+// on a new day or after facetCacheTTL
+func (efc *EventFacetCounts) Current(ctx context.Context, now time.Time) (*EventFacetCounts, error) {
+	efc.mu.Lock()
+	defer efc.mu.Unlock()
+	version := repository.AgendaVersion()
+	if !efc.CacheAt.IsZero() && efc.version == version && now.Sub(efc.CacheAt) < facetCacheTTL &&
+		efc.CacheAt.In(ical.DefaultLocation).YearDay() == now.In(ical.DefaultLocation).YearDay() {
+		return efc.snapshot(), nil
+	}
+
+	windows := FacetWindows(now)
+	counts, err := efc.GetFacetCount(ctx, windows)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := efc.queries.ListUpcomingCategoriesAndTags(ctx, windows.TodayStart)
+	if err != nil {
+		return nil, err
+	}
+	counts.Categories = map[string]int{}
+	counts.Tags = map[string]int{}
+	counts.Lifecycles = map[string]int{}
+	for _, row := range rows {
+		lifecycle, _ := row.EventLifecycleStatus.(string)
+		counts.Lifecycles[db.AgendaEntry{EventLifecycleStatus: lifecycle}.Lifecycle()]++
+		if category := strings.ToLower(strings.TrimSpace(row.Category.String)); category != "" {
+			counts.Categories[category]++
+		}
+		// an entry counts once per tag
+		seen := map[string]bool{}
+		for _, tag := range strings.Split(row.Tag.String, ",") {
+			if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" && !seen[tag] {
+				seen[tag] = true
+				counts.Tags[tag]++
+			}
+		}
+	}
+
+	efc.Today, efc.ThisWeekend, efc.ThisWeek, efc.NextWeek = counts.Today, counts.ThisWeekend, counts.ThisWeek, counts.NextWeek
+	efc.Categories, efc.Tags, efc.Lifecycles = counts.Categories, counts.Tags, counts.Lifecycles
+	efc.CacheAt = now
+	efc.version = version
+	return efc.snapshot(), nil
+}
+
+func (efc *EventFacetCounts) snapshot() *EventFacetCounts {
+	return &EventFacetCounts{
+		Today:       efc.Today,
+		ThisWeekend: efc.ThisWeekend,
+		ThisWeek:    efc.ThisWeek,
+		NextWeek:    efc.NextWeek,
+		Categories:  efc.Categories,
+		Tags:        efc.Tags,
+		Lifecycles:  efc.Lifecycles,
+		CacheAt:     efc.CacheAt,
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"database/sql"
 	internal "dpatrov/scraper/internal"
 	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
 	"dpatrov/scraper/internal/gendb"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 )
 
@@ -18,28 +20,47 @@ func timeUntilMidnight() time.Duration {
 	return nextMidnight.Sub(now)
 }
 
+// DefaultTrashRetention the trashed entries are purged after 30 days
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
 type EventArchiver struct {
 	queries  gendb.Queries
+	agenda   *repository.AgendaRepository
 	interval time.Duration
-	stopChan chan struct{}
+	// retention of the trashed entries and submissions
+	retention time.Duration
+	stopChan  chan struct{}
+	wg        sync.WaitGroup
 }
 
 func NewEventArchiver(db *sql.DB, interval time.Duration) *EventArchiver {
 	return &EventArchiver{
-		queries:  *gendb.New(db),
-		interval: interval,
-		stopChan: make(chan struct{}),
+		queries:   *gendb.New(db),
+		agenda:    repository.NewAgendaRepository(db),
+		interval:  interval,
+		retention: DefaultTrashRetention,
+		stopChan:  make(chan struct{}),
 	}
 }
+
+// WithRetention the trashed entries are kept for retention before they are purged
+func (ea *EventArchiver) WithRetention(retention time.Duration) *EventArchiver {
+	ea.retention = retention
+	return ea
+}
 func (ea *EventArchiver) Start(ctx context.Context) {
+	ea.wg.Add(1)
 	go ea.run(ctx)
 }
 
+// Stop the archiver and waits for the archiving in progress
 func (ea *EventArchiver) Stop() {
 	close(ea.stopChan)
+	ea.wg.Wait()
 }
 
 func (ea *EventArchiver) run(ctx context.Context) {
+	defer ea.wg.Done()
 
 	initialDelay := timeUntilMidnight()
 	log.Printf("Agenda archiver will start at next midnight (in %v)", initialDelay)
@@ -49,14 +70,18 @@ func (ea *EventArchiver) run(ctx context.Context) {
 	// run on start
 	ea.archivePastEvents(ctx)
 	ea.archivePastSubmissions(ctx)
+	ea.purgeTrash(ctx)
 	// Will wait until midnight
 	select {
 	case <-ctx.Done():
 		log.Println("First midnight: Agenda Archiver stopped due to context cancellation...")
 		return
+	case <-ea.stopChan:
+		return
 	case <-firstTimer.C:
 		ea.archivePastEvents(ctx)
 		ea.archivePastSubmissions(ctx)
+		ea.purgeTrash(ctx)
 	}
 	// reset timer from now on
 	ticker := time.NewTicker(ea.interval)
@@ -67,9 +92,12 @@ func (ea *EventArchiver) run(ctx context.Context) {
 		case <-ctx.Done():
 			log.Println("Agenda Archiver stopped due to context cancellation")
 			return
+		case <-ea.stopChan:
+			return
 		case <-ticker.C:
 			ea.archivePastEvents(ctx)
 			ea.archivePastSubmissions(ctx)
+			ea.purgeTrash(ctx)
 		}
 	}
 }
@@ -77,14 +105,50 @@ func (ea *EventArchiver) run(ctx context.Context) {
 func (ea *EventArchiver) archivePastEvents(ctx context.Context) {
 	now := time.Now()
 	log.Printf("Starting archive process at %s", now)
-	err := ea.queries.ArchivePastEvents(ctx)
-	if err != nil {
+	if err := ea.ArchivePastEvents(ctx, now); err != nil {
 		fmt.Printf("error %v", err)
 	}
 	log.Printf("Ending archive process at %s", time.Now())
 
 }
 
+// ArchivePastEvents archives the entries passed at now, a recurring entry only has its passed occurrences
+// archived, the series is archived after its last occurrence. Only the active entries can move to
+// archived (db.Status.CanMoveTo), the queries are limited to them
+func (ea *EventArchiver) ArchivePastEvents(ctx context.Context, now time.Time) error {
+	// keeps a year of occurrences ahead
+	if err := ea.agenda.RefreshOccurrences(ctx, now); err != nil {
+		return err
+	}
+	today := now.Format("2006-01-02")
+	if err := ea.queries.ArchivePastEvents(ctx, today); err != nil {
+		return err
+	}
+	if err := ea.queries.ArchivePastOccurrences(ctx, today); err != nil {
+		return err
+	}
+	return ea.queries.ArchiveEndedSeries(ctx)
+}
+
+func (ea *EventArchiver) purgeTrash(ctx context.Context) {
+	if err := ea.PurgeTrash(ctx, time.Now()); err != nil {
+		log.Printf("EventArchiver::purgeTrash %v", err)
+	}
+}
+
+// PurgeTrash deletes the entries and the submissions trashed for longer than the retention
+func (ea *EventArchiver) PurgeTrash(ctx context.Context, now time.Time) error {
+	before := now.Add(-ea.retention)
+	purged, err := ea.agenda.PurgeTrash(ctx, before)
+	if err != nil {
+		return err
+	}
+	if len(purged) > 0 {
+		log.Printf("Purged %d agenda entries trashed before %s", len(purged), before.Format(time.DateOnly))
+	}
+	return ea.queries.PurgeTrashedSubmissions(ctx, sql.NullTime{Time: before.UTC(), Valid: true})
+}
+
 func (ea *EventArchiver) archivePastSubmissions(ctx context.Context) {
 	log.Printf("Start Submission process as %s", time.Now())
 
@@ -110,10 +174,25 @@ func (ea *EventArchiver) archivePastSubmissions(ctx context.Context) {
 			return
 		}
 		// If submission is canceled or agendaEntry is end
-		if agendaEntry.EndDate.Add(time.Hour * 24).Before(time.Now()) {
+		endDate := agendaEntry.EndDate
+		if agendaEntry.RRule != "" {
+			// the end of the last occurrence, a series without end is never archived
+			occurrences, err := agendaEntry.Occurrences(time.Now().AddDate(1, 0, 0), 0)
+			if err != nil || len(occurrences) == 0 {
+				continue
+			}
+			endDate = occurrences[len(occurrences)-1].EndDate
+		}
+		if submission.Status == string(db.SubmissionStatus_Archived) {
+			continue
+		}
+		if endDate.Add(time.Hour * 24).Before(time.Now()) {
+			if err := db.SubmissionTransition(submission.ID, submission.Status, db.SubmissionStatus_Archived); err != nil {
+				continue
+			}
 			err := ea.queries.UpdateStatusByID(ctx, gendb.UpdateStatusByIDParams{
 				ID:     submission.ID,
-				Status: "archived",
+				Status: string(db.SubmissionStatus_Archived),
 			})
 			if err != nil {
 				fmt.Sprintf("UpdateStatusByID:error %v", err)
@@ -0,0 +1,199 @@
+package utils
+
+import (
+	"context"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/ical"
+	"dpatrov/scraper/internal/scraper"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 10MB
+const maxICSSize = 10 << 20
+
+var icsClient = &http.Client{Timeout: 30 * time.Second}
+
+var postalCodeRegexp = regexp.MustCompile(`^\d{4,5}\s+`)
+
+var imageExtensions = []string{".jpg", ".jpeg", ".png", ".gif", ".webp"}
+
+var ErrUnknownDefaultField = errors.New("unknown default field")
+
+// ValidateICSDefaults checks the defaults only fill agenda entry fields
+func ValidateICSDefaults(defaults map[string]string) error {
+	var unknown []string
+	for field := range defaults {
+		if !slices.Contains(scraper.AgendaFields, field) {
+			unknown = append(unknown, fmt.Sprintf("%q", field))
+		}
+	}
+	if len(unknown) > 0 {
+		slices.Sort(unknown)
+		return fmt.Errorf("%w %s", ErrUnknownDefaultField, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// ICSImporter creates pending agenda entries from the VEVENTs of a calendar.
+// The event UID gives the entry id: a re-import updates the entries still pending.
+type ICSImporter struct {
+	agenda *repository.AgendaRepository
+}
+
+func NewICSImporter(agenda *repository.AgendaRepository) *ICSImporter {
+	return &ICSImporter{agenda: agenda}
+}
+
+// ImportSource reads the calendar from a file or an url (http, https, webcal)
+func (im *ICSImporter) ImportSource(ctx context.Context, source string, defaults map[string]string) (*RunResult, error) {
+	if strings.HasPrefix(source, "webcal://") {
+		source = "https://" + strings.TrimPrefix(source, "webcal://")
+	}
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		file, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return im.Import(ctx, file, "", defaults)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := icsClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("can't load calendar %s: %s", source, response.Status)
+	}
+	return im.Import(ctx, response.Body, source, defaults)
+}
+
+// Import saves the events, defaults fill the fields missing in the calendar (category, price...)
+func (im *ICSImporter) Import(ctx context.Context, reader io.Reader, link string, defaults map[string]string) (*RunResult, error) {
+	if err := ValidateICSDefaults(defaults); err != nil {
+		return nil, err
+	}
+	events, err := ical.Parse(io.LimitReader(reader, maxICSSize))
+	if err != nil {
+		return nil, err
+	}
+	result := NewRunResult()
+	for _, event := range events {
+		record := ICSEventRecord(event)
+		for field, value := range defaults {
+			if record[field] == "" {
+				record[field] = value
+			}
+		}
+		fieldErrors := map[string]string{}
+		if event.Status == "CANCELLED" {
+			fieldErrors["status"] = "event is cancelled"
+		}
+		entry, fieldErrors := scraper.BuildEntry(record, fieldErrors, link)
+		if len(fieldErrors) > 0 {
+			result.Invalid = append(result.Invalid, scraper.InvalidItem{Record: record, Errors: fieldErrors})
+			continue
+		}
+		entry.ID = ICSEntryID(event)
+		if entry.ID == "" {
+			entry.ID = ScrapedEntryID(entry)
+		}
+		if err := savePendingEntry(ctx, im.agenda, &entry, result); err != nil {
+			return result, err
+		}
+	}
+	log.Printf("ICS import: %d created, %d updated, %d skipped, %d invalid, %d possible duplicates",
+		len(result.Created), len(result.Updated), len(result.Skipped), len(result.Invalid), len(result.Duplicates))
+	return result, nil
+}
+
+// ICSEntryID is derived from the UID (and RECURRENCE-ID for a modified occurrence)
+func ICSEntryID(event ical.Event) string {
+	if event.UID == "" {
+		return ""
+	}
+	key := "ics:" + event.UID
+	if event.RecurrenceID != "" {
+		key += "#" + event.RecurrenceID
+	}
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(key)).String()
+}
+
+// ICSEventRecord maps the event on the agenda entry fields
+func ICSEventRecord(event ical.Event) scraper.Record {
+	record := scraper.Record{
+		"title":       event.Summary,
+		"description": event.Description,
+		"link":        event.URL,
+		"startdate":   event.Start.Format("2006-01-02"),
+		"enddate":     event.End.Format("2006-01-02"),
+	}
+	if !event.AllDay {
+		record["starttime"] = event.Start.Format("15:04")
+		if event.End.After(event.Start) {
+			record["endtime"] = event.End.Format("15:04")
+		}
+	}
+
+	// "Le Chat Noir, Rue Vautier 13, 1227 Carouge"
+	var parts []string
+	for _, part := range strings.Split(event.Location, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	switch len(parts) {
+	case 0:
+	case 1:
+		record["venuename"] = parts[0]
+		record["address"] = parts[0]
+	default:
+		record["venuename"] = parts[0]
+		record["address"] = strings.Join(parts[1:], ", ")
+		record["place"] = postalCodeRegexp.ReplaceAllString(parts[len(parts)-1], "")
+	}
+
+	if len(event.Categories) > 0 {
+		record["category"] = strings.ToLower(event.Categories[0])
+		record["tags"] = strings.Join(event.Categories, ",")
+	}
+	record["poster"] = icsPoster(event.Attach)
+
+	for field, value := range record {
+		if value == "" {
+			delete(record, field)
+		}
+	}
+	return record
+}
+
+// icsPoster returns the first image attached by url
+func icsPoster(attachments []ical.Property) string {
+	for _, attach := range attachments {
+		if attach.Params["ENCODING"] != "" || !strings.HasPrefix(attach.Value, "http") {
+			continue
+		}
+		extension := strings.ToLower(path.Ext(strings.SplitN(attach.Value, "?", 2)[0]))
+		if strings.HasPrefix(attach.Params["FMTTYPE"], "image/") || slices.Contains(imageExtensions, extension) {
+			return attach.Value
+		}
+	}
+	return ""
+}
@@ -2,7 +2,11 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
 	"dpatrov/scraper/internal/gendb"
 	"encoding/json"
 	"fmt"
@@ -11,6 +15,7 @@ import (
 	"net/smtp"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"golang.org/x/text/language" // Import language
@@ -19,9 +24,10 @@ import (
 )
 
 type EmailTask struct {
-	To      string
-	Subject string
-	Body    string
+	To       string
+	Subject  string
+	Body     string
+	Template string
 }
 
 type TemplateData struct {
@@ -39,9 +45,16 @@ type Mailer struct {
 	smtpPassword string
 	fromMail     string
 	baseUrl      string
-	emailQueue   chan EmailTask
+	outbox       *repository.OutboxRepository
+	send         func(EmailTask) error
+	wake         chan struct{}
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
 }
 
+// outboxBatch the emails sent at each poll of the outbox
+const outboxBatch = 20
+
 // Option pattern
 type MailerConf struct {
 	SmtpHost     string
@@ -52,7 +65,8 @@ type MailerConf struct {
 	FromEmail    string
 }
 
-func NewMailer(conf MailerConf /* opts...Options**/) *Mailer {
+// NewMailer the emails go through the outbox of the database, Start sends them
+func NewMailer(conf MailerConf, localDb *sql.DB /* opts...Options**/) *Mailer {
 	service := &Mailer{
 		smtpHost:     conf.SmtpHost,
 		smtpPort:     conf.SmtpPort,
@@ -60,23 +74,122 @@ func NewMailer(conf MailerConf /* opts...Options**/) *Mailer {
 		smtpPassword: conf.SmtpPassword,
 		baseUrl:      conf.BaseUrl,
 		fromMail:     conf.FromEmail,
-		emailQueue:   make(chan EmailTask, 100),
+		outbox:       repository.NewOutboxRepository(localDb),
+		wake:         make(chan struct{}, 1),
 	}
-	// Start email worker
-	go service.startWorker()
+	service.send = service.sendEmail
 
 	return service
 }
 
-func (m *Mailer) startWorker() {
-	for task := range m.emailQueue {
-		err := m.sendEmail(task)
+// WithSender sends the emails with send instead of SMTP
+func (m *Mailer) WithSender(send func(EmailTask) error) *Mailer {
+	m.send = send
+	return m
+}
+
+// Start the email worker, the emails left sending by a stop are sent again
+func (m *Mailer) Start(ctx context.Context) {
+	if reset, err := m.outbox.ResetSending(ctx); err != nil {
+		log.Printf("Mailer: %v", err)
+	} else if reset > 0 {
+		log.Printf("Mailer: %d interrupted emails back in the outbox", reset)
+	}
+	ctx, m.cancel = context.WithCancel(ctx)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			if _, err := m.SendDue(ctx, time.Now()); err != nil {
+				log.Printf("Mailer: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-m.wake:
+			}
+		}
+	}()
+}
+
+// Stop the worker and waits for the email being sent
+func (m *Mailer) Stop() {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.wg.Wait()
+}
+
+// Wake the worker, a new email is sent without waiting for the next poll
+func (m *Mailer) Wake() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SendDue sends the emails of the outbox due at now, returns how many were sent
+// a failed email is retried later, see OutboxRepository.MarkFailed
+func (m *Mailer) SendDue(ctx context.Context, now time.Time) (int, error) {
+	emails, err := m.outbox.FindDue(ctx, now, outboxBatch)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	// the result of a send is saved even when the mailer is stopping
+	saveCtx := context.WithoutCancel(ctx)
+	for _, email := range emails {
+		if ctx.Err() != nil {
+			break
+		}
+		claimed, err := m.outbox.Claim(ctx, email.ID)
 		if err != nil {
-			log.Printf("%v", err)
-		} else {
-			log.Printf("Email sent successfully to %s", task.To)
+			return sent, err
+		}
+		if !claimed {
+			continue
 		}
+		err = m.send(EmailTask{To: email.Recipient, Subject: email.Subject, Body: email.Body, Template: email.Template})
+		if err != nil {
+			failed, markErr := m.outbox.MarkFailed(saveCtx, email.ID, err, time.Now())
+			if markErr != nil {
+				return sent, markErr
+			}
+			if failed.Status == db.OutboxStatus_Dead {
+				log.Printf("Mailer: [%s] mail to %s is dead after %d attempts: %v", email.Template, email.Recipient, failed.Attempts, err)
+			} else {
+				log.Printf("Mailer: [%s] mail to %s failed, retry at %s: %v", email.Template, email.Recipient, failed.NextAttemptAt.Format(time.RFC3339), err)
+			}
+			continue
+		}
+		if err := m.outbox.MarkSent(saveCtx, email.ID, time.Now()); err != nil {
+			return sent, err
+		}
+		log.Printf("Email sent successfully to %s", email.Recipient)
+		sent++
+	}
+	return sent, nil
+}
+
+// Enqueue the email in the outbox, inside the transaction when tx is not nil
+// so the email is only sent once the changes which trigger it are committed
+func (m *Mailer) Enqueue(ctx context.Context, tx *sql.Tx, task EmailTask) error {
+	outbox := m.outbox
+	if tx != nil {
+		outbox = outbox.WithTx(tx)
+	}
+	err := outbox.Enqueue(ctx, &db.OutboxEmail{Recipient: task.To, Subject: task.Subject, Body: task.Body, Template: task.Template})
+	if err != nil {
+		return err
 	}
+	if tx == nil {
+		m.Wake()
+	}
+	log.Printf("Sending [%s] mail to...%s\n", task.Template, task.To)
+	return nil
 }
 
 func (m *Mailer) _sendEmail(task EmailTask) error {
@@ -119,31 +232,39 @@ func (m *Mailer) sendEmail(task EmailTask) error {
 	// 2. Create an SMTP client from the TLS connection
 	client, err := smtp.NewClient(conn, m.smtpHost)
 	if err != nil {
+		conn.Close()
 		return fmt.Errorf("failed to create SMTP client: %w", err)
 	}
-	defer client.Quit() // Ensure the client connection is closed
 
 	// 3. Authenticate
 	if err = client.Auth(auth); err != nil {
+		client.Close()
 		return fmt.Errorf("SMTP authentication failed: %w", err)
 	}
+	return SendSMTP(client, m.fromMail, task)
+}
 
+// SendSMTP sends the email through an open SMTP client and closes it.
+// The server accepts or refuses the message when the data writer is closed
+func SendSMTP(client *smtp.Client, from string, task EmailTask) error {
 	// 4. Set the sender
-	if err = client.Mail(m.fromMail); err != nil {
-		return fmt.Errorf("failed to set sender (%s): %w", m.fromMail, err)
+	if err := client.Mail(from); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to set sender (%s): %w", from, err)
 	}
 
 	// 5. Add recipient(s)
-	if err = client.Rcpt(task.To); err != nil {
+	if err := client.Rcpt(task.To); err != nil {
+		client.Close()
 		return fmt.Errorf("failed to add recipient (%s): %w", task.To, err)
 	}
 
 	// 6. Get a writer for the email data
 	wc, err := client.Data()
 	if err != nil {
+		client.Close()
 		return fmt.Errorf("failed to get data writer: %w", err)
 	}
-	defer wc.Close() // Ensure the writer is closed
 
 	// Construct the full email message including headers
 	msgHeaders := fmt.Sprintf("To: %s\r\nSubject: %s\r\nContent-Type: text/html; charset=UTF-8\r\nMIME-Version: 1.0\r\n\r\n",
@@ -153,9 +274,19 @@ func (m *Mailer) sendEmail(task EmailTask) error {
 
 	// 7. Write the message body
 	if _, err = wc.Write(fullMessage); err != nil {
+		client.Close()
 		return fmt.Errorf("failed to write email body: %w", err)
 	}
-	// 8. Quit the SMTP s
+	// the final answer of the server
+	if err := wc.Close(); err != nil {
+		client.Close()
+		return fmt.Errorf("email refused by the server: %w", err)
+	}
+	// 8. Quit the SMTP session, the message is already accepted: sending it again would duplicate it
+	if err := client.Quit(); err != nil {
+		log.Printf("Mailer: quit after sending to %s: %v", task.To, err)
+		client.Close()
+	}
 	return nil
 }
 
@@ -197,27 +328,33 @@ func (m *Mailer) SendConfirmationMail(submission *gendb.FormSubmission) error {
 		return err
 	}
 	task := EmailTask{
-		To:      submission.Email,
-		Subject: "Form Submission Confirmation - Manage Your Submission",
-		Body:    body.String(),
+		To:       submission.Email,
+		Subject:  "Form Submission Confirmation - Manage Your Submission",
+		Body:     body.String(),
+		Template: "confirmation_email",
 	}
-	select {
-	case m.emailQueue <- task:
-		log.Printf("Sending mail...\n%v", task)
-	default:
-		log.Println("Email queue is full, dropping email")
-	}
-	return nil
+	return m.Enqueue(context.Background(), nil, task)
 }
 
 type Record map[string]any
 
+// SendMail renders the template and enqueues the email, use Render and Enqueue
+// to send it with the changes of a transaction
 func (m *Mailer) SendMail(templateName string, email string, subject string, templateData Record) error {
+	task, err := m.Render(templateName, email, subject, templateData)
+	if err != nil {
+		return err
+	}
+	return m.Enqueue(context.Background(), nil, task)
+}
+
+// Render the email from internal/templates/<templateName>.html
+func (m *Mailer) Render(templateName string, email string, subject string, templateData Record) (EmailTask, error) {
 	dir, _ := os.Getwd()
 	templatePath := filepath.Join(dir, "internal", "templates", fmt.Sprintf("%s.html", templateName))
 	if _, err := os.Stat(templatePath); err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("Template doesn't exist...")
+			return EmailTask{}, fmt.Errorf("Template doesn't exist...")
 		}
 	}
 
@@ -225,26 +362,19 @@ func (m *Mailer) SendMail(templateName string, email string, subject string, tem
 	titleCaser := cases.Title(language.English)
 	tmpl, err := template.New(fmt.Sprintf("%s.html", templateName)).Funcs(template.FuncMap{"title": titleCaser.String}).ParseFiles(templatePath)
 	if err != nil {
-		return fmt.Errorf("Error parsing email template: %w", err)
+		return EmailTask{}, fmt.Errorf("Error parsing email template: %w", err)
 	}
 	// Deal with template
 	var body bytes.Buffer
 	err = tmpl.Execute(&body, templateData)
 	if err != nil {
 		log.Printf("Error executing email template for %s: %v", email, err)
-		return err
+		return EmailTask{}, err
 	}
-	task := EmailTask{
-		To:      email,
-		Subject: subject,
-		Body:    body.String(),
-	}
-	select {
-	case m.emailQueue <- task:
-		log.Printf("Sending [%s] mail to...%s\n", templateName, email)
-	default:
-		log.Println("Email queue is full, dropping email")
-	}
-	return nil
-
+	return EmailTask{
+		To:       email,
+		Subject:  subject,
+		Body:     body.String(),
+		Template: templateName,
+	}, nil
 }
@@ -0,0 +1,333 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/scraper"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrRunAlreadyFinished = errors.New("Scraping run is already finished")
+var ErrRunNotRunningHere = errors.New("Scraping run is not running on this server")
+
+// RunResult is saved with the run once the scraping is done, or returned by an import
+type RunResult struct {
+	Created []string              `json:"created"`
+	Updated []string              `json:"updated"`
+	Skipped []string              `json:"skipped"`
+	Invalid []scraper.InvalidItem `json:"invalid"`
+	// Duplicates the ids of the possible duplicates of the created entries
+	Duplicates map[string][]string `json:"duplicates"`
+}
+
+func NewRunResult() *RunResult {
+	return &RunResult{Created: []string{}, Updated: []string{}, Skipped: []string{}, Invalid: []scraper.InvalidItem{},
+		Duplicates: map[string][]string{}}
+}
+
+// ScrapingRunner executes the queued scraping runs with a pool of workers.
+// Runs are persisted: a run queued before a restart is picked up on Start.
+type ScrapingRunner struct {
+	tasks        *repository.TaskRepository
+	agenda       *repository.AgendaRepository
+	workers      int
+	pollInterval time.Duration
+	queue        chan int
+	mu           sync.Mutex
+	cancels      map[int]context.CancelFunc
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+}
+
+// NewScrapingRunner uses SCRAPER_WORKERS workers (default 2)
+func NewScrapingRunner(database *sql.DB) *ScrapingRunner {
+	workers, err := strconv.Atoi(os.Getenv("SCRAPER_WORKERS"))
+	if err != nil || workers < 1 {
+		workers = 2
+	}
+	return &ScrapingRunner{
+		tasks:        repository.NewTaskRepository(database),
+		agenda:       repository.NewAgendaRepository(database),
+		workers:      workers,
+		pollInterval: time.Minute,
+		queue:        make(chan int, 100),
+		cancels:      make(map[int]context.CancelFunc),
+	}
+}
+
+func (sr *ScrapingRunner) Start(ctx context.Context) {
+	ctx, sr.cancel = context.WithCancel(ctx)
+
+	// runs interrupted by a shutdown can't be resumed
+	interrupted, err := sr.tasks.FindRunsByStatus(ctx, db.TaskStatus_Running)
+	if err != nil {
+		log.Printf("ScrapingRunner::Start %v", err)
+	}
+	for _, run := range interrupted {
+		run.Status = db.TaskStatus_Failed
+		run.Error = "interrupted by a server shutdown"
+		sr.finish(ctx, run)
+	}
+
+	for i := 0; i < sr.workers; i++ {
+		sr.wg.Add(1)
+		go sr.work(ctx)
+	}
+	sr.wg.Add(1)
+	go sr.poll(ctx)
+	log.Printf("Scraping runner started with %d workers", sr.workers)
+}
+
+// Stop cancels the running scrapings and waits for the workers
+func (sr *ScrapingRunner) Stop() {
+	if sr.cancel != nil {
+		sr.cancel()
+	}
+	sr.wg.Wait()
+	log.Println("Scraping runner stopped")
+}
+
+// Enqueue creates a queued run for the task
+func (sr *ScrapingRunner) Enqueue(ctx context.Context, taskID int) (db.ScrapingRun, error) {
+	task, err := sr.tasks.FindByID(ctx, taskID)
+	if err != nil {
+		return db.ScrapingRun{}, err
+	}
+	run, err := sr.tasks.CreateRun(ctx, task.Id)
+	if err != nil {
+		return run, err
+	}
+	if err := sr.tasks.QueueTask(ctx, task.Id); err != nil {
+		log.Printf("ScrapingRunner::Enqueue %v", err)
+	}
+	select {
+	case sr.queue <- run.ID:
+	default:
+		// queue is full, the poller will pick it up
+	}
+	return run, nil
+}
+
+// Cancel stops a queued or running run
+func (sr *ScrapingRunner) Cancel(ctx context.Context, runID int) (db.ScrapingRun, error) {
+	run, err := sr.tasks.FindRunByID(ctx, runID)
+	if err != nil {
+		return run, err
+	}
+	if run.Status.IsFinished() {
+		return run, ErrRunAlreadyFinished
+	}
+	cancelled, err := sr.tasks.CancelQueuedRun(ctx, runID)
+	if err != nil {
+		return run, err
+	}
+	if cancelled {
+		sr.updateTaskStatus(ctx, run.TaskID, db.TaskStatus_Cancelled)
+		return sr.tasks.FindRunByID(ctx, runID)
+	}
+
+	// the worker registers the cancel func before claiming the run, a running run of this server is always found
+	sr.mu.Lock()
+	cancel, running := sr.cancels[runID]
+	sr.mu.Unlock()
+	if !running {
+		// finished meanwhile
+		if run, err = sr.tasks.FindRunByID(ctx, runID); err != nil {
+			return run, err
+		}
+		if run.Status.IsFinished() {
+			return run, ErrRunAlreadyFinished
+		}
+		return run, ErrRunNotRunningHere
+	}
+	// the worker saves the cancelled status
+	cancel()
+	run.Status = db.TaskStatus_Cancelled
+	return run, nil
+}
+
+func (sr *ScrapingRunner) poll(ctx context.Context) {
+	defer sr.wg.Done()
+	ticker := time.NewTicker(sr.pollInterval)
+	defer ticker.Stop()
+	for {
+		sr.enqueuePending(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (sr *ScrapingRunner) enqueuePending(ctx context.Context) {
+	runs, err := sr.tasks.FindRunsByStatus(ctx, db.TaskStatus_Queued)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("ScrapingRunner::enqueuePending %v", err)
+		}
+		return
+	}
+	for _, run := range runs {
+		select {
+		case sr.queue <- run.ID:
+		case <-ctx.Done():
+			return
+		default:
+			return
+		}
+	}
+}
+
+func (sr *ScrapingRunner) work(ctx context.Context) {
+	defer sr.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case runID := <-sr.queue:
+			sr.execute(ctx, runID)
+		}
+	}
+}
+
+func (sr *ScrapingRunner) execute(ctx context.Context, runID int) {
+	// registered before the claim: once the run is running, Cancel finds its cancel func
+	runCtx, cancel := context.WithCancel(ctx)
+	sr.mu.Lock()
+	if _, claiming := sr.cancels[runID]; claiming {
+		// queued twice (Enqueue + poll), another worker has it
+		sr.mu.Unlock()
+		cancel()
+		return
+	}
+	sr.cancels[runID] = cancel
+	sr.mu.Unlock()
+	defer func() {
+		sr.mu.Lock()
+		delete(sr.cancels, runID)
+		sr.mu.Unlock()
+		cancel()
+	}()
+
+	// a run can be queued twice (Enqueue + poll), only one worker claims it
+	claimed, err := sr.tasks.ClaimRun(ctx, runID)
+	if err != nil || !claimed {
+		return
+	}
+	run, err := sr.tasks.FindRunByID(ctx, runID)
+	if err != nil {
+		log.Printf("ScrapingRunner::execute %v", err)
+		return
+	}
+	sr.updateTaskStatus(ctx, run.TaskID, db.TaskStatus_Running)
+
+	result, err := sr.scrap(runCtx, run.TaskID)
+	switch {
+	case runCtx.Err() != nil && ctx.Err() == nil:
+		run.Status = db.TaskStatus_Cancelled
+	case err != nil:
+		run.Status = db.TaskStatus_Failed
+		run.Error = err.Error()
+	default:
+		run.Status = db.TaskStatus_Succeeded
+	}
+	if result != nil {
+		run.EntriesCount = len(result.Created) + len(result.Updated)
+		run.Result, _ = json.Marshal(result)
+	}
+	// the run must be saved even when the server is stopping
+	sr.finish(context.WithoutCancel(ctx), run)
+}
+
+func (sr *ScrapingRunner) scrap(ctx context.Context, taskID int) (*RunResult, error) {
+	task, err := sr.tasks.FindByID(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	scrapResult, err := scraper.HandleScrapTask(ctx, task.Definition)
+	if err != nil {
+		return nil, err
+	}
+	result := NewRunResult()
+	result.Invalid = scrapResult.Invalid
+	for _, entry := range scrapResult.Entries {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		entry.ID = ScrapedEntryID(entry)
+		if err := savePendingEntry(ctx, sr.agenda, &entry, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// savePendingEntry creates the entry, or updates it while it is still pending
+func savePendingEntry(ctx context.Context, agenda *repository.AgendaRepository, entry *db.AgendaEntry, result *RunResult) error {
+	existing, err := agenda.FindByID(ctx, entry.ID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err := agenda.Create(ctx, entry)
+		if errors.Is(err, repository.ErrEntryInTrash) {
+			// removed by a moderator
+			result.Skipped = append(result.Skipped, entry.ID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result.Created = append(result.Created, entry.ID)
+		// the moderators merge them
+		candidates, err := agenda.FindDuplicates(ctx, *entry)
+		if err != nil {
+			return err
+		}
+		for _, candidate := range candidates {
+			result.Duplicates[entry.ID] = append(result.Duplicates[entry.ID], candidate.ID)
+		}
+	case err != nil:
+		return err
+	case existing.Status == db.Status_Pending:
+		if err := agenda.Update(ctx, *entry); err != nil {
+			return err
+		}
+		result.Updated = append(result.Updated, entry.ID)
+	default:
+		// already moderated
+		result.Skipped = append(result.Skipped, entry.ID)
+	}
+	return nil
+}
+
+// ScrapedEntryID is stable across runs: the same event scraped twice gets the same id
+func ScrapedEntryID(entry db.AgendaEntry) string {
+	key := fmt.Sprintf("%s#%s#%s", entry.Link, entry.Title, entry.StartDate.Format("2006-01-02"))
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(key)).String()
+}
+
+func (sr *ScrapingRunner) finish(ctx context.Context, run db.ScrapingRun) {
+	run.FinishedAt = time.Now()
+	if err := sr.tasks.FinishRun(ctx, run); err != nil {
+		log.Printf("ScrapingRunner::finish %v", err)
+	}
+	sr.updateTaskStatus(ctx, run.TaskID, run.Status)
+	log.Printf("Scraping run %d of task %d: %s", run.ID, run.TaskID, run.Status)
+}
+
+func (sr *ScrapingRunner) updateTaskStatus(ctx context.Context, taskID int, status db.TaskStatus) {
+	if err := sr.tasks.UpdateStatus(ctx, taskID, status); err != nil {
+		log.Printf("ScrapingRunner::updateTaskStatus %v", err)
+	}
+}
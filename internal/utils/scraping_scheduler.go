@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"log"
+	"sync"
+	"time"
+)
+
+// ScrapingScheduler queues a run for the tasks whose cron schedule is due
+type ScrapingScheduler struct {
+	tasks    *repository.TaskRepository
+	runner   *ScrapingRunner
+	interval time.Duration
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func NewScrapingScheduler(database *sql.DB, runner *ScrapingRunner, interval time.Duration) *ScrapingScheduler {
+	return &ScrapingScheduler{
+		tasks:    repository.NewTaskRepository(database),
+		runner:   runner,
+		interval: interval,
+	}
+}
+
+func (ss *ScrapingScheduler) Start(ctx context.Context) {
+	ctx, ss.cancel = context.WithCancel(ctx)
+	ss.wg.Add(1)
+	go ss.run(ctx)
+}
+
+// Stop waits for the current tick to finish
+func (ss *ScrapingScheduler) Stop() {
+	if ss.cancel != nil {
+		ss.cancel()
+	}
+	ss.wg.Wait()
+	log.Println("Scraping scheduler stopped")
+}
+
+func (ss *ScrapingScheduler) run(ctx context.Context) {
+	defer ss.wg.Done()
+	ticker := time.NewTicker(ss.interval)
+	defer ticker.Stop()
+	for {
+		ss.queueDueTasks(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (ss *ScrapingScheduler) queueDueTasks(ctx context.Context, now time.Time) {
+	tasks, err := ss.tasks.FindDueTasks(ctx, now)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("ScrapingScheduler::queueDueTasks %v", err)
+		}
+		return
+	}
+	for _, task := range tasks {
+		// the next run is saved first: a failing task is not retried every tick
+		var nextRunAt time.Time
+		schedule, err := ParseCron(task.Schedule)
+		if err != nil {
+			log.Printf("ScrapingScheduler: task %d is disabled, %v", task.Id, err)
+		} else {
+			nextRunAt = schedule.Next(now)
+		}
+		if err := ss.tasks.UpdateSchedule(ctx, task.Id, task.Schedule, nextRunAt); err != nil {
+			log.Printf("ScrapingScheduler::UpdateSchedule %v", err)
+			continue
+		}
+		if schedule == nil {
+			continue
+		}
+		// skip the run if the previous one is not over yet
+		if task.Status == db.TaskStatus_Queued || task.Status == db.TaskStatus_Running {
+			log.Printf("ScrapingScheduler: task %d is still %s, next run at %s", task.Id, task.Status, nextRunAt)
+			continue
+		}
+		if _, err := ss.runner.Enqueue(ctx, task.Id); err != nil {
+			log.Printf("ScrapingScheduler::Enqueue %v", err)
+			continue
+		}
+		log.Printf("ScrapingScheduler: task %d queued, next run at %s", task.Id, nextRunAt)
+	}
+}
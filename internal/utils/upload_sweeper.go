@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/storage"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTmpUploadTTL the uploads of an abandoned form are deleted after a day
+	DefaultTmpUploadTTL = 24 * time.Hour
+	// a published poster is stored before its entry is saved
+	uploadGracePeriod = storage.ContentGracePeriod
+)
+
+// SweepResult the uploads deleted, or which would be deleted by a dry run
+type SweepResult struct {
+	Tmp     []storage.Object
+	Orphans []storage.Object
+}
+
+func (result SweepResult) Size() int64 {
+	var size int64
+	for _, object := range append(result.Tmp, result.Orphans...) {
+		size += object.Size
+	}
+	return size
+}
+
+// UploadSweeper deletes the uploads of the abandoned forms and the posters no longer used by an entry,
+// a submission or an organizer
+type UploadSweeper struct {
+	db       *sql.DB
+	store    storage.Storage
+	interval time.Duration
+	tmpTTL   time.Duration
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+func NewUploadSweeper(db *sql.DB, store storage.Storage, interval time.Duration) *UploadSweeper {
+	return &UploadSweeper{
+		db:       db,
+		store:    store,
+		interval: interval,
+		tmpTTL:   DefaultTmpUploadTTL,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// WithTmpTTL the uploads not published are kept for ttl
+func (sweeper *UploadSweeper) WithTmpTTL(ttl time.Duration) *UploadSweeper {
+	sweeper.tmpTTL = ttl
+	return sweeper
+}
+
+func (sweeper *UploadSweeper) Start(ctx context.Context) {
+	sweeper.wg.Add(1)
+	go sweeper.run(ctx)
+}
+
+// Stop the sweeper and waits for the sweep in progress
+func (sweeper *UploadSweeper) Stop() {
+	close(sweeper.stopChan)
+	sweeper.wg.Wait()
+}
+
+func (sweeper *UploadSweeper) run(ctx context.Context) {
+	defer sweeper.wg.Done()
+	ticker := time.NewTicker(sweeper.interval)
+	defer ticker.Stop()
+	// run on start
+	sweeper.sweep(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Upload sweeper stopped due to context cancellation")
+			return
+		case <-sweeper.stopChan:
+			return
+		case <-ticker.C:
+			sweeper.sweep(ctx)
+		}
+	}
+}
+
+func (sweeper *UploadSweeper) sweep(ctx context.Context) {
+	result, err := sweeper.Sweep(ctx, time.Now(), false)
+	if err != nil {
+		log.Printf("UploadSweeper::Sweep %v", err)
+		return
+	}
+	if len(result.Tmp)+len(result.Orphans) > 0 {
+		log.Printf("Upload sweeper deleted %d tmp uploads and %d orphaned uploads (%d bytes)", len(result.Tmp), len(result.Orphans), result.Size())
+	}
+}
+
+// Sweep deletes the tmp uploads older than the ttl and the uploads no longer referenced, nothing is
+// deleted by a dry run. A tmp upload of a submission waiting for moderation is referenced.
+// Only the poster key space is listed: the tmp uploads and the content keys (storage.ContentKey),
+// the other files of the bucket are never deleted.
+func (sweeper *UploadSweeper) Sweep(ctx context.Context, now time.Time, dryRun bool) (SweepResult, error) {
+	result := SweepResult{Tmp: []storage.Object{}, Orphans: []storage.Object{}}
+	referenced, err := sweeper.references(ctx)
+	if err != nil {
+		return result, err
+	}
+	objects, err := sweeper.listUploads(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, object := range objects {
+		if referenced[object.Key] {
+			continue
+		}
+		tmp := strings.HasPrefix(object.Key, storage.TmpPrefix)
+		keep := uploadGracePeriod
+		if tmp {
+			keep = sweeper.tmpTTL
+		}
+		if object.ModTime.After(now.Add(-keep)) {
+			continue
+		}
+		// stored again since the listing (storage.PutContent)
+		if current, err := sweeper.store.Stat(ctx, object.Key); err != nil || current.ModTime.After(now.Add(-keep)) {
+			continue
+		}
+		if !dryRun {
+			if err := sweeper.store.Delete(ctx, object.Key); err != nil {
+				return result, err
+			}
+		}
+		if tmp {
+			result.Tmp = append(result.Tmp, object)
+		} else {
+			result.Orphans = append(result.Orphans, object)
+		}
+	}
+	return result, nil
+}
+
+// listUploads the tmp uploads and the published uploads, listed by the first hex digit of their
+// content key
+func (sweeper *UploadSweeper) listUploads(ctx context.Context) ([]storage.Object, error) {
+	uploads, err := sweeper.store.List(ctx, storage.TmpPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for _, digit := range "0123456789abcdef" {
+		objects, err := sweeper.store.List(ctx, string(digit))
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			if storage.IsContentKey(object.Key) {
+				uploads = append(uploads, object)
+			}
+		}
+	}
+	return uploads, nil
+}
+
+// references the keys of the posters of the entries (trashed included), of their revisions, of the
+// submissions and of the organizer logos
+func (sweeper *UploadSweeper) references(ctx context.Context) (map[string]bool, error) {
+	referenced := map[string]bool{}
+	addEntry := func(entry db.AgendaEntry) {
+		addUploadKey(referenced, entry.Poster)
+		for _, variant := range entry.PosterVariants {
+			addUploadKey(referenced, variant.File)
+		}
+	}
+
+	rows, err := sweeper.db.QueryContext(ctx, `SELECT COALESCE(poster, ''), poster_variants FROM agenda_entry`)
+	if err != nil {
+		return nil, fmt.Errorf("upload references: %w", err)
+	}
+	for rows.Next() {
+		var entry db.AgendaEntry
+		var variants string
+		if err := rows.Scan(&entry.Poster, &variants); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("upload references: %w", err)
+		}
+		if variants != "" {
+			if err := json.Unmarshal([]byte(variants), &entry.PosterVariants); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("upload references: %w", err)
+			}
+		}
+		addEntry(entry)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("upload references: %w", err)
+	}
+
+	// the submissions and the versions of the entries, a revision may be restored
+	for _, query := range []string{`SELECT data FROM form_submissions`, `SELECT snapshot FROM agenda_revision`} {
+		if err := sweeper.addSnapshots(ctx, query, addEntry); err != nil {
+			return nil, err
+		}
+	}
+
+	rows, err = sweeper.db.QueryContext(ctx, `SELECT logo FROM organizer WHERE logo != ''`)
+	if err != nil {
+		return nil, fmt.Errorf("upload references: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var logo string
+		if err := rows.Scan(&logo); err != nil {
+			return nil, fmt.Errorf("upload references: %w", err)
+		}
+		addUploadKey(referenced, logo)
+	}
+	return referenced, rows.Err()
+}
+
+// addSnapshots the entries saved as json by the query
+func (sweeper *UploadSweeper) addSnapshots(ctx context.Context, query string, addEntry func(db.AgendaEntry)) error {
+	rows, err := sweeper.db.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("upload references: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return fmt.Errorf("upload references: %w", err)
+		}
+		var entry db.AgendaEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			// keeps the poster of a submission which is not a complete entry
+			var submission struct {
+				Poster string `json:"poster"`
+			}
+			json.Unmarshal([]byte(data), &submission)
+			entry.Poster = submission.Poster
+		}
+		addEntry(entry)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("upload references: %w", err)
+	}
+	return nil
+}
+
+// addUploadKey the poster of an entry is the name of the upload, /tmp/name while it's not published
+func addUploadKey(referenced map[string]bool, poster string) {
+	poster = strings.TrimSpace(poster)
+	if poster == "" || strings.HasPrefix(poster, "http://") || strings.HasPrefix(poster, "https://") {
+		return
+	}
+	if strings.HasPrefix(poster, "/tmp/") {
+		referenced[storage.TmpPrefix+path.Base(poster)] = true
+		return
+	}
+	referenced[strings.TrimPrefix(poster, "/")] = true
+}
@@ -1,6 +1,8 @@
 package validators
 
 import (
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/ical"
 	"strings"
 
 	z "github.com/Oudwins/zog"
@@ -17,6 +19,17 @@ var AgendaEntrySchema = z.Struct(z.Shape{
 	"Category":  z.String().Trim().Min(1, z.Message("Catégorie ne peut pas être vide")).Required(z.Message("Catégorie ne peut pas être vide")),
 	"EndTime":   z.Time().Optional(),
 	"EndDate":   z.Time().Optional(),
+	"RRule": z.String().Trim().Optional().TestFunc(func(value *string, ctx z.Ctx) bool {
+		if *value == "" {
+			return true
+		}
+		_, err := ical.ParseRRule(*value)
+		return err == nil
+	}, z.Message("Règle de récurrence invalide")),
+	"EventLifecycleStatus": z.String().Trim().Optional().TestFunc(func(value *string, ctx z.Ctx) bool {
+		_, known := db.NormalizeLifecycle(*value)
+		return known
+	}, z.Message("Statut de l'événement invalide")),
 })
 
 var FormSubmissionSchema = z.Struct(z.Shape{
@@ -10,12 +10,6 @@ import (
 func main() {
 	portNumber := os.Getenv("API_BACKEND_PORT")
 
-	uploadDir := "./uploads"
-	err := os.MkdirAll(uploadDir, os.ModePerm)
-	if err != nil {
-		log.Fatalln("uploads folder can't be found")
-	}
-
 	if portNumber == "" {
 		portNumber = "8080"
 	}
@@ -0,0 +1,85 @@
+package test
+
+import (
+	"context"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindAgendaEntries(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	agendaRepository := repository.NewAgendaRepository(migratedDB(t))
+	day := func(d int) time.Time { return time.Date(2025, 10, d, 0, 0, 0, 0, time.UTC) }
+
+	for _, entry := range []db.AgendaEntry{
+		{Title: "Concert Kora", StartDate: day(12), Category: "concert", Tags: []string{"Jazz", "World"}, VenueName: "Le Chat Noir", Place: "Carouge", Status: db.Status_Active},
+		{Title: "Festival", StartDate: day(1), EndDate: day(20), Category: "festival", Tags: []string{"World"}, Place: "Genève", Status: db.Status_Active},
+		{Title: "Expo 100%", StartDate: day(15), Category: "expo", Description: "Photographies", Status: db.Status_Active, EventLifecycleStatus: "cancelled"},
+		{Title: "Pending", StartDate: day(16), Category: "concert", Status: db.Status_Pending},
+		{Title: "Unlinked", StartDate: day(17), Category: "concert", Status: db.Status_Unlinked},
+	} {
+		_, err := agendaRepository.Create(ctx, &entry)
+		assert.Nil(err)
+	}
+	titles := func(query string) []string {
+		values, _ := url.ParseQuery(query)
+		agendaQuery, err := api.AgendaQueryFromRequest(values)
+		assert.Nil(err)
+		page, err := agendaRepository.Find(ctx, agendaQuery)
+		assert.Nil(err)
+		result := []string{}
+		for _, entry := range page.Entries {
+			result = append(result, entry.Title)
+		}
+		return result
+	}
+
+	assert.Equal([]string{"Festival", "Concert Kora", "Expo 100%", "Pending", "Unlinked"}, titles(""))
+	assert.Equal([]string{"Festival", "Concert Kora"}, titles("from=2025-10-10&to=2025-10-14"))
+	assert.Equal([]string{"Festival"}, titles("from=2025-10-18&status=1"))
+	assert.Equal([]string{"Festival", "Concert Kora"}, titles("tag=world"))
+	assert.Equal([]string{"Concert Kora", "Expo 100%", "Pending"}, titles("category=Concert,expo&status=1&status=2"))
+	assert.Equal([]string{"Concert Kora"}, titles("venue=chat"))
+	assert.Equal([]string{"Expo 100%"}, titles("q=100%25"))
+	assert.Equal([]string{"Expo 100%"}, titles("q=photo&lifecycle=Cancelled"))
+	assert.Equal([]string{"Unlinked", "Pending", "Expo 100%", "Concert Kora", "Festival"}, titles("sort=-startdate"))
+
+	// the pages follow each other without duplicates
+	var pages [][]string
+	query := repository.AgendaQuery{Sort: "title", Limit: 2}
+	for {
+		page, err := agendaRepository.Find(ctx, query)
+		assert.Nil(err)
+		pages = append(pages, []string{})
+		for _, entry := range page.Entries {
+			pages[len(pages)-1] = append(pages[len(pages)-1], entry.Title)
+		}
+		if page.Next == "" {
+			break
+		}
+		query.Cursor = page.Next
+	}
+	assert.Equal([][]string{{"Concert Kora", "Expo 100%"}, {"Festival", "Pending"}, {"Unlinked"}}, pages)
+
+	_, err := agendaRepository.Find(ctx, repository.AgendaQuery{Cursor: "bad"})
+	assert.ErrorIs(err, repository.ErrInvalidAgendaQuery)
+	_, err = api.AgendaQueryFromRequest(url.Values{"limit": {"1000"}})
+	assert.NotNil(err)
+	_, err = api.AgendaQueryFromRequest(url.Values{"from": {"12.10.2025"}})
+	assert.NotNil(err)
+
+	// legacy filters
+	entries, err := agendaRepository.FindAll(ctx, repository.Filter{"status": int(db.Status_Active)})
+	assert.Nil(err)
+	assert.Len(entries, 3)
+	entries, err = agendaRepository.FindAll(ctx, repository.Filter{"status": -int(db.Status_Unlinked)})
+	assert.Nil(err)
+	assert.Len(entries, 4)
+}
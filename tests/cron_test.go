@@ -0,0 +1,42 @@
+package test
+
+import (
+	"dpatrov/scraper/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronSchedule(t *testing.T) {
+	assert := assert.New(t)
+	// wednesday
+	from := time.Date(2025, 5, 14, 10, 17, 30, 0, time.UTC)
+
+	cases := []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2025, 5, 14, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2025, 5, 14, 10, 30, 0, 0, time.UTC)},
+		{"0 6 * * mon", time.Date(2025, 5, 19, 6, 0, 0, 0, time.UTC)},
+		{"30 8-9 * * 1-5", time.Date(2025, 5, 15, 8, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 31 dec *", time.Date(2025, 12, 31, 12, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2025, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2025, 5, 18, 0, 0, 0, 0, time.UTC)},
+		// day of month or day of week
+		{"0 0 20 * 5", time.Date(2025, 5, 16, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 feb *", time.Time{}},
+	}
+	for _, c := range cases {
+		schedule, err := utils.ParseCron(c.spec)
+		assert.Nil(err, c.spec)
+		assert.Equal(c.expected, schedule.Next(from), c.spec)
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "@often"} {
+		_, err := utils.ParseCron(spec)
+		assert.NotNil(err, spec)
+	}
+}
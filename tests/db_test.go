@@ -44,12 +44,12 @@ func TestCreateAgendaEntry(t *testing.T) {
 	agendaEntry := &db.AgendaEntry{
 		Title:       "This is my title",
 		Link:        fmt.Sprintf("http://google.fr/%s", fmt.Sprint(randomInt)),
-		Price:       23,
+		Price:       "23",
 		Address:     "You better know what's going on",
-		Time:        time.Now().Format("2006-01-02"),
+		StartDate:   time.Now(),
 		Description: "this is my description",
 		Status:      db.Status_Active,
-		Tag:         []string{"test", "avenir"},
+		Tags:        []string{"test", "avenir"},
 	}
 	ctx := context.Background()
 	agendaRepository := repository.NewAgendaRepository(myDb)
@@ -0,0 +1,161 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	gendb "dpatrov/scraper/internal/gendb"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicateScore(t *testing.T) {
+	assert := assert.New(t)
+	day := time.Date(2026, 11, 14, 0, 0, 0, 0, time.UTC)
+	evening := time.Date(0, 1, 1, 20, 0, 0, 0, time.UTC)
+	concert := db.AgendaEntry{Title: "Les Bâtards Magnifiques en concert", VenueName: "La Gravière", StartDate: day, StartTime: evening}
+
+	same := db.AgendaEntry{Title: "LES BATARDS MAGNIFIQUES - concert", VenueName: "Gravière", StartDate: day, StartTime: evening.Add(30 * time.Minute)}
+	assert.GreaterOrEqual(repository.DuplicateScore(concert, same), 0.75)
+
+	// missing venue and time count half
+	partial := db.AgendaEntry{Title: "Les Bâtards Magnifiques en concert", StartDate: day}
+	assert.Equal(0.8, repository.DuplicateScore(concert, partial))
+
+	otherDay := same
+	otherDay.StartDate = day.AddDate(0, 0, 1)
+	assert.Equal(0.0, repository.DuplicateScore(concert, otherDay))
+
+	otherShow := db.AgendaEntry{Title: "Soirée jazz", VenueName: "La Gravière", StartDate: day, StartTime: evening}
+	assert.Equal(0.0, repository.DuplicateScore(concert, otherShow))
+}
+
+func TestFindAndMergeDuplicates(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	subscriptionRepository := repository.NewSubscriptionRepository(localDb)
+	queries := gendb.New(localDb)
+
+	day := time.Now().AddDate(0, 0, 10).UTC().Truncate(24 * time.Hour)
+	concert := db.AgendaEntry{Title: "Kora Jazz Trio", VenueName: "Le Chat Noir", StartDate: day, Tags: []string{"jazz"}, Status: db.Status_Active}
+	_, err := agendaRepository.Create(ctx, &concert)
+	assert.Nil(err)
+	scraped := db.AgendaEntry{Title: "Kora Jazz Trio", Subtitle: "Tournée", VenueName: "Chat Noir", StartDate: day,
+		Link: "https://example.ch/kora", Price: "25.-", Tags: []string{"Jazz", "world"}, Status: db.Status_Pending,
+		Poster: "kora.jpg", PosterVariants: []db.PosterVariant{{Size: "thumbnail", File: "kora-thumbnail.jpg", Width: 320, Height: 240}}}
+	_, err = agendaRepository.Create(ctx, &scraped)
+	assert.Nil(err)
+	unrelated := db.AgendaEntry{Title: "Marché aux puces", StartDate: day, Status: db.Status_Active}
+	_, err = agendaRepository.Create(ctx, &unrelated)
+	assert.Nil(err)
+
+	data, _ := db.AgendaEntry{Title: "Kora jazz trio", VenueName: "Le Chat Noir", StartDate: day, Description: "Trio de kora"}.ToJSON()
+	assert.Nil(queries.CreateFormSubmission(ctx, gendb.CreateFormSubmissionParams{
+		ID:                "submission-kora",
+		Email:             "kora@example.ch",
+		Data:              data,
+		EditToken:         "edit",
+		CancelToken:       "cancel",
+		ConfirmationToken: "confirm",
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+		ExpiredAt:         time.Now().Add(24 * time.Hour),
+		Status:            string(db.SubmissionStatus_Pending),
+	}))
+
+	duplicates, err := agendaRepository.FindDuplicates(ctx, concert)
+	assert.Nil(err)
+	if assert.Len(duplicates, 2) {
+		assert.Equal("submission-kora", duplicates[0].ID)
+		assert.Equal(repository.DuplicateSubmission, duplicates[0].Kind)
+		assert.Equal(scraped.ID, duplicates[1].ID)
+		assert.Equal(repository.DuplicateEntry, duplicates[1].Kind)
+	}
+
+	for _, follower := range []struct{ entryID, email string }{
+		{scraped.ID, "fan@example.ch"}, {scraped.ID, "both@example.ch"}, {concert.ID, "both@example.ch"},
+	} {
+		subscription, err := subscriptionRepository.Subscribe(ctx, follower.entryID, follower.email)
+		assert.Nil(err)
+		_, err = subscriptionRepository.Confirm(ctx, subscription.Token)
+		assert.Nil(err)
+	}
+
+	_, err = agendaRepository.Merge(ctx, concert.ID, concert.ID, repository.DuplicateEntry)
+	assert.ErrorIs(err, repository.ErrInvalidMerge)
+
+	services := api.NewServiceMiddleWare(localDb)
+	recorder := httptest.NewRecorder()
+	body, _ := json.Marshal(api.MergeRequest{DuplicateID: scraped.ID})
+	api.AgendaDuplicateHandler(services)(recorder, httptest.NewRequest(http.MethodPost, "/agenda/"+concert.ID+"/merge", bytes.NewReader(body)))
+	assert.Equal(http.StatusOK, recorder.Code)
+
+	merged, err := agendaRepository.FindByID(ctx, concert.ID)
+	assert.Nil(err)
+	assert.Equal("Le Chat Noir", merged.VenueName)
+	assert.Equal("Tournée", merged.Subtitle)
+	assert.Equal("https://example.ch/kora", merged.Link)
+	assert.Equal("25.-", merged.Price)
+	assert.ElementsMatch([]string{"jazz", "world"}, merged.Tags)
+	assert.Equal("kora.jpg", merged.Poster)
+	assert.Equal("/images/kora-thumbnail.jpg 320w", merged.PosterSrcset())
+	revisions, _ := agendaRepository.FindRevisions(ctx, concert.ID)
+	assert.Equal(repository.RevisionMerge, revisions[0].Action)
+
+	trashed, err := agendaRepository.FindTrashed(ctx)
+	assert.Nil(err)
+	if assert.Len(trashed, 1) {
+		assert.Equal(scraped.ID, trashed[0].ID)
+	}
+	subscriptions, err := subscriptionRepository.FindByEntry(ctx, concert.ID)
+	assert.Nil(err)
+	assert.Len(subscriptions, 2)
+
+	recorder = httptest.NewRecorder()
+	api.AgendaDuplicateHandler(services)(recorder, httptest.NewRequest(http.MethodGet, "/agenda/"+concert.ID+"/duplicates", nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	var response struct {
+		Data []db.DuplicateCandidate `json:"data"`
+	}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Len(response.Data, 1)
+
+	recorder = httptest.NewRecorder()
+	api.AgendaDuplicateHandler(services)(recorder, httptest.NewRequest(http.MethodPost, "/agenda/"+concert.ID+"/merge", bytes.NewReader(body)))
+	assert.Equal(http.StatusNotFound, recorder.Code)
+
+	merge := func(body string) int {
+		recorder := httptest.NewRecorder()
+		api.AgendaDuplicateHandler(services)(recorder, httptest.NewRequest(http.MethodPost, "/agenda/"+concert.ID+"/merge", bytes.NewReader([]byte(body))))
+		return recorder.Code
+	}
+	assert.Equal(http.StatusUnprocessableEntity, merge(`{"duplicateId":"submission-kora","kind":"poster"}`))
+	assert.Equal(http.StatusNotFound, merge(`{"duplicateId":"unknown","kind":"submission"}`))
+
+	// a failure part-way leaves nothing merged
+	_, err = localDb.Exec(`CREATE TRIGGER locked BEFORE UPDATE OF deleted_at ON form_submissions BEGIN SELECT RAISE(ABORT, 'locked'); END`)
+	assert.Nil(err)
+	assert.Equal(http.StatusInternalServerError, merge(`{"duplicateId":"submission-kora","kind":"submission"}`))
+	merged, _ = agendaRepository.FindByID(ctx, concert.ID)
+	assert.Empty(merged.Description)
+	_, err = localDb.Exec(`DROP TRIGGER locked`)
+	assert.Nil(err)
+
+	// the submission completes the entry and goes to the trash
+	assert.Equal(http.StatusOK, merge(`{"duplicateId":"submission-kora","kind":"submission"}`))
+	merged, _ = agendaRepository.FindByID(ctx, concert.ID)
+	assert.Equal("Trio de kora", merged.Description)
+	var deleted int
+	assert.Nil(localDb.QueryRow(`SELECT COUNT(*) FROM form_submissions WHERE id = 'submission-kora' AND deleted_at IS NOT NULL`).Scan(&deleted))
+	assert.Equal(1, deleted)
+	assert.Equal(http.StatusNotFound, merge(`{"duplicateId":"submission-kora","kind":"submission"}`))
+}
@@ -0,0 +1,103 @@
+package test
+
+import (
+	"context"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFacetWindows(t *testing.T) {
+	assert := assert.New(t)
+	// wednesday 15 october 2025, 23:30 in Geneva
+	windows := utils.FacetWindows(time.Date(2025, 10, 15, 21, 30, 0, 0, time.UTC))
+	assert.Equal("2025-10-15", windows.TodayStart)
+	assert.Equal("2025-10-17", windows.WeekendStart)
+	assert.Equal("2025-10-19", windows.WeekendEnd)
+	assert.Equal("2025-10-19", windows.WeekEnd)
+	assert.Equal("2025-10-20", windows.NextWeekStart)
+	assert.Equal("2025-10-26", windows.NextWeekEnd)
+
+	// on sunday the weekend is today
+	windows = utils.FacetWindows(time.Date(2025, 10, 19, 10, 0, 0, 0, time.UTC))
+	assert.Equal("2025-10-19", windows.WeekendStart)
+	assert.Equal("2025-10-19", windows.WeekendEnd)
+}
+
+func TestEventFacetCounts(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	facets := utils.NewEventFacetCounts(localDb)
+	now := time.Date(2025, 10, 15, 10, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2025, 10, d, 0, 0, 0, 0, time.UTC) }
+
+	entries := []*db.AgendaEntry{
+		{Title: "Concert", StartDate: day(15), Category: "Concert", Tags: []string{"Jazz", "World"}, Status: db.Status_Active},
+		{Title: "Festival", StartDate: day(10), EndDate: day(25), Category: "festival", Tags: []string{"world"}, Status: db.Status_Active},
+		{Title: "Expo", StartDate: day(18), Category: "expo", Status: db.Status_Active},
+		{Title: "Past", StartDate: day(1), Category: "expo", Status: db.Status_Active},
+		{Title: "Pending", StartDate: day(15), Category: "concert", Status: db.Status_Pending},
+	}
+	for _, entry := range entries {
+		_, err := agendaRepository.Create(ctx, entry)
+		assert.Nil(err)
+	}
+
+	counts, err := facets.Current(ctx, now)
+	assert.Nil(err)
+	assert.Equal(2, counts.Today)
+	assert.Equal(2, counts.ThisWeekend)
+	assert.Equal(3, counts.ThisWeek)
+	assert.Equal(1, counts.NextWeek)
+	assert.Equal(map[string]int{"concert": 1, "festival": 1, "expo": 1}, counts.Categories)
+	assert.Equal(map[string]int{"jazz": 1, "world": 2}, counts.Tags)
+
+	// the cache is kept until an entry changes
+	_, err = localDb.Exec(`UPDATE agenda_entry SET status = 1 WHERE title = 'Pending'`)
+	assert.Nil(err)
+	counts, _ = facets.Current(ctx, now.Add(time.Minute))
+	assert.Equal(2, counts.Today)
+	assert.Nil(agendaRepository.UpdateStatus(ctx, entries[0].ID, int(db.Status_Archived)))
+	counts, _ = facets.Current(ctx, now.Add(time.Minute))
+	assert.Equal(2, counts.Today)
+	assert.Equal(map[string]int{"concert": 1, "festival": 1, "expo": 1}, counts.Categories)
+	assert.NotContains(counts.Tags, "jazz")
+}
+
+func TestAgendaVersionAfterCommit(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+
+	// a transaction of the caller: the version changes once it is committed
+	version := repository.AgendaVersion()
+	tx, err := localDb.Begin()
+	assert.Nil(err)
+	entry := db.AgendaEntry{Title: "Concert", StartDate: time.Now(), VenueName: "Le Chat Noir", RRule: "FREQ=WEEKLY;COUNT=3", Status: db.Status_Active}
+	_, err = agendaRepository.WithTx(tx).Create(ctx, &entry)
+	assert.Nil(err)
+	assert.Equal(version, repository.AgendaVersion())
+	assert.Nil(tx.Commit())
+	repository.AgendaChanged()
+	assert.NotEqual(version, repository.AgendaVersion())
+
+	// a rolled back write keeps the version
+	version = repository.AgendaVersion()
+	_, err = localDb.Exec(`CREATE TRIGGER refuse_revision BEFORE INSERT ON agenda_revision BEGIN SELECT RAISE(ABORT, 'revision refused'); END`)
+	assert.Nil(err)
+	entry.Title = "Concert annulé"
+	assert.NotNil(agendaRepository.Update(ctx, entry))
+	assert.Equal(version, repository.AgendaVersion())
+	_, err = localDb.Exec(`DROP TRIGGER refuse_revision`)
+	assert.Nil(err)
+
+	assert.Nil(agendaRepository.Update(ctx, entry))
+	assert.NotEqual(version, repository.AgendaVersion())
+}
@@ -0,0 +1,88 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/feed"
+	"encoding/xml"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpcomingFeed(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	agendaRepository := repository.NewAgendaRepository(migratedDB(t))
+	now := time.Now()
+
+	newEntry := func(title string, start time.Time, category string, tags []string, status db.Status) {
+		_, err := agendaRepository.Create(ctx, &db.AgendaEntry{
+			Title:     title,
+			StartDate: start,
+			StartTime: time.Date(0, 1, 1, 20, 0, 0, 0, time.UTC),
+			Category:  category,
+			Tags:      tags,
+			Poster:    "kora.jpg",
+			Status:    status,
+		})
+		assert.Nil(err)
+	}
+	newEntry("Concert Kora", now.AddDate(0, 0, 3), "concert", []string{"Jazz", "World"}, db.Status_Active)
+	newEntry("Expo", now.AddDate(0, 0, 5), "expo", []string{}, db.Status_Active)
+	newEntry("Last year", now.AddDate(-1, 0, 0), "concert", []string{"Jazz"}, db.Status_Active)
+	newEntry("Pending", now.AddDate(0, 0, 1), "concert", []string{"Jazz"}, db.Status_Pending)
+
+	upcoming := func(query string) []db.AgendaEntry {
+		values, _ := url.ParseQuery(query)
+		page, err := agendaRepository.Find(ctx, api.UpcomingQuery(values, now))
+		assert.Nil(err)
+		return page.Entries
+	}
+	assert.Len(upcoming(""), 2)
+	assert.Len(upcoming("category=expo"), 1)
+	concerts := upcoming("category=concert&tag=jazz")
+	assert.Len(concerts, 1)
+	assert.False(concerts[0].CreatedAt.IsZero())
+
+	items := api.UpcomingFeed(concerts, "https://api.afromemo.ch", "https://api.afromemo.ch/api/feeds/upcoming.rss")
+	var rssBuffer bytes.Buffer
+	assert.Nil(feed.WriteRSS(&rssBuffer, items))
+	var rss struct {
+		Items []struct {
+			Title     string `xml:"title"`
+			PubDate   string `xml:"pubDate"`
+			Enclosure struct {
+				URL  string `xml:"url,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"enclosure"`
+		} `xml:"channel>item"`
+	}
+	assert.Nil(xml.Unmarshal(rssBuffer.Bytes(), &rss))
+	assert.Len(rss.Items, 1)
+	assert.Equal("Concert Kora", rss.Items[0].Title)
+	assert.Equal("https://api.afromemo.ch/images/kora.jpg", rss.Items[0].Enclosure.URL)
+	assert.Equal("image/jpeg", rss.Items[0].Enclosure.Type)
+	pubDate, err := time.Parse(time.RFC1123Z, rss.Items[0].PubDate)
+	assert.Nil(err)
+	assert.WithinDuration(concerts[0].CreatedAt, pubDate, time.Second)
+
+	var atomBuffer bytes.Buffer
+	assert.Nil(feed.WriteAtom(&atomBuffer, items))
+	var atom struct {
+		Entries []struct {
+			ID      string `xml:"id"`
+			Updated string `xml:"updated"`
+		} `xml:"entry"`
+	}
+	assert.Nil(xml.Unmarshal(atomBuffer.Bytes(), &atom))
+	assert.Len(atom.Entries, 1)
+	assert.Contains(atom.Entries[0].ID, "/agenda/"+concerts[0].ID)
+	_, err = time.Parse(time.RFC3339, atom.Entries[0].Updated)
+	assert.Nil(err)
+}
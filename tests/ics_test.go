@@ -0,0 +1,188 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/ical"
+	"dpatrov/scraper/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const ICS_CALENDAR = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Partner//Agenda//FR\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:Europe/Zurich\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:concert-kora@partner.ch\r\n" +
+	"DTSTART;TZID=Europe/Zurich:20251012T203000\r\n" +
+	"DTEND;TZID=Europe/Zurich:20251012T230000\r\n" +
+	"SUMMARY:Concert Kora\\, Jazz & World\r\n" +
+	"LOCATION:Le Chat Noir\\, Rue Vautier 13\\, 1227 Carouge\r\n" +
+	"DESCRIPTION:Une soirée\\navec le trio\r\n" +
+	"  Kora.\r\n" +
+	"URL:https://partner.ch/concert-kora\r\n" +
+	"CATEGORIES:Concert,Jazz\r\n" +
+	"ATTACH;FMTTYPE=image/jpeg:https://partner.ch/kora.jpg\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"DESCRIPTION:Reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:festival@partner.ch\r\n" +
+	"DTSTART;VALUE=DATE:20251101\r\n" +
+	"DTEND;VALUE=DATE:20251103\r\n" +
+	"SUMMARY:Festival\r\n" +
+	"LOCATION:Parc des Bastions\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:utc@partner.ch\r\n" +
+	"DTSTART:20251115T180000Z\r\n" +
+	"DURATION:PT2H\r\n" +
+	"SUMMARY:Conférence\r\n" +
+	"LOCATION:Uni Mail\\, Genève\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICS(t *testing.T) {
+	assert := assert.New(t)
+	events, err := ical.Parse(strings.NewReader(ICS_CALENDAR))
+	assert.Nil(err)
+	assert.Len(events, 3)
+
+	concert := events[0]
+	assert.Equal("concert-kora@partner.ch", concert.UID)
+	assert.Equal("Concert Kora, Jazz & World", concert.Summary)
+	assert.Equal("Une soirée\navec le trio Kora.", concert.Description)
+	assert.Equal([]string{"Concert", "Jazz"}, concert.Categories)
+	assert.Equal("20:30", concert.Start.Format("15:04"))
+	assert.Len(concert.Properties["DESCRIPTION"], 1)
+
+	festival := events[1]
+	assert.True(festival.AllDay)
+	assert.Equal("2025-11-02", festival.End.Format("2006-01-02"))
+
+	// 18:00 UTC is 19:00 in Geneva
+	conference := events[2]
+	assert.Equal("19:00", conference.Start.Format("15:04"))
+	assert.Equal("21:00", conference.End.Format("15:04"))
+
+	_, err = ical.Parse(strings.NewReader("<html></html>"))
+	assert.ErrorIs(err, ical.ErrNoCalendar)
+}
+
+func TestImportICS(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	agendaRepository := repository.NewAgendaRepository(migratedDB(t))
+	importer := utils.NewICSImporter(agendaRepository)
+	defaults := map[string]string{"price": "0", "category": "festival", "place": "Genève", "starttime": "10:00"}
+
+	result, err := importer.Import(ctx, strings.NewReader(ICS_CALENDAR), "", defaults)
+	assert.Nil(err)
+	assert.Len(result.Created, 2)
+	assert.Len(result.Invalid, 1)
+	assert.Contains(result.Invalid[0].Errors, "status")
+
+	concert, err := agendaRepository.FindByID(ctx, result.Created[0])
+	assert.Nil(err)
+	assert.Equal(db.Status_Pending, concert.Status)
+	assert.Equal("Le Chat Noir", concert.VenueName)
+	assert.Equal("Rue Vautier 13, 1227 Carouge", concert.Address)
+	assert.Equal("Carouge", concert.Place)
+	assert.Equal("concert", concert.Category)
+	assert.Equal("https://partner.ch/kora.jpg", concert.Poster)
+
+	// a re-import updates the pending entries
+	result, err = importer.Import(ctx, strings.NewReader(ICS_CALENDAR), "", defaults)
+	assert.Nil(err)
+	assert.Len(result.Created, 0)
+	assert.Len(result.Updated, 2)
+
+	// moderated entries are left untouched
+	assert.Nil(agendaRepository.UpdateStatus(ctx, concert.ID, int(db.Status_Active)))
+	result, _ = importer.Import(ctx, strings.NewReader(ICS_CALENDAR), "", defaults)
+	assert.Equal([]string{concert.ID}, result.Skipped)
+
+	_, err = importer.Import(ctx, strings.NewReader(ICS_CALENDAR), "", map[string]string{"status": "1"})
+	assert.ErrorIs(err, utils.ErrUnknownDefaultField)
+}
+
+func TestImportICSHandlerDefaults(t *testing.T) {
+	assert := assert.New(t)
+	localDb := migratedDB(t)
+	services := api.NewServiceMiddleWare(localDb)
+	importCalendar := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/agenda/import-ics"+query, strings.NewReader(ICS_CALENDAR))
+		request.Header.Set("Content-Type", "text/calendar")
+		api.ImportICSHandler(services)(recorder, request)
+		return recorder
+	}
+
+	// only the agenda entry fields can be defaulted
+	recorder := importCalendar("?category=festival&status=1&id=forged")
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+	assert.Contains(recorder.Body.String(), `\"id\", \"status\"`)
+	var imported int
+	assert.Nil(localDb.QueryRow(`SELECT COUNT(*) FROM agenda_entry`).Scan(&imported))
+	assert.Equal(0, imported)
+
+	recorder = importCalendar("?category=festival&price=0")
+	assert.Equal(http.StatusOK, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	body := `{"url": "https://partner.ch/agenda.ics", "defaults": {"status": "1"}}`
+	api.ImportICSHandler(services)(recorder, httptest.NewRequest(http.MethodPost, "/agenda/import-ics", strings.NewReader(body)))
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func TestWriteICS(t *testing.T) {
+	assert := assert.New(t)
+	entry := db.AgendaEntry{
+		ID:          "8d3c",
+		Title:       "Concert Kora",
+		Description: "Une soirée avec le trio Kora; un moment unique, à ne pas manquer. " + strings.Repeat("Musique ", 20) + "!",
+		VenueName:   "Le Chat Noir",
+		Address:     "Rue Vautier 13",
+		Place:       "Carouge",
+		Category:    "concert",
+		Tags:        []string{"Jazz", "World"},
+		StartDate:   time.Date(2025, 10, 12, 0, 0, 0, 0, time.UTC),
+		StartTime:   time.Date(0, 1, 1, 20, 30, 0, 0, time.UTC),
+		EndTime:     time.Date(0, 1, 1, 23, 0, 0, 0, time.UTC),
+		Status:      db.Status_Active,
+	}
+	var buffer bytes.Buffer
+	assert.Nil(ical.Write(&buffer, "Afromemo", []ical.Event{api.EntryEvent(entry)}))
+	content := buffer.String()
+
+	assert.Contains(content, "BEGIN:VTIMEZONE\r\nTZID:Europe/Zurich\r\n")
+	assert.Contains(content, "DTSTART;TZID=Europe/Zurich:20251012T203000\r\n")
+	assert.Contains(content, "DTEND;TZID=Europe/Zurich:20251012T230000\r\n")
+	assert.Contains(content, "LOCATION:Le Chat Noir\\, Rue Vautier 13\\, Carouge\r\n")
+	assert.Contains(content, "CATEGORIES:concert,Jazz,World\r\n")
+	for _, line := range strings.Split(content, "\r\n") {
+		assert.LessOrEqual(len(line), 75, line)
+	}
+
+	// the feed can be read back
+	events, err := ical.Parse(strings.NewReader(content))
+	assert.Nil(err)
+	assert.Len(events, 1)
+	assert.Equal(entry.Description, events[0].Description)
+	assert.Equal("Le Chat Noir, Rue Vautier 13, Carouge", events[0].Location)
+	assert.True(events[0].Start.Equal(time.Date(2025, 10, 12, 18, 30, 0, 0, time.UTC)))
+}
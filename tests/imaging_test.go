@@ -0,0 +1,131 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/imaging"
+	"dpatrov/scraper/internal/storage"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// jpegWithOrientation a landscape JPEG carrying the EXIF orientation
+func jpegWithOrientation(t *testing.T, width int, height int, orientation byte) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := range width / 2 {
+		for y := range height {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 1, 0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, orientation, 0, 0, 0, 0, 0, 0}
+	exif := append([]byte("Exif\x00\x00"), tiff...)
+	segment := append([]byte{0xFF, 0xE1, byte((len(exif) + 2) >> 8), byte(len(exif) + 2)}, exif...)
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func TestProcessPoster(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := storage.NewLocal(dir, "/images")
+
+	// rotated 90 clockwise: the portrait poster was shot in landscape
+	poster := jpegWithOrientation(t, 1600, 1000, 6)
+	variants, err := imaging.ProcessPoster(ctx, bytes.NewReader(poster), store)
+	assert.Nil(err)
+	if assert.Len(variants, 3) {
+		assert.Equal("thumbnail", variants[0].Size)
+		assert.Equal([]int{320, 512}, []int{variants[0].Width, variants[0].Height})
+		assert.Equal("card", variants[1].Size)
+		// the poster is smaller than the full size
+		assert.Equal([]int{1000, 1600}, []int{variants[2].Width, variants[2].Height})
+		for _, variant := range variants {
+			assert.True(storage.IsContentKey(variant.File), variant.File)
+			assert.Equal(".jpg", filepath.Ext(variant.File))
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, variants[2].File))
+	assert.Nil(err)
+	assert.Equal(storage.ContentKey(data, ".jpg"), variants[2].File)
+	assert.False(bytes.Contains(data, []byte("Exif")))
+	full, err := jpeg.Decode(bytes.NewReader(data))
+	assert.Nil(err)
+	// the red half is on the top once upright
+	r, g, _, _ := full.At(500, 100).RGBA()
+	assert.Greater(r, g)
+	r, g, _, _ = full.At(500, 1500).RGBA()
+	assert.Less(r, uint32(0x8000))
+
+	// the same poster uploaded again is stored once
+	again, err := imaging.ProcessPoster(ctx, bytes.NewReader(poster), store)
+	assert.Nil(err)
+	assert.Equal(variants, again)
+	stored, _ := store.List(ctx, "")
+	assert.Len(stored, 3)
+
+	// transparency is kept, no size larger than the poster
+	transparent := image.NewNRGBA(image.Rect(0, 0, 400, 300))
+	var encoded bytes.Buffer
+	assert.Nil(png.Encode(&encoded, transparent))
+	variants, err = imaging.ProcessPoster(ctx, &encoded, store)
+	assert.Nil(err)
+	if assert.Len(variants, 2) {
+		assert.Equal(".png", filepath.Ext(variants[0].File))
+		assert.Equal(400, variants[1].Width)
+	}
+
+	_, err = imaging.ProcessPoster(ctx, bytes.NewReader([]byte("not an image")), store)
+	assert.True(errors.Is(err, imaging.ErrUnsupportedImage))
+}
+
+func TestPosterVariants(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	agendaRepository := repository.NewAgendaRepository(migratedDB(t))
+
+	concert := db.AgendaEntry{Title: "Concert", StartDate: time.Now(), Status: db.Status_Active, Poster: "affiche-card.jpg",
+		PosterVariants: []db.PosterVariant{
+			{Size: "thumbnail", File: "affiche-thumbnail.jpg", Width: 320, Height: 452},
+			{Size: "card", File: "affiche-card.jpg", Width: 640, Height: 905},
+		}}
+	_, err := agendaRepository.Create(ctx, &concert)
+	assert.Nil(err)
+
+	// the form sends the poster only
+	concert.PosterVariants = nil
+	concert.Title = "Concert de jazz"
+	assert.Nil(agendaRepository.Update(ctx, concert))
+	saved, err := agendaRepository.FindByID(ctx, concert.ID)
+	assert.Nil(err)
+	assert.Len(saved.PosterVariants, 2)
+
+	data, err := json.Marshal(saved)
+	assert.Nil(err)
+	var response map[string]any
+	assert.Nil(json.Unmarshal(data, &response))
+	assert.Equal("/images/affiche-thumbnail.jpg 320w, /images/affiche-card.jpg 640w", response["posterSrcset"])
+
+	// a new poster has no sizes yet
+	saved.Poster = "https://example.ch/affiche.jpg"
+	saved.PosterVariants = nil
+	assert.Nil(agendaRepository.Update(ctx, saved))
+	saved, _ = agendaRepository.FindByID(ctx, concert.ID)
+	assert.Empty(saved.PosterVariants)
+}
@@ -0,0 +1,181 @@
+package test
+
+import (
+	"context"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/utils"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventLifecycle(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	day := func(d int) time.Time { return time.Date(2025, 11, d, 0, 0, 0, 0, time.UTC) }
+
+	festival := db.AgendaEntry{Title: "Festival", StartDate: day(14), EndDate: day(16), Status: db.Status_Active}
+	concert := db.AgendaEntry{Title: "Concert", StartDate: day(15), EndDate: day(15), Status: db.Status_Active}
+	for _, entry := range []*db.AgendaEntry{&festival, &concert} {
+		_, err := agendaRepository.Create(ctx, entry)
+		assert.Nil(err)
+	}
+
+	_, err := agendaRepository.UpdateLifecycle(ctx, festival.ID, "closed", time.Time{})
+	assert.True(errors.Is(err, repository.ErrInvalidLifecycle))
+	_, err = agendaRepository.UpdateLifecycle(ctx, festival.ID, db.Lifecycle_SoldOut, day(20))
+	assert.True(errors.Is(err, repository.ErrInvalidLifecycle))
+	_, err = agendaRepository.UpdateLifecycle(ctx, "unknown", db.Lifecycle_Cancelled, time.Time{})
+	assert.Equal(repository.ErrNoAgendaEntryFound, err)
+
+	// the postponed festival keeps its three days and its first date
+	postponed, err := agendaRepository.UpdateLifecycle(ctx, festival.ID, "Postponed", day(21))
+	assert.Nil(err)
+	assert.Equal(db.Lifecycle_Postponed, postponed.Lifecycle())
+	assert.Equal(day(21), postponed.StartDate)
+	assert.Equal(day(23), postponed.EndDate)
+	assert.Equal("2025-11-14", postponed.OriginalStartDate)
+	postponed, err = agendaRepository.UpdateLifecycle(ctx, festival.ID, db.Lifecycle_Postponed, day(28))
+	assert.Nil(err)
+	assert.Equal("2025-11-14", postponed.OriginalStartDate)
+
+	// an update without the first date keeps it
+	postponed.OriginalStartDate = ""
+	assert.Nil(agendaRepository.Update(ctx, postponed))
+	postponed, _ = agendaRepository.FindByID(ctx, festival.ID)
+	assert.Equal("2025-11-14", postponed.OriginalStartDate)
+
+	revisions, err := agendaRepository.FindRevisions(ctx, festival.ID)
+	assert.Nil(err)
+	assert.Equal(repository.RevisionLifecycle, revisions[1].Action)
+
+	// the scheduled events have no lifecycle
+	page, err := agendaRepository.Find(ctx, repository.AgendaQuery{Lifecycles: []string{db.Lifecycle_Scheduled}})
+	assert.Nil(err)
+	if assert.Len(page.Entries, 1) {
+		assert.Equal(concert.ID, page.Entries[0].ID)
+	}
+
+	counts, err := utils.NewEventFacetCounts(localDb).Current(ctx, time.Date(2025, 11, 10, 10, 0, 0, 0, time.UTC))
+	assert.Nil(err)
+	assert.Equal(map[string]int{db.Lifecycle_Scheduled: 1, db.Lifecycle_Postponed: 1}, counts.Lifecycles)
+
+	event := api.EntryEvent(postponed)
+	assert.Equal("[Reporté] Festival", event.Summary)
+	assert.Contains(event.Description, "Initialement prévu le 14.11.2025")
+	cancelled, err := agendaRepository.UpdateLifecycle(ctx, concert.ID, db.Lifecycle_Cancelled, time.Time{})
+	assert.Nil(err)
+	assert.Equal("CANCELLED", api.EntryEvent(cancelled).Status)
+	assert.Equal("CONFIRMED", api.EntryEvent(db.AgendaEntry{Title: "Expo"}).Status)
+}
+
+func TestLifecycleHandlers(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	concert := db.AgendaEntry{Title: "Concert", StartDate: time.Now().AddDate(0, 0, 7), Status: db.Status_Active}
+	pending := db.AgendaEntry{Title: "Pending", StartDate: time.Now().AddDate(0, 0, 7), Status: db.Status_Pending}
+	for _, entry := range []*db.AgendaEntry{&concert, &pending} {
+		_, err := agendaRepository.Create(ctx, entry)
+		assert.Nil(err)
+	}
+
+	// the confirmation email is rendered from the root of the project
+	t.Chdir("..")
+	services := api.NewServiceMiddleWare(localDb)
+	subscriptions := api.EventSubscriptionHandler(services)
+	subscribe := func(body string, origin string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/subscriptions", strings.NewReader(body))
+		req.Header.Set("X-Real-IP", "192.0.2.18")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		subscriptions(recorder, req)
+		return recorder.Code
+	}
+	assert.Equal(http.StatusAccepted, subscribe(`{"entryId":"`+concert.ID+`","email":"Kora@example.ch"}`, ""))
+	assert.Equal(http.StatusAccepted, subscribe(`{"entryId":"`+concert.ID+`","email":"kora@example.ch"}`, ""))
+	assert.Equal(http.StatusUnprocessableEntity, subscribe(`{"entryId":"`+concert.ID+`","email":"kora"}`, ""))
+	assert.Equal(http.StatusNotFound, subscribe(`{"entryId":"`+pending.ID+`","email":"kora@example.ch"}`, ""))
+	assert.Equal(http.StatusForbidden, subscribe(`{"entryId":"`+concert.ID+`","email":"kora@example.ch"}`, "https://spam.example"))
+	assert.Equal(http.StatusTooManyRequests, subscribe(`{"entryId":"`+concert.ID+`","email":"kora@example.ch"}`, ""))
+
+	// nobody is notified before confirming the email
+	subscriptionRepository := repository.NewSubscriptionRepository(localDb)
+	followers, err := subscriptionRepository.FindByEntry(ctx, concert.ID)
+	assert.Nil(err)
+	assert.Empty(followers)
+	emails, _ := repository.NewOutboxRepository(localDb).Find(ctx, db.OutboxStatus_Pending, 10)
+	if assert.NotEmpty(emails) {
+		assert.Equal("subscription_confirmation", emails[0].Template)
+		assert.Equal("kora@example.ch", emails[0].Recipient)
+	}
+	unconfirmed, _ := subscriptionRepository.Subscribe(ctx, concert.ID, "kora@example.ch")
+	assert.Nil(unconfirmed.ConfirmedAt)
+	assert.Contains(emails[0].Body, unconfirmed.Token)
+
+	confirm := func(token string) int {
+		recorder := httptest.NewRecorder()
+		subscriptions(recorder, httptest.NewRequest(http.MethodPost, "/api/subscriptions/"+token+"/confirm", nil))
+		return recorder.Code
+	}
+	assert.Equal(http.StatusNotFound, confirm("unknown"))
+	assert.Equal(http.StatusOK, confirm(unconfirmed.Token))
+	assert.Equal(http.StatusOK, confirm(unconfirmed.Token))
+	followers, err = subscriptionRepository.FindByEntry(ctx, concert.ID)
+	assert.Nil(err)
+	if assert.Len(followers, 1) {
+		assert.NotNil(followers[0].ConfirmedAt)
+	}
+
+	lifecycle := func(id string, body string) int {
+		recorder := httptest.NewRecorder()
+		api.AgendaLifecycleHandler(services)(recorder, httptest.NewRequest(http.MethodPost, "/agenda/"+id+"/lifecycle", strings.NewReader(body)))
+		return recorder.Code
+	}
+	assert.Equal(http.StatusUnprocessableEntity, lifecycle(concert.ID, `{"lifecycle":"postponed","startdate":"next week"}`))
+	assert.Equal(http.StatusUnprocessableEntity, lifecycle(concert.ID, `{"lifecycle":"closed"}`))
+	assert.Equal(http.StatusNotFound, lifecycle("unknown", `{"lifecycle":"soldout"}`))
+	assert.Equal(http.StatusOK, lifecycle(concert.ID, `{"lifecycle":"soldout"}`))
+	stored, _ := agendaRepository.FindByID(ctx, concert.ID)
+	assert.Equal(db.Lifecycle_SoldOut, stored.EventLifecycleStatus)
+	emails, _ = repository.NewOutboxRepository(localDb).Find(ctx, db.OutboxStatus_Pending, 10)
+	if assert.NotEmpty(emails) {
+		assert.Equal("event_lifecycle", emails[0].Template)
+	}
+
+	// the notices can't be enqueued: the lifecycle and the subscription aren't saved either
+	_, err = localDb.Exec(`ALTER TABLE email_outbox RENAME TO email_outbox_off`)
+	assert.Nil(err)
+	assert.Equal(http.StatusInternalServerError, lifecycle(concert.ID, `{"lifecycle":"cancelled"}`))
+	stored, _ = agendaRepository.FindByID(ctx, concert.ID)
+	assert.Equal(db.Lifecycle_SoldOut, stored.EventLifecycleStatus)
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/subscriptions", strings.NewReader(`{"entryId":"`+concert.ID+`","email":"ama@example.ch"}`))
+	req.Header.Set("X-Real-IP", "192.0.2.19")
+	subscriptions(recorder, req)
+	assert.Equal(http.StatusInternalServerError, recorder.Code)
+	var subscribed int
+	assert.Nil(localDb.QueryRow(`SELECT COUNT(*) FROM event_subscription WHERE email = 'ama@example.ch'`).Scan(&subscribed))
+	assert.Equal(0, subscribed)
+	_, err = localDb.Exec(`ALTER TABLE email_outbox_off RENAME TO email_outbox`)
+	assert.Nil(err)
+
+	recorder = httptest.NewRecorder()
+	subscriptions(recorder, httptest.NewRequest(http.MethodDelete, "/api/subscriptions/"+followers[0].Token, nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	recorder = httptest.NewRecorder()
+	subscriptions(recorder, httptest.NewRequest(http.MethodDelete, "/api/subscriptions/"+followers[0].Token, nil))
+	assert.Equal(http.StatusNotFound, recorder.Code)
+}
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"context"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	gendb "dpatrov/scraper/internal/gendb"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubmissionStatusMoves(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(db.SubmissionStatus_Unconfirmed.CanMoveTo(db.SubmissionStatus_Pending))
+	assert.True(db.SubmissionStatus_Pending.CanMoveTo(db.SubmissionStatus_ChangesRequested))
+	assert.True(db.SubmissionStatus_ChangesRequested.CanMoveTo(db.SubmissionStatus_Pending))
+	assert.False(db.SubmissionStatus_Unconfirmed.CanMoveTo(db.SubmissionStatus_Active))
+	assert.False(db.SubmissionStatus_Rejected.CanMoveTo(db.SubmissionStatus_Active))
+	assert.False(db.SubmissionStatus("unknown").CanMoveTo(db.SubmissionStatus_Pending))
+	// the email is confirmed once, a submission waiting for changes is not confirmed back to pending
+	assert.Nil(db.SubmissionConfirmation("submission", "unconfirmed"))
+	assert.True(errors.Is(db.SubmissionConfirmation("submission", "pending"), db.ErrInvalidTransition))
+	assert.True(errors.Is(db.SubmissionConfirmation("submission", "changes_requested"), db.ErrInvalidTransition))
+}
+
+func TestModerationActions(t *testing.T) {
+	assert := assert.New(t)
+	localDb := migratedDB(t)
+	queries := gendb.New(localDb)
+	assert.Nil(queries.CreateFormSubmission(context.Background(), gendb.CreateFormSubmissionParams{
+		ID:                "submission",
+		Email:             "kora@example.ch",
+		Data:              `{"title":"Concert"}`,
+		EditToken:         "edit",
+		CancelToken:       "cancel",
+		ConfirmationToken: "confirm",
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+		ExpiredAt:         time.Now().Add(24 * time.Hour),
+		Status:            string(db.SubmissionStatus_Pending),
+	}))
+	// the templates of the emails are read from the root of the project
+	t.Chdir("..")
+
+	services := api.NewServiceMiddleWare(localDb)
+	handler := api.AdminActionHandler(services)
+	post := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/agenda/admin", strings.NewReader(body)))
+		return recorder
+	}
+
+	assert.Equal(http.StatusBadRequest, post(`{"action":"archive","token":"edit"}`).Code)
+	assert.Equal(http.StatusUnprocessableEntity, post(`{"action":"request_changes","token":"edit"}`).Code)
+	assert.Equal(http.StatusNotFound, post(`{"action":"reject","token":"unknown","reason":"Spam"}`).Code)
+	assert.Equal(http.StatusOK, post(`{"action":"request_changes","token":"edit","comment":"Add the price"}`).Code)
+	assert.Equal(http.StatusCreated, post(`{"action":"note","token":"edit","note":"Asked for the price"}`).Code)
+	assert.Equal(http.StatusUnprocessableEntity, post(`{"action":"assign","token":"edit","assignedTo":1000}`).Code)
+
+	// the submitter sees the requested changes on the edit page
+	recorder := httptest.NewRecorder()
+	api.SubmissionHandler(services)(recorder, httptest.NewRequest(http.MethodGet, "/api/submissions/edit", nil))
+	var visitorRequest api.VisitorFormRequest
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(&visitorRequest))
+	assert.Equal("changes_requested", visitorRequest.Status)
+	assert.Equal("Add the price", visitorRequest.Comment)
+
+	assert.Equal(http.StatusOK, post(`{"action":"reject","token":"edit","reason":"Not an afro event"}`).Code)
+	// a rejected submission is not published
+	assert.Equal(http.StatusConflict, post(`{"action":"publish","token":"edit","formData":{"title":"Concert"}}`).Code)
+	assert.Equal(http.StatusConflict, post(`{"action":"request_changes","token":"edit","comment":"Again"}`).Code)
+	// a moderation from a status read before another moderator moved it updates nothing
+	updated, err := queries.UpdateSubmissionModeration(context.Background(), gendb.UpdateSubmissionModerationParams{
+		Status:            string(db.SubmissionStatus_ChangesRequested),
+		ModerationComment: "Stale",
+		UpdatedAt:         time.Now(),
+		ID:                "submission",
+		Status_2:          string(db.SubmissionStatus_Pending),
+	})
+	assert.Nil(err)
+	assert.Equal(int64(0), updated)
+
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/agenda/admin?token=edit", nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	var response struct {
+		Data api.SubmissionModeration `json:"data"`
+	}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Equal("rejected", response.Data.Status)
+	assert.Equal("Not an afro event", response.Data.Comment)
+	if assert.Len(response.Data.Notes, 1) {
+		assert.Equal("Asked for the price", response.Data.Notes[0].Body)
+	}
+}
@@ -0,0 +1,89 @@
+package test
+
+import (
+	"context"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlugify(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("collectif-kora-cie", repository.Slugify("Collectif Kora & Cie"))
+	assert.Equal("l-ete-a-geneve", repository.Slugify(" L'Été à Genève! "))
+	assert.Equal("", repository.Slugify("?!"))
+}
+
+func TestOrganizers(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	organizerRepository := repository.NewOrganizerRepository(localDb)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+
+	kora := db.Organizer{Name: "Collectif Kora", Email: "kora@example.ch", Links: []string{"https://kora.ch", " "}}
+	assert.Nil(organizerRepository.Create(ctx, &kora))
+	assert.Equal("collectif-kora", kora.Slug)
+	assert.Equal([]string{"https://kora.ch"}, kora.Links)
+	homonym := db.Organizer{Name: "Collectif  Kora"}
+	assert.Nil(organizerRepository.Create(ctx, &homonym))
+	assert.Equal("collectif-kora-2", homonym.Slug)
+
+	for _, invalid := range []db.Organizer{{Name: " "}, {Name: "Kora", Email: "kora"}, {Name: "Kora", Links: []string{"javascript:alert(1)"}}} {
+		assert.True(errors.Is(organizerRepository.Create(ctx, &invalid), repository.ErrInvalidOrganizer))
+	}
+	homonym.Slug = "collectif-kora"
+	assert.True(errors.Is(organizerRepository.Update(ctx, homonym), repository.ErrOrganizerExists))
+
+	day := func(days int) time.Time { return time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, days) }
+	for _, entry := range []db.AgendaEntry{
+		{Title: "Concert", StartDate: day(3), OrganizerID: kora.ID, Status: db.Status_Active},
+		{Title: "Festival", StartDate: day(-2), EndDate: day(2), OrganizerID: kora.ID, Status: db.Status_Active},
+		{Title: "Bal", StartDate: day(-10), OrganizerID: kora.ID, Status: db.Status_Archived},
+		{Title: "Pending", StartDate: day(5), OrganizerID: kora.ID, Status: db.Status_Pending},
+		{Title: "Other", StartDate: day(3), Status: db.Status_Active},
+	} {
+		_, err := agendaRepository.Create(ctx, &entry)
+		assert.Nil(err)
+	}
+	_, err := agendaRepository.Create(ctx, &db.AgendaEntry{Title: "Unknown", StartDate: day(1), OrganizerID: 1000})
+	assert.True(errors.Is(err, repository.ErrNoOrganizerFound))
+
+	handler := api.OrganizersHandler(api.NewServiceMiddleWare(localDb))
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/api/organizers/collectif-kora", nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.NotContains(recorder.Body.String(), "kora@example.ch")
+	var response struct {
+		Data api.OrganizerDetail `json:"data"`
+	}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(&response))
+	titles := func(entries []db.AgendaEntry) []string {
+		result := []string{}
+		for _, entry := range entries {
+			result = append(result, entry.Title)
+		}
+		return result
+	}
+	assert.Equal("Collectif Kora", response.Data.Name)
+	assert.Equal([]string{"Festival", "Concert"}, titles(response.Data.Upcoming))
+	assert.Equal([]string{"Bal"}, titles(response.Data.Past))
+
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/api/organizers/unknown", nil))
+	assert.Equal(http.StatusNotFound, recorder.Code)
+
+	// the events stay without organizer
+	assert.Nil(organizerRepository.Delete(ctx, kora.ID))
+	page, err := agendaRepository.Find(ctx, repository.AgendaQuery{OrganizerID: kora.ID})
+	assert.Nil(err)
+	assert.Empty(page.Entries)
+}
@@ -0,0 +1,332 @@
+package test
+
+import (
+	"context"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	gendb "dpatrov/scraper/internal/gendb"
+	"dpatrov/scraper/internal/utils"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutboxRetries(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	services := api.NewServiceMiddleWare(localDb)
+	outbox := repository.NewOutboxRepository(localDb)
+
+	sent := []utils.EmailTask{}
+	smtpDown := true
+	services.Mailer().WithSender(func(task utils.EmailTask) error {
+		if smtpDown {
+			return errors.New("connection refused")
+		}
+		sent = append(sent, task)
+		return nil
+	})
+
+	// a rolled back transaction keeps no email
+	tx, err := localDb.Begin()
+	assert.Nil(err)
+	assert.Nil(services.Mailer().Enqueue(ctx, tx, utils.EmailTask{To: "kora@example.ch", Subject: "Lost", Body: "<p>Lost</p>"}))
+	assert.Nil(tx.Rollback())
+	emails, _ := outbox.Find(ctx, "", 10)
+	assert.Empty(emails)
+
+	email := db.OutboxEmail{Recipient: "kora@example.ch", Subject: "Publication", Body: "<p>Publié</p>", Template: "submission_accepted"}
+	assert.Nil(outbox.Enqueue(ctx, &email))
+
+	assert.Equal(time.Minute, repository.OutboxBackoff(1))
+	assert.Equal(4*time.Minute, repository.OutboxBackoff(3))
+	assert.Equal(6*time.Hour, repository.OutboxBackoff(20))
+
+	// every failure waits twice as long, then the email goes dead
+	now := time.Now()
+	for attempt := 1; attempt <= repository.OutboxMaxAttempts; attempt++ {
+		count, err := services.Mailer().SendDue(ctx, now)
+		assert.Nil(err)
+		assert.Equal(0, count)
+		saved, _ := outbox.FindByID(ctx, email.ID)
+		assert.Equal(attempt, saved.Attempts)
+		assert.Equal("connection refused", saved.LastError)
+		if attempt < repository.OutboxMaxAttempts {
+			assert.Equal(db.OutboxStatus_Pending, saved.Status)
+			// not due before the backoff
+			count, _ = services.Mailer().SendDue(ctx, now)
+			assert.Equal(0, count)
+			retried, _ := outbox.FindByID(ctx, email.ID)
+			assert.Equal(attempt, retried.Attempts)
+		} else {
+			assert.Equal(db.OutboxStatus_Dead, saved.Status)
+		}
+		now = saved.NextAttemptAt.Add(time.Second)
+	}
+	count, _ := services.Mailer().SendDue(ctx, now.Add(24*time.Hour))
+	assert.Equal(0, count)
+
+	handler := api.OutboxHandler(services)
+	request := func(method string, path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(method, path, nil))
+		return recorder
+	}
+	recorder := request(http.MethodGet, "/outbox?status=dead")
+	assert.Equal(http.StatusOK, recorder.Code)
+	var response struct{ Data []db.OutboxEmail }
+	assert.Nil(json.Unmarshal(recorder.Body.Bytes(), &response))
+	if assert.Len(response.Data, 1) {
+		assert.Equal(email.ID, response.Data[0].ID)
+		assert.Empty(response.Data[0].Body)
+	}
+	assert.Equal(http.StatusBadRequest, request(http.MethodGet, "/outbox?status=lost").Code)
+	assert.Equal(http.StatusNotFound, request(http.MethodGet, "/outbox/1000").Code)
+	assert.Equal(http.StatusOK, request(http.MethodGet, "/outbox/1").Code)
+
+	// the SMTP is back, the dead email is sent again
+	smtpDown = false
+	assert.Equal(http.StatusOK, request(http.MethodPost, "/outbox/1/resend").Code)
+	count, err = services.Mailer().SendDue(ctx, time.Now().Add(time.Second))
+	assert.Nil(err)
+	assert.Equal(1, count)
+	if assert.Len(sent, 1) {
+		assert.Equal("<p>Publié</p>", sent[0].Body)
+	}
+	saved, _ := outbox.FindByID(ctx, email.ID)
+	assert.Equal(db.OutboxStatus_Sent, saved.Status)
+	assert.NotNil(saved.SentAt)
+	assert.Equal(http.StatusConflict, request(http.MethodPost, "/outbox/1/resend").Code)
+}
+
+// fakeSMTP answers dataReply once the message is written
+func fakeSMTP(t *testing.T, dataReply string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				text := textproto.NewConn(conn)
+				text.PrintfLine("220 localhost")
+				for {
+					line, err := text.ReadLine()
+					if err != nil {
+						return
+					}
+					switch command := strings.ToUpper(strings.Fields(line + " ")[0]); command {
+					case "DATA":
+						text.PrintfLine("354 go ahead")
+						if _, err := text.ReadDotBytes(); err != nil {
+							return
+						}
+						text.PrintfLine("%s", dataReply)
+					case "QUIT":
+						text.PrintfLine("221 bye")
+						return
+					default:
+						text.PrintfLine("250 ok")
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestOutboxRefusedData(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	services := api.NewServiceMiddleWare(localDb)
+	outbox := repository.NewOutboxRepository(localDb)
+
+	dataReply := "554 5.7.1 message refused"
+	addr := fakeSMTP(t, dataReply)
+	accepted := fakeSMTP(t, "250 queued")
+	services.Mailer().WithSender(func(task utils.EmailTask) error {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return err
+		}
+		client, err := smtp.NewClient(conn, "localhost")
+		if err != nil {
+			return err
+		}
+		return utils.SendSMTP(client, "agenda@example.ch", task)
+	})
+
+	email := db.OutboxEmail{Recipient: "kora@example.ch", Subject: "Publication", Body: "<p>Publié</p>", Template: "submission_accepted"}
+	assert.Nil(outbox.Enqueue(ctx, &email))
+
+	// the server refuses the message once it is written, the email is retried
+	now := time.Now()
+	count, err := services.Mailer().SendDue(ctx, now)
+	assert.Nil(err)
+	assert.Equal(0, count)
+	saved, _ := outbox.FindByID(ctx, email.ID)
+	assert.Equal(db.OutboxStatus_Pending, saved.Status)
+	assert.Equal(1, saved.Attempts)
+	assert.Contains(saved.LastError, dataReply[4:])
+
+	addr = accepted
+	count, err = services.Mailer().SendDue(ctx, saved.NextAttemptAt.Add(time.Second))
+	assert.Nil(err)
+	assert.Equal(1, count)
+	saved, _ = outbox.FindByID(ctx, email.ID)
+	assert.Equal(db.OutboxStatus_Sent, saved.Status)
+}
+
+func TestMailerStop(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	services := api.NewServiceMiddleWare(localDb)
+	outbox := repository.NewOutboxRepository(localDb)
+
+	sending, release := make(chan struct{}), make(chan struct{})
+	services.Mailer().WithSender(func(task utils.EmailTask) error {
+		close(sending)
+		<-release
+		return nil
+	})
+	email := db.OutboxEmail{Recipient: "kora@example.ch", Subject: "Publication", Body: "<p>Publié</p>", Template: "submission_accepted"}
+	assert.Nil(outbox.Enqueue(ctx, &email))
+	services.Mailer().Start(ctx)
+	<-sending
+
+	// Stop waits for the email being sent and saves it as sent
+	stopped := make(chan struct{})
+	go func() {
+		services.Mailer().Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("the mailer stopped while sending")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+	saved, _ := outbox.FindByID(ctx, email.ID)
+	assert.Equal(db.OutboxStatus_Sent, saved.Status)
+}
+
+func TestConfirmationOutbox(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	queries := gendb.New(localDb)
+	for _, token := range []string{"confirm", "confirm-later"} {
+		assert.Nil(queries.CreateFormSubmission(ctx, gendb.CreateFormSubmissionParams{
+			ID:                "submission-" + token,
+			Email:             "kora@example.ch",
+			Data:              `{"title":"Concert"}`,
+			EditToken:         "edit-" + token,
+			CancelToken:       "cancel-" + token,
+			ConfirmationToken: token,
+			CreatedAt:         time.Now(),
+			UpdatedAt:         time.Now(),
+			ExpiredAt:         time.Now().Add(24 * time.Hour),
+			Status:            string(db.SubmissionStatus_Unconfirmed),
+		}))
+	}
+	// the templates are read from the root of the project
+	t.Chdir("..")
+	services := api.NewServiceMiddleWare(localDb)
+	handler := api.ConfirmSubmission(services)
+	confirm := func(token string) int {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/submission/confirm", strings.NewReader(`{"token":"`+token+`"}`)))
+		return recorder.Code
+	}
+
+	assert.Equal(http.StatusOK, confirm("confirm"))
+	emails, _ := repository.NewOutboxRepository(localDb).Find(ctx, db.OutboxStatus_Pending, 10)
+	if assert.Len(emails, 1) {
+		assert.Equal("actions_email", emails[0].Template)
+		assert.Equal("kora@example.ch", emails[0].Recipient)
+		assert.Contains(emails[0].Body, "edit-confirm")
+	}
+
+	// the confirmation is not kept without its email
+	_, err := localDb.Exec(`DROP TABLE email_outbox`)
+	assert.Nil(err)
+	assert.Equal(http.StatusInternalServerError, confirm("confirm-later"))
+	submission, _ := queries.GetSubmissionByToken(ctx, gendb.GetSubmissionByTokenParams{ConfirmationToken: "confirm-later"})
+	assert.Equal(string(db.SubmissionStatus_Unconfirmed), submission.Status)
+}
+
+func TestPublishOutbox(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	queries := gendb.New(localDb)
+	for _, token := range []string{"publish", "publish-later"} {
+		assert.Nil(queries.CreateFormSubmission(ctx, gendb.CreateFormSubmissionParams{
+			ID:                "submission-" + token,
+			Email:             "kora@example.ch",
+			Data:              `{"title":"Concert"}`,
+			EditToken:         token,
+			CancelToken:       "cancel-" + token,
+			ConfirmationToken: "confirm-" + token,
+			CreatedAt:         time.Now(),
+			UpdatedAt:         time.Now(),
+			ExpiredAt:         time.Now().Add(24 * time.Hour),
+			Status:            string(db.SubmissionStatus_Pending),
+		}))
+	}
+	services := api.NewServiceMiddleWare(localDb)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	handler := api.AdminActionHandler(services)
+	publish := func(token string) int {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/agenda/admin", strings.NewReader(`{"action":"publish","token":"`+token+`",
+			"formData":{"title":"Concert de kora","address":"Rue de Genève 12","venuename":"Le Romandie","place":"Lausanne",
+			"price":"20","category":"concert","startdate":"2025-10-15","starttime":"20:00"}}`)))
+		return recorder.Code
+	}
+	published := func(token string) bool {
+		_, err := agendaRepository.FindByID(ctx, "submission-"+token)
+		return err == nil
+	}
+
+	// without its email template, nothing is published
+	assert.Equal(http.StatusInternalServerError, publish("publish"))
+	assert.False(published("publish"))
+
+	// the templates are read from the root of the project
+	t.Chdir("..")
+	assert.Equal(http.StatusOK, publish("publish"))
+	assert.True(published("publish"))
+	emails, _ := repository.NewOutboxRepository(localDb).Find(ctx, db.OutboxStatus_Pending, 10)
+	if assert.Len(emails, 1) {
+		assert.Equal("submission_accepted", emails[0].Template)
+	}
+
+	// the email can't be queued: neither the entry nor the submission are kept
+	_, err := localDb.Exec(`DROP TABLE email_outbox`)
+	assert.Nil(err)
+	assert.Equal(http.StatusInternalServerError, publish("publish-later"))
+	assert.False(published("publish-later"))
+	submission, _ := queries.GetSubmissionByToken(ctx, gendb.GetSubmissionByTokenParams{EditToken: "publish-later"})
+	assert.Equal(string(db.SubmissionStatus_Pending), submission.Status)
+}
@@ -0,0 +1,168 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffStructs(t *testing.T) {
+	assert := assert.New(t)
+	old := db.AgendaEntry{Title: "Concert", Price: "10", Tags: []string{"jazz"}, UpdatedAt: time.Now()}
+	new := db.AgendaEntry{Title: "Concert", Price: "15", Tags: []string{"jazz", "live"}}
+	changes := utils.DiffStructs(old, new, "updatedat")
+	assert.Len(changes, 2)
+	assert.Equal(utils.FieldChange{Old: "10", New: "15"}, changes["price"])
+	assert.Contains(changes, "tags")
+	assert.Empty(utils.DiffStructs(old, &old))
+}
+
+func TestAgendaRevisions(t *testing.T) {
+	assert := assert.New(t)
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	ctx := repository.WithActor(context.Background(), 7)
+
+	entry := db.AgendaEntry{Title: "Concert", Price: "10", StartDate: time.Now(), Status: db.Status_Pending}
+	_, err := agendaRepository.Create(ctx, &entry)
+	assert.Nil(err)
+	entry.Price = "15"
+	entry.Title = "Bad edit"
+	assert.Nil(agendaRepository.Update(ctx, entry))
+	assert.Nil(agendaRepository.UpdateStatus(context.Background(), entry.ID, int(db.Status_Active)))
+
+	revisions, err := agendaRepository.FindRevisions(ctx, entry.ID)
+	assert.Nil(err)
+	if !assert.Len(revisions, 3) {
+		return
+	}
+	assert.Equal(repository.RevisionStatus, revisions[0].Action)
+	assert.Zero(revisions[0].UserID)
+	assert.Equal(repository.RevisionCreate, revisions[2].Action)
+	assert.Equal(7, revisions[2].UserID)
+	assert.Equal("Concert", revisions[2].Entry.Title)
+
+	services := api.NewServiceMiddleWare(localDb)
+	handler := api.AgendaRevisionHandler(services)
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet,
+		fmt.Sprintf("/agenda/%s/revisions/diff?from=%d&to=%d", entry.ID, revisions[2].ID, revisions[1].ID), nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	var response struct {
+		Data api.RevisionDiff `json:"data"`
+	}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(&response))
+	assert.Equal(map[string]utils.FieldChange{
+		"title": {Old: "Concert", New: "Bad edit"},
+		"price": {Old: "10", New: "15"},
+	}, response.Data.Changes)
+
+	// undo the bad edit, the status stays a moderation decision of the revision
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/agenda/%s/revisions/%d/restore", entry.ID, revisions[2].ID), nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	restored, _ := agendaRepository.FindByID(ctx, entry.ID)
+	assert.Equal("Concert", restored.Title)
+	assert.Equal("10", restored.Price)
+	assert.Equal(db.Status_Pending, restored.Status)
+	revisions, _ = agendaRepository.FindRevisions(ctx, entry.ID)
+	assert.Equal(repository.RevisionRestore, revisions[0].Action)
+
+	// a deleted entry is created again
+	assert.Nil(agendaRepository.Delete(ctx, entry.ID))
+	revisions, _ = agendaRepository.FindRevisions(ctx, entry.ID)
+	assert.Equal(repository.RevisionDelete, revisions[0].Action)
+	_, err = agendaRepository.Restore(ctx, entry.ID, revisions[0].ID)
+	assert.Nil(err)
+	restored, err = agendaRepository.FindByID(ctx, entry.ID)
+	assert.Nil(err)
+	assert.Equal("Concert", restored.Title)
+
+	_, err = agendaRepository.Restore(ctx, entry.ID, 1000)
+	assert.True(errors.Is(err, repository.ErrNoRevisionFound))
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/agenda/unknown/revisions", nil))
+	assert.Equal(http.StatusNotFound, recorder.Code)
+}
+
+func TestAgendaRevisionBaseline(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+
+	// an entry saved before the revisions
+	entry := db.AgendaEntry{Title: "Expo", StartDate: time.Now(), Status: db.Status_Active}
+	_, err := agendaRepository.Create(ctx, &entry)
+	assert.Nil(err)
+	_, err = localDb.Exec(`DELETE FROM agenda_revision`)
+	assert.Nil(err)
+
+	entry.Title = "Expo photo"
+	assert.Nil(agendaRepository.Update(ctx, entry))
+	revisions, err := agendaRepository.FindRevisions(ctx, entry.ID)
+	assert.Nil(err)
+	if assert.Len(revisions, 2) {
+		assert.Equal(repository.RevisionUpdate, revisions[0].Action)
+		assert.Equal(repository.RevisionInitial, revisions[1].Action)
+		assert.Equal("Expo", revisions[1].Entry.Title)
+	}
+}
+
+func TestAgendaWritesRollback(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	venueRepository := repository.NewVenueRepository(localDb)
+
+	entry := db.AgendaEntry{Title: "Expo", StartDate: time.Now(), VenueName: "Le Chat Noir", Place: "Carouge", Status: db.Status_Active}
+	_, err := agendaRepository.Create(ctx, &entry)
+	assert.Nil(err)
+
+	// the revision can't be saved, nothing else is
+	_, err = localDb.Exec(`CREATE TRIGGER refuse_revision BEFORE INSERT ON agenda_revision BEGIN SELECT RAISE(ABORT, 'revision refused'); END`)
+	assert.Nil(err)
+	changed := entry
+	changed.Title = "Expo photo"
+	changed.VenueName = "L'Usine"
+	changed.VenueID = 0
+	assert.NotNil(agendaRepository.Update(ctx, changed))
+	saved, err := agendaRepository.FindByID(ctx, entry.ID)
+	assert.Nil(err)
+	assert.Equal("Expo", saved.Title)
+	assert.Equal(entry.VenueID, saved.VenueID)
+	_, err = venueRepository.FindByKey(ctx, "L'Usine", "")
+	assert.ErrorIs(err, repository.ErrNoVenueFound)
+
+	created := db.AgendaEntry{Title: "Bal", StartDate: time.Now(), VenueName: "L'Usine", Status: db.Status_Active}
+	_, err = agendaRepository.Create(ctx, &created)
+	assert.NotNil(err)
+	_, err = agendaRepository.FindByID(ctx, created.ID)
+	assert.ErrorIs(err, sql.ErrNoRows)
+	_, err = venueRepository.FindByKey(ctx, "L'Usine", "")
+	assert.ErrorIs(err, repository.ErrNoVenueFound)
+	_, err = localDb.Exec(`DROP TRIGGER refuse_revision`)
+	assert.Nil(err)
+
+	// an unknown or trashed entry isn't updated
+	unknown := db.AgendaEntry{ID: "unknown", Title: "Bal", StartDate: time.Now(), Status: db.Status_Active}
+	assert.ErrorIs(agendaRepository.Update(ctx, unknown), repository.ErrNoAgendaEntryFound)
+	revisions, err := agendaRepository.FindRevisions(ctx, unknown.ID)
+	assert.Nil(err)
+	assert.Empty(revisions)
+	assert.Nil(agendaRepository.Trash(ctx, entry.ID))
+	assert.ErrorIs(agendaRepository.Update(ctx, changed), repository.ErrNoAgendaEntryFound)
+}
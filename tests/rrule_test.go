@@ -0,0 +1,139 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/ical"
+	"dpatrov/scraper/internal/utils"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRRule(t *testing.T) {
+	assert := assert.New(t)
+	rule, err := ical.ParseRRule("RRULE:FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,-1FR;UNTIL=20251231;WKST=MO")
+	assert.Nil(err)
+	assert.Equal(ical.FreqWeekly, rule.Freq)
+	assert.Equal(2, rule.Interval)
+	assert.Equal([]ical.WeekdayNum{{Weekday: time.Tuesday}, {Weekday: time.Friday, N: -1}}, rule.ByDay)
+	assert.Equal("FREQ=WEEKLY;INTERVAL=2;UNTIL=20251231;BYDAY=TU,-1FR", rule.String())
+
+	for _, value := range []string{"", "BYDAY=MO", "FREQ=HOURLY", "FREQ=DAILY;COUNT=0", "FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=DAILY;COUNT=2;UNTIL=20251231", "FREQ=DAILY;BYSETPOS=1", "FREQ=MONTHLY;BYMONTHDAY=32"} {
+		_, err := ical.ParseRRule(value)
+		assert.True(errors.Is(err, ical.ErrInvalidRule), value)
+	}
+}
+
+func TestRRuleOccurrences(t *testing.T) {
+	assert := assert.New(t)
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+	}
+	dates := func(value string, start time.Time, exdates []time.Time, horizon time.Time) []string {
+		rule, err := ical.ParseRRule(value)
+		assert.Nil(err)
+		result := []string{}
+		for _, occurrence := range rule.Occurrences(start, exdates, horizon, 0) {
+			result = append(result, occurrence.Format("2006-01-02"))
+		}
+		return result
+	}
+
+	// tuesdays and thursdays from tuesday 7 october
+	assert.Equal([]string{"2025-10-07", "2025-10-09", "2025-10-14", "2025-10-16"},
+		dates("FREQ=WEEKLY;BYDAY=TU,TH", day(2025, 10, 7), nil, day(2025, 10, 20)))
+	// every other week, the weekday of the start
+	assert.Equal([]string{"2025-10-07", "2025-10-21", "2025-11-04"},
+		dates("FREQ=WEEKLY;INTERVAL=2", day(2025, 10, 7), nil, day(2025, 11, 10)))
+	// last friday of the month
+	assert.Equal([]string{"2025-10-31", "2025-11-28", "2025-12-26"},
+		dates("FREQ=MONTHLY;BYDAY=-1FR", day(2025, 10, 31), nil, day(2025, 12, 31)))
+	// the excluded dates count in COUNT
+	assert.Equal([]string{"2025-10-01", "2025-10-03"},
+		dates("FREQ=DAILY;COUNT=3", day(2025, 10, 1), []time.Time{day(2025, 10, 2)}, day(2026, 1, 1)))
+	assert.Equal([]string{"2025-10-30", "2025-10-31"},
+		dates("FREQ=DAILY;UNTIL=20251031", day(2025, 10, 30), nil, day(2026, 1, 1)))
+	assert.Equal([]string{"2024-02-29", "2028-02-29"},
+		dates("FREQ=YEARLY", day(2024, 2, 29), nil, day(2029, 1, 1)))
+}
+
+func TestRecurringAgendaEntry(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	now := time.Date(2025, 10, 15, 10, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2025, 10, d, 0, 0, 0, 0, time.UTC) }
+
+	// a weekly workshop on wednesdays from 1 october, not on the 22
+	workshop := db.AgendaEntry{Title: "Atelier", StartDate: day(1), Category: "atelier", Status: db.Status_Active,
+		RRule: "FREQ=WEEKLY;COUNT=5", ExDates: []string{"2025-10-22"}}
+	assert.Nil(workshop.Validate())
+	_, err := agendaRepository.Create(ctx, &workshop)
+	assert.Nil(err)
+	concert := db.AgendaEntry{Title: "Concert", StartDate: day(16), EndDate: day(16), Category: "concert", Status: db.Status_Active}
+	_, err = agendaRepository.Create(ctx, &concert)
+	assert.Nil(err)
+	assert.Nil(agendaRepository.SaveOccurrences(ctx, workshop, now))
+
+	entry, err := agendaRepository.FindByID(ctx, workshop.ID)
+	assert.Nil(err)
+	assert.Equal("FREQ=WEEKLY;COUNT=5", entry.RRule)
+	assert.Equal([]string{"2025-10-22"}, entry.ExDates)
+
+	// each occurrence is listed in a window
+	page, err := agendaRepository.Find(ctx, repository.AgendaQuery{From: day(10), To: day(31), Statuses: []db.Status{db.Status_Active}})
+	assert.Nil(err)
+	var listed []string
+	for _, entry := range page.Entries {
+		listed = append(listed, entry.Title+" "+entry.StartDate.Format("02"))
+	}
+	assert.Equal([]string{"Atelier 15", "Concert 16", "Atelier 29"}, listed)
+
+	counts, err := utils.NewEventFacetCounts(localDb).Current(ctx, now)
+	assert.Nil(err)
+	assert.Equal(1, counts.Today)
+	assert.Equal(2, counts.ThisWeek)
+	assert.Equal(0, counts.NextWeek)
+	assert.Equal(map[string]int{"atelier": 1, "concert": 1}, counts.Categories)
+
+	// the series is a single event of the calendar
+	var buffer bytes.Buffer
+	assert.Nil(ical.Write(&buffer, "Afromemo", []ical.Event{api.EntryEvent(entry)}))
+	assert.Contains(buffer.String(), "RRULE:FREQ=WEEKLY;COUNT=5\r\n")
+	assert.Contains(buffer.String(), "EXDATE;TZID=Europe/Zurich:20251022T000000\r\n")
+
+	// the occurrences are archived one by one, then the series after the last one
+	archiver := utils.NewEventArchiver(localDb, time.Hour)
+	assert.Nil(archiver.ArchivePastEvents(ctx, now))
+	var active []string
+	rows, err := localDb.Query(`SELECT date(startdate) FROM agenda_occurrence WHERE status = 1 ORDER BY startdate`)
+	assert.Nil(err)
+	for rows.Next() {
+		var date string
+		assert.Nil(rows.Scan(&date))
+		active = append(active, date)
+	}
+	rows.Close()
+	assert.Equal([]string{"2025-10-15", "2025-10-29"}, active)
+	entry, _ = agendaRepository.FindByID(ctx, workshop.ID)
+	assert.Equal(db.Status_Active, entry.Status)
+	stored, _ := agendaRepository.FindByID(ctx, concert.ID)
+	assert.Equal(db.Status_Active, stored.Status)
+
+	assert.Nil(archiver.ArchivePastEvents(ctx, day(31)))
+	var count int
+	assert.Nil(localDb.QueryRow(`SELECT COUNT(*) FROM agenda_occurrence WHERE status = 1`).Scan(&count))
+	assert.Equal(0, count)
+	entry, _ = agendaRepository.FindByID(ctx, workshop.ID)
+	assert.Equal(db.Status_Archived, entry.Status)
+	stored, _ = agendaRepository.FindByID(ctx, concert.ID)
+	assert.Equal(db.Status_Archived, stored.Status)
+}
@@ -1,13 +1,18 @@
 package test
 
 import (
+	"context"
+	"dpatrov/scraper/internal/db"
 	"dpatrov/scraper/internal/types"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"dpatrov/scraper/internal/scraper"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
 )
 
 func TestLoadTaskFile(t *testing.T) {
@@ -36,8 +41,175 @@ func TestVisistTask(t *testing.T) {
 	if err != nil {
 		fmt.Println("error", err)
 	}
-	result := scraper.HandleScrapTask(scraperTask)
+	result, err := scraper.HandleScrapTask(context.Background(), scraperTask)
+	if err != nil {
+		fmt.Println("error", err)
+	}
 	// result
 	fmt.Println(result)
 
 }
+
+const AGENDA_TEMPLATE = `<html><body>
+	<div class="event">
+		<h2><a href="/evenement.php?idE=1">  Concert
+			Kora  </a></h2>
+		<time datetime="2025-10-12">dimanche 12 octobre</time>
+		<span class="heure">20h30</span>
+		<p class="lieu">Le Chat Noir</p>
+		<p class="prix">Prix: 15.- CHF</p>
+		<img src="/images/affiche.jpg">
+		<ul><li>Jazz</li><li>World</li></ul>
+	</div>
+	<div class="event">
+		<h2><a href="/evenement.php?idE=2">Atelier danse</a></h2>
+		<time datetime="">1er novembre</time>
+		<span class="heure">14h00</span>
+		<p class="lieu">Salle du Faubourg</p>
+		<p class="prix">Entrée libre</p>
+	</div>
+	<div class="event">
+		<h2><a href="/evenement.php?idE=3">Sans date</a></h2>
+	</div>
+</body></html>`
+
+func TestExtractEntries(t *testing.T) {
+	assert := assert.New(t)
+	task := types.ScrapingTask{
+		Url:          "https://www.ladecadanse.ch/agenda.php",
+		ItemSelector: "div.event",
+		ScrapingMap: types.ScrapingMap{
+			"h2 > a":     "title",
+			"h2 a[href]": "link",
+			"time":       "startdate",
+			".heure":     "starttime",
+			".lieu":      "venuename",
+			".prix":      "price",
+			"img":        "poster",
+			"li":         "tags",
+		},
+		Converters: map[string][]types.Converter{
+			"title":     {{Type: "trim"}},
+			"link":      {{Type: "attr", Name: "href"}, {Type: "url"}},
+			"startdate": {{Type: "date", Layouts: []string{"Monday 2 January", "2 January"}, Locale: "fr"}},
+			"starttime": {{Type: "time", Layouts: []string{"15h04"}}},
+			"price":     {{Type: "replace", Pattern: `(?i)entrée libre`, Replacement: "0"}, {Type: "regex", Pattern: `(\d+(?:[.,]\d+)?)`}},
+			"poster":    {{Type: "attr", Name: "src"}, {Type: "url"}},
+		},
+		Defaults: map[string]string{
+			"address":  "Rue Vautier 13",
+			"place":    "Genève",
+			"category": "concert",
+		},
+	}
+	assert.Nil(scraper.ValidateScrapingTask(task))
+
+	doc, err := html.Parse(strings.NewReader(AGENDA_TEMPLATE))
+	assert.Nil(err)
+	result := scraper.ExtractEntries(task, scraper.NewNodeWrapper(doc))
+
+	assert.Equal(3, len(result.Records))
+	assert.Equal(2, len(result.Entries))
+	assert.Equal(1, len(result.Invalid))
+
+	concert := result.Entries[0]
+	assert.Equal("Concert Kora", concert.Title)
+	assert.Equal("https://www.ladecadanse.ch/evenement.php?idE=1", concert.Link)
+	assert.Equal("15", concert.Price)
+	assert.Equal("https://www.ladecadanse.ch/images/affiche.jpg", concert.Poster)
+	assert.Equal([]string{"Jazz", "World"}, concert.Tags)
+	assert.Equal(time.October, concert.StartDate.Month())
+	assert.Equal(12, concert.StartDate.Day())
+	assert.Equal("20:30", concert.StartTime.Format("15:04"))
+	assert.Equal(db.Status_Pending, concert.Status)
+
+	workshop := result.Entries[1]
+	assert.Equal("0", workshop.Price)
+	assert.Equal(time.November, workshop.StartDate.Month())
+	assert.Equal(1, workshop.StartDate.Day())
+
+	assert.Contains(result.Invalid[0].Errors, "startdate")
+
+	// unknown fields and converters are rejected
+	task.ScrapingMap["h1"] = "unknown"
+	task.Converters["title"] = []types.Converter{{Type: "uppercase"}}
+	assert.NotNil(scraper.ValidateScrapingTask(task))
+}
+
+const STRUCTURED_TEMPLATE = `<html><head>
+<script type="application/ld+json">
+{"@context": "https://schema.org", "@graph": [
+	{"@type": "WebPage", "name": "Agenda"},
+	{"@type": "MusicEvent", "name": "Concert Kora", "url": "/evenement/1",
+	 "startDate": "2025-10-12T20:30:00+02:00", "endDate": "2025-10-12T23:00:00+02:00",
+	 "image": {"@type": "ImageObject", "url": "/images/kora.jpg"},
+	 "location": {"@type": "Place", "name": "Le Chat Noir",
+		"address": {"@type": "PostalAddress", "streetAddress": "Rue Vautier 13", "postalCode": "1227", "addressLocality": "Carouge"}},
+	 "offers": [{"@type": "Offer", "price": 25, "priceCurrency": "CHF"}],
+	 "keywords": ["Jazz", "World"]}
+]}
+</script>
+</head><body>
+<div itemscope itemtype="https://schema.org/TheaterEvent">
+	<h2 itemprop="name">Le Bal</h2>
+	<time itemprop="startDate" datetime="2025-11-01T19:00">1er novembre</time>
+	<div itemprop="location" itemscope itemtype="https://schema.org/Place">
+		<span itemprop="name">Théâtre du Grütli</span>
+		<div itemprop="address" itemscope itemtype="https://schema.org/PostalAddress">
+			<span itemprop="streetAddress">Rue du Général-Dufour 16</span>
+			<span itemprop="addressLocality">Genève</span>
+		</div>
+	</div>
+	<div itemprop="offers" itemscope itemtype="https://schema.org/Offer"><meta itemprop="price" content="30"></div>
+</div>
+<div vocab="https://schema.org/" typeof="Event">
+	<h2 property="name">Atelier Djembé</h2>
+	<meta property="startDate" content="2025-11-08T14:00:00">
+	<div property="location" typeof="Place"><span property="name">Maison de quartier</span>
+		<span property="address">Rue des Pâquis 4</span></div>
+</div>
+</body></html>`
+
+func TestExtractStructuredEntries(t *testing.T) {
+	assert := assert.New(t)
+	task := types.ScrapingTask{
+		Url:      "https://www.chatnoir.ch/agenda",
+		Defaults: map[string]string{"category": "concert", "price": "0", "place": "Genève"},
+	}
+	assert.True(task.IsStructured())
+	assert.Nil(scraper.ValidateScrapingTask(task))
+
+	doc, err := html.Parse(strings.NewReader(STRUCTURED_TEMPLATE))
+	assert.Nil(err)
+	result := scraper.ExtractStructuredEntries(task, scraper.NewNodeWrapper(doc))
+
+	assert.Equal(3, len(result.Records))
+	assert.Equal(3, len(result.Entries), result.Invalid)
+
+	concert := result.Entries[0]
+	assert.Equal("Concert Kora", concert.Title)
+	assert.Equal("https://www.chatnoir.ch/evenement/1", concert.Link)
+	assert.Equal("https://www.chatnoir.ch/images/kora.jpg", concert.Poster)
+	assert.Equal("Le Chat Noir", concert.VenueName)
+	assert.Equal("Rue Vautier 13 1227", concert.Address)
+	assert.Equal("Carouge", concert.Place)
+	assert.Equal("CHF 25", concert.Price)
+	assert.Equal([]string{"Jazz", "World"}, concert.Tags)
+	assert.Equal("2025-10-12", concert.StartDate.Format("2006-01-02"))
+	assert.Equal("20:30", concert.StartTime.Format("15:04"))
+	assert.Equal("23:00", concert.EndTime.Format("15:04"))
+
+	theater := result.Entries[1]
+	assert.Equal("Le Bal", theater.Title)
+	assert.Equal("Théâtre du Grütli", theater.VenueName)
+	assert.Equal("Rue du Général-Dufour 16", theater.Address)
+	assert.Equal("30", theater.Price)
+	assert.Equal("19:00", theater.StartTime.Format("15:04"))
+	assert.Equal(task.Url, theater.Link)
+
+	workshop := result.Entries[2]
+	assert.Equal("Atelier Djembé", workshop.Title)
+	assert.Equal("Maison de quartier", workshop.VenueName)
+	assert.Equal("Rue des Pâquis 4", workshop.Address)
+	assert.Equal("0", workshop.Price)
+}
@@ -2,9 +2,11 @@ package test
 
 import (
 	"dpatrov/scraper/internal/scraper"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
 )
 
 const TEMPLATE = `<div>
@@ -17,6 +19,29 @@ const TEMPLATE = `<div>
 					</div>
 				`
 
+const EVENT_TEMPLATE = `<html><body>
+	<div id="entete_contenu_titre">
+		<a href="/lieu.php?idL=12" class="lieu">Le Chat Noir</a>
+		<a href="/evenement-agenda.php?courant=2025-10-12"><time datetime="2025-10-12">dimanche 12 octobre</time></a>
+	</div>
+	<h3 class="left">Concert <span>Kora</span></h3>
+	<ul class="tags">
+		<li data-tag="jazz">Jazz</li>
+		<li data-tag="world-music" class="current">World</li>
+		<li data-tag="live">Live</li>
+		<li><a href="https://example.org/billets" rel="external nofollow">Billets</a></li>
+	</ul>
+	<p class="adr">Rue Vautier 13, 1227 Carouge</p>
+</body></html>`
+
+func parseTemplate(t *testing.T, content string) *scraper.NodeWrapper {
+	node, err := html.Parse(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to parse template %v", err)
+	}
+	return scraper.NewNodeWrapper(node)
+}
+
 func TestVisit(t *testing.T) {
 
 	t.Run("Node Selector", func(t *testing.T) {
@@ -41,6 +66,73 @@ func TestVisit(t *testing.T) {
 			assert.Equal("#pt-3", selector_2.ID)
 		}
 	})
+
+	t.Run("Invalid Selector", func(t *testing.T) {
+		assert := assert.New(t)
+		for _, selector := range []string{"", "div >", "a[href", "li:unknown", "li:nth-child(x)", "p,"} {
+			_, ok := new(scraper.NodeSelector).Parse(selector)
+			assert.False(ok, "selector %q should not parse", selector)
+		}
+	})
+
+	t.Run("Combinators", func(t *testing.T) {
+		assert := assert.New(t)
+		doc := parseTemplate(t, EVENT_TEMPLATE)
+
+		time := doc.Find("#entete_contenu_titre > a:nth-child(2) > time:nth-child(1)")
+		assert.Equal(1, len(time))
+		assert.Equal("dimanche 12 octobre", time.Eq(0).Text())
+
+		assert.Equal(1, len(doc.Find("body h3.left span")))
+		assert.Equal(0, len(doc.Find("body > span")))
+		assert.Equal("World", doc.Find("li[data-tag=jazz] + li").Eq(0).Text())
+		assert.Equal(3, len(doc.Find("li:first-child ~ li")))
+		assert.Equal(0, len(doc.Find("h3 + p")))
+	})
+
+	t.Run("Attributes", func(t *testing.T) {
+		assert := assert.New(t)
+		doc := parseTemplate(t, EVENT_TEMPLATE)
+
+		assert.Equal(1, len(doc.Find("time[datetime]")))
+		assert.Equal(1, len(doc.Find(`a[href^="https://"]`)))
+		assert.Equal(1, len(doc.Find("a[href$=billets]")))
+		assert.Equal(2, len(doc.Find("a[href*=php]")))
+		assert.Equal(1, len(doc.Find("a[rel~=nofollow]")))
+		assert.Equal(1, len(doc.Find("li[data-tag|=world]")))
+		assert.Equal(1, len(doc.Find("a[class=LIEU i]")))
+	})
+
+	t.Run("Pseudo Classes", func(t *testing.T) {
+		assert := assert.New(t)
+		doc := parseTemplate(t, EVENT_TEMPLATE)
+
+		assert.Equal("Jazz", doc.Find("ul.tags li:first-of-type").Eq(0).Text())
+		assert.Equal("Billets", doc.Find("ul.tags li:last-child").Eq(0).Text())
+		assert.Equal(2, len(doc.Find("ul.tags li:nth-child(odd)")))
+		assert.Equal(2, len(doc.Find("ul.tags li:nth-child(-n+2)")))
+		assert.Equal("Live", doc.Find("li:nth-last-child(2)").Eq(0).Text())
+		assert.Equal(3, len(doc.Find("li:not(.current)")))
+		assert.Equal(2, len(doc.Find("li[data-tag]:not(.current, :last-child)")))
+		assert.Equal(1, len(doc.Find("h3 > span:only-child")))
+	})
+
+	t.Run("Selector Groups", func(t *testing.T) {
+		assert := assert.New(t)
+		doc := parseTemplate(t, EVENT_TEMPLATE)
+
+		nodes := doc.Find("p.adr, h3.left, time")
+		assert.Equal(3, len(nodes))
+		// document order
+		assert.Equal("time", nodes[0].Data)
+		assert.Equal("h3", nodes[1].Data)
+		assert.Equal("p", nodes[2].Data)
+
+		ns, ok := new(scraper.NodeSelector).Parse("ul.tags > li.current, #entete_contenu_titre a")
+		assert.True(ok)
+		assert.Equal("li", ns.NodeType)
+		assert.Equal([]string{".current"}, ns.ClassNames)
+	})
 	/*
 		t.Run("SCRAPER VISIT", func(t *testing.T) {
 			assert := assert.New(t)
@@ -0,0 +1,79 @@
+package test
+
+import (
+	"context"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchAgenda(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	search := api.SearchHandler(api.NewServiceMiddleWare(localDb))
+
+	kora := &db.AgendaEntry{
+		Title:       "Concert Kora",
+		Description: "Une soirée <b>unique</b> avec le trio Kora et ses invités.",
+		VenueName:   "Le Chat Noir",
+		Place:       "Genève",
+		Tags:        []string{"Jazz", "World"},
+		StartDate:   time.Now(),
+		Status:      db.Status_Active,
+	}
+	_, err := agendaRepository.Create(ctx, kora)
+	assert.Nil(err)
+	_, err = agendaRepository.Create(ctx, &db.AgendaEntry{Title: "Concert Kora en attente", Place: "Genève", StartDate: time.Now(), Status: db.Status_Pending})
+	assert.Nil(err)
+	_, err = agendaRepository.Create(ctx, &db.AgendaEntry{Title: "Expo photo", Description: "Un concert en fin de soirée", Place: "Lausanne", StartDate: time.Now(), Status: db.Status_Active})
+	assert.Nil(err)
+
+	type searchResponse struct {
+		Data []api.SearchResult `json:"data"`
+	}
+	find := func(query string) []api.SearchResult {
+		recorder := httptest.NewRecorder()
+		search(recorder, httptest.NewRequest(http.MethodGet, "/api/agenda/search?q="+url.QueryEscape(query), nil))
+		assert.Equal(http.StatusOK, recorder.Code, query)
+		var response searchResponse
+		assert.Nil(json.NewDecoder(recorder.Body).Decode(&response))
+		return response.Data
+	}
+
+	results := find("concert kora Gen")
+	assert.Len(results, 1)
+	assert.Equal(kora.ID, results[0].ID)
+	assert.Equal("<mark>Concert</mark> <mark>Kora</mark>", results[0].TitleHighlight)
+
+	// accents and case are ignored, the title ranks first
+	results = find("CONCERT geneve")
+	assert.Len(results, 1)
+	results = find("concert")
+	assert.Len(results, 2)
+	assert.Equal(kora.ID, results[0].ID)
+	assert.NotContains(find("unique")[0].Snippet, "<b>")
+	assert.Len(find("jazz"), 1)
+	assert.Len(find(`"kora*) (`), 1)
+
+	// the index follows the updates and deletes
+	kora.Title = "Récital"
+	assert.Nil(agendaRepository.Update(ctx, *kora))
+	assert.Len(find("kora trio"), 1)
+	assert.Len(find("recital"), 1)
+	assert.Nil(agendaRepository.Delete(ctx, kora.ID))
+	assert.Len(find("recital"), 0)
+
+	recorder := httptest.NewRecorder()
+	search(recorder, httptest.NewRequest(http.MethodGet, "/api/agenda/search?q=%20!", nil))
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+}
@@ -0,0 +1,267 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/storage"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 a MinIO-style stand-in: one bucket in memory, the signature is checked for its form only
+type fakeS3 struct {
+	mu      sync.Mutex
+	bucket  string
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newFakeS3(t *testing.T, bucket string) *httptest.Server {
+	fake := &fakeS3{bucket: bucket, objects: map[string][]byte{}, types: map[string]string{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (fake *fakeS3) ServeHTTP(writer http.ResponseWriter, req *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	presigned := req.URL.Query().Get("X-Amz-Signature") != ""
+	authorization := req.Header.Get("Authorization")
+	if !presigned && !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=minio/") {
+		http.Error(writer, "<Error><Code>AccessDenied</Code></Error>", http.StatusForbidden)
+		return
+	}
+	key, found := strings.CutPrefix(req.URL.Path, "/"+fake.bucket)
+	if !found {
+		http.Error(writer, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key = strings.TrimPrefix(key, "/")
+	switch {
+	case req.Method == http.MethodPut:
+		body, _ := io.ReadAll(req.Body)
+		hash := sha256.Sum256(body)
+		if req.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(hash[:]) {
+			http.Error(writer, "<Error><Code>XAmzContentSHA256Mismatch</Code></Error>", http.StatusBadRequest)
+			return
+		}
+		fake.objects[key] = body
+		fake.types[key] = req.Header.Get("Content-Type")
+	case req.Method == http.MethodGet && key == "":
+		fake.list(writer, req)
+	case req.Method == http.MethodGet || req.Method == http.MethodHead:
+		body, ok := fake.objects[key]
+		if !ok {
+			http.Error(writer, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		writer.Header().Set("Content-Type", fake.types[key])
+		writer.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		http.ServeContent(writer, req, key, time.Time{}, bytes.NewReader(body))
+	case req.Method == http.MethodDelete:
+		delete(fake.objects, key)
+		writer.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// list two keys per page to go through the continuation
+func (fake *fakeS3) list(writer http.ResponseWriter, req *http.Request) {
+	keys := []string{}
+	for key := range fake.objects {
+		if strings.HasPrefix(key, req.URL.Query().Get("prefix")) && key > req.URL.Query().Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	type content struct {
+		Key  string
+		Size int
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []content
+		IsTruncated           bool
+		NextContinuationToken string
+	}{}
+	for i, key := range keys {
+		if i == 2 {
+			result.IsTruncated = true
+			result.NextContinuationToken = keys[1]
+			break
+		}
+		result.Contents = append(result.Contents, content{Key: key, Size: len(fake.objects[key])})
+	}
+	xml.NewEncoder(writer).Encode(result)
+}
+
+func TestStorageBackends(t *testing.T) {
+	server := newFakeS3(t, "afromemo")
+	s3, err := storage.NewS3(storage.S3Config{Endpoint: server.URL, Region: "ch-dk-2", Bucket: "afromemo", AccessKey: "minio", SecretKey: "minio123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.NewS3(storage.S3Config{Endpoint: server.URL})
+	assert.NotNil(t, err)
+
+	for name, store := range map[string]storage.Storage{"local": storage.NewLocal(t.TempDir(), "/images"), "s3": s3} {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			ctx := context.Background()
+
+			for _, key := range []string{"kora.jpg", "tmp/poster1.jpg", "tmp/poster2.jpg", "tmp/poster3.png"} {
+				assert.Nil(store.Put(ctx, key, strings.NewReader("image "+key), storage.ContentType(key)))
+			}
+			body, object, err := store.Get(ctx, "kora.jpg")
+			if assert.Nil(err) {
+				data, _ := io.ReadAll(body)
+				body.Close()
+				assert.Equal("image kora.jpg", string(data))
+				assert.Equal("image/jpeg", object.ContentType)
+				assert.Equal(int64(len(data)), object.Size)
+			}
+			object, err = store.Stat(ctx, "tmp/poster3.png")
+			assert.Nil(err)
+			assert.Equal(int64(len("image tmp/poster3.png")), object.Size)
+
+			objects, err := store.List(ctx, storage.TmpPrefix)
+			assert.Nil(err)
+			keys := []string{}
+			for _, object := range objects {
+				keys = append(keys, object.Key)
+			}
+			assert.ElementsMatch([]string{"tmp/poster1.jpg", "tmp/poster2.jpg", "tmp/poster3.png"}, keys)
+
+			signedURL, err := store.SignedURL(ctx, "tmp/poster1.jpg", time.Hour)
+			assert.Nil(err)
+			assert.Contains(signedURL, "tmp/poster1.jpg")
+
+			assert.Nil(store.Delete(ctx, "tmp/poster1.jpg"))
+			assert.Nil(store.Delete(ctx, "tmp/poster1.jpg"))
+			_, _, err = store.Get(ctx, "tmp/poster1.jpg")
+			assert.True(errors.Is(err, storage.ErrNotFound))
+			_, err = store.Stat(ctx, "missing.jpg")
+			assert.True(errors.Is(err, storage.ErrNotFound))
+
+			assert.True(errors.Is(store.Put(ctx, "../outside.jpg", strings.NewReader(""), ""), storage.ErrInvalidKey))
+			_, _, err = store.Get(ctx, "/etc/passwd")
+			assert.True(errors.Is(err, storage.ErrInvalidKey))
+		})
+	}
+
+	// the presigned URL is read without credentials
+	signedURL, _ := s3.SignedURL(context.Background(), "kora.jpg", time.Minute)
+	assert.Contains(t, signedURL, "X-Amz-Credential=minio%2F")
+	resp, err := http.Get(signedURL)
+	if assert.Nil(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+}
+
+func TestImageHandler(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	server := newFakeS3(t, "afromemo")
+	s3, _ := storage.NewS3(storage.S3Config{Endpoint: server.URL, Bucket: "afromemo", AccessKey: "minio", SecretKey: "minio123"})
+	assert.Nil(s3.Put(ctx, "tmp/poster1.png", strings.NewReader("png"), "image/png"))
+	services := api.NewServiceMiddleWare(migratedDB(t)).WithStorage(s3)
+
+	recorder := httptest.NewRecorder()
+	api.ImageHandler(services)(recorder, httptest.NewRequest(http.MethodGet, "/images/tmp/poster1.png", nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Equal("image/png", recorder.Header().Get("Content-Type"))
+	assert.Equal("png", recorder.Body.String())
+	assert.Equal("nosniff", recorder.Header().Get("X-Content-Type-Options"))
+
+	// an image/html polyglot is never served as a page
+	assert.Nil(s3.Put(ctx, "tmp/poster2.html", strings.NewReader("GIF89a<script>alert(1)</script>"), "text/html"))
+	assert.Nil(s3.Put(ctx, "tmp/poster3.svg", strings.NewReader("<svg onload=alert(1)>"), "image/svg+xml"))
+	for _, key := range []string{"tmp/poster2.html", "tmp/poster3.svg"} {
+		recorder = httptest.NewRecorder()
+		api.ImageHandler(services)(recorder, httptest.NewRequest(http.MethodGet, "/images/"+key, nil))
+		assert.Equal(http.StatusNotFound, recorder.Code, key)
+	}
+	assert.Equal(".gif", storage.FormatExtension("gif"))
+	assert.Equal(".jpg", storage.FormatExtension("jpeg"))
+	assert.Equal("", storage.FormatExtension("html"))
+
+	recorder = httptest.NewRecorder()
+	api.ImageHandler(services)(recorder, httptest.NewRequest(http.MethodGet, "/images/missing.jpg", nil))
+	assert.Equal(http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/images/", nil)
+	req.URL.Path = "/images/../config.yml"
+	api.ImageHandler(services)(recorder, req)
+	assert.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func TestContentAddressedPosters(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	store := storage.NewLocal(t.TempDir(), "/images")
+
+	// a PNG named affiche.jpg
+	data := []byte("\x89PNG\r\n\x1a\n the poster")
+	assert.Equal(".png", storage.DetectExtension(data, "affiche.jpg"))
+	assert.Equal(".pdf", storage.DetectExtension([]byte("%PDF-1.4"), "programme.PDF"))
+
+	key, created, err := storage.PutContent(ctx, store, data, ".png")
+	assert.Nil(err)
+	assert.True(created)
+	assert.True(storage.IsContentKey(key))
+	assert.Equal(storage.ContentKey(data, ".png"), key)
+	again, created, err := storage.PutContent(ctx, store, data, ".png")
+	assert.Nil(err)
+	assert.False(created)
+	assert.Equal(key, again)
+	other, _, _ := storage.PutContent(ctx, store, []byte("\x89PNG\r\n\x1a\n another poster"), ".png")
+	assert.NotEqual(key, other)
+	assert.False(storage.IsContentKey("affiche.png"))
+	assert.Nil(store.Put(ctx, "tmp/poster1.png", strings.NewReader("png"), "image/png"))
+	assert.Nil(store.Put(ctx, "affiche.png", strings.NewReader("png"), "image/png"))
+
+	services := api.NewServiceMiddleWare(migratedDB(t)).WithStorage(store)
+	recorder := httptest.NewRecorder()
+	api.ImageHandler(services)(recorder, httptest.NewRequest(http.MethodGet, "/images/"+key, nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	assert.Equal("public, max-age=31536000, immutable", recorder.Header().Get("Cache-Control"))
+	etag := recorder.Header().Get("ETag")
+	assert.Equal(`"`+storage.ContentHash(key)+`"`, etag)
+	assert.Equal(string(data), recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/images/"+key, nil)
+	req.Header.Set("If-None-Match", `W/"other", `+etag)
+	api.ImageHandler(services)(recorder, req)
+	assert.Equal(http.StatusNotModified, recorder.Code)
+	assert.Empty(recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	api.ImageHandler(services)(recorder, httptest.NewRequest(http.MethodGet, "/images/tmp/poster1.png", nil))
+	assert.Equal("no-store", recorder.Header().Get("Cache-Control"))
+	assert.Empty(recorder.Header().Get("ETag"))
+	recorder = httptest.NewRecorder()
+	api.ImageHandler(services)(recorder, httptest.NewRequest(http.MethodGet, "/images/affiche.png", nil))
+	assert.Equal("public, max-age=3600", recorder.Header().Get("Cache-Control"))
+
+	// a missing content key is not cached
+	recorder = httptest.NewRecorder()
+	api.ImageHandler(services)(recorder, httptest.NewRequest(http.MethodGet, "/images/"+storage.ContentKey([]byte("missing"), ".jpg"), nil))
+	assert.Equal(http.StatusNotFound, recorder.Code)
+	assert.Empty(recorder.Header().Get("Cache-Control"))
+}
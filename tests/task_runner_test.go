@@ -0,0 +1,190 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"dpatrov/scraper/internal/types"
+	"dpatrov/scraper/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func migratedDB(t *testing.T) *sql.DB {
+	localDb, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "agenda.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { localDb.Close() })
+	if err := db.RunMigration(localDb, "../internal/db/migrations"); err != nil {
+		t.Fatal(err)
+	}
+	return localDb
+}
+
+func TestScrapingRuns(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	taskRepository := repository.NewTaskRepository(migratedDB(t))
+
+	task := &db.ScrapingTask{
+		Name:        "Decadanse",
+		Type:        "agenda",
+		Url:         "https://www.ladecadanse.ch/agenda",
+		CreatedTime: time.Now(),
+		Definition: types.ScrapingTask{
+			ItemSelector: ".evenement",
+			ScrapingMap:  types.ScrapingMap{"h2": "title"},
+		},
+	}
+	assert.Nil(taskRepository.Create(ctx, task))
+	assert.NotZero(task.Id)
+
+	saved, err := taskRepository.FindByID(ctx, task.Id)
+	assert.Nil(err)
+	assert.Equal(".evenement", saved.Definition.ItemSelector)
+	assert.Equal(task.Url, saved.Definition.Url)
+	assert.Equal(db.Record{"h2": "title"}, saved.ScrapingParams)
+
+	t.Run("Claim once", func(t *testing.T) {
+		run, err := taskRepository.CreateRun(ctx, task.Id)
+		assert.Nil(err)
+		claimed, _ := taskRepository.ClaimRun(ctx, run.ID)
+		assert.True(claimed)
+		claimed, _ = taskRepository.ClaimRun(ctx, run.ID)
+		assert.False(claimed)
+
+		run.Status = db.TaskStatus_Succeeded
+		run.FinishedAt = time.Now()
+		run.EntriesCount = 3
+		run.Result = []byte(`{"created":[]}`)
+		assert.Nil(taskRepository.FinishRun(ctx, run))
+
+		finished, err := taskRepository.FindRunByID(ctx, run.ID)
+		assert.Nil(err)
+		assert.Equal(db.TaskStatus_Succeeded, finished.Status)
+		assert.Equal(3, finished.EntriesCount)
+		assert.False(finished.StartedAt.IsZero())
+	})
+
+	t.Run("Cancel queued run", func(t *testing.T) {
+		run, _ := taskRepository.CreateRun(ctx, task.Id)
+		cancelled, err := taskRepository.CancelQueuedRun(ctx, run.ID)
+		assert.Nil(err)
+		assert.True(cancelled)
+		claimed, _ := taskRepository.ClaimRun(ctx, run.ID)
+		assert.False(claimed)
+
+		runs, err := taskRepository.FindRunsByTask(ctx, task.Id, 10)
+		assert.Nil(err)
+		assert.Len(runs, 2)
+		assert.Equal(db.TaskStatus_Cancelled, runs[0].Status)
+	})
+
+	t.Run("Due tasks", func(t *testing.T) {
+		now := time.Now()
+		assert.Nil(taskRepository.UpdateSchedule(ctx, task.Id, "@hourly", now.Add(-time.Minute)))
+		due, err := taskRepository.FindDueTasks(ctx, now)
+		assert.Nil(err)
+		assert.Len(due, 1)
+		assert.Equal("@hourly", due[0].Schedule)
+
+		assert.Nil(taskRepository.UpdateSchedule(ctx, task.Id, "@hourly", now.Add(time.Hour)))
+		due, _ = taskRepository.FindDueTasks(ctx, now)
+		assert.Len(due, 0)
+
+		assert.Nil(taskRepository.UpdateSchedule(ctx, task.Id, "", time.Time{}))
+		disabled, _ := taskRepository.FindByID(ctx, task.Id)
+		assert.True(disabled.NextRunAt.IsZero())
+	})
+
+	t.Run("Stable scraped entry id", func(t *testing.T) {
+		entry := db.AgendaEntry{Title: "Concert", Link: "https://example.org/concert", StartDate: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}
+		assert.Equal(utils.ScrapedEntryID(entry), utils.ScrapedEntryID(entry))
+		entry.StartDate = entry.StartDate.AddDate(0, 0, 1)
+		assert.NotEqual(utils.ScrapedEntryID(db.AgendaEntry{Title: "Concert", Link: "https://example.org/concert"}), utils.ScrapedEntryID(entry))
+	})
+}
+
+func TestCancelRun(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	taskRepository := repository.NewTaskRepository(localDb)
+
+	// the page never answers, only a cancel ends the run
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	task := &db.ScrapingTask{
+		Name:        "Hanging",
+		Type:        "agenda",
+		Url:         server.URL,
+		CreatedTime: time.Now(),
+		Definition: types.ScrapingTask{
+			ItemSelector: ".evenement",
+			ScrapingMap:  types.ScrapingMap{"h2": "title"},
+		},
+	}
+	assert.Nil(taskRepository.Create(ctx, task))
+
+	t.Setenv("SCRAPER_WORKERS", "4")
+	runner := utils.NewScrapingRunner(localDb)
+	runner.Start(ctx)
+	defer runner.Stop()
+
+	// cancelled right away, while a worker may be claiming the run
+	for range 20 {
+		run, err := runner.Enqueue(ctx, task.Id)
+		assert.Nil(err)
+		cancelled, err := runner.Cancel(ctx, run.ID)
+		assert.Nil(err)
+		assert.Equal(db.TaskStatus_Cancelled, cancelled.Status)
+		assert.Eventually(func() bool {
+			run, err := taskRepository.FindRunByID(ctx, run.ID)
+			return err == nil && run.Status == db.TaskStatus_Cancelled
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	// cancelled while running
+	run, err := runner.Enqueue(ctx, task.Id)
+	assert.Nil(err)
+	assert.Eventually(func() bool {
+		run, err := taskRepository.FindRunByID(ctx, run.ID)
+		return err == nil && run.Status == db.TaskStatus_Running
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = runner.Cancel(ctx, run.ID)
+	assert.Nil(err)
+	assert.Eventually(func() bool {
+		run, err := taskRepository.FindRunByID(ctx, run.ID)
+		return err == nil && run.Status == db.TaskStatus_Cancelled
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = runner.Cancel(ctx, run.ID)
+	assert.ErrorIs(err, utils.ErrRunAlreadyFinished)
+
+	// a run left running by another server
+	run, err = taskRepository.CreateRun(ctx, task.Id)
+	assert.Nil(err)
+	claimed, _ := taskRepository.ClaimRun(ctx, run.ID)
+	assert.True(claimed)
+	_, err = runner.Cancel(ctx, run.ID)
+	assert.ErrorIs(err, utils.ErrRunNotRunningHere)
+
+	// the task stays running while a run is running, queued once it is finished
+	assert.Nil(taskRepository.UpdateStatus(ctx, task.Id, db.TaskStatus_Running))
+	assert.Nil(taskRepository.QueueTask(ctx, task.Id))
+	saved, _ := taskRepository.FindByID(ctx, task.Id)
+	assert.Equal(db.TaskStatus_Running, saved.Status)
+	run.Status, run.FinishedAt = db.TaskStatus_Succeeded, time.Now()
+	assert.Nil(taskRepository.FinishRun(ctx, run))
+	assert.Nil(taskRepository.QueueTask(ctx, task.Id))
+	saved, _ = taskRepository.FindByID(ctx, task.Id)
+	assert.Equal(db.TaskStatus_Queued, saved.Status)
+}
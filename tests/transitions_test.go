@@ -0,0 +1,95 @@
+package test
+
+import (
+	"context"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	gendb "dpatrov/scraper/internal/gendb"
+	"dpatrov/scraper/internal/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgendaStatusMoves(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(db.Status_Pending.CanMoveTo(db.Status_Active))
+	assert.True(db.Status_Active.CanMoveTo(db.Status_Archived))
+	assert.True(db.Status_Archived.CanMoveTo(db.Status_Archived))
+	assert.False(db.Status_Pending.CanMoveTo(db.Status_Archived))
+	assert.False(db.Status_Removed.CanMoveTo(db.Status_Active))
+	assert.False(db.Status(42).CanMoveTo(db.Status_Active))
+
+	err := db.SubmissionTransition("submission", "rejected", db.SubmissionStatus_Active)
+	assert.True(errors.Is(err, db.ErrInvalidTransition))
+	assert.Nil(db.AgendaTransition("entry", db.Status_Unlinked, db.Status_Active))
+
+	var entry db.AgendaEntry
+	assert.NotNil(json.Unmarshal([]byte(`{"status": 9}`), &entry))
+	assert.Nil(json.Unmarshal([]byte(`{"status": 4}`), &entry))
+	assert.Equal(db.Status_Unlinked, entry.Status)
+	assert.Equal("unlinked", entry.Status.String())
+}
+
+func TestAgendaTransitions(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+
+	past := time.Now().AddDate(0, 0, -10)
+	pending := db.AgendaEntry{Title: "Pending", StartDate: past, EndDate: past, Status: db.Status_Pending}
+	deleted := db.AgendaEntry{Title: "Deleted", StartDate: past, EndDate: past, Status: db.Status_Deleted}
+	active := db.AgendaEntry{Title: "Active", StartDate: past, EndDate: past, Status: db.Status_Active}
+	for _, entry := range []*db.AgendaEntry{&pending, &deleted, &active} {
+		_, err := agendaRepository.Create(ctx, entry)
+		assert.Nil(err)
+	}
+
+	err := agendaRepository.UpdateStatus(ctx, pending.ID, int(db.Status_Archived))
+	assert.True(errors.Is(err, db.ErrInvalidTransition))
+	assert.Equal(repository.ErrNoAgendaEntryFound, agendaRepository.UpdateStatus(ctx, "unknown", int(db.Status_Active)))
+
+	// the archiver only archives the published entries
+	assert.Nil(utils.NewEventArchiver(localDb, time.Hour).ArchivePastEvents(ctx, time.Now()))
+	for entry, status := range map[string]db.Status{pending.ID: db.Status_Pending, deleted.ID: db.Status_Deleted, active.ID: db.Status_Active} {
+		stored, err := agendaRepository.FindByID(ctx, entry)
+		assert.Nil(err)
+		if status == db.Status_Active {
+			status = db.Status_Archived
+		}
+		assert.Equal(status, stored.Status)
+	}
+
+	active, _ = agendaRepository.FindByID(ctx, active.ID)
+	active.Status = db.Status_Unlinked
+	assert.True(errors.Is(agendaRepository.Update(ctx, active), db.ErrInvalidTransition))
+}
+
+func TestConfirmSubmissionTwice(t *testing.T) {
+	assert := assert.New(t)
+	localDb := migratedDB(t)
+	assert.Nil(gendb.New(localDb).CreateFormSubmission(context.Background(), gendb.CreateFormSubmissionParams{
+		ID:                "submission",
+		Email:             "kora@example.ch",
+		Data:              `{"title":"Concert"}`,
+		EditToken:         "edit",
+		CancelToken:       "cancel",
+		ConfirmationToken: "confirm",
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+		ExpiredAt:         time.Now().Add(24 * time.Hour),
+		Status:            string(db.SubmissionStatus_Pending),
+	}))
+	recorder := httptest.NewRecorder()
+	api.ConfirmSubmission(api.NewServiceMiddleWare(localDb))(recorder,
+		httptest.NewRequest(http.MethodPost, "/api/submissions/confirm", strings.NewReader(`{"token":"confirm"}`)))
+	assert.Equal(http.StatusConflict, recorder.Code)
+}
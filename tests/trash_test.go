@@ -0,0 +1,115 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	gendb "dpatrov/scraper/internal/gendb"
+	"dpatrov/scraper/internal/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgendaTrash(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	queries := gendb.New(localDb)
+
+	concert := db.AgendaEntry{Title: "Concert", StartDate: time.Now().AddDate(0, 0, 3), Status: db.Status_Active}
+	_, err := agendaRepository.Create(ctx, &concert)
+	assert.Nil(err)
+	assert.Nil(queries.CreateFormSubmission(ctx, gendb.CreateFormSubmissionParams{
+		ID:                concert.ID,
+		Email:             "kora@example.ch",
+		Data:              `{"title":"Concert"}`,
+		EditToken:         "edit",
+		CancelToken:       "cancel",
+		ConfirmationToken: "confirm",
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
+		ExpiredAt:         time.Now().Add(24 * time.Hour),
+		Status:            string(db.SubmissionStatus_Active),
+	}))
+
+	assert.Equal(repository.ErrNoAgendaEntryFound, agendaRepository.Trash(ctx, "unknown"))
+	assert.Nil(agendaRepository.Trash(ctx, concert.ID))
+	assert.Nil(queries.TrashSubmission(ctx, gendb.TrashSubmissionParams{DeletedAt: sql.NullTime{Time: time.Now().UTC(), Valid: true}, ID: concert.ID}))
+
+	// a trashed entry is out of the agenda and can't be created again
+	_, err = agendaRepository.FindByID(ctx, concert.ID)
+	assert.Equal(sql.ErrNoRows, err)
+	page, err := agendaRepository.Find(ctx, repository.AgendaQuery{})
+	assert.Nil(err)
+	assert.Empty(page.Entries)
+	_, err = queries.GetSubmissionByToken(ctx, gendb.GetSubmissionByTokenParams{EditToken: "edit"})
+	assert.Equal(sql.ErrNoRows, err)
+	_, err = agendaRepository.Create(ctx, &db.AgendaEntry{ID: concert.ID, Title: "Concert"})
+	assert.True(errors.Is(err, repository.ErrEntryInTrash))
+
+	services := api.NewServiceMiddleWare(localDb)
+	recorder := httptest.NewRecorder()
+	api.TrashHandler(services)(recorder, httptest.NewRequest(http.MethodGet, "/trash", nil))
+	var response struct {
+		Data []db.AgendaEntry `json:"data"`
+	}
+	assert.Nil(json.NewDecoder(recorder.Body).Decode(&response))
+	if assert.Len(response.Data, 1) {
+		assert.False(response.Data[0].DeletedAt.IsZero())
+	}
+
+	recorder = httptest.NewRecorder()
+	api.TrashHandler(services)(recorder, httptest.NewRequest(http.MethodPost, "/trash/"+concert.ID+"/restore", nil))
+	assert.Equal(http.StatusOK, recorder.Code)
+	restored, err := agendaRepository.FindByID(ctx, concert.ID)
+	assert.Nil(err)
+	assert.Equal(db.Status_Active, restored.Status)
+	_, err = queries.GetSubmissionByToken(ctx, gendb.GetSubmissionByTokenParams{EditToken: "edit"})
+	assert.Nil(err)
+	revisions, _ := agendaRepository.FindRevisions(ctx, concert.ID)
+	assert.Equal(repository.RevisionUntrash, revisions[0].Action)
+	assert.Equal(repository.RevisionTrash, revisions[1].Action)
+
+	recorder = httptest.NewRecorder()
+	api.TrashHandler(services)(recorder, httptest.NewRequest(http.MethodPost, "/trash/"+concert.ID+"/restore", nil))
+	assert.Equal(http.StatusNotFound, recorder.Code)
+}
+
+func TestPurgeTrash(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+
+	concert := db.AgendaEntry{Title: "Concert", StartDate: time.Now(), Status: db.Status_Active}
+	_, err := agendaRepository.Create(ctx, &concert)
+	assert.Nil(err)
+	assert.Nil(agendaRepository.Trash(ctx, concert.ID))
+
+	archiver := utils.NewEventArchiver(localDb, time.Hour).WithRetention(7 * 24 * time.Hour)
+	// kept during the retention
+	assert.Nil(archiver.PurgeTrash(ctx, time.Now().AddDate(0, 0, 6)))
+	trashed, err := agendaRepository.FindTrashed(ctx)
+	assert.Nil(err)
+	assert.Len(trashed, 1)
+
+	assert.Nil(archiver.PurgeTrash(ctx, time.Now().AddDate(0, 0, 8)))
+	trashed, err = agendaRepository.FindTrashed(ctx)
+	assert.Nil(err)
+	assert.Empty(trashed)
+	revisions, err := agendaRepository.FindRevisions(ctx, concert.ID)
+	assert.Nil(err)
+	assert.Empty(revisions)
+	// purged for good, the id can be used again
+	_, err = agendaRepository.Create(ctx, &db.AgendaEntry{ID: concert.ID, Title: "Concert"})
+	assert.Nil(err)
+}
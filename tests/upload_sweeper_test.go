@@ -0,0 +1,120 @@
+package test
+
+import (
+	"context"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	gendb "dpatrov/scraper/internal/gendb"
+	"dpatrov/scraper/internal/storage"
+	"dpatrov/scraper/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadSweeper(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	dir := t.TempDir()
+	store := storage.NewLocal(dir, "/images")
+	now := time.Now()
+
+	// the published uploads are named after their content
+	key := func(name string) string {
+		return storage.ContentKey([]byte(name), filepath.Ext(name))
+	}
+	uploads := map[string]time.Time{
+		key("kora-card.jpg"):      now.AddDate(0, 0, -10),
+		key("kora-thumbnail.jpg"): now.AddDate(0, 0, -10),
+		key("first-poster.jpg"):   now.AddDate(0, 0, -10),
+		key("logo.png"):           now.AddDate(0, 0, -10),
+		key("replaced.jpg"):       now.AddDate(0, 0, -10),
+		key("just-published.jpg"): now.Add(-10 * time.Minute),
+		"tmp/pending.jpg":         now.AddDate(0, 0, -3),
+		"tmp/abandoned.jpg":       now.Add(-25 * time.Hour),
+		"tmp/being-filled.jpg":    now.Add(-time.Hour),
+		// the other files of the bucket are not uploads
+		"backups/agenda.db": now.AddDate(0, 0, -10),
+		"robots.txt":        now.AddDate(0, 0, -10),
+	}
+	for key, modTime := range uploads {
+		assert.Nil(store.Put(ctx, key, strings.NewReader(key), ""))
+		assert.Nil(os.Chtimes(filepath.Join(dir, key), modTime, modTime))
+	}
+
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	concert := db.AgendaEntry{Title: "Kora", StartDate: now, Status: db.Status_Active, Poster: key("first-poster.jpg")}
+	_, err := agendaRepository.Create(ctx, &concert)
+	assert.Nil(err)
+	// the first poster is kept by the revision of the creation
+	concert.Poster = key("kora-card.jpg")
+	concert.PosterVariants = []db.PosterVariant{
+		{Size: "thumbnail", File: key("kora-thumbnail.jpg"), Width: 320},
+		{Size: "card", File: key("kora-card.jpg"), Width: 640}}
+	assert.Nil(agendaRepository.Update(ctx, concert))
+	assert.Nil(repository.NewOrganizerRepository(localDb).Create(ctx, &db.Organizer{Name: "Afromemo", Logo: key("logo.png")}))
+	data, _ := db.AgendaEntry{Title: "Pending", StartDate: now, Poster: "/tmp/pending.jpg"}.ToJSON()
+	assert.Nil(gendb.New(localDb).CreateFormSubmission(ctx, gendb.CreateFormSubmissionParams{
+		ID:                "pending",
+		Email:             "kora@example.ch",
+		Data:              data,
+		EditToken:         "edit",
+		CancelToken:       "cancel",
+		ConfirmationToken: "confirm",
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		ExpiredAt:         now.Add(24 * time.Hour),
+		Status:            string(db.SubmissionStatus_Pending),
+	}))
+
+	keys := func(objects []storage.Object) []string {
+		keys := []string{}
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		return keys
+	}
+	sweeper := utils.NewUploadSweeper(localDb, store, time.Hour)
+	result, err := sweeper.Sweep(ctx, now, true)
+	assert.Nil(err)
+	assert.Equal([]string{"tmp/abandoned.jpg"}, keys(result.Tmp))
+	assert.Equal([]string{key("replaced.jpg")}, keys(result.Orphans))
+	// a dry run deletes nothing
+	remaining, _ := store.List(ctx, "")
+	assert.Len(remaining, len(uploads))
+
+	result, err = sweeper.Sweep(ctx, now, false)
+	assert.Nil(err)
+	assert.Len(result.Tmp, 1)
+	assert.Equal(int64(len(key("replaced.jpg"))+len("tmp/abandoned.jpg")), result.Size())
+	remaining, _ = store.List(ctx, "")
+	assert.ElementsMatch([]string{key("kora-card.jpg"), key("kora-thumbnail.jpg"), key("first-poster.jpg"),
+		key("logo.png"), key("just-published.jpg"), "tmp/pending.jpg", "tmp/being-filled.jpg", "backups/agenda.db", "robots.txt"}, keys(remaining))
+
+	// a shorter ttl for the tmp uploads
+	result, err = sweeper.WithTmpTTL(30*time.Minute).Sweep(ctx, now, false)
+	assert.Nil(err)
+	assert.Equal([]string{"tmp/being-filled.jpg"}, keys(result.Tmp))
+
+	// an orphan uploaded again starts a new grace period, its entry isn't saved yet
+	poster := []byte("uploaded again")
+	orphan, created, err := storage.PutContent(ctx, store, poster, ".jpg")
+	assert.Nil(err)
+	assert.True(created)
+	old := now.AddDate(0, 0, -10)
+	assert.Nil(os.Chtimes(filepath.Join(dir, orphan), old, old))
+	again, created, err := storage.PutContent(ctx, store, poster, ".jpg")
+	assert.Nil(err)
+	assert.False(created)
+	assert.Equal(orphan, again)
+	result, err = sweeper.Sweep(ctx, time.Now(), false)
+	assert.Nil(err)
+	assert.Empty(result.Orphans)
+	_, err = store.Stat(ctx, orphan)
+	assert.Nil(err)
+}
@@ -0,0 +1,184 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	api "dpatrov/scraper/api/v1"
+	"dpatrov/scraper/internal/db"
+	"dpatrov/scraper/internal/db/repository"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVenueKey(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("le chat noir|carouge", repository.VenueKey(" Le  Chat-Noir ", "Carouge"))
+	assert.Equal(repository.VenueKey("L'Usine", "Genève"), repository.VenueKey("l usine", "GENEVE"))
+	assert.NotEqual(repository.VenueKey("Le Chat Noir", "Carouge"), repository.VenueKey("Le Chat Noir", "Lausanne"))
+}
+
+func TestVenueBackfill(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "agenda.db"))
+	assert.Nil(err)
+	defer localDb.Close()
+	migration, err := db.SetupMigration(localDb, "../internal/db/migrations")
+	assert.Nil(err)
+	assert.Nil(migration.Migrate(202505180000021))
+
+	for i, venue := range [][3]string{
+		{"Le Chat Noir", "Rue Vautier 13", "Carouge"},
+		{"le chat-noir", "", "carouge"},
+		{"Le Chat Noir", "Rue Vautier 13", "Carouge"},
+		{"L'Usine", "Place des Volontaires 4", "Genève"},
+		{"", "Parc des Bastions", "Genève"},
+		// the same venue for VenueKey, whatever the case of the accents and the spaces
+		{"ÓPÉRA\tDES NATIONS", "", "GENÈVE"},
+		{"Opéra des Nations", "Avenue de France 40", "Genève"},
+	} {
+		_, err := localDb.Exec(`INSERT INTO agenda_entry (id, title, link, price, address, startdate, description, venuename, place, status)
+			VALUES (?, 'Concert', '', '', ?, '2025-10-15', '', ?, ?, 1)`, string(rune('a'+i)), venue[1], venue[0], venue[2])
+		assert.Nil(err)
+	}
+	assert.Nil(db.RunMigration(localDb, "../internal/db/migrations"))
+
+	venueRepository := repository.NewVenueRepository(localDb)
+	venues, err := venueRepository.Search(ctx, "", 0)
+	assert.Nil(err)
+	if assert.Len(venues, 3) {
+		// the most used spelling
+		assert.Equal("Le Chat Noir", venues[0].Name)
+		assert.Equal("Rue Vautier 13", venues[0].Address)
+		assert.Equal(3, venues[0].Events)
+		// the longest address
+		assert.Equal("Opéra des Nations", venues[1].Name)
+		assert.Equal(2, venues[1].Events)
+		assert.Equal("L'Usine", venues[2].Name)
+	}
+	var unlinked int
+	assert.Nil(localDb.QueryRow(`SELECT COUNT(*) FROM agenda_entry WHERE venue_id IS NULL`).Scan(&unlinked))
+	assert.Equal(1, unlinked)
+
+	// the venues keyed by another normalization are keyed again, the duplicates are merged
+	_, err = localDb.Exec(`UPDATE venue SET normalized_key = 'stale|' || id`)
+	assert.Nil(err)
+	_, err = localDb.Exec(`INSERT INTO venue (name, place, normalized_key) VALUES ('LE CHAT NOIR', 'CAROUGE', 'le chat noir|carouge')`)
+	assert.Nil(err)
+	linked, err := venueRepository.Backfill(ctx)
+	assert.Nil(err)
+	assert.Equal(0, linked)
+	venues, err = venueRepository.Search(ctx, "", 0)
+	assert.Nil(err)
+	assert.Len(venues, 3)
+	venue, err := venueRepository.FindByKey(ctx, "le chat-noir", "carouge")
+	assert.Nil(err)
+	assert.Equal("Le Chat Noir", venue.Name)
+	assert.Equal(3, venue.Events)
+	_, err = venueRepository.FindByKey(ctx, "Opera des nations", "Geneve")
+	assert.Nil(err)
+}
+
+func TestVenues(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	venueRepository := repository.NewVenueRepository(localDb)
+
+	// the entries with the same venue share it
+	first := db.AgendaEntry{Title: "Concert", StartDate: time.Now(), VenueName: "Le Chat Noir", Address: "Rue Vautier 13", Place: "Carouge", Status: db.Status_Active}
+	_, err := agendaRepository.Create(ctx, &first)
+	assert.Nil(err)
+	second := db.AgendaEntry{Title: "Bal", StartDate: time.Now(), VenueName: "le chat-noir", Place: "carouge", Status: db.Status_Active}
+	_, err = agendaRepository.Create(ctx, &second)
+	assert.Nil(err)
+	assert.NotZero(first.VenueID)
+	assert.Equal(first.VenueID, second.VenueID)
+
+	// an entry chosen from the autocomplete gets the venue
+	suggestions, err := venueRepository.Search(ctx, "chat", 10)
+	assert.Nil(err)
+	assert.Len(suggestions, 1)
+	third := db.AgendaEntry{Title: "Expo", StartDate: time.Now(), VenueID: suggestions[0].ID, Status: db.Status_Active}
+	_, err = agendaRepository.Create(ctx, &third)
+	assert.Nil(err)
+	assert.Equal("Le Chat Noir", third.VenueName)
+	assert.Equal("Rue Vautier 13", third.Address)
+
+	venue := db.Venue{Name: "Le Chat Noir ", Place: "Carouge"}
+	assert.True(errors.Is(venueRepository.Create(ctx, &venue), repository.ErrVenueExists))
+
+	// the entries keep a copy of the venue
+	venue, _ = venueRepository.FindByID(ctx, first.VenueID)
+	assert.Equal(3, venue.Events)
+	venue.Name = "Chat Noir"
+	assert.Nil(venueRepository.Update(ctx, venue))
+	entry, _ := agendaRepository.FindByID(ctx, second.ID)
+	assert.Equal("Chat Noir", entry.VenueName)
+
+	duplicate := db.Venue{Name: "Chat Noir Carouge", Place: "Carouge"}
+	assert.Nil(venueRepository.Create(ctx, &duplicate))
+	entry.VenueID, entry.VenueName = duplicate.ID, ""
+	assert.Nil(agendaRepository.Update(ctx, entry))
+	assert.Nil(venueRepository.Merge(ctx, duplicate.ID, venue.ID))
+	page, err := agendaRepository.Find(ctx, repository.AgendaQuery{VenueID: venue.ID})
+	assert.Nil(err)
+	assert.Len(page.Entries, 3)
+	_, err = venueRepository.FindByID(ctx, duplicate.ID)
+	assert.True(errors.Is(err, repository.ErrNoVenueFound))
+
+	assert.Nil(venueRepository.Delete(ctx, venue.ID))
+	entry, _ = agendaRepository.FindByID(ctx, first.ID)
+	assert.Zero(entry.VenueID)
+	assert.Equal("Chat Noir", entry.VenueName)
+}
+
+func TestPublicVenues(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	localDb := migratedDB(t)
+	agendaRepository := repository.NewAgendaRepository(localDb)
+	services := api.NewServiceMiddleWare(localDb)
+	request := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		api.VenuesHandler(services)(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+	suggestions := func(text string) []db.Venue {
+		recorder := request("/api/venues?q=" + text)
+		assert.Equal(http.StatusOK, recorder.Code)
+		var response struct{ Data []db.Venue }
+		assert.Nil(json.Unmarshal(recorder.Body.Bytes(), &response))
+		return response.Data
+	}
+
+	// a scraped entry waiting for the moderation
+	scraped := db.AgendaEntry{Title: "Soirée", StartDate: time.Now(), VenueName: "Cave Secrète", Place: "Genève", Status: db.Status_Pending}
+	_, err := agendaRepository.Create(ctx, &scraped)
+	assert.Nil(err)
+	assert.NotZero(scraped.VenueID)
+	assert.Empty(suggestions("secrete"))
+	assert.Equal(http.StatusNotFound, request(fmt.Sprintf("/api/venues/%d", scraped.VenueID)).Code)
+	venues, err := repository.NewVenueRepository(localDb).Search(ctx, "secrete", 10)
+	assert.Nil(err)
+	assert.Len(venues, 1)
+
+	assert.Nil(agendaRepository.UpdateStatus(ctx, scraped.ID, int(db.Status_Active)))
+	if venues := suggestions("secrete"); assert.Len(venues, 1) {
+		assert.Equal("Cave Secrète", venues[0].Name)
+	}
+	assert.Equal(http.StatusOK, request(fmt.Sprintf("/api/venues/%d", scraped.VenueID)).Code)
+
+	// a trashed entry doesn't publish its venue
+	assert.Nil(agendaRepository.Trash(ctx, scraped.ID))
+	assert.Empty(suggestions("secrete"))
+}
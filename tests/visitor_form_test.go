@@ -22,7 +22,7 @@ func TestUserFormSubmission(t *testing.T) {
 			len(arg.CancelToken) > 0
 	}
 	// mock response
-	mockDBReturn
+	mockQueries.On("CreateFormSubmission", mock.MatchedBy(expectedFormData)).Return(nil)
 
 	//send request
 	// assert form created